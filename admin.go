@@ -0,0 +1,56 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/polycloze/polycloze/admin"
+	"github.com/polycloze/polycloze/basedir"
+	"github.com/polycloze/polycloze/config"
+	"github.com/polycloze/polycloze/database"
+)
+
+// Runs `polycloze admin`.
+// args: arguments after "admin"
+func runAdmin(args []string) {
+	flags := flag.NewFlagSet("admin", flag.ExitOnError)
+	configFile := flags.String("config", config.DefaultPath(), "path to config file")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: polycloze admin [-config file] <command> [args]")
+		flags.PrintDefaults()
+		admin.Usage(flags.Output())
+	}
+	_ = flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	c, err := config.Load(*configFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	applyConfig(c)
+
+	db, err := database.OpenAuthDB(basedir.Auth())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	a := admin.Admin{
+		DB:     db,
+		Stdin:  os.Stdin,
+		Stdout: os.Stdout,
+	}
+	if err := a.Run(flags.Args()); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		db.Close()
+		os.Exit(1)
+	}
+}
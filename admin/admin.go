@@ -0,0 +1,307 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+// Admin commands for managing users.
+// The commands work directly on the state directory, so the server doesn't
+// have to be running.
+package admin
+
+import (
+	"bufio"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/polycloze/polycloze/auth"
+	"github.com/polycloze/polycloze/basedir"
+	"github.com/polycloze/polycloze/database"
+	"github.com/polycloze/polycloze/sessions"
+)
+
+type Admin struct {
+	DB     *sql.DB // Auth DB
+	Stdin  io.Reader
+	Stdout io.Writer
+}
+
+type command struct {
+	name    string
+	args    string
+	summary string
+	run     func(a Admin, args []string) error
+}
+
+func commands() []command {
+	return []command{
+		{"users", "", "list users", listUsers},
+		{"create-user", "<username>", "create user (reads password from stdin)", createUser},
+		{"reset-password", "<username>", "change password (reads password from stdin)", resetPassword},
+		{"disable-2fa", "<username>", "disable two-factor authentication (for lost devices)", disableTOTP},
+		{"delete-user", "<username>", "delete user and their files", deleteUser},
+		{"grant-admin", "<username>", "give user access to the admin dashboard", setAdmin(true)},
+		{"revoke-admin", "<username>", "remove user's access to the admin dashboard", setAdmin(false)},
+		{"invites", "", "list unused invite codes", listInvites},
+		{"create-invite", "[uses]", "create invite code (single-use by default)", createInvite},
+		{"expire-sessions", "[username]", "sign out user, or everyone if no user is given", expireSessions},
+		{"migrate", "", "upgrade review databases of all users", migrate},
+	}
+}
+
+// Writes list of commands.
+func Usage(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "Commands:")
+	for _, c := range commands() {
+		fmt.Fprintf(tw, "  %s %s\t%s\n", c.name, c.args, c.summary)
+	}
+	tw.Flush()
+}
+
+// Runs admin command.
+// args: command name followed by its arguments
+func (a Admin) Run(args []string) error {
+	if len(args) == 0 {
+		return errors.New("missing command")
+	}
+	for _, c := range commands() {
+		if c.name != args[0] {
+			continue
+		}
+		if err := c.run(a, args[1:]); err != nil {
+			return fmt.Errorf("%v: %w", c.name, err)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown command: %v", args[0])
+}
+
+// Checks if there's exactly one argument, and returns it.
+func singleArg(args []string, name string) (string, error) {
+	if len(args) != 1 {
+		return "", fmt.Errorf("expected one argument: %v", name)
+	}
+	return args[0], nil
+}
+
+// Reads password from the first line of stdin.
+func (a Admin) readPassword() (string, error) {
+	fmt.Fprint(a.Stdout, "Password: ")
+	line, err := bufio.NewReader(a.Stdin).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", fmt.Errorf("failed to read password: %w", err)
+	}
+	fmt.Fprintln(a.Stdout)
+
+	password := strings.TrimRight(line, "\r\n")
+	if password == "" {
+		return "", errors.New("empty password")
+	}
+	return password, nil
+}
+
+func listUsers(a Admin, args []string) error {
+	users, err := auth.ListUsers(a.DB)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(a.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tUSERNAME\tADMIN\tDISABLED")
+	for _, user := range users {
+		fmt.Fprintf(tw, "%d\t%s\t%v\t%v\n", user.ID, user.Username, user.IsAdmin, user.Disabled)
+	}
+	return tw.Flush()
+}
+
+func createUser(a Admin, args []string) error {
+	username, err := singleArg(args, "username")
+	if err != nil {
+		return err
+	}
+	if _, err := auth.GetUserID(a.DB, username); err == nil {
+		return fmt.Errorf("username is already taken: %v", username)
+	}
+
+	password, err := a.readPassword()
+	if err != nil {
+		return err
+	}
+	if err := auth.Register(a.DB, username, password); err != nil {
+		return err
+	}
+	fmt.Fprintf(a.Stdout, "Created user %v.\n", username)
+	return nil
+}
+
+func resetPassword(a Admin, args []string) error {
+	username, err := singleArg(args, "username")
+	if err != nil {
+		return err
+	}
+	userID, err := auth.GetUserID(a.DB, username)
+	if err != nil {
+		return err
+	}
+
+	password, err := a.readPassword()
+	if err != nil {
+		return err
+	}
+	if err := auth.ChangePassword(a.DB, userID, password, ""); err != nil {
+		return err
+	}
+	fmt.Fprintf(a.Stdout, "Changed password of %v.\n", username)
+	return nil
+}
+
+func disableTOTP(a Admin, args []string) error {
+	username, err := singleArg(args, "username")
+	if err != nil {
+		return err
+	}
+	userID, err := auth.GetUserID(a.DB, username)
+	if err != nil {
+		return err
+	}
+
+	if err := auth.DisableTOTP(a.DB, userID); err != nil {
+		return err
+	}
+	fmt.Fprintf(a.Stdout, "Disabled two-factor authentication of %v.\n", username)
+	return nil
+}
+
+func deleteUser(a Admin, args []string) error {
+	username, err := singleArg(args, "username")
+	if err != nil {
+		return err
+	}
+	userID, err := auth.GetUserID(a.DB, username)
+	if err != nil {
+		return err
+	}
+
+	if err := auth.DeleteUser(a.DB, userID); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(basedir.User(userID)); err != nil {
+		return fmt.Errorf("deleted account, but failed to delete user files: %w", err)
+	}
+	fmt.Fprintf(a.Stdout, "Deleted user %v.\n", username)
+	return nil
+}
+
+func setAdmin(isAdmin bool) func(a Admin, args []string) error {
+	return func(a Admin, args []string) error {
+		username, err := singleArg(args, "username")
+		if err != nil {
+			return err
+		}
+		userID, err := auth.GetUserID(a.DB, username)
+		if err != nil {
+			return err
+		}
+
+		if err := auth.SetAdmin(a.DB, userID, isAdmin); err != nil {
+			return err
+		}
+		if isAdmin {
+			fmt.Fprintf(a.Stdout, "%v is now an admin.\n", username)
+		} else {
+			fmt.Fprintf(a.Stdout, "%v is no longer an admin.\n", username)
+		}
+		return nil
+	}
+}
+
+func listInvites(a Admin, args []string) error {
+	invites, err := auth.ListInvites(a.DB)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(a.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "CODE\tUSES\tCREATED")
+	for _, invite := range invites {
+		fmt.Fprintf(tw, "%s\t%d/%d\t%s\n", invite.Code, invite.Uses, invite.MaxUses, invite.Created.Format("2006-01-02"))
+	}
+	return tw.Flush()
+}
+
+func createInvite(a Admin, args []string) error {
+	if len(args) > 1 {
+		return errors.New("expected at most one argument: uses")
+	}
+
+	maxUses := 1
+	if len(args) == 1 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid number of uses: %v", args[0])
+		}
+		maxUses = n
+	}
+
+	code, err := auth.CreateInvite(a.DB, 0, maxUses)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(a.Stdout, code)
+	return nil
+}
+
+func expireSessions(a Admin, args []string) error {
+	if len(args) > 1 {
+		return errors.New("expected at most one argument: username")
+	}
+
+	var count int64
+	var err error
+	if len(args) == 0 {
+		count, err = sessions.DeleteAllSessions(a.DB)
+	} else {
+		var userID int
+		userID, err = auth.GetUserID(a.DB, args[0])
+		if err != nil {
+			return err
+		}
+		count, err = sessions.DeleteUserSessions(a.DB, userID)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(a.Stdout, "Deleted %d session(s).\n", count)
+	return nil
+}
+
+// Opens and closes every review DB, which runs pending migrations.
+func migrate(a Admin, args []string) error {
+	pattern := filepath.Join(basedir.StateDir, "users", "*", "reviews", "*.db")
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		return fmt.Errorf("failed to find review databases: %w", err)
+	}
+
+	var failed int
+	for _, path := range paths {
+		db, err := database.OpenReviewDB(path)
+		if err != nil {
+			fmt.Fprintf(a.Stdout, "%v: %v\n", path, err)
+			failed++
+			continue
+		}
+		db.Close()
+	}
+
+	fmt.Fprintf(a.Stdout, "Upgraded %d of %d review database(s).\n", len(paths)-failed, len(paths))
+	if failed > 0 {
+		return fmt.Errorf("failed to upgrade %d review database(s)", failed)
+	}
+	return nil
+}
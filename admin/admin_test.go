@@ -0,0 +1,243 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+package admin
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/polycloze/polycloze/auth"
+	"github.com/polycloze/polycloze/basedir"
+	"github.com/polycloze/polycloze/database"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "polycloze-admin-test")
+	if err != nil {
+		panic(err)
+	}
+	if err := basedir.SetStateDir(dir); err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func openDB() *sql.DB {
+	db, err := database.OpenAuthDB(":memory:")
+	if err != nil {
+		panic(err)
+	}
+	return db
+}
+
+// Runs admin command and returns its output.
+func run(db *sql.DB, stdin string, args ...string) (string, error) {
+	var stdout strings.Builder
+	a := Admin{
+		DB:     db,
+		Stdin:  strings.NewReader(stdin),
+		Stdout: &stdout,
+	}
+	err := a.Run(args)
+	return stdout.String(), err
+}
+
+func TestCreateUserAndResetPassword(t *testing.T) {
+	t.Parallel()
+	db := openDB()
+	defer db.Close()
+
+	if _, err := run(db, "hunter2\n", "create-user", "foo"); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if _, err := auth.Authenticate(db, "foo", "hunter2"); err != nil {
+		t.Fatal("expected user to be created:", err)
+	}
+	if _, err := run(db, "password\n", "create-user", "foo"); err == nil {
+		t.Fatal("expected duplicate username to be rejected")
+	}
+
+	if _, err := run(db, "correct horse\n", "reset-password", "foo"); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if _, err := auth.Authenticate(db, "foo", "correct horse"); err != nil {
+		t.Fatal("expected password to be changed:", err)
+	}
+}
+
+func TestCreateUserEmptyPassword(t *testing.T) {
+	t.Parallel()
+	db := openDB()
+	defer db.Close()
+
+	if _, err := run(db, "\n", "create-user", "foo"); err == nil {
+		t.Fatal("expected empty password to be rejected")
+	}
+}
+
+func TestListUsers(t *testing.T) {
+	t.Parallel()
+	db := openDB()
+	defer db.Close()
+
+	if err := auth.Register(db, "foo", "bar"); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	output, err := run(db, "", "users")
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if !strings.Contains(output, "foo") {
+		t.Fatal("expected user to be listed:", output)
+	}
+}
+
+func TestUnknownCommand(t *testing.T) {
+	t.Parallel()
+	db := openDB()
+	defer db.Close()
+
+	if _, err := run(db, "", "frobnicate"); err == nil {
+		t.Fatal("expected unknown command to be rejected")
+	}
+}
+
+func TestExpireSessions(t *testing.T) {
+	t.Parallel()
+	db := openDB()
+	defer db.Close()
+
+	if err := auth.Register(db, "foo", "bar"); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	query := `INSERT INTO user_session (session_id, user_id, username) VALUES (?, 1, 'foo')`
+	for _, id := range []string{"a", "b"} {
+		if _, err := db.Exec(query, id); err != nil {
+			t.Fatal("expected err to be nil:", err)
+		}
+	}
+
+	output, err := run(db, "", "expire-sessions", "foo")
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if !strings.Contains(output, "Deleted 2 session(s).") {
+		t.Fatal("expected sessions to be deleted:", output)
+	}
+}
+
+func TestDeleteUserAndMigrate(t *testing.T) {
+	// Not parallel, because it touches files in the state directory.
+	db := openDB()
+	defer db.Close()
+
+	for _, username := range []string{"foo", "bar"} {
+		if err := auth.Register(db, username, "password"); err != nil {
+			t.Fatal("expected err to be nil:", err)
+		}
+	}
+	for _, id := range []int{1, 2} {
+		path := basedir.Review(id, "eng", "spa")
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			t.Fatal("expected err to be nil:", err)
+		}
+		if err := os.WriteFile(path, nil, 0o600); err != nil {
+			t.Fatal("expected err to be nil:", err)
+		}
+	}
+
+	if _, err := run(db, "", "delete-user", "foo"); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if _, err := os.Stat(basedir.User(1)); !os.IsNotExist(err) {
+		t.Fatal("expected user files to be deleted:", err)
+	}
+
+	output, err := run(db, "", "migrate")
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if !strings.Contains(output, "Upgraded 1 of 1 review database(s).") {
+		t.Fatal("unexpected output:", output)
+	}
+}
+
+func TestGrantAndRevokeAdmin(t *testing.T) {
+	t.Parallel()
+	db := openDB()
+	defer db.Close()
+
+	if err := auth.Register(db, "foo", "bar"); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	userID, err := auth.GetUserID(db, "foo")
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	if _, err := run(db, "", "grant-admin", "foo"); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if isAdmin, err := auth.IsAdmin(db, userID); err != nil || !isAdmin {
+		t.Fatal("expected user to be an admin:", err)
+	}
+
+	if _, err := run(db, "", "revoke-admin", "foo"); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if isAdmin, err := auth.IsAdmin(db, userID); err != nil || isAdmin {
+		t.Fatal("expected user to not be an admin:", err)
+	}
+}
+
+func TestCreateInvite(t *testing.T) {
+	t.Parallel()
+	db := openDB()
+	defer db.Close()
+
+	out, err := run(db, "", "create-invite", "3")
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	code := strings.TrimSpace(out)
+	if err := auth.RegisterWithInvite(db, "foo", "password", code); err != nil {
+		t.Fatal("expected invite code to work:", err)
+	}
+
+	out, err = run(db, "", "invites")
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if !strings.Contains(out, code+"  1/3") {
+		t.Fatal("expected invite to be listed:", out)
+	}
+
+	if _, err := run(db, "", "create-invite", "0"); err == nil {
+		t.Fatal("expected invalid number of uses to be rejected")
+	}
+}
+
+func TestDisableTOTP(t *testing.T) {
+	t.Parallel()
+	db := openDB()
+	defer db.Close()
+
+	if err := auth.Register(db, "foo", "bar"); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if _, err := auth.SetupTOTP(db, 1); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if _, err := run(db, "", "disable-2fa", "foo"); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if secret, _ := auth.PendingTOTPSecret(db, 1); secret != "" {
+		t.Fatal("expected 2FA setup to be cleared:", secret)
+	}
+}
@@ -0,0 +1,195 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+// Data export and account deletion.
+package api
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/polycloze/polycloze/auth"
+	"github.com/polycloze/polycloze/basedir"
+	"github.com/polycloze/polycloze/logging"
+	"github.com/polycloze/polycloze/sessions"
+)
+
+// Returns paths of the user's review DBs.
+func reviewDBs(userID int) ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(basedir.User(userID), "reviews", "*.db"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list review DBs: %w", err)
+	}
+	return paths, nil
+}
+
+// Writes zip archive of files into w.
+// Files are stored in the "reviews" directory of the archive.
+func writeBundle(w io.Writer, paths []string) error {
+	zw := zip.NewWriter(w)
+	for _, path := range paths {
+		if err := addToBundle(zw, path); err != nil {
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("failed to write bundle: %w", err)
+	}
+	return nil
+}
+
+func addToBundle(zw *zip.Writer, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to add file to bundle: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to add file to bundle: %w", err)
+	}
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return fmt.Errorf("failed to add file to bundle: %w", err)
+	}
+	header.Name = "reviews/" + filepath.Base(path)
+	header.Method = zip.Deflate
+
+	dst, err := zw.CreateHeader(header)
+	if err != nil {
+		return fmt.Errorf("failed to add file to bundle: %w", err)
+	}
+	if _, err := io.Copy(dst, file); err != nil {
+		return fmt.Errorf("failed to add file to bundle: %w", err)
+	}
+	return nil
+}
+
+// Sends zip archive of all the user's review DBs.
+// The download gets cut off if the server has a write timeout, and the
+// download takes longer than that.
+func handleExportBundle(w http.ResponseWriter, r *http.Request) {
+	s, err := resumeSession(w, r, auth.ScopeImportExport)
+	if err != nil || !s.IsSignedIn() {
+		http.NotFound(w, r)
+		return
+	}
+
+	paths, err := reviewDBs(s.Data["userID"].(int))
+	if err != nil {
+		logging.Error(r, err)
+		internalError(w)
+		return
+	}
+
+	filename := fmt.Sprintf("polycloze-%v.zip", s.Data["username"])
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	// Too late to send an error status after the response has started.
+	if err := writeBundle(w, paths); err != nil {
+		logging.Error(r, err)
+	}
+}
+
+// Deletes the user's account and files.
+func deleteAccount(r *http.Request, userID int) error {
+	if err := auth.DeleteUser(auth.GetDB(r), userID); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(basedir.User(userID)); err != nil {
+		return fmt.Errorf("deleted account, but failed to delete user files: %w", err)
+	}
+	return nil
+}
+
+// How long users provisioned by single sign-on can delete their account
+// after signing in, without entering their password.
+const oidcReauthWindow = 10 * time.Minute
+
+// Checks if the user signed in with single sign-on recently.
+func recentOIDCSignIn(s *sessions.Session, now time.Time) bool {
+	since, ok := s.Data["oidcSignedIn"].(int64)
+	return ok && now.Sub(time.Unix(since, 0)) < oidcReauthWindow
+}
+
+// Checks if the user can delete their account without entering their
+// password.
+// Users provisioned by single sign-on don't know their password, so a recent
+// single sign-on counts instead.
+func canSkipPassword(r *http.Request, s *sessions.Session) (bool, error) {
+	if !recentOIDCSignIn(s, time.Now()) {
+		return false, nil
+	}
+	return auth.IsOIDCProvisioned(auth.GetDB(r), s.Data["userID"].(int))
+}
+
+// Deletes the signed-in user's account.
+// Requires the user's password (see canSkipPassword), except when a reverse
+// proxy handles authentication.
+func handleDeleteAccount(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "expected POST request", http.StatusBadRequest)
+		return
+	}
+
+	db := auth.GetDB(r)
+	s, err := sessions.ResumeSession(db, w, r)
+	if err != nil || !s.IsSignedIn() {
+		http.Redirect(w, r, "/signin", http.StatusSeeOther)
+		return
+	}
+	config := getConfig(r)
+	userID := s.Data["userID"].(int)
+	username := s.Data["username"].(string)
+
+	if !sessions.CheckCSRFToken(s.ID, r.FormValue("csrf-token")) {
+		_ = s.ErrorMessage("Something went wrong. Please try again.", "delete-account")
+		goto fail
+	}
+
+	if r.FormValue("confirm") != username {
+		_ = s.ErrorMessage("Incorrect confirmation string.", "delete-account")
+		goto fail
+	}
+
+	if config.ProxyAuthHeader == "" {
+		skip, err := canSkipPassword(r, s)
+		if err != nil {
+			logging.Error(r, err)
+		}
+		if !skip {
+			err := confirmPassword(w, r, username, r.FormValue("password"))
+			if err != nil {
+				_ = s.ErrorMessage(confirmPasswordMessage(err), "delete-account")
+				goto fail
+			}
+		}
+	}
+
+	if err := deleteAccount(r, userID); err != nil {
+		logging.Error(r, err)
+		_ = s.ErrorMessage("Something went wrong. Please try again.", "delete-account")
+		goto fail
+	}
+
+	// The session got deleted with the account.
+	if err := sessions.EndSession(db, w, r); err != nil {
+		logging.Error(r, err)
+	}
+	if next := config.ProxyAuthSignOutURL; next != "" {
+		http.Redirect(w, r, next, http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+	return
+
+fail:
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
@@ -0,0 +1,184 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/polycloze/polycloze/auth"
+	"github.com/polycloze/polycloze/basedir"
+	"github.com/polycloze/polycloze/sessions"
+)
+
+// Creates test server with a signed-in user.
+// Uses an unusual user ID, because tests share the state directory.
+// "/csrf" responds with the session's CSRF token.
+func accountServer(t *testing.T, db *sql.DB, userID int) *httptest.Server {
+	if err := auth.Register(db, "foo", "password"); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if _, err := db.Exec(`UPDATE user SET id = ?`, userID); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	r := chi.NewRouter()
+	r.Use(configMiddleware(DefaultConfig()))
+	r.Use(auth.Middleware(db))
+	r.HandleFunc("/signin", handleSignIn)
+	r.HandleFunc("/settings/export", handleExportBundle)
+	r.HandleFunc("/settings/delete-account", handleDeleteAccount)
+	r.HandleFunc("/csrf", func(w http.ResponseWriter, r *http.Request) {
+		s, err := sessions.ResumeSession(db, w, r)
+		if err == nil && s.IsSignedIn() {
+			fmt.Fprint(w, sessions.CSRFToken(s.ID))
+		}
+	})
+	ts := serverWithJar(r)
+
+	v := url.Values{}
+	v.Set("username", "foo")
+	v.Set("password", "password")
+	v.Set("csrf-token", pageCSRFToken(t, ts, "/signin"))
+	submit(t, ts, "/signin", v)
+	return ts
+}
+
+func TestExportBundle(t *testing.T) {
+	t.Parallel()
+
+	db := testDB()
+	defer db.Close()
+	ts := accountServer(t, db, 4401)
+	defer ts.Close()
+
+	path := basedir.Review(4401, "eng", "spa")
+	if err := os.WriteFile(path, []byte("reviews"), 0o600); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	body := get(t, ts, "/settings/export")
+	zr, err := zip.NewReader(bytes.NewReader([]byte(body)), int64(len(body)))
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if len(zr.File) != 1 || zr.File[0].Name != "reviews/eng-spa.db" {
+		t.Fatal("expected bundle to contain review DB:", zr.File)
+	}
+
+	file, err := zr.File[0].Open()
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	defer file.Close()
+	if contents, _ := io.ReadAll(file); string(contents) != "reviews" {
+		t.Fatal("expected file contents to be exported:", string(contents))
+	}
+}
+
+func TestDeleteAccount(t *testing.T) {
+	t.Parallel()
+
+	db := testDB()
+	defer db.Close()
+	ts := accountServer(t, db, 4402)
+	defer ts.Close()
+
+	if _, err := os.Stat(basedir.User(4402)); err != nil {
+		t.Fatal("expected user directory to exist:", err)
+	}
+
+	v := url.Values{}
+	v.Set("confirm", "foo")
+	v.Set("password", "wrong")
+	v.Set("csrf-token", get(t, ts, "/csrf"))
+	submit(t, ts, "/settings/delete-account", v)
+	if _, err := auth.GetUserID(db, "foo"); err != nil {
+		t.Fatal("expected wrong password to be rejected:", err)
+	}
+
+	v.Set("password", "password")
+	submit(t, ts, "/settings/delete-account", v)
+	if _, err := auth.GetUserID(db, "foo"); err == nil {
+		t.Fatal("expected user to be deleted")
+	}
+	if _, err := os.Stat(basedir.User(4402)); !os.IsNotExist(err) {
+		t.Fatal("expected user directory to be deleted:", err)
+	}
+	if token := get(t, ts, "/csrf"); token != "" {
+		t.Fatal("expected user to be signed out")
+	}
+
+	var count int
+	query := `SELECT count(*) FROM user_session WHERE user_id = 4402`
+	if err := db.QueryRow(query).Scan(&count); err != nil || count > 0 {
+		t.Fatal("expected sessions to be deleted:", count, err)
+	}
+}
+
+func TestDeleteAccountRequiresPassword(t *testing.T) {
+	// A 2FA code isn't enough.
+	t.Parallel()
+
+	db := testDB()
+	defer db.Close()
+	ts := accountServer(t, db, 4403)
+	defer ts.Close()
+
+	secret, err := auth.SetupTOTP(db, 4403)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	enrolled := time.Now().Add(-time.Minute)
+	if _, err := auth.EnableTOTP(db, 4403, totpCode(secret, enrolled), enrolled); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	v := url.Values{}
+	v.Set("confirm", "foo")
+	v.Set("code", totpCode(secret, time.Now()))
+	v.Set("csrf-token", get(t, ts, "/csrf"))
+	submit(t, ts, "/settings/delete-account", v)
+	if _, err := auth.GetUserID(db, "foo"); err != nil {
+		t.Fatal("expected user to not be deleted without password:", err)
+	}
+}
+
+func TestDeleteAccountThrottled(t *testing.T) {
+	// Password guesses get throttled like sign-in attempts.
+	t.Parallel()
+
+	db := testDB()
+	defer db.Close()
+	ts := accountServer(t, db, 4404)
+	defer ts.Close()
+	defer os.RemoveAll(basedir.User(4404))
+
+	for i := 0; i < auth.UsernameThrottle.MaxAttempts; i++ {
+		attempt := auth.SignInAttempt{Username: "foo", IP: "192.0.2.1", Time: time.Now()}
+		if err := auth.RecordSignInAttempt(db, attempt); err != nil {
+			t.Fatal("expected err to be nil:", err)
+		}
+	}
+
+	v := url.Values{}
+	v.Set("confirm", "foo")
+	v.Set("password", "password")
+	v.Set("csrf-token", get(t, ts, "/csrf"))
+	submit(t, ts, "/settings/delete-account", v)
+	if _, err := auth.GetUserID(db, "foo"); err != nil {
+		t.Fatal("expected throttled attempt to be rejected:", err)
+	}
+}
@@ -5,13 +5,10 @@ package api
 
 import (
 	"io"
-	"log"
 	"net/http"
 
 	"github.com/polycloze/polycloze/auth"
-	"github.com/polycloze/polycloze/basedir"
-	"github.com/polycloze/polycloze/database"
-	"github.com/polycloze/polycloze/sessions"
+	"github.com/polycloze/polycloze/logging"
 )
 
 func handleSetCourse(w http.ResponseWriter, r *http.Request) {
@@ -22,8 +19,7 @@ func handleSetCourse(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Sign in.
-	db := auth.GetDB(r)
-	s, err := sessions.ResumeSession(db, w, r)
+	s, err := resumeSession(w, r, auth.ScopeSubmitReviews)
 	if err != nil || !s.IsSignedIn() {
 		http.Error(w, "Unauthorized.", http.StatusUnauthorized)
 		return
@@ -32,7 +28,7 @@ func handleSetCourse(w http.ResponseWriter, r *http.Request) {
 	// Read request data.
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		log.Println(err)
+		logging.Error(r, err)
 		http.Error(w, "Could not read request.", http.StatusInternalServerError)
 		return
 	}
@@ -50,25 +46,16 @@ func handleSetCourse(w http.ResponseWriter, r *http.Request) {
 
 	// Check csrf token.
 	token := r.Header.Get("X-CSRF-Token")
-	if !sessions.CheckCSRFToken(s.ID, token) {
+	if !s.CheckCSRFToken(token) {
 		http.Error(w, "Forbidden.", http.StatusForbidden)
 		return
 	}
 
-	// Open user data DB.
-	userID := s.Data["userID"].(int)
-	db, err = database.OpenUserDB(basedir.UserData(userID))
-	if err != nil {
-		log.Println(err)
-		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
-		return
-	}
-	defer db.Close()
-
 	// Set active course.
-	if err := setActiveCourse(db, userID, data.L1Code, data.L2Code); err != nil {
-		log.Println(err)
-		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+	userID := s.Data["userID"].(int)
+	if err := saveActiveCourse(userID, data.L1Code, data.L2Code); err != nil {
+		logging.Error(r, err)
+		internalError(w)
 		return
 	}
 
@@ -0,0 +1,312 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+// Admin dashboard.
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/polycloze/polycloze/auth"
+	"github.com/polycloze/polycloze/basedir"
+	"github.com/polycloze/polycloze/logging"
+	"github.com/polycloze/polycloze/sessions"
+)
+
+// Max number of sign-in attempts shown on the dashboard.
+const recentSignInAttemptsLimit = 50
+
+// User info shown on the admin dashboard.
+type userSummary struct {
+	auth.User
+
+	ActiveCourse string   // e.g. "eng-spa"; empty if there's none
+	Courses      []string // Courses with review data
+	Storage      string   // Human-readable size of user files
+}
+
+// Resumes session of signed-in admin.
+// Responds with 404 if the user isn't an admin, so that the admin area doesn't
+// reveal itself.
+// Returns false if the caller shouldn't continue handling the request.
+func resumeAdminSession(w http.ResponseWriter, r *http.Request) (*sessions.Session, bool) {
+	db := auth.GetDB(r)
+	s, err := sessions.ResumeSession(db, w, r)
+	if err != nil || !s.IsSignedIn() {
+		http.Redirect(w, r, "/signin", http.StatusTemporaryRedirect)
+		return nil, false
+	}
+
+	isAdmin, err := auth.IsAdmin(db, s.Data["userID"].(int))
+	if err != nil {
+		logging.Error(r, err)
+		internalError(w)
+		return nil, false
+	}
+	if !isAdmin {
+		http.NotFound(w, r)
+		return nil, false
+	}
+	return s, true
+}
+
+// Returns total size of files in the directory.
+// Returns 0 if the directory doesn't exist.
+func directorySize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to compute directory size: %w", err)
+	}
+	return size, nil
+}
+
+// Formats size in bytes, e.g. "1.5 MB".
+func formatSize(size int64) string {
+	const unit = 1000
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	value := float64(size)
+	for _, prefix := range []string{"kB", "MB", "GB"} {
+		value /= unit
+		if value < unit || prefix == "GB" {
+			return fmt.Sprintf("%.1f %s", value, prefix)
+		}
+	}
+	panic("unreachable")
+}
+
+// Returns courses the user has review data for.
+func studiedCourses(userID int) []string {
+	matches, _ := filepath.Glob(filepath.Join(basedir.User(userID), "reviews", "*.db"))
+
+	var courses []string
+	for _, match := range matches {
+		courses = append(courses, strings.TrimSuffix(filepath.Base(match), ".db"))
+	}
+	return courses
+}
+
+func summarizeUser(user auth.User) (userSummary, error) {
+	summary := userSummary{
+		User:    user,
+		Courses: studiedCourses(user.ID),
+	}
+
+	// Don't create user.db for users that haven't signed in.
+	if _, err := os.Stat(basedir.UserData(user.ID)); err == nil {
+		course, err := getUserActiveCourse(user.ID)
+		if err == nil {
+			summary.ActiveCourse = course.L1.Code + "-" + course.L2.Code
+		}
+	}
+
+	size, err := directorySize(basedir.User(user.ID))
+	if err != nil {
+		return summary, err
+	}
+	summary.Storage = formatSize(size)
+	return summary, nil
+}
+
+func handleAdmin(w http.ResponseWriter, r *http.Request) {
+	s, ok := resumeAdminSession(w, r)
+	if !ok {
+		return
+	}
+	renderAdmin(w, r, s)
+}
+
+func renderAdmin(w http.ResponseWriter, r *http.Request, s *sessions.Session) {
+	db := auth.GetDB(r)
+	users, err := auth.ListUsers(db)
+	if err != nil {
+		logging.Error(r, err)
+		internalError(w)
+		return
+	}
+
+	var summaries []userSummary
+	for _, user := range users {
+		summary, err := summarizeUser(user)
+		if err != nil {
+			logging.Error(r, err)
+		}
+		summaries = append(summaries, summary)
+	}
+
+	attempts, err := auth.RecentSignInAttempts(db, recentSignInAttemptsLimit)
+	if err != nil {
+		logging.Error(r, err)
+		internalError(w)
+		return
+	}
+
+	invites, err := auth.ListInvites(db)
+	if err != nil {
+		logging.Error(r, err)
+		internalError(w)
+		return
+	}
+
+	s.Data["courses"] = installedCourses()
+	s.Data["rejectedCourses"] = rejectedCourses()
+	s.Data["users"] = summaries
+	s.Data["signInAttempts"] = attempts
+	s.Data["invites"] = invites
+	s.Data["adminMessages"], _ = s.Messages("admin")
+	s.Data["inviteMessages"], _ = s.Messages("admin-invites")
+	s.Data["courseMessages"], _ = s.Messages("admin-courses")
+	s.Data["csrfToken"] = sessions.CSRFToken(s.ID)
+	renderTemplate(w, "admin.html", s.Data)
+}
+
+// Disables or enables user account.
+// Disabled users get signed out.
+func handleSetUserDisabled(disabled bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "expected POST request", http.StatusBadRequest)
+			return
+		}
+
+		s, ok := resumeAdminSession(w, r)
+		if !ok {
+			return
+		}
+
+		db := auth.GetDB(r)
+		if !sessions.CheckCSRFToken(s.ID, r.FormValue("csrf-token")) {
+			_ = s.ErrorMessage("Something went wrong. Please try again.", "admin")
+			goto done
+		}
+
+		if userID, err := strconv.Atoi(r.FormValue("user-id")); err != nil {
+			_ = s.ErrorMessage("Invalid user.", "admin")
+		} else if userID == s.Data["userID"].(int) {
+			_ = s.ErrorMessage("You can't disable your own account.", "admin")
+		} else if err := setUserDisabled(db, userID, disabled); err != nil {
+			logging.Error(r, err)
+			_ = s.ErrorMessage("Something went wrong. Please try again.", "admin")
+		} else if disabled {
+			_ = s.SuccessMessage("Account disabled.", "admin")
+		} else {
+			_ = s.SuccessMessage("Account enabled.", "admin")
+		}
+
+	done:
+		http.Redirect(w, r, "/admin", http.StatusSeeOther)
+	}
+}
+
+func setUserDisabled(db *sql.DB, userID int, disabled bool) error {
+	if err := auth.SetDisabled(db, userID, disabled); err != nil {
+		return err
+	}
+	if !disabled {
+		return nil
+	}
+	_, err := sessions.DeleteUserSessions(db, userID)
+	return err
+}
+
+// Creates invite code.
+func handleCreateInvite(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "expected POST request", http.StatusBadRequest)
+		return
+	}
+
+	s, ok := resumeAdminSession(w, r)
+	if !ok {
+		return
+	}
+
+	if !sessions.CheckCSRFToken(s.ID, r.FormValue("csrf-token")) {
+		_ = s.ErrorMessage("Something went wrong. Please try again.", "admin-invites")
+	} else if maxUses, err := strconv.Atoi(r.FormValue("max-uses")); err != nil || maxUses < 1 {
+		_ = s.ErrorMessage("Number of uses must be a positive number.", "admin-invites")
+	} else if code, err := auth.CreateInvite(auth.GetDB(r), s.Data["userID"].(int), maxUses); err != nil {
+		logging.Error(r, err)
+		_ = s.ErrorMessage("Something went wrong. Please try again.", "admin-invites")
+	} else {
+		_ = s.SuccessMessage("Invite code created: "+code, "admin-invites")
+	}
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// Deletes invite code.
+func handleDeleteInvite(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "expected POST request", http.StatusBadRequest)
+		return
+	}
+
+	s, ok := resumeAdminSession(w, r)
+	if !ok {
+		return
+	}
+
+	if !sessions.CheckCSRFToken(s.ID, r.FormValue("csrf-token")) {
+		_ = s.ErrorMessage("Something went wrong. Please try again.", "admin-invites")
+	} else if inviteID, err := strconv.Atoi(r.FormValue("invite-id")); err != nil {
+		_ = s.ErrorMessage("Invalid invite.", "admin-invites")
+	} else if err := auth.DeleteInvite(auth.GetDB(r), inviteID); err != nil {
+		logging.Error(r, err)
+		_ = s.ErrorMessage("Something went wrong. Please try again.", "admin-invites")
+	} else {
+		_ = s.SuccessMessage("Invite code deleted.", "admin-invites")
+	}
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// Reloads courses, so that new and updated courses get served without a
+// restart.
+func handleReloadCourses(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "expected POST request", http.StatusBadRequest)
+		return
+	}
+
+	s, ok := resumeAdminSession(w, r)
+	if !ok {
+		return
+	}
+
+	if !sessions.CheckCSRFToken(s.ID, r.FormValue("csrf-token")) {
+		_ = s.ErrorMessage("Something went wrong. Please try again.", "admin-courses")
+	} else if changes, err := ReloadCourses(); err != nil {
+		logging.Error(r, err)
+		_ = s.ErrorMessage("Something went wrong. Please try again.", "admin-courses")
+	} else if len(changes.Rejected) > 0 {
+		_ = s.ErrorMessage("Reloaded courses: "+changes.String()+".", "admin-courses")
+	} else {
+		_ = s.SuccessMessage("Reloaded courses: "+changes.String()+".", "admin-courses")
+	}
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
@@ -0,0 +1,51 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/polycloze/polycloze/auth"
+)
+
+func TestAdminRequiresSignIn(t *testing.T) {
+	t.Parallel()
+
+	db := testDB()
+	defer db.Close()
+
+	r := chi.NewRouter()
+	r.Use(auth.Middleware(db))
+	r.HandleFunc("/admin", handleAdmin)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/admin", nil))
+	if w.Code != http.StatusTemporaryRedirect {
+		t.Fatal("expected redirect:", w.Code)
+	}
+	if location := w.Header().Get("Location"); location != "/signin" {
+		t.Fatal("expected redirect to /signin:", location)
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	t.Parallel()
+
+	cases := map[int64]string{
+		0:          "0 B",
+		999:        "999 B",
+		1500:       "1.5 kB",
+		2500000:    "2.5 MB",
+		3000000000: "3.0 GB",
+	}
+	for size, expected := range cases {
+		if actual := formatSize(size); actual != expected {
+			t.Fatalf("expected formatSize(%d) to be %q: %q", size, expected, actual)
+		}
+	}
+}
@@ -5,13 +5,14 @@ package api
 
 import (
 	"database/sql"
-	"log"
+	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/go-chi/chi/v5"
-	"github.com/go-chi/chi/v5/middleware"
 
 	"github.com/polycloze/polycloze/auth"
+	"github.com/polycloze/polycloze/logging"
 	"github.com/polycloze/polycloze/sessions"
 )
 
@@ -19,11 +20,22 @@ import (
 func cors(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type")
+		w.Header().Set("Access-Control-Allow-Headers", "Accept, Authorization, Content-Type")
 		next.ServeHTTP(w, r)
 	})
 }
 
+// Tells browsers to only use HTTPS for the next maxAge.
+func hsts(maxAge time.Duration) func(http.Handler) http.Handler {
+	value := fmt.Sprintf("max-age=%d", int64(maxAge.Seconds()))
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Strict-Transport-Security", value)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 func handleHome(w http.ResponseWriter, r *http.Request) {
 	// Check if user is signed in.
 	db := auth.GetDB(r)
@@ -38,12 +50,13 @@ func handleHome(w http.ResponseWriter, r *http.Request) {
 	userID := s.Data["userID"].(int)
 	course, err := getUserActiveCourse(userID)
 	if err != nil {
-		log.Println(err)
-		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		logging.Error(r, err)
+		internalError(w)
 		return
 	}
 	s.Data["course"] = course
 	s.Data["csrfToken"] = sessions.CSRFToken(s.ID)
+	s.Data["guest"] = isGuest(r, s)
 	renderTemplate(w, "home.html", s.Data)
 }
 
@@ -52,14 +65,16 @@ func handleAbout(w http.ResponseWriter, r *http.Request) {
 	db := auth.GetDB(r)
 	if s, err := sessions.StartOrResumeSession(db, w, r); err == nil {
 		data = s.Data
+		data["csrfToken"] = sessions.CSRFToken(s.ID)
+		data["canStudyAsGuest"] = guestsEnabled(r) && !s.IsSignedIn()
 
 		if s.IsSignedIn() {
 			// Get active course.
 			userID := data["userID"].(int)
 			course, err := getUserActiveCourse(userID)
 			if err != nil {
-				log.Println(err)
-				http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+				logging.Error(r, err)
+				internalError(w)
 				return
 			}
 			data["course"] = course
@@ -80,8 +95,8 @@ func handleStudy(w http.ResponseWriter, r *http.Request) {
 	userID := s.Data["userID"].(int)
 	course, err := getUserActiveCourse(userID)
 	if err != nil {
-		log.Println(err)
-		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		logging.Error(r, err)
+		internalError(w)
 		return
 	}
 
@@ -102,8 +117,8 @@ func handleListen(w http.ResponseWriter, r *http.Request) {
 	userID := s.Data["userID"].(int)
 	course, err := getUserActiveCourse(userID)
 	if err != nil {
-		log.Println(err)
-		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		logging.Error(r, err)
+		internalError(w)
 		return
 	}
 
@@ -124,8 +139,8 @@ func handleVocabularyPage(w http.ResponseWriter, r *http.Request) {
 	userID := s.Data["userID"].(int)
 	course, err := getUserActiveCourse(userID)
 	if err != nil {
-		log.Println(err)
-		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		logging.Error(r, err)
+		internalError(w)
 		return
 	}
 
@@ -140,8 +155,23 @@ func Router(config Config, db *sql.DB) (chi.Router, error) {
 	if config.AllowCORS {
 		r.Use(cors)
 	}
-	r.Use(middleware.Logger)
+	if config.HSTSMaxAge > 0 {
+		r.Use(hsts(config.HSTSMaxAge))
+	}
+	r.Use(logging.Middleware)
+	r.Use(recoverer)
+	r.Use(instrument)
+	r.Use(configMiddleware(config))
+	r.Use(recordClientIP)
 	r.Use(auth.Middleware(db))
+	r.Use(auth.TokenMiddlewareFunc(invalidToken))
+	r.Use(auth.ProxyMiddleware(config.ProxyAuthHeader, fromTrustedProxy))
+
+	r.HandleFunc("/healthz", handleHealthz)
+	r.HandleFunc("/readyz", handleReadyz(db))
+	if config.ServeMetrics {
+		r.Handle("/metrics", MetricsHandler(db))
+	}
 
 	r.HandleFunc("/", handleHome)
 	r.HandleFunc("/study", handleStudy)
@@ -149,11 +179,20 @@ func Router(config Config, db *sql.DB) (chi.Router, error) {
 	r.HandleFunc("/vocab", handleVocabularyPage)
 	r.HandleFunc("/about", handleAbout)
 	r.HandleFunc("/welcome", handleWelcome)
-	r.HandleFunc("/settings", handleSettings)
+	r.HandleFunc("/settings/export", handleExportBundle)
+	r.HandleFunc("/admin", handleAdmin)
+	r.HandleFunc("/admin/users/disable", handleSetUserDisabled(true))
+	r.HandleFunc("/admin/users/enable", handleSetUserDisabled(false))
+	r.HandleFunc("/admin/invites", handleCreateInvite)
+	r.HandleFunc("/admin/invites/delete", handleDeleteInvite)
+	r.HandleFunc("/admin/courses/reload", handleReloadCourses)
 
-	r.HandleFunc("/register", handleRegister)
 	r.HandleFunc("/signin", handleSignIn)
+	r.HandleFunc("/signin/2fa", handleSignIn2FA)
+	r.HandleFunc("/signin/oidc", handleOIDCSignIn)
+	r.HandleFunc("/signin/oidc/callback", handleOIDCCallback)
 	r.HandleFunc("/signout", handleSignOut)
+	r.HandleFunc("/guest", handleGuest)
 
 	r.Handle("/dist/*", http.StripPrefix("/dist/", serveDist()))
 	r.Handle("/public/*", http.StripPrefix("/public/", servePublic()))
@@ -165,6 +204,8 @@ func Router(config Config, db *sql.DB) (chi.Router, error) {
 	r.Handle("/serviceworker.js*", http.StripPrefix("/", serveDist()))
 	r.Handle("/robots.txt", http.StripPrefix("/", servePublic()))
 
+	r.Mount("/api/v1", v1Router())
+
 	r.HandleFunc("/api/sentences", handleSentences)
 
 	r.HandleFunc("/api/flashcards/{l1}/{l2}", handleFlashcards)
@@ -172,12 +213,32 @@ func Router(config Config, db *sql.DB) (chi.Router, error) {
 	r.HandleFunc("/api/stats/activity/{l1}/{l2}", handleStatsActivity)
 	r.HandleFunc("/api/stats/vocab/{l1}/{l2}", handleStatsVocab)
 	r.HandleFunc("/api/stats/estimate/{l1}/{l2}", handleStatsEstimatedLevel)
+	r.HandleFunc("/api/events/{l1}/{l2}", handleEvents)
 
 	r.HandleFunc("/api/languages", serveLanguagesJSON())
 	r.HandleFunc("/api/courses", serveCoursesJSON())
 
 	r.HandleFunc("/api/actions/set-course", handleSetCourse)
-	r.HandleFunc("/api/settings/upload/{l1}/{l2}", handleUpload)
-	r.HandleFunc("/api/settings/reset/{l1}/{l2}", handleResetProgress)
+
+	// Demo visitors share accounts, so they can't change them.
+	r.Group(func(r chi.Router) {
+		r.Use(blockInDemo)
+		r.HandleFunc("/settings", handleSettings)
+		r.HandleFunc("/settings/tokens", handleCreateToken)
+		r.HandleFunc("/settings/tokens/revoke", handleRevokeToken)
+		r.HandleFunc("/settings/sessions/revoke", handleRevokeSession)
+		r.HandleFunc("/settings/sessions/revoke-others", handleRevokeOtherSessions)
+		r.HandleFunc("/settings/2fa/setup", handleSetupTOTP)
+		r.HandleFunc("/settings/2fa/enable", handleEnableTOTP)
+		r.HandleFunc("/settings/2fa/disable", handleDisableTOTP)
+		r.HandleFunc("/settings/oidc/link", handleLinkOIDC)
+		r.HandleFunc("/settings/email", handleChangeEmail)
+		r.HandleFunc("/settings/delete-account", handleDeleteAccount)
+		r.HandleFunc("/register", handleRegister)
+		r.HandleFunc("/reset-password", handleResetPassword)
+		r.HandleFunc("/reset-password/confirm", handleResetPasswordConfirm)
+		r.HandleFunc("/api/settings/upload/{l1}/{l2}", handleUpload)
+		r.HandleFunc("/api/settings/reset/{l1}/{l2}", handleResetProgress)
+	})
 	return r, nil
 }
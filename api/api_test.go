@@ -0,0 +1,153 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/polycloze/polycloze/logging"
+)
+
+func TestHSTS(t *testing.T) {
+	t.Parallel()
+	handler := hsts(24 * time.Hour)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if value := w.Header().Get("Strict-Transport-Security"); value != "max-age=86400" {
+		t.Fatal("unexpected Strict-Transport-Security header:", value)
+	}
+}
+
+func TestRecoverer(t *testing.T) {
+	// Panics should become error responses.
+	t.Parallel()
+	handler := recoverer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("bad course data")
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Fatal("expected internal server error:", w.Code)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	// Request latencies should be recorded per route.
+	t.Parallel()
+	db := testDB()
+	defer db.Close()
+
+	config := DefaultConfig()
+	config.ServeMetrics = true
+	r, err := Router(config, db)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	if _, err := ts.Client().Get(resolve(ts, "/api/vocabulary/xxx/yyy")); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	resp, err := ts.Client().Get(resolve(ts, "/metrics"))
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	for _, s := range []string{
+		`route="/api/vocabulary/{l1}/{l2}"`,
+		"polycloze_active_sessions 0",
+		"polycloze_reviews_processed_total",
+	} {
+		if !strings.Contains(string(body), s) {
+			t.Fatalf("expected %q in metrics:\n%s", s, body)
+		}
+	}
+}
+
+func TestErrorResponsesIncludeRequestID(t *testing.T) {
+	t.Parallel()
+	handler := logging.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/json" {
+			sendInternalError(w)
+		} else {
+			internalError(w)
+		}
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/text", nil))
+	id := w.Header().Get(logging.RequestIDHeader)
+	if id == "" || !strings.Contains(w.Body.String(), id) {
+		t.Fatal("expected request ID in error response:", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/json", nil))
+	var body ErrorResponse
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if body.Error.RequestID != w.Header().Get(logging.RequestIDHeader) {
+		t.Fatal("expected request ID in JSON error response:", body)
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	t.Parallel()
+	w := httptest.NewRecorder()
+	handleHealthz(w, httptest.NewRequest("GET", "/healthz", nil))
+	if w.Code != http.StatusOK {
+		t.Fatal("expected status OK:", w.Code)
+	}
+}
+
+func TestReadyzReportsFailedChecks(t *testing.T) {
+	// There are no installed courses in the test environment.
+	t.Parallel()
+	db := testDB()
+	defer db.Close()
+
+	w := httptest.NewRecorder()
+	handleReadyz(db)(w, httptest.NewRequest("GET", "/readyz", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatal("expected service to be unavailable:", w.Code)
+	}
+
+	var body ReadinessResponse
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if body.Status != "unavailable" || body.Checks["authDB"] != "ok" {
+		t.Fatal("unexpected readiness response:", body)
+	}
+	if body.Checks["courses"] == "ok" {
+		t.Fatal("expected courses check to fail:", body)
+	}
+}
+
+func TestCheckWritable(t *testing.T) {
+	t.Parallel()
+	if err := checkWritable(t.TempDir()); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if err := checkWritable(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Fatal("expected missing directory to be unwritable")
+	}
+}
@@ -5,43 +5,102 @@
 package api
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
 	"os"
 	"path"
+	"strconv"
+	"time"
 
 	"github.com/polycloze/polycloze/auth"
 	"github.com/polycloze/polycloze/basedir"
+	"github.com/polycloze/polycloze/config"
+	"github.com/polycloze/polycloze/logging"
 	"github.com/polycloze/polycloze/sessions"
 )
 
+// Checks if new users can't register.
+func registrationClosed(r *http.Request) bool {
+	c := getConfig(r)
+	return c.Registration == config.RegistrationClosed || c.ProxyAuthHeader != "" || c.Demo != ""
+}
+
+// Registers user.
+// If the session's user is a guest, turns the guest into a registered user
+// instead, so that they keep their progress.
+// Requires a valid invite code if registration is invite-only.
+func register(r *http.Request, s *sessions.Session, username, password string) error {
+	db := auth.GetDB(r)
+	invite := getConfig(r).Registration == config.RegistrationInvite
+	code := r.FormValue("invite-code")
+	if s.IsSignedIn() {
+		userID := s.Data["userID"].(int)
+		if invite {
+			return auth.ConvertGuestWithInvite(db, userID, username, password, code)
+		}
+		return auth.ConvertGuest(db, userID, username, password)
+	}
+	if invite {
+		return auth.RegisterWithInvite(db, username, password, code)
+	}
+	return auth.Register(db, username, password)
+}
+
 // HandlerFunc for user registrations.
 func handleRegister(w http.ResponseWriter, r *http.Request) {
 	// Redirect to home page if already signed in.
 	db := auth.GetDB(r)
 	s, err := sessions.StartOrResumeSession(db, w, r)
 	if err != nil {
-		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		internalError(w)
 		return
 	}
-	if s.IsSignedIn() {
+	// Guests can register to keep their progress.
+	if s.IsSignedIn() && !isGuest(r, s) {
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 		return
 	}
+	if registrationClosed(r) {
+		goto fail
+	}
 	if r.Method == "POST" {
 		username := r.FormValue("username")
 		password := r.FormValue("password")
 		csrfToken := r.FormValue("csrf-token")
+		minLength := getConfig(r).MinPasswordLength
 
 		if !sessions.CheckCSRFToken(s.ID, csrfToken) {
 			_ = s.ErrorMessage("Something went wrong. Please try again.", "register")
 			goto fail
 		}
-		if auth.Register(db, username, password) == nil {
+		if err := auth.CheckPassword(username, password, minLength); err != nil {
+			_ = s.ErrorMessage(auth.PasswordMessage(err, minLength), "register")
+			goto fail
+		}
+
+		err := register(r, s, username, password)
+		if err == nil && s.IsSignedIn() {
+			s.Data["username"] = username
+			if err := sessions.SaveData(db, s); err != nil {
+				logging.Error(r, err)
+			}
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+		if err == nil {
 			// `StatusTemporaryRedirect` also resends POST data to the next page.
 			http.Redirect(w, r, "/signin", http.StatusTemporaryRedirect)
 			return
 		}
+		if errors.Is(err, auth.ErrInvalidInvite) {
+			_ = s.ErrorMessage(
+				"This invite code is invalid or has already been used.",
+				"register",
+			)
+			goto fail
+		}
 		_ = s.ErrorMessage(
 			"This username is unavailable. Try another one.",
 			"register",
@@ -50,9 +109,20 @@ func handleRegister(w http.ResponseWriter, r *http.Request) {
 
 fail:
 	messages, _ := s.Messages("register")
+	mode := getConfig(r).Registration
 	data := map[string]any{
-		"csrfToken": sessions.CSRFToken(s.ID),
-		"messages":  messages,
+		"csrfToken":          sessions.CSRFToken(s.ID),
+		"messages":           messages,
+		"registrationClosed": registrationClosed(r),
+		"inviteRequired":     mode == config.RegistrationInvite,
+		"minPasswordLength":  getConfig(r).MinPasswordLength,
+		"guest":              s.IsSignedIn(),
+
+		// Invite links look like /register?invite=CODE.
+		"inviteCode": r.FormValue("invite"),
+	}
+	if code := r.FormValue("invite-code"); code != "" {
+		data["inviteCode"] = code
 	}
 	renderTemplate(w, "register.html", data)
 }
@@ -62,12 +132,28 @@ func handleSignIn(w http.ResponseWriter, r *http.Request) {
 	db := auth.GetDB(r)
 	s, err := sessions.StartOrResumeSession(db, w, r)
 	if err != nil {
-		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		internalError(w)
 		return
 	}
 
 	var messages []sessions.Message
-	if s.IsSignedIn() {
+	if !needsSignIn(r, s) {
+		goto success
+	}
+
+	// The reverse proxy signs in users instead.
+	if getConfig(r).ProxyAuthHeader != "" {
+		goto fail
+	}
+
+	// Demo visitors don't need an account.
+	// Admins can still sign in with a password at /signin?password.
+	if demoEnabled(r) && r.Method != "POST" && !r.URL.Query().Has("password") {
+		if err := signInDemo(r, s); err != nil {
+			logging.Error(r, err)
+			internalError(w)
+			return
+		}
 		goto success
 	}
 
@@ -80,37 +166,214 @@ func handleSignIn(w http.ResponseWriter, r *http.Request) {
 			_ = s.ErrorMessage("Authentication failed.", "sign-in")
 			goto fail
 		}
+		ip := clientIP(r)
+		logging.Set(r.Context(), "clientIP", ip)
+
+		// Held until the attempt gets recorded.
+		unlock := auth.LockSignIn(username, ip)
+		defer unlock()
+
+		now := time.Now()
+		wait, err := auth.SignInWait(db, username, ip, now)
+		if err != nil {
+			logging.Error(r, err)
+		}
+		if wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			_ = s.ErrorMessage(
+				"Too many failed sign-in attempts. Try again in "+formatWait(wait)+".",
+				"sign-in",
+			)
+			goto fail
+		}
+
 		userID, err := auth.Authenticate(db, username, password)
+		var hasTOTP bool
+		if err == nil {
+			hasTOTP, err = auth.HasTOTP(db, userID)
+		}
+
+		// With 2FA, the attempt gets recorded after the second step.
+		if err != nil || !hasTOTP {
+			attempt := auth.SignInAttempt{
+				Username: username,
+				IP:       ip,
+				Success:  err == nil,
+				Time:     now,
+			}
+			if err := auth.RecordSignInAttempt(db, attempt); err != nil {
+				logging.Error(r, err)
+			}
+		}
+		if errors.Is(err, auth.ErrDisabled) {
+			_ = s.ErrorMessage("This account has been disabled.", "sign-in")
+			goto fail
+		}
 		if err != nil {
 			_ = s.ErrorMessage("Incorrect username or password.", "sign-in")
 			goto fail
 		}
 
-		s.Data["userID"] = userID
-		s.Data["username"] = username
-		if sessions.SaveData(db, s) != nil {
+		if hasTOTP {
+			s.Data["pendingUserID"] = userID
+			s.Data["pendingSince"] = now.Unix()
+			if sessions.SaveData(db, s) != nil {
+				_ = s.ErrorMessage("Authentication failed.", "sign-in")
+				goto fail
+			}
+			next := "/signin/2fa"
+			if r.FormValue("remember") != "" {
+				next += "?remember=1"
+			}
+			http.Redirect(w, r, next, http.StatusSeeOther)
+			return
+		}
+
+		if s, err = replaceGuestSession(w, r, s); err != nil {
+			internalError(w)
+			return
+		}
+		if completeSignIn(r, s, userID, username) != nil {
 			_ = s.ErrorMessage("Authentication failed.", "sign-in")
 			goto fail
 		}
+		rememberSignIn(w, r, s)
 		goto success
 	}
 
 fail:
 	messages, _ = s.Messages("sign-in")
 	renderTemplate(w, "signin.html", map[string]any{
-		"csrfToken": sessions.CSRFToken(s.ID),
-		"messages":  messages,
+		"csrfToken":   sessions.CSRFToken(s.ID),
+		"messages":    messages,
+		"canRemember": sessions.RememberFor > 0,
+		"oidcName":    getConfig(r).OIDCName,
+		"oidcEnabled": getConfig(r).OIDC != nil,
+		"proxyAuth":   getConfig(r).ProxyAuthHeader != "",
+
+		"canResetPassword": passwordResetEnabled(r),
 	})
 	return
 
 success:
 	if err := initUserDirectory(s.Data["userID"].(int)); err != nil {
-		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		internalError(w)
 		return
 	}
 	http.Redirect(w, r, "/welcome", http.StatusTemporaryRedirect)
 }
 
+// Checks if the visitor has to sign in.
+// Guests can still sign in to a registered account.
+func needsSignIn(r *http.Request, s *sessions.Session) bool {
+	return !s.IsSignedIn() || (guestsEnabled(r) && isGuest(r, s))
+}
+
+// Starts a new session if a guest is signing in to a registered account, so
+// that the guest's session doesn't get reused.
+func replaceGuestSession(
+	w http.ResponseWriter,
+	r *http.Request,
+	s *sessions.Session,
+) (*sessions.Session, error) {
+	if !s.IsSignedIn() {
+		return s, nil
+	}
+	return sessions.StartSession(auth.GetDB(r), w, r)
+}
+
+// Signs in user after successful authentication.
+func completeSignIn(r *http.Request, s *sessions.Session, userID int, username string) error {
+	db := auth.GetDB(r)
+	delete(s.Data, "pendingUserID")
+	delete(s.Data, "pendingSince")
+	s.Data["userID"] = userID
+	s.Data["username"] = username
+	if err := sessions.SaveData(db, s); err != nil {
+		return err
+	}
+	if err := auth.RecordSignIn(db, userID); err != nil {
+		logging.Error(r, err)
+	}
+	return nil
+}
+
+// Keeps the user signed in across sessions if they checked "remember me".
+// Failing to remember the user doesn't fail the sign-in.
+func rememberSignIn(w http.ResponseWriter, r *http.Request, s *sessions.Session) {
+	if r.FormValue("remember") == "" {
+		return
+	}
+	if err := sessions.Remember(auth.GetDB(r), w, s); err != nil {
+		logging.Error(r, err)
+	}
+}
+
+// Formats wait time for sign-in throttling messages, e.g. "2 minutes".
+func formatWait(wait time.Duration) string {
+	if wait <= time.Minute {
+		seconds := int(math.Ceil(wait.Seconds()))
+		if seconds == 1 {
+			return "1 second"
+		}
+		return fmt.Sprintf("%d seconds", seconds)
+	}
+	minutes := int(math.Ceil(wait.Minutes()))
+	return fmt.Sprintf("%d minutes", minutes)
+}
+
+// Returned by confirmPassword if the client has to wait before trying again.
+type throttledError struct {
+	wait time.Duration
+}
+
+func (e throttledError) Error() string {
+	return "too many failed attempts"
+}
+
+// Checks the signed-in user's password again before sensitive changes.
+// Wrong passwords count as failed sign-in attempts, so that guessing gets
+// throttled like on the sign-in page.
+func confirmPassword(w http.ResponseWriter, r *http.Request, username, password string) error {
+	db := auth.GetDB(r)
+	ip := clientIP(r)
+	logging.Set(r.Context(), "clientIP", ip)
+
+	unlock := auth.LockSignIn(username, ip)
+	defer unlock()
+
+	now := time.Now()
+	wait, err := auth.SignInWait(db, username, ip, now)
+	if err != nil {
+		logging.Error(r, err)
+	}
+	if wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		return throttledError{wait: wait}
+	}
+
+	_, err = auth.Authenticate(db, username, password)
+	attempt := auth.SignInAttempt{
+		Username: username,
+		IP:       ip,
+		Success:  err == nil,
+		Time:     now,
+	}
+	if err := auth.RecordSignInAttempt(db, attempt); err != nil {
+		logging.Error(r, err)
+	}
+	return err
+}
+
+// Returns message to show when confirmPassword fails.
+func confirmPasswordMessage(err error) string {
+	var throttled throttledError
+	if errors.As(err, &throttled) {
+		return "Too many failed attempts. Try again in " + formatWait(throttled.wait) + "."
+	}
+	return "Incorrect password."
+}
+
 // HandlerFunc for signing out.
 func handleSignOut(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
@@ -125,11 +388,16 @@ func handleSignOut(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := sessions.EndSession(db, w, r); err != nil {
-		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		internalError(w)
 		return
 	}
 
 done:
+	// Otherwise, the reverse proxy would sign the user back in.
+	if next := getConfig(r).ProxyAuthSignOutURL; next != "" {
+		http.Redirect(w, r, next, http.StatusSeeOther)
+		return
+	}
 	http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 }
 
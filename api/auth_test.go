@@ -5,9 +5,13 @@ package api
 
 import (
 	"database/sql"
+	"net/http"
+	"net/http/cookiejar"
 	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/PuerkitoBio/goquery"
 	"github.com/go-chi/chi/v5"
@@ -69,3 +73,189 @@ func TestRegisterNoCSRFToken(t *testing.T) {
 		t.Fatal("expected form button text to be 'Register':", text)
 	}
 }
+
+func TestRegisterClosed(t *testing.T) {
+	// Registration should fail if registration is closed.
+	t.Parallel()
+
+	db := testDB()
+	defer db.Close()
+
+	config := DefaultConfig()
+	config.Registration = "closed"
+
+	r := chi.NewRouter()
+	r.Use(configMiddleware(config))
+	r.Use(auth.Middleware(db))
+	r.HandleFunc("/register", handleRegister)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	v := url.Values{}
+	v.Set("username", "foo")
+	v.Set("password", "bar")
+	if _, err := ts.Client().PostForm(resolve(ts, "/register"), v); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	if _, err := auth.Authenticate(db, "foo", "bar"); err == nil {
+		t.Fatal("expected registration to fail")
+	}
+}
+
+// Gets CSRF token from the register page.
+func registerCSRFToken(t *testing.T, ts *httptest.Server) string {
+	resp, err := ts.Client().Get(resolve(ts, "/register"))
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	defer resp.Body.Close()
+
+	doc, err := goquery.NewDocumentFromReader(resp.Body)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	token, _ := doc.Find(`input[name="csrf-token"]`).Attr("value")
+	return token
+}
+
+// Creates test server whose client keeps cookies.
+func serverWithJar(handler http.Handler) *httptest.Server {
+	ts := httptest.NewServer(handler)
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		panic(err)
+	}
+	ts.Client().Jar = jar
+	return ts
+}
+
+// Creates test server with the registration handler.
+func registerServer(db *sql.DB, config Config) *httptest.Server {
+	r := chi.NewRouter()
+	r.Use(configMiddleware(config))
+	r.Use(auth.Middleware(db))
+	r.HandleFunc("/register", handleRegister)
+	r.HandleFunc("/signin", func(w http.ResponseWriter, r *http.Request) {})
+	return serverWithJar(r)
+}
+
+func TestRegisterPasswordPolicy(t *testing.T) {
+	t.Parallel()
+
+	db := testDB()
+	defer db.Close()
+
+	ts := registerServer(db, DefaultConfig())
+	defer ts.Close()
+
+	v := url.Values{}
+	v.Set("username", "foo")
+	v.Set("password", "short")
+	v.Set("csrf-token", registerCSRFToken(t, ts))
+	if _, err := ts.Client().PostForm(resolve(ts, "/register"), v); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if _, err := auth.GetUserID(db, "foo"); err == nil {
+		t.Fatal("expected short password to be rejected")
+	}
+
+	v.Set("password", "long enough")
+	if _, err := ts.Client().PostForm(resolve(ts, "/register"), v); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if _, err := auth.Authenticate(db, "foo", "long enough"); err != nil {
+		t.Fatal("expected registration to succeed:", err)
+	}
+}
+
+func TestRegisterInviteOnly(t *testing.T) {
+	t.Parallel()
+
+	db := testDB()
+	defer db.Close()
+
+	config := DefaultConfig()
+	config.Registration = "invite"
+	ts := registerServer(db, config)
+	defer ts.Close()
+
+	v := url.Values{}
+	v.Set("username", "foo")
+	v.Set("password", "password")
+	v.Set("csrf-token", registerCSRFToken(t, ts))
+	if _, err := ts.Client().PostForm(resolve(ts, "/register"), v); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if _, err := auth.GetUserID(db, "foo"); err == nil {
+		t.Fatal("expected registration without invite code to fail")
+	}
+
+	code, err := auth.CreateInvite(db, 0, 1)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	v.Set("invite-code", code)
+	if _, err := ts.Client().PostForm(resolve(ts, "/register"), v); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if _, err := auth.Authenticate(db, "foo", "password"); err != nil {
+		t.Fatal("expected registration to succeed:", err)
+	}
+}
+
+func TestSignInThrottled(t *testing.T) {
+	t.Parallel()
+
+	db := testDB()
+	defer db.Close()
+
+	if err := auth.Register(db, "foo", "password"); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	for i := 0; i < auth.UsernameThrottle.MaxAttempts; i++ {
+		attempt := auth.SignInAttempt{Username: "foo", IP: "192.0.2.1", Time: time.Now()}
+		if err := auth.RecordSignInAttempt(db, attempt); err != nil {
+			t.Fatal("expected err to be nil:", err)
+		}
+	}
+
+	r := chi.NewRouter()
+	r.Use(auth.Middleware(db))
+	r.HandleFunc("/signin", handleSignIn)
+	ts := serverWithJar(r)
+	defer ts.Close()
+
+	resp, err := ts.Client().Get(resolve(ts, "/signin"))
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	doc, err := goquery.NewDocumentFromReader(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	csrfToken, _ := doc.Find(`input[name="csrf-token"]`).Attr("value")
+
+	// Locked out even with the correct password.
+	v := url.Values{}
+	v.Set("username", "foo")
+	v.Set("password", "password")
+	v.Set("csrf-token", csrfToken)
+	resp, err = ts.Client().PostForm(resolve(ts, "/signin"), v)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.Header.Get("Retry-After") == "" {
+		t.Fatal("expected Retry-After header")
+	}
+	doc, err = goquery.NewDocumentFromReader(resp.Body)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if !strings.Contains(doc.Text(), "Too many failed sign-in attempts") {
+		t.Fatal("expected sign-in to be throttled")
+	}
+}
@@ -50,17 +50,18 @@ func computeHashes() error {
 		// This shouldn't happen.
 		panic(err)
 	}
-	_ = fs.WalkDir(sub, ".", func(path string, d fs.DirEntry, _ error) error {
-		file, err := sub.Open(path)
+	err = fs.WalkDir(sub, ".", func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
-			// Ignore error.
+			return err
+		}
+		if d.IsDir() {
 			return nil
 		}
-		defer file.Close()
-
-		_, _ = cachedHashFile(filepath.Join("/dist", path), file)
-		return nil
+		return hashStaticFile(sub, path, filepath.Join("/dist", path))
 	})
+	if err != nil {
+		return fmt.Errorf("failed to compute hashes: %w", err)
+	}
 
 	// Hash some public files.
 	sub, err = fs.Sub(public, "js/public")
@@ -68,24 +69,39 @@ func computeHashes() error {
 		// This shouldn't happen.
 		panic(err)
 	}
-	_ = fs.WalkDir(sub, ".", func(path string, d fs.DirEntry, _ error) error {
+	err = fs.WalkDir(sub, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
 		// Ignore fonts and icons.
 		if strings.HasPrefix(path, "fonts") || strings.HasPrefix(path, "svg") {
 			return nil
 		}
-
-		file, err := sub.Open(path)
-		if err != nil {
-			// Ignore error.
+		if d.IsDir() {
 			return nil
 		}
-		defer file.Close()
-		_, _ = cachedHashFile(filepath.Join("/public", path), file)
-		return nil
+		return hashStaticFile(sub, path, filepath.Join("/public", path))
 	})
+	if err != nil {
+		return fmt.Errorf("failed to compute hashes: %w", err)
+	}
 	return nil
 }
 
+// Hashes file in fsys.
+// url: URL path of the file
+func hashStaticFile(fsys fs.FS, path, url string) error {
+	file, err := fsys.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	_, err = cachedHashFile(url, file)
+	return err
+}
+
 // Returns URL of file with the content hash as its version.
 // If the file hasn't been hashed, simply returns the input URL.
 func versionedURL(url string) string {
@@ -0,0 +1,73 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+package api
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Returns IP address of client.
+// Uses X-Forwarded-For if the request came from a trusted proxy.
+// Returns "unix" for requests over unix sockets that didn't come from a
+// trusted proxy.
+func clientIP(r *http.Request) string {
+	proxies := getConfig(r).TrustedProxies
+
+	addr, ok := remoteAddr(r)
+	if !ok && !proxies.Unix {
+		return "unix"
+	}
+	if ok && !proxies.Contains(addr) {
+		return addr.String()
+	}
+
+	// Walk the header from right to left, because proxies append the address
+	// they received the request from.
+	// The first address that isn't a trusted proxy is the client's.
+	var client string
+	if ok {
+		client = addr.String()
+	} else {
+		client = "unix"
+	}
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(forwarded[i]))
+		if err != nil {
+			break
+		}
+		client = hop.Unmap().String()
+		if !proxies.Contains(hop) {
+			break
+		}
+	}
+	return client
+}
+
+// Parses request's remote address.
+// Returns false if it's not an IP address (e.g. unix sockets).
+func remoteAddr(r *http.Request) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return addr, false
+	}
+	return addr.Unmap(), true
+}
+
+// Checks if the request came directly from a trusted proxy.
+func fromTrustedProxy(r *http.Request) bool {
+	proxies := getConfig(r).TrustedProxies
+	addr, ok := remoteAddr(r)
+	if !ok {
+		return proxies.Unix
+	}
+	return proxies.Contains(addr)
+}
@@ -0,0 +1,55 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+package api
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/polycloze/polycloze/config"
+)
+
+func TestClientIP(t *testing.T) {
+	t.Parallel()
+
+	proxies, err := config.ParseTrustedProxies([]string{"127.0.0.1", "10.0.0.0/8"})
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	c := DefaultConfig()
+	c.TrustedProxies = proxies
+
+	cases := []struct {
+		remoteAddr string
+		forwarded  string
+		expected   string
+	}{
+		// Untrusted clients can't spoof their address.
+		{"192.0.2.1:1234", "198.51.100.1", "192.0.2.1"},
+
+		{"127.0.0.1:1234", "", "127.0.0.1"},
+		{"127.0.0.1:1234", "198.51.100.1", "198.51.100.1"},
+
+		// Skips trusted proxies, but not addresses added by the client.
+		{"127.0.0.1:1234", "203.0.113.1, 198.51.100.1, 10.0.0.2", "198.51.100.1"},
+
+		// Stops at garbage.
+		{"127.0.0.1:1234", "198.51.100.1, nonsense", "127.0.0.1"},
+
+		{"@", "198.51.100.1", "unix"},
+	}
+	for _, tc := range cases {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tc.remoteAddr
+		if tc.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", tc.forwarded)
+		}
+		r = r.WithContext(context.WithValue(r.Context(), keyConfig, c))
+
+		if actual := clientIP(r); actual != tc.expected {
+			t.Fatalf("expected client IP of %v (%q) to be %v: %v", tc.remoteAddr, tc.forwarded, tc.expected, actual)
+		}
+	}
+}
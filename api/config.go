@@ -3,7 +3,108 @@
 
 package api
 
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/polycloze/polycloze/config"
+	"github.com/polycloze/polycloze/mail"
+	"github.com/polycloze/polycloze/oidc"
+)
+
 type Config struct {
 	AllowCORS bool
-	Port      int
+
+	// Max size of uploaded files in bytes.
+	MaxUploadSize int64
+
+	// See config.RegistrationConfig.
+	Registration string
+
+	// Min number of characters in new passwords.
+	MinPasswordLength int
+
+	// Let visitors study without an account.
+	Guests bool
+
+	// Server write timeout; event streams get closed before it runs out.
+	// Zero means no timeout.
+	WriteTimeout time.Duration
+
+	// Max age of Strict-Transport-Security header.
+	// Zero means the header doesn't get sent.
+	// Should only be set if the server uses TLS.
+	HSTSMaxAge time.Duration
+
+	// Serve Prometheus metrics on /metrics.
+	ServeMetrics bool
+
+	// Reverse proxies whose X-Forwarded-For headers are trusted.
+	TrustedProxies config.TrustedProxies
+
+	// OpenID Connect client for single sign-on.
+	// nil if single sign-on is disabled.
+	OIDC *oidc.Client
+
+	// See config.OIDCConfig.
+	OIDCProvision bool
+	OIDCName      string
+
+	// See config.ProxyAuthConfig.
+	// Proxy authentication is disabled if the header is empty.
+	ProxyAuthHeader     string
+	ProxyAuthSignOutURL string
+
+	// SMTP client for sending password reset links.
+	// nil if password resets are disabled.
+	Mail *mail.Client
+
+	// See config.MailConfig.
+	MailBaseURL string
+
+	// See config.DemoConfig.
+	// Demo mode is disabled if empty.
+	Demo            string
+	DemoUsername    string
+	DemoSnapshotDir string
+}
+
+// Returns API config with default values.
+func DefaultConfig() Config {
+	c := config.Default()
+	return Config{
+		AllowCORS:         c.Server.AllowCORS,
+		MaxUploadSize:     c.Uploads.MaxSize,
+		Registration:      c.Registration.Mode,
+		MinPasswordLength: c.Registration.MinPasswordLength,
+		WriteTimeout:      time.Duration(c.Server.WriteTimeout),
+	}
+}
+
+type contextValueKey int
+
+// Keys for getting values from request context.
+const (
+	keyConfig contextValueKey = iota
+)
+
+// Stuffs config into request context.
+func configMiddleware(c Config) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), keyConfig, c)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// Gets config from request context.
+// Returns the default config if there's none.
+func getConfig(r *http.Request) Config {
+	c, ok := r.Context().Value(keyConfig).(Config)
+	if !ok {
+		return DefaultConfig()
+	}
+	return c
 }
@@ -5,7 +5,6 @@ package api
 
 import (
 	"fmt"
-	"os"
 	"path/filepath"
 
 	"github.com/polycloze/polycloze/basedir"
@@ -17,11 +16,11 @@ type Course struct {
 	L2 Language `json:"l2"`
 }
 
-// Checks if course exists.
+// Checks if course is installed.
+// Course DBs that were rejected or removed by the registry don't count.
 func courseExists(l1, l2 string) bool {
-	path := basedir.Course(l1, l2)
-	_, err := os.Stat(path)
-	return !os.IsNotExist(err)
+	_, ok := lookupCourse(l1 + "-" + l2)
+	return ok
 }
 
 // Gets user's active course.
@@ -0,0 +1,22 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+package api
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/polycloze/polycloze/basedir"
+	"github.com/polycloze/polycloze/database"
+	"github.com/polycloze/polycloze/logging"
+)
+
+// Opens user's review DB for the course.
+// Adds the path to the request's log entries.
+// The caller has to Close the db.
+func openReviewDB(r *http.Request, userID int, l1, l2 string) (*sql.DB, error) {
+	path := basedir.Review(userID, l1, l2)
+	logging.Set(r.Context(), "db", path)
+	return database.OpenReviewDB(path)
+}
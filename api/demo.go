@@ -0,0 +1,225 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+// Read-only public demo.
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/polycloze/polycloze/auth"
+	"github.com/polycloze/polycloze/basedir"
+	"github.com/polycloze/polycloze/config"
+	"github.com/polycloze/polycloze/database"
+	"github.com/polycloze/polycloze/sessions"
+)
+
+// Checks if the server is running as a public demo.
+func demoEnabled(r *http.Request) bool {
+	return getConfig(r).Demo != ""
+}
+
+// Rejects requests that would change settings or accounts in demo mode.
+// GET and HEAD requests still go through, so that pages can be viewed.
+func blockInDemo(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if demoEnabled(r) && r.Method != "GET" && r.Method != "HEAD" {
+			http.Error(w, "Disabled in the demo.", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Signs in visitor as the demo user.
+// In visitor mode, every visitor gets their own demo user, who starts with
+// the snapshot's progress.
+func signInDemo(r *http.Request, s *sessions.Session) error {
+	c := getConfig(r)
+	db := auth.GetDB(r)
+
+	if c.Demo == config.DemoVisitor {
+		userID, username, err := auth.CreateDemoVisitor(db, time.Now())
+		if err != nil {
+			return err
+		}
+		if err := completeSignIn(r, s, userID, username); err != nil {
+			return err
+		}
+		return restoreDemoDirectory(userID, c.DemoSnapshotDir)
+	}
+
+	userID, err := auth.GetDemoUser(db, c.DemoUsername)
+	if err != nil {
+		return err
+	}
+	return completeSignIn(r, s, userID, c.DemoUsername)
+}
+
+// Replaces user's files with copies of the files in the snapshot directory,
+// and deletes files that aren't in the snapshot.
+// The user starts from scratch if there's no snapshot.
+// Files get replaced one at a time instead of deleting the whole directory,
+// because visitors may be using them.
+func restoreDemoDirectory(userID int, snapshot string) error {
+	dir := basedir.User(userID)
+	restored := make(map[string]bool)
+	if snapshot != "" {
+		err := filepath.WalkDir(snapshot, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(snapshot, path)
+			if err != nil {
+				return err
+			}
+			target := filepath.Join(dir, rel)
+			if d.IsDir() {
+				return os.MkdirAll(target, 0o700)
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			restored[target] = true
+			return replaceFile(path, target)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to reset demo user files: %w", err)
+		}
+	}
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || restored[path] || isJournal(path) {
+			return nil
+		}
+		unlock, err := lockDatabase(path)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+		return os.Remove(path)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to reset demo user files: %w", err)
+	}
+	return initUserDirectory(userID)
+}
+
+// Checks if the file is an SQLite journal.
+// Journals belong to the database next to them, so they don't get deleted
+// separately.
+func isJournal(path string) bool {
+	for _, suffix := range []string{"-journal", "-wal", "-shm"} {
+		if strings.HasSuffix(path, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Atomically replaces dst with a copy of src.
+func replaceFile(src, dst string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".restore-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	tmp.Close()
+
+	if err := copyFile(src, tmp.Name()); err != nil {
+		return err
+	}
+
+	unlock, err := lockDatabase(dst)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return os.Rename(tmp.Name(), dst)
+}
+
+// Waits for writes to the SQLite database to finish, and blocks new ones
+// until unlock gets called.
+// Requests that still have the old file open then can't be halfway through a
+// write when it gets replaced or deleted.
+// Does nothing if the file doesn't exist or isn't a database.
+func lockDatabase(path string) (func(), error) {
+	unlock := func() {}
+	if !strings.HasSuffix(path, ".db") {
+		return unlock, nil
+	}
+	// Don't let sqlite create a new file.
+	if _, err := os.Stat(path); err != nil {
+		return unlock, nil
+	}
+
+	db, err := database.Open(path)
+	if err != nil {
+		return unlock, fmt.Errorf("failed to lock database: %w", err)
+	}
+	ctx := context.Background()
+	con, err := db.Conn(ctx)
+	if err != nil {
+		db.Close()
+		return unlock, fmt.Errorf("failed to lock database: %w", err)
+	}
+	if _, err := con.ExecContext(ctx, `BEGIN EXCLUSIVE`); err != nil {
+		con.Close()
+		db.Close()
+		return unlock, fmt.Errorf("failed to lock database: %w", err)
+	}
+	return func() {
+		_, _ = con.ExecContext(ctx, `ROLLBACK`)
+		con.Close()
+		db.Close()
+	}, nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// Resets progress of the shared demo user to the snapshot.
+// Also creates the demo user if they don't exist yet.
+// Does nothing in visitor mode, because every visitor starts with the
+// snapshot, and gets deleted after their sessions expire.
+func ResetDemo(db *sql.DB, c Config) error {
+	if c.Demo != config.DemoShared {
+		return nil
+	}
+	id, err := auth.GetDemoUser(db, c.DemoUsername)
+	if err != nil {
+		return err
+	}
+	return restoreDemoDirectory(id, c.DemoSnapshotDir)
+}
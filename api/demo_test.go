@@ -0,0 +1,273 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/polycloze/polycloze/auth"
+	"github.com/polycloze/polycloze/basedir"
+	"github.com/polycloze/polycloze/config"
+	"github.com/polycloze/polycloze/database"
+	"github.com/polycloze/polycloze/events"
+	"github.com/polycloze/polycloze/sessions"
+)
+
+// Creates test server with demo mode enabled.
+// "/whoami" responds with the signed-in user's username.
+func demoServer(db *sql.DB, mode string) *httptest.Server {
+	c := DefaultConfig()
+	c.Demo = mode
+	c.DemoUsername = "demo"
+
+	r := chi.NewRouter()
+	r.Use(configMiddleware(c))
+	r.Use(auth.Middleware(db))
+	r.HandleFunc("/signin", handleSignIn)
+	r.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {})
+	r.HandleFunc("/welcome", func(w http.ResponseWriter, r *http.Request) {})
+	r.HandleFunc("/whoami", func(w http.ResponseWriter, r *http.Request) {
+		s, err := sessions.ResumeSession(db, w, r)
+		if err == nil && s.IsSignedIn() {
+			fmt.Fprint(w, s.Data["username"])
+		}
+	})
+	r.Group(func(r chi.Router) {
+		r.Use(blockInDemo)
+		r.HandleFunc("/register", handleRegister)
+		r.HandleFunc("/settings/delete-account", handleDeleteAccount)
+	})
+	return serverWithJar(r)
+}
+
+func TestDemoSignsInVisitors(t *testing.T) {
+	t.Parallel()
+
+	db := testDB()
+	defer db.Close()
+	ts := demoServer(db, config.DemoShared)
+	defer ts.Close()
+
+	get(t, ts, "/signin")
+	if username := get(t, ts, "/whoami"); username != "demo" {
+		t.Fatal("expected visitor to be signed in as the demo user:", username)
+	}
+}
+
+func TestDemoVisitorsGetTheirOwnUser(t *testing.T) {
+	t.Parallel()
+
+	db := testDB()
+	defer db.Close()
+	ts := demoServer(db, config.DemoVisitor)
+	defer ts.Close()
+	other := demoServer(db, config.DemoVisitor)
+	defer other.Close()
+
+	get(t, ts, "/signin")
+	get(t, other, "/signin")
+	if a, b := get(t, ts, "/whoami"), get(t, other, "/whoami"); a == "" || a == b {
+		t.Fatal("expected visitors to be signed in as different users:", a, b)
+	}
+}
+
+func TestDemoBlocksChanges(t *testing.T) {
+	t.Parallel()
+
+	db := testDB()
+	defer db.Close()
+	ts := demoServer(db, config.DemoShared)
+	defer ts.Close()
+
+	get(t, ts, "/signin")
+	for _, path := range []string{"/register", "/settings/delete-account"} {
+		resp, err := ts.Client().PostForm(resolve(ts, path), url.Values{})
+		if err != nil {
+			t.Fatal("expected err to be nil:", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Fatal("expected request to be forbidden:", path, resp.StatusCode)
+		}
+	}
+
+	resp, err := ts.Client().Get(resolve(ts, "/register"))
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatal("expected pages to still be viewable:", resp.StatusCode)
+	}
+}
+
+func TestDemoAllowsPasswordSignIn(t *testing.T) {
+	// Otherwise admins wouldn't be able to sign in.
+	t.Parallel()
+
+	db := testDB()
+	defer db.Close()
+	ts := demoServer(db, config.DemoShared)
+	defer ts.Close()
+
+	if err := auth.Register(db, "admin", "password"); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	v := url.Values{}
+	v.Set("username", "admin")
+	v.Set("password", "password")
+	v.Set("csrf-token", pageCSRFToken(t, ts, "/signin?password"))
+	submit(t, ts, "/signin", v)
+	if username := get(t, ts, "/whoami"); username != "admin" {
+		t.Fatal("expected user to be signed in with password:", username)
+	}
+}
+
+func TestSharedDemoDoesNotPublishReviews(t *testing.T) {
+	// Otherwise, visitors would see each other's reviews.
+	t.Parallel()
+
+	topic := events.Topic{UserID: 4702, L1: "eng", L2: "spa"}
+	sub := reviewEvents.Subscribe(topic, "other")
+	defer reviewEvents.Unsubscribe(sub)
+
+	s := &sessions.Session{ID: "visitor", Data: map[string]any{"userID": 4702}}
+	publish := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		publishReviews(r, s, "eng", "spa", []ReviewResult{{Word: "hola"}})
+	})
+
+	c := DefaultConfig()
+	c.Demo = config.DemoShared
+	configMiddleware(c)(publish).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/", nil))
+	select {
+	case event := <-sub.C:
+		t.Fatal("expected review to not be published:", event)
+	default:
+	}
+
+	c.Demo = config.DemoVisitor
+	configMiddleware(c)(publish).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/", nil))
+	select {
+	case <-sub.C:
+	default:
+		t.Fatal("expected review to be published to the visitor's other clients")
+	}
+}
+
+// Creates SQLite database with a single value.
+func createValueDB(t *testing.T, path, value string) {
+	db, err := database.Open(path)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	defer db.Close()
+
+	query := `
+		DROP TABLE IF EXISTS value;
+		CREATE TABLE value (value TEXT);
+		INSERT INTO value VALUES (?);
+	`
+	if _, err := db.Exec(query, value); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+}
+
+// Reads value from database created by createValueDB.
+func readValueDB(db *sql.DB) (string, error) {
+	var value string
+	err := db.QueryRow(`SELECT value FROM value`).Scan(&value)
+	return value, err
+}
+
+func TestResetDemo(t *testing.T) {
+	t.Parallel()
+
+	db := testDB()
+	defer db.Close()
+
+	snapshot := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(snapshot, "reviews"), 0o700); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	createValueDB(t, filepath.Join(snapshot, "reviews", "eng-spa.db"), "snapshot")
+
+	// Uses an unusual user ID, because tests share the state directory.
+	if _, err := auth.GetDemoUser(db, "demo"); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if _, err := db.Exec(`UPDATE user SET id = 4701`); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	defer os.RemoveAll(basedir.User(4701))
+
+	if err := initUserDirectory(4701); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	progress := filepath.Join(basedir.User(4701), "reviews", "eng-spa.db")
+	createValueDB(t, progress, "progress")
+	other := filepath.Join(basedir.User(4701), "reviews", "eng-deu.db")
+	createValueDB(t, other, "progress")
+
+	// Visitor who's still using the old file.
+	open, err := database.Open(progress)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	defer open.Close()
+	if _, err := readValueDB(open); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	c := DefaultConfig()
+	c.Demo = config.DemoShared
+	c.DemoUsername = "demo"
+	c.DemoSnapshotDir = snapshot
+	if err := ResetDemo(db, c); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	restored, err := database.Open(progress)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	defer restored.Close()
+	if value, err := readValueDB(restored); err != nil || value != "snapshot" {
+		t.Fatal("expected demo user's progress to be restored from the snapshot:", value, err)
+	}
+	if _, err := os.Stat(other); !os.IsNotExist(err) {
+		t.Fatal("expected progress that isn't in the snapshot to be deleted:", err)
+	}
+	if _, err := readValueDB(open); err != nil {
+		t.Fatal("expected open database to stay readable:", err)
+	}
+}
+
+func TestResetDemoRefusesRegisteredUser(t *testing.T) {
+	t.Parallel()
+
+	db := testDB()
+	defer db.Close()
+
+	if err := auth.Register(db, "demo", "password"); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	c := DefaultConfig()
+	c.Demo = config.DemoShared
+	c.DemoUsername = "demo"
+	if err := ResetDemo(db, c); !errors.Is(err, auth.ErrNotDemoUser) {
+		t.Fatal("expected registered user not to be used as the demo user:", err)
+	}
+}
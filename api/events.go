@@ -0,0 +1,182 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+// Server-sent events for syncing clients of the same user.
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/polycloze/polycloze/auth"
+	"github.com/polycloze/polycloze/config"
+	"github.com/polycloze/polycloze/events"
+	"github.com/polycloze/polycloze/logging"
+	"github.com/polycloze/polycloze/sessions"
+)
+
+// Interval between keep-alive comments sent to event stream clients.
+const keepAliveInterval = 30 * time.Second
+
+// Time reserved for ending event streams before the write timeout.
+const streamMargin = 5 * time.Second
+
+// Delay in milliseconds before clients reconnect to closed event streams.
+const reconnectDelay = 1000
+
+// Broker for review events.
+var reviewEvents = events.NewBroker()
+
+// Closes open event streams.
+// Should be called when the server shuts down, because long-lived streams
+// never finish on their own.
+func Shutdown() {
+	reviewEvents.Close()
+}
+
+// Returns how long an event stream may stay open, or 0 if there's no limit.
+func streamLifetime(writeTimeout time.Duration) time.Duration {
+	if writeTimeout <= 0 {
+		return 0
+	}
+	if writeTimeout <= 2*streamMargin {
+		return writeTimeout / 2
+	}
+	return writeTimeout - streamMargin
+}
+
+// Checks if review events get sent to the user's other clients.
+// In shared demo mode, every visitor is the same user, so visitors would see
+// each other's reviews.
+func eventsEnabled(r *http.Request) bool {
+	return getConfig(r).Demo != config.DemoShared
+}
+
+// Tells other clients about uploaded reviews.
+func publishReviews(r *http.Request, s *sessions.Session, l1, l2 string, reviews []ReviewResult) {
+	if !eventsEnabled(r) {
+		return
+	}
+	topic := events.Topic{
+		UserID: s.Data["userID"].(int),
+		L1:     l1,
+		L2:     l2,
+	}
+	for _, review := range reviews {
+		reviewEvents.Publish(topic, events.Event{
+			Kind:   "review",
+			Data:   review,
+			Source: s.ID,
+		})
+	}
+}
+
+// Tells other clients that course progress has been reset.
+func publishReset(s *sessions.Session, l1, l2 string) {
+	topic := events.Topic{
+		UserID: s.Data["userID"].(int),
+		L1:     l1,
+		L2:     l2,
+	}
+	reviewEvents.Publish(topic, events.Event{
+		Kind:   "reset",
+		Data:   map[string]any{},
+		Source: s.ID,
+	})
+}
+
+// Writes event in text/event-stream format.
+func writeEvent(w http.ResponseWriter, event events.Event) error {
+	bytes, err := json.Marshal(event.Data)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Kind, bytes); err != nil {
+		return fmt.Errorf("failed to send event: %w", err)
+	}
+	return nil
+}
+
+// Streams review events in the course to the client.
+func handleEvents(w http.ResponseWriter, r *http.Request) {
+	// Check if course exists.
+	l1 := chi.URLParam(r, "l1")
+	l2 := chi.URLParam(r, "l2")
+	if !courseExists(l1, l2) {
+		http.NotFound(w, r)
+		return
+	}
+
+	if !eventsEnabled(r) {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Check if user is signed in.
+	s, err := resumeSession(w, r, auth.ScopeReadStats)
+	if err != nil || !s.IsSignedIn() {
+		http.NotFound(w, r)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported.", http.StatusInternalServerError)
+		return
+	}
+
+	topic := events.Topic{
+		UserID: s.Data["userID"].(int),
+		L1:     l1,
+		L2:     l2,
+	}
+	sub := reviewEvents.Subscribe(topic, s.ID)
+	defer reviewEvents.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", reconnectDelay)
+	flusher.Flush()
+
+	ticker := time.NewTicker(keepAliveInterval)
+	defer ticker.Stop()
+
+	// End the stream before the server's write timeout cuts it off.
+	// The client reconnects on its own.
+	var expired <-chan time.Time
+	if lifetime := streamLifetime(getConfig(r).WriteTimeout); lifetime > 0 {
+		timer := time.NewTimer(lifetime)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-expired:
+			return
+		case <-ticker.C:
+			// Comments keep proxies from closing idle connections.
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case event, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if err := writeEvent(w, event); err != nil {
+				logging.Error(r, err)
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
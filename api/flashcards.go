@@ -6,7 +6,6 @@ package api
 import (
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"time"
 
@@ -17,11 +16,23 @@ import (
 	"github.com/polycloze/polycloze/database"
 	"github.com/polycloze/polycloze/difficulty"
 	"github.com/polycloze/polycloze/flashcards"
+	"github.com/polycloze/polycloze/logging"
+	"github.com/polycloze/polycloze/metrics"
+	"github.com/polycloze/polycloze/sentences"
 	"github.com/polycloze/polycloze/sessions"
 	"github.com/polycloze/polycloze/text"
 	"github.com/polycloze/polycloze/word_scheduler"
 )
 
+// Logs sentences that were skipped because of bad course data, so that the
+// course can be fixed.
+func recordBadSentences(r *http.Request, course string, skipped []*sentences.BadSentenceError) {
+	for _, err := range skipped {
+		logging.Error(r, err)
+		metrics.BadSentences.Inc(course)
+	}
+}
+
 // Returns predicate to pass to item generator.
 func excludeWords(words []string) func(string) bool {
 	exclude := make(map[string]bool)
@@ -34,6 +45,50 @@ func excludeWords(words []string) func(string) bool {
 	}
 }
 
+// Saves uploaded reviews and difficulty stats.
+// The caller has to check the CSRF token first.
+func saveReviews(
+	r *http.Request,
+	s *sessions.Session,
+	con *database.Connection,
+	l1, l2 string,
+	data FlashcardsRequest,
+) error {
+	if err := word_scheduler.BulkSaveWords(con, data.Reviews, time.Now()); err != nil {
+		return err
+	}
+	metrics.ReviewsProcessed.Add(float64(len(data.Reviews)))
+
+	if data.Difficulty != nil {
+		if err := difficulty.Update(con, *data.Difficulty); err != nil {
+			return err
+		}
+	}
+
+	// Let the user's other clients drop stale flashcards.
+	publishReviews(r, s, l1, l2, data.Reviews)
+	return nil
+}
+
+// Generates flashcards.
+func nextFlashcards(
+	r *http.Request,
+	con *database.Connection,
+	l1, l2 string,
+	data FlashcardsRequest,
+) FlashcardsResponse {
+	items, skipped := flashcards.Get(con, data.Limit, excludeWords(data.Exclude))
+	recordBadSentences(r, l1+"-"+l2, skipped)
+	if items == nil {
+		items = make([]flashcards.Item, 0)
+	}
+	newDiff := difficulty.GetLatest(con)
+	return FlashcardsResponse{
+		Items:      items,
+		Difficulty: &newDiff,
+	}
+}
+
 func handleFlashcards(w http.ResponseWriter, r *http.Request) {
 	// Check request method and content type.
 	if r.Method != "POST" || r.Header.Get("Content-Type") != "application/json" {
@@ -51,7 +106,7 @@ func handleFlashcards(w http.ResponseWriter, r *http.Request) {
 
 	// Sign in.
 	db := auth.GetDB(r)
-	s, err := sessions.ResumeSession(db, w, r)
+	s, err := resumeSession(w, r, auth.ScopeSubmitReviews)
 	if err != nil || !s.IsSignedIn() {
 		http.NotFound(w, r)
 		return
@@ -59,10 +114,10 @@ func handleFlashcards(w http.ResponseWriter, r *http.Request) {
 
 	// Open user's review DB.
 	userID := s.Data["userID"].(int)
-	db, err = database.OpenReviewDB(basedir.Review(userID, l1, l2))
+	db, err = openReviewDB(r, userID, l1, l2)
 	if err != nil {
-		log.Println(fmt.Errorf("could not open review database (%v-%v): %w", l1, l2, err))
-		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		logging.Error(r, fmt.Errorf("could not open review database (%v-%v): %w", l1, l2, err))
+		internalError(w)
 		return
 	}
 	defer db.Close()
@@ -71,8 +126,8 @@ func handleFlashcards(w http.ResponseWriter, r *http.Request) {
 	hook := database.AttachCourse(basedir.Course(l1, l2))
 	con, err := database.NewConnection(db, r.Context(), hook)
 	if err != nil {
-		log.Println(err)
-		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		logging.Error(r, err)
+		internalError(w)
 		return
 	}
 	defer con.Close()
@@ -80,7 +135,7 @@ func handleFlashcards(w http.ResponseWriter, r *http.Request) {
 	// Read request data.
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		log.Println(err)
+		logging.Error(r, err)
 		http.Error(w, "Could not read request.", http.StatusInternalServerError)
 		return
 	}
@@ -99,32 +154,19 @@ func handleFlashcards(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// Check csrf token.
-		if !sessions.CheckCSRFToken(s.ID, token) {
+		if !s.CheckCSRFToken(token) {
 			http.Error(w, "Forbidden.", http.StatusForbidden)
 			return
 		}
 
 		// Save review results.
-		if err := word_scheduler.BulkSaveWords(con, data.Reviews, time.Now()); err != nil {
-			log.Println(err)
-			http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		if err := saveReviews(r, s, con, l1, l2, data); err != nil {
+			logging.Error(r, err)
+			internalError(w)
 			return
 		}
-
-		if data.Difficulty != nil {
-			if err := difficulty.Update(con, *data.Difficulty); err != nil {
-				log.Println(err)
-				http.Error(w, "Something went wrong.", http.StatusInternalServerError)
-				return
-			}
-		}
 	}
 
 	// Generate flashcards.
-	items := flashcards.Get(con, data.Limit, excludeWords(data.Exclude))
-	newDiff := difficulty.GetLatest(con)
-	sendJSON(w, FlashcardsResponse{
-		Items:      items,
-		Difficulty: &newDiff,
-	})
+	sendJSON(w, nextFlashcards(r, con, l1, l2, data))
 }
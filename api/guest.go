@@ -0,0 +1,95 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+// Guest mode.
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/polycloze/polycloze/auth"
+	"github.com/polycloze/polycloze/basedir"
+	"github.com/polycloze/polycloze/logging"
+	"github.com/polycloze/polycloze/sessions"
+)
+
+// Checks if visitors can study as guests.
+func guestsEnabled(r *http.Request) bool {
+	config := getConfig(r)
+	return config.Guests && config.ProxyAuthHeader == "" && config.Demo == ""
+}
+
+// Checks if the signed-in user is a guest.
+func isGuest(r *http.Request, s *sessions.Session) bool {
+	isGuest, err := auth.IsGuest(auth.GetDB(r), s.Data["userID"].(int))
+	if err != nil {
+		logging.Error(r, err)
+	}
+	return isGuest
+}
+
+// Signs in visitor as a new guest.
+func handleGuest(w http.ResponseWriter, r *http.Request) {
+	if !guestsEnabled(r) {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "expected POST request", http.StatusBadRequest)
+		return
+	}
+
+	db := auth.GetDB(r)
+	s, err := sessions.StartOrResumeSession(db, w, r)
+	if err != nil {
+		internalError(w)
+		return
+	}
+	if s.IsSignedIn() {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	if !sessions.CheckCSRFToken(s.ID, r.FormValue("csrf-token")) {
+		http.Error(w, "Something went wrong. Please try again.", http.StatusBadRequest)
+		return
+	}
+
+	userID, username, err := auth.CreateGuest(db, time.Now())
+	if err != nil {
+		logging.Error(r, err)
+		internalError(w)
+		return
+	}
+	if err := completeSignIn(r, s, userID, username); err != nil {
+		logging.Error(r, err)
+		internalError(w)
+		return
+	}
+	if err := initUserDirectory(userID); err != nil {
+		internalError(w)
+		return
+	}
+	http.Redirect(w, r, "/welcome", http.StatusSeeOther)
+}
+
+// Deletes guests whose sessions have expired, along with their files.
+// Returns the number of deleted guests.
+func CollectGuests(db *sql.DB) (int, error) {
+	ids, err := auth.AbandonedGuests(db, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	for i, id := range ids {
+		if err := auth.DeleteUser(db, id); err != nil {
+			return i, err
+		}
+		if err := os.RemoveAll(basedir.User(id)); err != nil {
+			return i, fmt.Errorf("deleted guest, but failed to delete guest files: %w", err)
+		}
+	}
+	return len(ids), nil
+}
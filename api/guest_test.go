@@ -0,0 +1,149 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/polycloze/polycloze/auth"
+	"github.com/polycloze/polycloze/basedir"
+	"github.com/polycloze/polycloze/sessions"
+)
+
+// Creates test server with guest mode enabled.
+// "/whoami" responds with the signed-in user's username.
+func guestServer(db *sql.DB) *httptest.Server {
+	config := DefaultConfig()
+	config.Guests = true
+
+	r := chi.NewRouter()
+	r.Use(configMiddleware(config))
+	r.Use(auth.Middleware(db))
+	r.HandleFunc("/about", handleAbout)
+	r.HandleFunc("/guest", handleGuest)
+	r.HandleFunc("/register", handleRegister)
+	r.HandleFunc("/signin", handleSignIn)
+	r.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {})
+	r.HandleFunc("/welcome", func(w http.ResponseWriter, r *http.Request) {})
+	r.HandleFunc("/whoami", func(w http.ResponseWriter, r *http.Request) {
+		s, err := sessions.ResumeSession(db, w, r)
+		if err == nil && s.IsSignedIn() {
+			fmt.Fprint(w, s.Data["username"])
+		}
+	})
+	return serverWithJar(r)
+}
+
+func TestGuestRegistrationKeepsProgress(t *testing.T) {
+	t.Parallel()
+
+	db := testDB()
+	defer db.Close()
+	ts := guestServer(db)
+	defer ts.Close()
+
+	v := url.Values{}
+	v.Set("csrf-token", pageCSRFToken(t, ts, "/about"))
+	submit(t, ts, "/guest", v)
+	if username := get(t, ts, "/whoami"); !strings.HasPrefix(username, "guest-") {
+		t.Fatal("expected visitor to be signed in as guest:", username)
+	}
+
+	v = url.Values{}
+	v.Set("username", "foo")
+	v.Set("password", "password")
+	v.Set("csrf-token", pageCSRFToken(t, ts, "/register"))
+	submit(t, ts, "/register", v)
+	if username := get(t, ts, "/whoami"); username != "foo" {
+		t.Fatal("expected guest to stay signed in as the new user:", username)
+	}
+
+	// The guest's account, and therefore their files, are kept.
+	if userID, err := auth.Authenticate(db, "foo", "password"); err != nil || userID != 1 {
+		t.Fatal("expected guest to be converted:", userID, err)
+	}
+}
+
+func TestGuestCanSignIn(t *testing.T) {
+	// Guests who already have an account can still sign in to it.
+	t.Parallel()
+
+	db := testDB()
+	defer db.Close()
+	ts := guestServer(db)
+	defer ts.Close()
+
+	if err := auth.Register(db, "foo", "password"); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	v := url.Values{}
+	v.Set("csrf-token", pageCSRFToken(t, ts, "/about"))
+	submit(t, ts, "/guest", v)
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	guestCookies := ts.Client().Jar.Cookies(u)
+	if len(guestCookies) == 0 {
+		t.Fatal("expected guest to have a session cookie")
+	}
+
+	v = url.Values{}
+	v.Set("username", "foo")
+	v.Set("password", "password")
+	v.Set("csrf-token", pageCSRFToken(t, ts, "/signin"))
+	submit(t, ts, "/signin", v)
+	if username := get(t, ts, "/whoami"); username != "foo" {
+		t.Fatal("expected guest to be signed in as the registered user:", username)
+	}
+
+	// The guest's session got replaced.
+	for _, c := range guestCookies {
+		if c.Name != "id" {
+			continue
+		}
+		var count int
+		query := `SELECT count(*) FROM user_session WHERE session_id = ?`
+		if err := db.QueryRow(query, c.Value).Scan(&count); err != nil || count > 0 {
+			t.Fatal("expected guest's session to be ended:", count, err)
+		}
+	}
+}
+
+func TestCollectGuests(t *testing.T) {
+	t.Parallel()
+
+	db := testDB()
+	defer db.Close()
+
+	// Uses an unusual user ID, because tests share the state directory.
+	if _, _, err := auth.CreateGuest(db, time.Now().Add(-24*time.Hour)); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if _, err := db.Exec(`UPDATE user SET id = 4601`); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if err := initUserDirectory(4601); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	n, err := CollectGuests(db)
+	if err != nil || n != 1 {
+		t.Fatal("expected abandoned guest to be deleted:", n, err)
+	}
+	if _, err := os.Stat(basedir.User(4601)); !os.IsNotExist(err) {
+		t.Fatal("expected guest files to be deleted:", err)
+	}
+}
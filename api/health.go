@@ -0,0 +1,114 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+// Health and readiness probes.
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/polycloze/polycloze/basedir"
+	"github.com/polycloze/polycloze/logging"
+)
+
+// Max time spent on readiness checks.
+const readinessTimeout = 5 * time.Second
+
+type ReadinessResponse struct {
+	// "ok" or "unavailable".
+	Status string `json:"status"`
+
+	// Check name -> "ok" or error message.
+	Checks map[string]string `json:"checks"`
+}
+
+type readinessCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+func readinessChecks(db *sql.DB) []readinessCheck {
+	return []readinessCheck{
+		{"authDB", func(ctx context.Context) error {
+			return db.PingContext(ctx)
+		}},
+		{"courses", func(context.Context) error {
+			if len(installedCourses()) == 0 {
+				return errors.New("no installed courses")
+			}
+			return nil
+		}},
+		{"version", func(context.Context) error {
+			if dataVersion == "" {
+				return errors.New("version.txt hasn't been read")
+			}
+			return nil
+		}},
+		{"stateDir", func(context.Context) error {
+			return checkWritable(basedir.StateDir)
+		}},
+	}
+}
+
+// Checks if files can be created in the directory.
+func checkWritable(dir string) error {
+	f, err := os.CreateTemp(dir, ".readyz-*")
+	if err != nil {
+		return fmt.Errorf("directory isn't writable: %w", err)
+	}
+	name := f.Name()
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("directory isn't writable: %w", err)
+	}
+	if err := os.Remove(name); err != nil {
+		return fmt.Errorf("directory isn't writable: %w", err)
+	}
+	return nil
+}
+
+// Responds if the process is up.
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	fmt.Fprintln(w, "ok")
+}
+
+// Responds with 503 if the server can't handle requests yet (or anymore).
+// db: auth DB
+func handleReadyz(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+		defer cancel()
+
+		response := ReadinessResponse{
+			Status: "ok",
+			Checks: make(map[string]string),
+		}
+		for _, c := range readinessChecks(db) {
+			if err := c.check(ctx); err != nil {
+				response.Status = "unavailable"
+				response.Checks[c.name] = err.Error()
+				continue
+			}
+			response.Checks[c.name] = "ok"
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		if response.Status != "ok" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			if err := json.NewEncoder(w).Encode(response); err != nil {
+				logging.Error(r, err)
+			}
+			return
+		}
+		sendJSON(w, response)
+	}
+}
@@ -363,3 +363,36 @@ function setParams(url: URL, params: Params) {
     url.searchParams.set(name, String(value));
   }
 }
+
+type ReviewEventHandlers = {
+  // Called when a word gets reviewed in another client.
+  review?: (review: ReviewResult) => void;
+
+  // Called when course progress gets reset in another client.
+  reset?: () => void;
+};
+
+// Listens to review events from the user's other clients (e.g. on another
+// device).
+// Returns a function that closes the connection.
+export function subscribeReviewEvents(
+  handlers: ReviewEventHandlers
+): () => void {
+  const l1 = getL1().code;
+  const l2 = getL2().code;
+  const url = resolve(`/api/events/${l1}/${l2}`);
+  const source = new EventSource(url.href);
+
+  source.addEventListener("review", (event) => {
+    const review = JSON.parse((event as MessageEvent).data) as ReviewResult;
+    if (handlers.review != null) {
+      handlers.review(review);
+    }
+  });
+  source.addEventListener("reset", () => {
+    if (handlers.reset != null) {
+      handlers.reset();
+    }
+  });
+  return () => source.close();
+}
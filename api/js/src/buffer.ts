@@ -1,6 +1,11 @@
 // Item buffer
 
-import { fetchFlashcards, sendReviewResults, fetchSentences } from "./api";
+import {
+  fetchFlashcards,
+  sendReviewResults,
+  fetchSentences,
+  subscribeReviewEvents,
+} from "./api";
 import { PartWithAnswers, hasAnswers } from "./blank";
 import { Difficulty, DifficultyTuner } from "./difficulty";
 import { Item } from "./item";
@@ -21,6 +26,9 @@ export class ItemBuffer {
   difficultyTuner: DifficultyTuner;
   reviews: ReviewResult[];
 
+  // Removes event listeners and closes the review event stream.
+  close: () => void;
+
   constructor(difficulty: Difficulty = {}) {
     this.difficultyTuner = new DifficultyTuner(difficulty);
     this.buffer = [];
@@ -60,12 +68,11 @@ export class ItemBuffer {
       }
     };
 
-    // NOTE this never gets removed
     window.addEventListener("polycloze-review", listener);
 
     // MDN recommends `visibilitychange` instead of `unload` and `beforeunload`
     // because `visibilitychange` is more reliable on mobile.
-    window.addEventListener("visibilitychange", () => {
+    const onVisibilityChange = () => {
       if (document.visibilityState === "hidden" && this.reviews.length > 0) {
         sendReviewResults(this.reviews, this.difficultyTuner.difficulty);
 
@@ -78,7 +85,62 @@ export class ItemBuffer {
         // problem because the item buffer will get the updated stats on the
         // next fetch.
       }
+    };
+    window.addEventListener("visibilitychange", onVisibilityChange);
+
+    // Drop flashcards that were already reviewed on another device.
+    const subscribe = () => subscribeReviewEvents({
+      review: (review) => {
+        this.drop(review.word);
+        announceRemoteResult(review);
+      },
+      reset: () => {
+        this.buffer.splice(0);
+        this.keys.clear();
+        this.reviews.splice(0);
+      },
+    });
+    let unsubscribe: (() => void) | null = subscribe();
+
+    // Close the connection when leaving the page, so it doesn't linger on
+    // the server.
+    // Reconnect if the page gets restored from the back/forward cache.
+    const onPageHide = () => {
+      if (unsubscribe != null) {
+        unsubscribe();
+        unsubscribe = null;
+      }
+    };
+    const onPageShow = (event: PageTransitionEvent) => {
+      if (event.persisted && unsubscribe == null) {
+        unsubscribe = subscribe();
+      }
+    };
+    window.addEventListener("pagehide", onPageHide);
+    window.addEventListener("pageshow", onPageShow);
+
+    this.close = () => {
+      onPageHide();
+      window.removeEventListener("polycloze-review", listener);
+      window.removeEventListener("visibilitychange", onVisibilityChange);
+      window.removeEventListener("pagehide", onPageHide);
+      window.removeEventListener("pageshow", onPageShow);
+    };
+  }
+
+  // Removes buffered items for the word.
+  drop(word: string) {
+    this.buffer = this.buffer.filter((item) => {
+      for (const part of getBlankParts(item.sentence)) {
+        if (part.answers[0].normalized === word) {
+          return false;
+        }
+      }
+      return true;
     });
+    if (!this.reviews.some((review) => review.word === word)) {
+      this.keys.delete(word);
+    }
   }
 
   // Add item if it's not a duplicate.
@@ -142,6 +204,15 @@ export function announceResult(result: ReviewResult) {
   window.dispatchEvent(event);
 }
 
+// Dispatches custom event to tell stats components about a review result from
+// another client.
+export function announceRemoteResult(result: ReviewResult) {
+  const event = new CustomEvent("polycloze-remote-review", {
+    detail: result,
+  });
+  window.dispatchEvent(event);
+}
+
 export class RandomSentenceBuffer {
   buffer: RandomSentence[];
   difficulty: number;
@@ -19,13 +19,14 @@ import { createFileBrowser } from "./upload";
 import { createVocabularyList } from "./vocab";
 
 export class ClozeApp extends HTMLElement {
+  buffer: ItemBuffer;
   promise: Promise<[HTMLDivElement, () => void]>;
 
   constructor() {
     super();
 
-    const buffer = new ItemBuffer();
-    this.promise = createApp(buffer);
+    this.buffer = new ItemBuffer();
+    this.promise = createApp(this.buffer);
   }
 
   async connectedCallback() {
@@ -36,6 +37,10 @@ export class ClozeApp extends HTMLElement {
     const l2 = getL2().name;
     document.title = `${l2} | polycloze`;
   }
+
+  disconnectedCallback() {
+    this.buffer.close();
+  }
 }
 
 export class ListenApp extends HTMLElement {
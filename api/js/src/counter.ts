@@ -8,6 +8,7 @@ export function createScoreCounter(correct: number): HTMLDivElement {
 
   const listener = (event: Event) => {
     window.removeEventListener("polycloze-review", listener);
+    window.removeEventListener("polycloze-remote-review", listener);
     if ((event as CustomEvent).detail.correct) {
       div.replaceWith(createScoreCounter(correct + 1));
     } else {
@@ -15,6 +16,8 @@ export function createScoreCounter(correct: number): HTMLDivElement {
     }
   };
 
+  // Also count reviews from the user's other devices.
   window.addEventListener("polycloze-review", listener);
+  window.addEventListener("polycloze-remote-review", listener);
   return div;
 }
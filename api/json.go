@@ -9,6 +9,9 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
+
+	"github.com/polycloze/polycloze/logging"
 )
 
 // Sends JSON response.
@@ -17,31 +20,42 @@ func sendJSON(w http.ResponseWriter, data any) {
 	bytes, err := json.Marshal(data)
 	if err != nil {
 		log.Println("failed to encode to JSON:", err)
-		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		internalError(w)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	if _, err := w.Write(bytes); err != nil {
 		log.Println("failed to send JSON:", err)
-		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		internalError(w)
 	}
 }
 
 // Writes JSON to file.
+// Replaces the file atomically, so that readers never see a partial file.
 func writeJSON(name string, data any) error {
 	bytes, err := json.Marshal(data)
 	if err != nil {
 		return fmt.Errorf("failed to encode to JSON: %w", err)
 	}
 
-	f, err := os.Create(name)
+	f, err := os.CreateTemp(filepath.Dir(name), ".tmp-*.json")
 	if err != nil {
 		return fmt.Errorf("failed to create file: %w", err)
 	}
-	defer f.Close()
+	defer os.Remove(f.Name())
 
 	if _, err := f.Write(bytes); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write JSON: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write JSON: %w", err)
+	}
+	if err := os.Chmod(f.Name(), 0o644); err != nil {
+		return fmt.Errorf("failed to write JSON: %w", err)
+	}
+	if err := os.Rename(f.Name(), name); err != nil {
 		return fmt.Errorf("failed to write JSON: %w", err)
 	}
 	return nil
@@ -56,3 +70,52 @@ func parseJSON(w http.ResponseWriter, data []byte, v any) error {
 	}
 	return nil
 }
+
+// Error codes used in /api/v1 error responses.
+const (
+	errBadRequest   = "bad_request"
+	errUnauthorized = "unauthorized"
+	errForbidden    = "forbidden"
+	errNotFound     = "not_found"
+	errTooLarge     = "too_large"
+	errInternal     = "internal_error"
+)
+
+// Sends JSON error response.
+// The caller shouldn't write to w afterwards.
+func sendError(w http.ResponseWriter, status int, code, message string) {
+	bytes, err := json.Marshal(ErrorResponse{
+		Error: ErrorDetail{
+			Code:      code,
+			Message:   message,
+			RequestID: w.Header().Get(logging.RequestIDHeader),
+		},
+	})
+	if err != nil {
+		log.Println("failed to encode to JSON:", err)
+		internalError(w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	if _, err := w.Write(bytes); err != nil {
+		log.Println("failed to send JSON:", err)
+	}
+}
+
+// Sends generic internal server error response in plain text.
+// Includes the request ID so that users can report it.
+func internalError(w http.ResponseWriter) {
+	message := "Something went wrong."
+	if id := w.Header().Get(logging.RequestIDHeader); id != "" {
+		message = fmt.Sprintf("Something went wrong. (Request ID: %v)", id)
+	}
+	http.Error(w, message, http.StatusInternalServerError)
+}
+
+// Sends generic internal server error response.
+func sendInternalError(w http.ResponseWriter) {
+	sendError(w, http.StatusInternalServerError, errInternal, "Something went wrong.")
+}
@@ -0,0 +1,79 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+
+	"github.com/polycloze/polycloze/logging"
+	"github.com/polycloze/polycloze/metrics"
+	"github.com/polycloze/polycloze/sessions"
+)
+
+// Serves Prometheus metrics.
+// db: auth DB, for counting active sessions
+func MetricsHandler(db *sql.DB) http.Handler {
+	registry := metrics.NewRegistry()
+	registry.NewGaugeFunc(
+		"polycloze_active_sessions",
+		"Number of signed-in sessions that haven't expired.",
+		func() (float64, error) {
+			count, err := sessions.CountActive(db)
+			return float64(count), err
+		},
+	)
+	return metrics.Handler(metrics.Default, registry)
+}
+
+// Records request latency per route.
+// Should be used after routing so that route patterns are available.
+func instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil {
+			if pattern := rctx.RoutePattern(); pattern != "" {
+				route = pattern
+			}
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		metrics.RequestDuration.ObserveSince(start, r.Method, route, strconv.Itoa(status))
+	})
+}
+
+// Recovers from panics in handlers (e.g. because of bad course data), so that
+// the client gets an error response.
+func recoverer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			rvr := recover()
+			if rvr == nil {
+				return
+			}
+			if rvr == http.ErrAbortHandler {
+				// Lets net/http abort the response.
+				panic(rvr)
+			}
+			metrics.PanicsRecovered.Inc()
+			logging.Set(r.Context(), "stack", string(debug.Stack()))
+			logging.Error(r, fmt.Errorf("panic: %v", rvr))
+			internalError(w)
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,287 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+// Single sign-on handlers.
+package api
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/polycloze/polycloze/auth"
+	"github.com/polycloze/polycloze/logging"
+	"github.com/polycloze/polycloze/oidc"
+	"github.com/polycloze/polycloze/sessions"
+)
+
+// Binds sign-in state to the browser that started the sign-in.
+// Unlike the session cookie, it's sent when the provider redirects back,
+// because it's SameSite=Lax.
+const oidcStateCookie = "oidc-state"
+
+func setOIDCStateCookie(w http.ResponseWriter, state string, maxAge int) {
+	c := http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/signin/oidc",
+		MaxAge:   maxAge,
+		SameSite: http.SameSiteLaxMode,
+		HttpOnly: true,
+		Secure:   sessions.SecureCookies,
+	}
+	http.SetCookie(w, &c)
+}
+
+// Renders page after the provider redirects back.
+// The page redirects to next if it's set, or shows the error message.
+// Redirecting with a 3xx status wouldn't work, because browsers don't send
+// SameSite=Strict cookies (e.g. the session cookie) during redirects that
+// started on another site.
+func renderOIDCResult(w http.ResponseWriter, next, message, back string) {
+	renderTemplate(w, "oidc-callback.html", map[string]any{
+		"next":    next,
+		"message": message,
+		"back":    back,
+	})
+}
+
+// Redirects to the provider's authorization endpoint.
+// linkUserID: ID of signed-in user who's linking their account, or 0 if the
+// user is signing in
+func startOIDCLogin(w http.ResponseWriter, r *http.Request, linkUserID int) {
+	client := getConfig(r).OIDC
+	back := "/signin"
+	if linkUserID > 0 {
+		back = "/settings"
+	}
+
+	login := auth.OIDCLogin{LinkUserID: linkUserID}
+	for _, p := range []*string{&login.State, &login.Nonce, &login.Verifier} {
+		value, err := oidc.RandomString()
+		if err != nil {
+			logging.Error(r, err)
+			internalError(w)
+			return
+		}
+		*p = value
+	}
+
+	authURL, err := client.AuthCodeURL(r.Context(), login.State, login.Nonce, login.Verifier)
+	if err != nil {
+		logging.Error(r, err)
+		renderOIDCResult(w, "", "Single sign-on is unavailable right now. Please try again later.", back)
+		return
+	}
+	if err := auth.CreateOIDCLogin(auth.GetDB(r), login); err != nil {
+		logging.Error(r, err)
+		internalError(w)
+		return
+	}
+
+	setOIDCStateCookie(w, login.State, int((10 * time.Minute).Seconds()))
+	http.Redirect(w, r, authURL, http.StatusSeeOther)
+}
+
+// Starts single sign-on.
+func handleOIDCSignIn(w http.ResponseWriter, r *http.Request) {
+	if getConfig(r).OIDC == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	s, err := sessions.ResumeSession(auth.GetDB(r), w, r)
+	if err == nil && s.IsSignedIn() {
+		http.Redirect(w, r, "/welcome", http.StatusSeeOther)
+		return
+	}
+	startOIDCLogin(w, r, 0)
+}
+
+// Links the signed-in user's account to their identity from the provider.
+func handleLinkOIDC(w http.ResponseWriter, r *http.Request) {
+	if getConfig(r).OIDC == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "expected POST request", http.StatusBadRequest)
+		return
+	}
+
+	s, err := sessions.ResumeSession(auth.GetDB(r), w, r)
+	if err != nil || !s.IsSignedIn() {
+		http.Redirect(w, r, "/signin", http.StatusSeeOther)
+		return
+	}
+	if !sessions.CheckCSRFToken(s.ID, r.FormValue("csrf-token")) {
+		_ = s.ErrorMessage("Something went wrong. Please try again.", "oidc")
+		http.Redirect(w, r, "/settings", http.StatusSeeOther)
+		return
+	}
+	startOIDCLogin(w, r, s.Data["userID"].(int))
+}
+
+// Handles redirect from the provider.
+func handleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	config := getConfig(r)
+	if config.OIDC == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	db := auth.GetDB(r)
+	q := r.URL.Query()
+	state := q.Get("state")
+	c, err := r.Cookie(oidcStateCookie)
+	setOIDCStateCookie(w, "", -1)
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(c.Value), []byte(state)) != 1 {
+		renderOIDCResult(w, "", "Sign-in failed. Please try again.", "/signin")
+		return
+	}
+
+	login, err := auth.ConsumeOIDCLogin(db, state)
+	if err != nil {
+		if !errors.Is(err, auth.ErrInvalidLogin) {
+			logging.Error(r, err)
+		}
+		renderOIDCResult(w, "", "Sign-in expired. Please try again.", "/signin")
+		return
+	}
+	back := "/signin"
+	if login.LinkUserID > 0 {
+		back = "/settings"
+	}
+	if q.Get("error") != "" {
+		renderOIDCResult(w, "", "Sign-in was cancelled.", back)
+		return
+	}
+
+	token, err := config.OIDC.Exchange(r.Context(), q.Get("code"), login.Verifier, login.Nonce)
+	if err != nil {
+		logging.Error(r, err)
+		renderOIDCResult(w, "", "Sign-in failed. Please try again.", back)
+		return
+	}
+
+	if login.LinkUserID > 0 {
+		err := auth.LinkOIDCIdentity(db, login.LinkUserID, config.OIDC.Issuer(), token.Subject)
+		if errors.Is(err, auth.ErrIdentityInUse) {
+			renderOIDCResult(w, "", "This identity is already linked to another account.", back)
+			return
+		}
+		if err != nil {
+			logging.Error(r, err)
+			renderOIDCResult(w, "", "Something went wrong. Please try again.", back)
+			return
+		}
+		renderOIDCResult(w, "/settings", "", "")
+		return
+	}
+	signInOIDC(w, r, token)
+}
+
+// Signs in user with verified ID token.
+func signInOIDC(w http.ResponseWriter, r *http.Request, token *oidc.IDToken) {
+	db := auth.GetDB(r)
+	config := getConfig(r)
+
+	identity := auth.OIDCIdentity{
+		Issuer:   config.OIDC.Issuer(),
+		Subject:  token.Subject,
+		Username: token.PreferredUsername,
+	}
+	if identity.Username == "" {
+		identity.Username = token.Email
+	}
+	userID, err := auth.AuthenticateOIDC(db, identity, config.OIDCProvision)
+	if errors.Is(err, auth.ErrNoLinkedUser) {
+		renderOIDCResult(
+			w,
+			"",
+			"No account is linked to this identity. Sign in with your password, then link your account in the settings page.",
+			"/signin",
+		)
+		return
+	}
+	if errors.Is(err, auth.ErrDisabled) {
+		renderOIDCResult(w, "", "This account has been disabled.", "/signin")
+		return
+	}
+	if err != nil {
+		logging.Error(r, err)
+		renderOIDCResult(w, "", "Something went wrong. Please try again.", "/signin")
+		return
+	}
+
+	username, err := auth.GetUsername(db, userID)
+	if err != nil {
+		logging.Error(r, err)
+		internalError(w)
+		return
+	}
+	s, err := sessions.StartSession(db, w, r)
+	if err != nil {
+		logging.Error(r, err)
+		internalError(w)
+		return
+	}
+
+	// Users who enabled 2FA still need to enter a code.
+	hasTOTP, err := auth.HasTOTP(db, userID)
+	if err != nil {
+		logging.Error(r, err)
+		internalError(w)
+		return
+	}
+	if hasTOTP {
+		s.Data["pendingUserID"] = userID
+		s.Data["pendingSince"] = time.Now().Unix()
+		if err := sessions.SaveData(db, s); err != nil {
+			logging.Error(r, err)
+			internalError(w)
+			return
+		}
+		renderOIDCResult(w, "/signin/2fa", "", "")
+		return
+	}
+
+	attempt := auth.SignInAttempt{
+		Username: username,
+		IP:       clientIP(r),
+		Success:  true,
+		Time:     time.Now(),
+	}
+	if err := auth.RecordSignInAttempt(db, attempt); err != nil {
+		logging.Error(r, err)
+	}
+	s.Data["oidcSignedIn"] = time.Now().Unix()
+	if err := completeSignIn(r, s, userID, username); err != nil {
+		logging.Error(r, err)
+		internalError(w)
+		return
+	}
+	if err := initUserDirectory(userID); err != nil {
+		internalError(w)
+		return
+	}
+	renderOIDCResult(w, "/welcome", "", "")
+}
+
+// Adds single sign-on status to session data for rendering the settings page.
+func addOIDCData(r *http.Request, s *sessions.Session) error {
+	config := getConfig(r)
+	if config.OIDC == nil {
+		return nil
+	}
+
+	linked, err := auth.HasOIDCIdentity(auth.GetDB(r), s.Data["userID"].(int), config.OIDC.Issuer())
+	if err != nil {
+		return err
+	}
+	s.Data["oidcEnabled"] = true
+	s.Data["oidcName"] = config.OIDCName
+	s.Data["oidcLinked"] = linked
+	return nil
+}
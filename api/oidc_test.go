@@ -0,0 +1,242 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/polycloze/polycloze/auth"
+	"github.com/polycloze/polycloze/basedir"
+	"github.com/polycloze/polycloze/oidc"
+	"github.com/polycloze/polycloze/oidc/oidctest"
+	"github.com/polycloze/polycloze/sessions"
+)
+
+// Creates test server that uses the provider for single sign-on.
+// "/whoami" responds with the signed-in user's username, and "/csrf" with the
+// session's CSRF token.
+func oidcServer(db *sql.DB, p *oidctest.Provider, provision bool) *httptest.Server {
+	ts := httptest.NewUnstartedServer(nil)
+	base := "http://" + ts.Listener.Addr().String()
+
+	config := DefaultConfig()
+	config.OIDCProvision = provision
+	config.OIDC = oidc.NewClient(oidc.Config{
+		Issuer:       p.URL,
+		ClientID:     p.ClientID,
+		ClientSecret: p.ClientSecret,
+		RedirectURL:  base + "/signin/oidc/callback",
+	})
+
+	r := chi.NewRouter()
+	r.Use(configMiddleware(config))
+	r.Use(auth.Middleware(db))
+	r.HandleFunc("/signin/oidc", handleOIDCSignIn)
+	r.HandleFunc("/signin/oidc/callback", handleOIDCCallback)
+	r.HandleFunc("/settings/delete-account", handleDeleteAccount)
+	r.HandleFunc("/whoami", func(w http.ResponseWriter, r *http.Request) {
+		s, err := sessions.ResumeSession(db, w, r)
+		if err == nil && s.IsSignedIn() {
+			fmt.Fprint(w, s.Data["username"])
+		}
+	})
+	r.HandleFunc("/csrf", func(w http.ResponseWriter, r *http.Request) {
+		s, err := sessions.ResumeSession(db, w, r)
+		if err == nil && s.IsSignedIn() {
+			fmt.Fprint(w, sessions.CSRFToken(s.ID))
+		}
+	})
+	ts.Config.Handler = r
+	ts.Start()
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		panic(err)
+	}
+	ts.Client().Jar = jar
+	return ts
+}
+
+// Sends GET request and returns the response body.
+func get(t *testing.T, ts *httptest.Server, path string) string {
+	resp, err := ts.Client().Get(resolve(ts, path))
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	return string(body)
+}
+
+func TestOIDCSignInProvisionsUser(t *testing.T) {
+	t.Parallel()
+
+	db := testDB()
+	defer db.Close()
+	p := oidctest.NewProvider("polycloze", "secret")
+	defer p.Close()
+	p.SetUser(oidctest.User{Subject: "abc", PreferredUsername: "alice"})
+
+	ts := oidcServer(db, p, true)
+	defer ts.Close()
+
+	body := get(t, ts, "/signin/oidc")
+	if !strings.Contains(body, "url=/welcome") {
+		t.Fatal("expected sign-in to succeed:", body)
+	}
+	if username := get(t, ts, "/whoami"); username != "alice" {
+		t.Fatal("expected provisioned user to be signed in:", username)
+	}
+}
+
+func TestOIDCSignInWithoutLinkedAccount(t *testing.T) {
+	t.Parallel()
+
+	db := testDB()
+	defer db.Close()
+	p := oidctest.NewProvider("polycloze", "")
+	defer p.Close()
+
+	if err := auth.Register(db, "alice", "password"); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	ts := oidcServer(db, p, false)
+	defer ts.Close()
+
+	body := get(t, ts, "/signin/oidc")
+	if !strings.Contains(body, "No account is linked") {
+		t.Fatal("expected sign-in to fail:", body)
+	}
+	if username := get(t, ts, "/whoami"); username != "" {
+		t.Fatal("expected user to not be signed in:", username)
+	}
+
+	if err := auth.LinkOIDCIdentity(db, 1, p.URL, "1"); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	get(t, ts, "/signin/oidc")
+	if username := get(t, ts, "/whoami"); username != "alice" {
+		t.Fatal("expected linked user to be signed in:", username)
+	}
+}
+
+func TestOIDCCallbackRequiresStateCookie(t *testing.T) {
+	// Prevents attackers from signing in victims into the attackers' accounts.
+	t.Parallel()
+
+	db := testDB()
+	defer db.Close()
+	p := oidctest.NewProvider("polycloze", "")
+	defer p.Close()
+
+	login := auth.OIDCLogin{State: "state", Nonce: "nonce", Verifier: "verifier"}
+	if err := auth.CreateOIDCLogin(db, login); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	ts := oidcServer(db, p, true)
+	defer ts.Close()
+
+	body := get(t, ts, "/signin/oidc/callback?state=state&code=code")
+	if !strings.Contains(body, "Sign-in failed") {
+		t.Fatal("expected callback without state cookie to fail:", body)
+	}
+}
+
+func TestDeleteAccountAfterOIDCSignIn(t *testing.T) {
+	// Provisioned users don't know their password.
+	t.Parallel()
+
+	db := testDB()
+	defer db.Close()
+	p := oidctest.NewProvider("polycloze", "secret")
+	defer p.Close()
+	p.SetUser(oidctest.User{Subject: "abc", PreferredUsername: "alice"})
+
+	// Makes the provisioned user get an unusual user ID, because tests share
+	// the state directory.
+	if err := auth.Register(db, "placeholder", "password"); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if _, err := db.Exec(`UPDATE user SET id = 4410`); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	ts := oidcServer(db, p, true)
+	defer ts.Close()
+	get(t, ts, "/signin/oidc")
+
+	v := url.Values{}
+	v.Set("confirm", "alice")
+	v.Set("csrf-token", get(t, ts, "/csrf"))
+
+	// The sign-in is too old.
+	query := `UPDATE user_session SET oidc_signed_in = ?`
+	if _, err := db.Exec(query, time.Now().Add(-time.Hour).Unix()); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	submit(t, ts, "/settings/delete-account", v)
+	if _, err := auth.GetUserID(db, "alice"); err != nil {
+		t.Fatal("expected old sign-in to be rejected:", err)
+	}
+
+	if _, err := db.Exec(query, time.Now().Unix()); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	submit(t, ts, "/settings/delete-account", v)
+	if _, err := auth.GetUserID(db, "alice"); err == nil {
+		t.Fatal("expected user to be deleted")
+	}
+}
+
+func TestDeleteAccountAfterOIDCSignInRequiresPasswordIfLinked(t *testing.T) {
+	// Users who linked an existing account know their password.
+	t.Parallel()
+
+	db := testDB()
+	defer db.Close()
+	p := oidctest.NewProvider("polycloze", "secret")
+	defer p.Close()
+	p.SetUser(oidctest.User{Subject: "abc", PreferredUsername: "bob"})
+
+	if err := auth.Register(db, "bob", "password"); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if _, err := db.Exec(`UPDATE user SET id = 4411`); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	defer os.RemoveAll(basedir.User(4411))
+	if err := auth.LinkOIDCIdentity(db, 4411, p.URL, "abc"); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	ts := oidcServer(db, p, false)
+	defer ts.Close()
+	get(t, ts, "/signin/oidc")
+
+	v := url.Values{}
+	v.Set("confirm", "bob")
+	v.Set("csrf-token", get(t, ts, "/csrf"))
+	submit(t, ts, "/settings/delete-account", v)
+	if _, err := auth.GetUserID(db, "bob"); err != nil {
+		t.Fatal("expected linked user to need their password:", err)
+	}
+}
@@ -0,0 +1,302 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+// Generates OpenAPI document for /api/v1 from the endpoint table and the
+// request/response types in schema.go.
+package api
+
+import (
+	"net/http"
+	"path"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Version of the /api/v1 document.
+// Bump the minor version for backwards-compatible changes.
+const apiVersion = "1.0.0"
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// Matches path params (e.g. "{l1}").
+var pathParamPattern = regexp.MustCompile(`\{([^}]+)\}`)
+
+// Builds JSON schemas for Go types.
+// Named struct types go into the components section.
+type schemaBuilder struct {
+	components map[string]any
+}
+
+// Returns name of component for the type.
+// Names are qualified by package name to avoid collisions (e.g.
+// "flashcards.Sentence" and "sentences.Sentence").
+// Type args of generic types become part of the name (e.g. "Metric[int]" ->
+// "history.Metric_int").
+// Returns an empty string for anonymous types.
+func componentName(t reflect.Type) string {
+	name := t.Name()
+	if name == "" {
+		return ""
+	}
+	if i := strings.Index(name, "["); i >= 0 {
+		args := strings.TrimSuffix(name[i+1:], "]")
+		var parts []string
+		for _, arg := range strings.Split(args, ",") {
+			// Drop package path of type args.
+			arg = arg[strings.LastIndex(arg, ".")+1:]
+			parts = append(parts, arg)
+		}
+		name = name[:i] + "_" + strings.Join(parts, "_")
+	}
+	return path.Base(t.PkgPath()) + "." + name
+}
+
+// Parses json struct tag.
+// Returns the field name, whether the field can be omitted, and whether the
+// field is skipped.
+func parseJSONTag(field reflect.StructField) (string, bool, bool) {
+	tag, ok := field.Tag.Lookup("json")
+	if !ok {
+		return field.Name, false, false
+	}
+	if tag == "-" {
+		return "", false, true
+	}
+
+	name, options, _ := strings.Cut(tag, ",")
+	if name == "" {
+		name = field.Name
+	}
+	return name, strings.Contains(options, "omitempty"), false
+}
+
+// Returns JSON schema of type.
+func (b *schemaBuilder) schema(t reflect.Type) map[string]any {
+	if t == timeType {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		schema := b.schema(t.Elem())
+		if _, ok := schema["$ref"]; ok {
+			// Siblings of $ref are ignored in OpenAPI 3.0.
+			return map[string]any{
+				"allOf":    []any{schema},
+				"nullable": true,
+			}
+		}
+		schema["nullable"] = true
+		return schema
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]any{"type": "integer", "format": "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{
+			"type":  "array",
+			"items": b.schema(t.Elem()),
+		}
+	case reflect.Map:
+		return map[string]any{
+			"type":                 "object",
+			"additionalProperties": b.schema(t.Elem()),
+		}
+	case reflect.Struct:
+		return b.structSchema(t)
+	default:
+		return map[string]any{}
+	}
+}
+
+// Returns reference to the struct's component schema.
+// Anonymous structs are inlined.
+func (b *schemaBuilder) structSchema(t reflect.Type) map[string]any {
+	name := componentName(t)
+	if name != "" {
+		ref := map[string]any{"$ref": "#/components/schemas/" + name}
+		if _, ok := b.components[name]; ok {
+			return ref
+		}
+		// Reserve name first in case the type is recursive.
+		b.components[name] = nil
+	}
+
+	properties := make(map[string]any)
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		fieldName, omitempty, skip := parseJSONTag(field)
+		if skip {
+			continue
+		}
+		properties[fieldName] = b.schema(field.Type)
+		if !omitempty {
+			required = append(required, fieldName)
+		}
+	}
+
+	schema := map[string]any{
+		"type":       "object",
+		"properties": properties,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+
+	if name == "" {
+		return schema
+	}
+	b.components[name] = schema
+	return map[string]any{"$ref": "#/components/schemas/" + name}
+}
+
+// Returns response object with a JSON body.
+func (b *schemaBuilder) jsonResponse(description string, v any) map[string]any {
+	return map[string]any{
+		"description": description,
+		"content": map[string]any{
+			"application/json": map[string]any{
+				"schema": b.schema(reflect.TypeOf(v)),
+			},
+		},
+	}
+}
+
+// Returns OpenAPI operation object for endpoint.
+func (b *schemaBuilder) operation(e endpoint) map[string]any {
+	var parameters []any
+	for _, match := range pathParamPattern.FindAllStringSubmatch(e.Path, -1) {
+		parameters = append(parameters, map[string]any{
+			"name":        match[1],
+			"in":          "path",
+			"required":    true,
+			"description": "ISO 639-3 language code",
+			"schema":      map[string]any{"type": "string"},
+		})
+	}
+	for _, param := range e.Query {
+		parameters = append(parameters, map[string]any{
+			"name":        param.Name,
+			"in":          "query",
+			"required":    false,
+			"description": param.Description,
+			"schema":      map[string]any{"type": param.Type},
+		})
+	}
+	if e.CSRF {
+		parameters = append(parameters, map[string]any{
+			"name":        "X-CSRF-Token",
+			"in":          "header",
+			"required":    false,
+			"description": "Required when authenticating with a session cookie",
+			"schema":      map[string]any{"type": "string"},
+		})
+	}
+
+	responses := map[string]any{
+		"200": b.jsonResponse("Success", e.Response),
+		"400": b.jsonResponse("Bad request", ErrorResponse{}),
+		"500": b.jsonResponse("Internal server error", ErrorResponse{}),
+	}
+	if strings.Contains(e.Path, "{l1}") {
+		responses["404"] = b.jsonResponse("Course not found", ErrorResponse{})
+	}
+
+	operation := map[string]any{
+		"summary":   e.Summary,
+		"responses": responses,
+	}
+	if len(parameters) > 0 {
+		operation["parameters"] = parameters
+	}
+	if e.Request != nil {
+		responses["413"] = b.jsonResponse("Request body is too large", ErrorResponse{})
+		responses["415"] = b.jsonResponse("Request body isn't JSON", ErrorResponse{})
+		operation["requestBody"] = map[string]any{
+			"required": true,
+			"content": map[string]any{
+				"application/json": map[string]any{
+					"schema": b.schema(reflect.TypeOf(e.Request)),
+				},
+			},
+		}
+	}
+
+	if e.Scope == "" {
+		operation["security"] = []any{}
+	} else {
+		// Scopes can only be listed for OAuth2 and OpenID Connect schemes.
+		operation["security"] = []any{
+			map[string]any{"cookieAuth": []string{}},
+			map[string]any{"bearerAuth": []string{}},
+		}
+		operation["description"] = "API tokens need the `" + e.Scope + "` scope."
+		operation["x-scope"] = e.Scope
+		responses["401"] = b.jsonResponse("Not signed in", ErrorResponse{})
+		responses["403"] = b.jsonResponse("Forbidden", ErrorResponse{})
+	}
+	return operation
+}
+
+// Generates OpenAPI document.
+func openAPIDocument(endpoints []endpoint) map[string]any {
+	b := schemaBuilder{
+		components: make(map[string]any),
+	}
+
+	paths := make(map[string]any)
+	for _, e := range endpoints {
+		key := "/api/v1" + e.Path
+		item, ok := paths[key].(map[string]any)
+		if !ok {
+			item = make(map[string]any)
+			paths[key] = item
+		}
+		item[strings.ToLower(e.Method)] = b.operation(e)
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "polycloze API",
+			"version": apiVersion,
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": b.components,
+			"securitySchemes": map[string]any{
+				"cookieAuth": map[string]any{
+					"type": "apiKey",
+					"in":   "cookie",
+					"name": "id",
+				},
+				"bearerAuth": map[string]any{
+					"type":        "http",
+					"scheme":      "bearer",
+					"description": "Personal access token created in /settings",
+				},
+			},
+		},
+	}
+}
+
+// Usage: r.Get("/openapi.json", serveOpenAPI(endpoints))
+func serveOpenAPI(endpoints []endpoint) http.HandlerFunc {
+	document := openAPIDocument(endpoints)
+	return func(w http.ResponseWriter, r *http.Request) {
+		sendJSON(w, document)
+	}
+}
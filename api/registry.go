@@ -0,0 +1,319 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+// Registry of installed courses.
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/polycloze/polycloze/basedir"
+	"github.com/polycloze/polycloze/coursecheck"
+	"github.com/polycloze/polycloze/database"
+)
+
+// Installed course.
+type InstalledCourse struct {
+	Course
+
+	// Changes whenever the course DB gets replaced.
+	Version string `json:"version"`
+}
+
+// Returns ETag of the course DB.
+func (c InstalledCourse) ETag() string {
+	return fmt.Sprintf(`"%s"`, c.Version)
+}
+
+// Course DB that failed validation.
+type rejectedCourse struct {
+	version string
+	err     error
+}
+
+type courseRegistry struct {
+	// Held during reloads, so that only one runs at a time.
+	reloading sync.Mutex
+
+	mu       sync.RWMutex
+	courses  map[string]InstalledCourse // Course code (e.g. "eng-spa") -> course
+	rejected map[string]rejectedCourse  // Course code -> rejected DB
+	version  string                     // Changes whenever any course changes
+}
+
+var registry courseRegistry
+
+// Summary of changes found by ReloadCourses.
+// Contains course codes, e.g. "eng-spa".
+type CourseChanges struct {
+	Added   []string `json:"added"`
+	Updated []string `json:"updated"`
+	Removed []string `json:"removed"`
+
+	// Course code -> reason.
+	// Only contains DBs that were rejected in this reload.
+	Rejected map[string]string `json:"rejected"`
+}
+
+// Checks if anything changed.
+func (c CourseChanges) Empty() bool {
+	return len(c.Added) == 0 && len(c.Updated) == 0 && len(c.Removed) == 0 && len(c.Rejected) == 0
+}
+
+func (c CourseChanges) String() string {
+	var parts []string
+	if len(c.Added) > 0 {
+		parts = append(parts, "added "+strings.Join(c.Added, ", "))
+	}
+	if len(c.Updated) > 0 {
+		parts = append(parts, "updated "+strings.Join(c.Updated, ", "))
+	}
+	if len(c.Removed) > 0 {
+		parts = append(parts, "removed "+strings.Join(c.Removed, ", "))
+	}
+
+	var codes []string
+	for code := range c.Rejected {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	for _, code := range codes {
+		parts = append(parts, fmt.Sprintf("rejected %v (%v)", code, c.Rejected[code]))
+	}
+
+	if len(parts) == 0 {
+		return "no changes"
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Version of course DB file.
+// Uses the modification time and the size, so that unchanged files don't
+// have to be read.
+func fileVersion(info os.FileInfo) string {
+	return fmt.Sprintf("%x-%x", info.ModTime().UnixNano(), info.Size())
+}
+
+// Checks if the file is a usable course DB.
+// The file name has to match the course's languages, e.g. "eng-spa.db".
+func validateCourse(path string) (Course, error) {
+	course, err := getCourseInfo(path)
+	if err != nil {
+		return course, err
+	}
+
+	code := strings.TrimSuffix(filepath.Base(path), ".db")
+	if expected := course.L1.Code + "-" + course.L2.Code; code != expected {
+		return course, fmt.Errorf("course database should be named %v.db", expected)
+	}
+
+	db, err := database.Open(path)
+	if err != nil {
+		return course, fmt.Errorf("could not open course database: %w", err)
+	}
+	defer db.Close()
+
+	// Same schema check as `polycloze validate-course`.
+	if err := coursecheck.CheckSchema(db); err != nil {
+		return course, fmt.Errorf("invalid course database: %w", err)
+	}
+	return course, nil
+}
+
+// Returns installed courses, sorted by course code.
+func installedCourses() []InstalledCourse {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	courses := make([]InstalledCourse, 0, len(registry.courses))
+	for _, course := range registry.courses {
+		courses = append(courses, course)
+	}
+	sort.Slice(courses, func(i, j int) bool {
+		return courses[i].L1.Code+"-"+courses[i].L2.Code < courses[j].L1.Code+"-"+courses[j].L2.Code
+	})
+	return courses
+}
+
+// Returns course DBs that failed validation.
+// Course code -> reason.
+func rejectedCourses() map[string]string {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	rejected := make(map[string]string)
+	for code, r := range registry.rejected {
+		rejected[code] = r.err.Error()
+	}
+	return rejected
+}
+
+// Looks up installed course by code (e.g. "eng-spa").
+func lookupCourse(code string) (InstalledCourse, bool) {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+	course, ok := registry.courses[code]
+	return course, ok
+}
+
+// Returns ETag of courses.json and languages.json.
+// Returns an empty string if courses haven't been loaded yet.
+func catalogETag() string {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+	if registry.version == "" {
+		return ""
+	}
+	return fmt.Sprintf(`"%s"`, registry.version)
+}
+
+// Computes version of the set of courses.
+func catalogVersion(courses map[string]InstalledCourse) string {
+	var codes []string
+	for code := range courses {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+
+	h := sha256.New()
+	for _, code := range codes {
+		fmt.Fprintf(h, "%v %v\n", code, courses[code].Version)
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// Looks for new, updated and removed courses in the data directory.
+// Invalid course DBs are skipped, and unchanged files don't get validated
+// again.
+// Updates courses.json and languages.json if anything changed.
+func ReloadCourses() (CourseChanges, error) {
+	return registry.reload(basedir.DataDir, basedir.StateDir)
+}
+
+// See ReloadCourses.
+func (reg *courseRegistry) reload(dataDir, stateDir string) (CourseChanges, error) {
+	reg.reloading.Lock()
+	defer reg.reloading.Unlock()
+
+	reg.mu.RLock()
+	previous := reg.courses
+	previousRejected := reg.rejected
+	initialized := reg.courses != nil
+	reg.mu.RUnlock()
+
+	courses := make(map[string]InstalledCourse)
+	rejected := make(map[string]rejectedCourse)
+	changes := CourseChanges{Rejected: make(map[string]string)}
+
+	matches, _ := filepath.Glob(filepath.Join(dataDir, "courses", "*.db"))
+	for _, match := range matches {
+		code := strings.TrimSuffix(filepath.Base(match), ".db")
+		info, err := os.Stat(match)
+		if err != nil {
+			// The file got removed during the reload.
+			continue
+		}
+		version := fileVersion(info)
+
+		if course, ok := previous[code]; ok && course.Version == version {
+			courses[code] = course
+			continue
+		}
+		if r, ok := previousRejected[code]; ok && r.version == version {
+			rejected[code] = r
+			continue
+		}
+
+		course, err := validateCourse(match)
+		if err != nil {
+			rejected[code] = rejectedCourse{version: version, err: err}
+			changes.Rejected[code] = err.Error()
+			continue
+		}
+		courses[code] = InstalledCourse{Course: course, Version: version}
+		if _, ok := previous[code]; ok {
+			changes.Updated = append(changes.Updated, code)
+		} else {
+			changes.Added = append(changes.Added, code)
+		}
+	}
+	for code := range previous {
+		if _, ok := courses[code]; !ok {
+			changes.Removed = append(changes.Removed, code)
+		}
+	}
+	sort.Strings(changes.Removed)
+
+	if initialized && len(changes.Added) == 0 && len(changes.Updated) == 0 && len(changes.Removed) == 0 {
+		reg.mu.Lock()
+		reg.rejected = rejected
+		reg.mu.Unlock()
+		return changes, nil
+	}
+
+	if err := writeCatalog(stateDir, courses); err != nil {
+		return changes, err
+	}
+
+	reg.mu.Lock()
+	reg.courses = courses
+	reg.rejected = rejected
+	reg.version = catalogVersion(courses)
+	reg.mu.Unlock()
+	return changes, nil
+}
+
+// Writes courses.json and languages.json.
+func writeCatalog(stateDir string, installed map[string]InstalledCourse) error {
+	var courses []Course
+	for _, course := range installed {
+		courses = append(courses, course.Course)
+	}
+	sort.Slice(courses, func(i, j int) bool {
+		if courses[i].L1.Code != courses[j].L1.Code {
+			return courses[i].L1.Code < courses[j].L1.Code
+		}
+		return courses[i].L2.Code < courses[j].L2.Code
+	})
+	languages := findL1Languages(courses)
+	sort.Sort(ByCode(languages))
+
+	coursesJSON := filepath.Join(stateDir, "courses.json")
+	err := writeJSON(coursesJSON, map[string][]Course{
+		"courses": courses,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write courses.json: %w", err)
+	}
+
+	languagesJSON := filepath.Join(stateDir, "languages.json")
+	err = writeJSON(languagesJSON, map[string][]Language{
+		"languages": languages,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write languages.json: %w", err)
+	}
+	return nil
+}
+
+// Reloads courses every interval.
+func WatchCourses(interval time.Duration) {
+	for range time.Tick(interval) {
+		changes, err := ReloadCourses()
+		if err != nil {
+			log.Println(err)
+		}
+		if !changes.Empty() {
+			log.Println("Reloaded courses:", changes)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/polycloze/polycloze/database"
+)
+
+// Creates minimal course DB in dataDir.
+func createCourse(t *testing.T, dataDir, l1, l2 string) string {
+	if err := os.MkdirAll(filepath.Join(dataDir, "courses"), 0o700); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	path := filepath.Join(dataDir, "courses", l1+"-"+l2+".db")
+	db, err := database.Open(path)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	defer db.Close()
+
+	query := `
+		PRAGMA user_version = 5;
+		CREATE TABLE language (id TEXT, code TEXT, name TEXT, bcp47 TEXT);
+		CREATE TABLE word (id INTEGER, frequency_class INTEGER);
+		CREATE TABLE sentence (id INTEGER);
+		CREATE TABLE translation (id INTEGER);
+		CREATE TABLE translates (source INTEGER, target INTEGER);
+		CREATE TABLE contains (sentence INTEGER, word INTEGER);
+		CREATE INDEX index_contains_word ON contains (word);
+		CREATE INDEX index_translates_source ON translates (source);
+		CREATE INDEX index_word_frequency_class ON word (frequency_class);
+		INSERT INTO language VALUES ('l1', ?, ?, ?), ('l2', ?, ?, ?);
+	`
+	if _, err := db.Exec(query, l1, l1, l1, l2, l2, l2); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	return path
+}
+
+func TestReloadCourses(t *testing.T) {
+	t.Parallel()
+
+	dataDir := t.TempDir()
+	stateDir := t.TempDir()
+	var reg courseRegistry
+
+	createCourse(t, dataDir, "eng", "spa")
+	changes, err := reg.reload(dataDir, stateDir)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if len(changes.Added) != 1 || changes.Added[0] != "eng-spa" {
+		t.Fatal("expected course to be added:", changes)
+	}
+	version := reg.courses["eng-spa"].Version
+	catalog := reg.version
+
+	// Unchanged files don't count as changes.
+	changes, err = reg.reload(dataDir, stateDir)
+	if err != nil || !changes.Empty() {
+		t.Fatal("expected no changes:", changes, err)
+	}
+
+	// Installing a course updates courses.json.
+	path := createCourse(t, dataDir, "eng", "deu")
+	if _, err := reg.reload(dataDir, stateDir); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	var response struct {
+		Courses []Course `json:"courses"`
+	}
+	bytes, err := os.ReadFile(filepath.Join(stateDir, "courses.json"))
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if err := json.Unmarshal(bytes, &response); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if len(response.Courses) != 2 {
+		t.Fatal("expected courses.json to list new course:", response.Courses)
+	}
+	if reg.version == catalog {
+		t.Fatal("expected catalog version to change")
+	}
+	if reg.courses["eng-spa"].Version != version {
+		t.Fatal("expected version of unchanged course to stay the same")
+	}
+
+	// Updating a course changes its version.
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	changes, err = reg.reload(dataDir, stateDir)
+	if err != nil || len(changes.Updated) != 1 || changes.Updated[0] != "eng-deu" {
+		t.Fatal("expected course to be updated:", changes, err)
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	changes, err = reg.reload(dataDir, stateDir)
+	if err != nil || len(changes.Removed) != 1 || changes.Removed[0] != "eng-deu" {
+		t.Fatal("expected course to be removed:", changes, err)
+	}
+}
+
+func TestReloadCoursesSkipsInvalidCourses(t *testing.T) {
+	t.Parallel()
+
+	dataDir := t.TempDir()
+	stateDir := t.TempDir()
+	var reg courseRegistry
+
+	createCourse(t, dataDir, "eng", "spa")
+
+	// File name doesn't match the course's languages.
+	path := createCourse(t, dataDir, "eng", "deu")
+	if err := os.Rename(path, filepath.Join(dataDir, "courses", "eng-fra.db")); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	// Not a course DB.
+	junk := filepath.Join(dataDir, "courses", "eng-ita.db")
+	if err := os.WriteFile(junk, []byte("junk"), 0o600); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	changes, err := reg.reload(dataDir, stateDir)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if len(changes.Added) != 1 || len(changes.Rejected) != 2 {
+		t.Fatal("expected invalid courses to be rejected:", changes)
+	}
+
+	// Rejected files that haven't changed don't get reported again.
+	changes, err = reg.reload(dataDir, stateDir)
+	if err != nil || !changes.Empty() {
+		t.Fatal("expected no changes:", changes, err)
+	}
+}
+
+func TestReloadCoursesChecksSchema(t *testing.T) {
+	// Should reject the same DBs that `polycloze validate-course` rejects.
+	t.Parallel()
+
+	dataDir := t.TempDir()
+	stateDir := t.TempDir()
+	var reg courseRegistry
+
+	path := createCourse(t, dataDir, "eng", "spa")
+	db, err := database.Open(path)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	_, err = db.Exec(`PRAGMA user_version = 4`)
+	db.Close()
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	path = createCourse(t, dataDir, "eng", "deu")
+	db, err = database.Open(path)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	_, err = db.Exec(`DROP INDEX index_contains_word`)
+	db.Close()
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	changes, err := reg.reload(dataDir, stateDir)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if len(changes.Added) != 0 || len(changes.Rejected) != 2 {
+		t.Fatal("expected courses with invalid schema to be rejected:", changes)
+	}
+}
+
+func TestRejectedCourseNotFound(t *testing.T) {
+	// Modifies the global registry, so this test doesn't run in parallel.
+	dataDir := t.TempDir()
+	stateDir := t.TempDir()
+	t.Cleanup(func() {
+		registry.mu.Lock()
+		registry.courses = nil
+		registry.rejected = nil
+		registry.version = ""
+		registry.mu.Unlock()
+	})
+
+	createCourse(t, dataDir, "eng", "spa")
+	path := createCourse(t, dataDir, "eng", "deu")
+	db, err := database.Open(path)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	_, err = db.Exec(`PRAGMA user_version = 4`)
+	db.Close()
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	if _, err := registry.reload(dataDir, stateDir); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	ts := testV1Server(t)
+
+	// The course exists, but the client isn't signed in.
+	resp, err := ts.Client().Get(resolve(ts, "/api/v1/vocabulary/eng/spa"))
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	checkErrorResponse(t, resp, http.StatusUnauthorized, errUnauthorized)
+
+	// The DB file exists, but it was rejected.
+	resp, err = ts.Client().Get(resolve(ts, "/api/v1/vocabulary/eng/deu"))
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	checkErrorResponse(t, resp, http.StatusNotFound, errNotFound)
+}
@@ -0,0 +1,217 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+// Password reset handlers.
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/polycloze/polycloze/auth"
+	"github.com/polycloze/polycloze/logging"
+	"github.com/polycloze/polycloze/sessions"
+)
+
+// Checks if users can reset their passwords by email.
+func passwordResetEnabled(r *http.Request) bool {
+	config := getConfig(r)
+	return config.Mail != nil && config.ProxyAuthHeader == "" && config.Demo == ""
+}
+
+// Emails password reset link, if a user has the email address.
+// Doesn't return an error if no one does, so that responses don't reveal
+// who has an account.
+// The email gets sent in the background, so that the response time doesn't
+// reveal it either.
+func sendPasswordReset(r *http.Request, email string) error {
+	config := getConfig(r)
+	reset, err := auth.CreatePasswordReset(auth.GetDB(r), email, time.Now())
+	if errors.Is(err, auth.ErrUnknownEmail) || errors.Is(err, auth.ErrResetTooSoon) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	link := strings.TrimSuffix(config.MailBaseURL, "/") +
+		"/reset-password/confirm?token=" + url.QueryEscape(reset.Token)
+	body := fmt.Sprintf(`Hi %v,
+
+Someone asked to reset the password of your polycloze account.
+If it was you, open this link to choose a new password:
+
+%v
+
+The link expires in %v.
+If you didn't ask for this, you can ignore this email.
+`, reset.Username, link, formatWait(auth.ResetTokenLifetime))
+	go func() {
+		err := config.Mail.Send(reset.Email, "Reset your polycloze password", body)
+		if err != nil {
+			logging.Error(r, err)
+		}
+	}()
+	return nil
+}
+
+// Sends password reset link to the email address in the form.
+func handleResetPassword(w http.ResponseWriter, r *http.Request) {
+	if !passwordResetEnabled(r) {
+		http.NotFound(w, r)
+		return
+	}
+
+	db := auth.GetDB(r)
+	s, err := sessions.StartOrResumeSession(db, w, r)
+	if err != nil {
+		internalError(w)
+		return
+	}
+	if s.IsSignedIn() {
+		http.Redirect(w, r, "/settings", http.StatusSeeOther)
+		return
+	}
+
+	if r.Method == "POST" {
+		if !sessions.CheckCSRFToken(s.ID, r.FormValue("csrf-token")) {
+			_ = s.ErrorMessage("Something went wrong. Please try again.", "reset-password")
+			goto fail
+		}
+
+		// Failures aren't shown to the user, because they only happen if
+		// the email address belongs to someone.
+		if err := sendPasswordReset(r, r.FormValue("email")); err != nil {
+			logging.Error(r, err)
+		}
+		_ = s.SuccessMessage(
+			"If an account has this email address, we sent a link for resetting its password to it.",
+			"reset-password",
+		)
+	}
+
+fail:
+	messages, _ := s.Messages("reset-password")
+	renderTemplate(w, "reset-password.html", map[string]any{
+		"csrfToken": sessions.CSRFToken(s.ID),
+		"messages":  messages,
+	})
+}
+
+// Changes password of the user with the reset token in the link.
+func handleResetPasswordConfirm(w http.ResponseWriter, r *http.Request) {
+	if !passwordResetEnabled(r) {
+		http.NotFound(w, r)
+		return
+	}
+
+	db := auth.GetDB(r)
+	s, err := sessions.StartOrResumeSession(db, w, r)
+	if err != nil {
+		internalError(w)
+		return
+	}
+	if s.IsSignedIn() {
+		http.Redirect(w, r, "/settings", http.StatusSeeOther)
+		return
+	}
+
+	token := r.FormValue("token")
+	minLength := getConfig(r).MinPasswordLength
+	username, err := auth.CheckResetToken(db, token, time.Now())
+	if err != nil {
+		if !errors.Is(err, auth.ErrInvalidResetToken) {
+			logging.Error(r, err)
+		}
+		renderTemplate(w, "reset-password-confirm.html", map[string]any{
+			"invalid": true,
+		})
+		return
+	}
+
+	if r.Method == "POST" {
+		password := r.FormValue("password")
+		if !sessions.CheckCSRFToken(s.ID, r.FormValue("csrf-token")) {
+			_ = s.ErrorMessage("Something went wrong. Please try again.", "reset-password")
+			goto fail
+		}
+		if err := auth.CheckPassword(username, password, minLength); err != nil {
+			_ = s.ErrorMessage(auth.PasswordMessage(err, minLength), "reset-password")
+			goto fail
+		}
+
+		if err := auth.ResetPassword(db, token, password, time.Now()); err != nil {
+			logging.Error(r, err)
+			_ = s.ErrorMessage("Something went wrong. Please try again.", "reset-password")
+			goto fail
+		}
+		_ = s.SuccessMessage("Password updated. You can sign in with it now.", "sign-in")
+		http.Redirect(w, r, "/signin", http.StatusSeeOther)
+		return
+	}
+
+fail:
+	messages, _ := s.Messages("reset-password")
+	renderTemplate(w, "reset-password-confirm.html", map[string]any{
+		"csrfToken":         sessions.CSRFToken(s.ID),
+		"messages":          messages,
+		"token":             token,
+		"username":          username,
+		"minPasswordLength": minLength,
+	})
+}
+
+// Changes the signed-in user's email address.
+// Requires the current password, because the email address can be used to
+// take over the account.
+func handleChangeEmail(w http.ResponseWriter, r *http.Request) {
+	if !passwordResetEnabled(r) {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "expected POST request", http.StatusBadRequest)
+		return
+	}
+
+	db := auth.GetDB(r)
+	s, err := sessions.ResumeSession(db, w, r)
+	if err != nil || !s.IsSignedIn() {
+		http.Redirect(w, r, "/signin", http.StatusSeeOther)
+		return
+	}
+	username := s.Data["username"].(string)
+
+	if !sessions.CheckCSRFToken(s.ID, r.FormValue("csrf-token")) {
+		_ = s.ErrorMessage("Something went wrong. Please try again.", "email")
+		goto fail
+	}
+
+	if err := confirmPassword(w, r, username, r.FormValue("password")); err != nil {
+		_ = s.ErrorMessage(confirmPasswordMessage(err), "email")
+		goto fail
+	}
+
+	err = auth.SetEmail(db, s.Data["userID"].(int), r.FormValue("email"))
+	if errors.Is(err, auth.ErrInvalidEmail) {
+		_ = s.ErrorMessage("Invalid email address.", "email")
+		goto fail
+	}
+	if errors.Is(err, auth.ErrEmailInUse) {
+		_ = s.ErrorMessage("This email address is used by another account.", "email")
+		goto fail
+	}
+	if err != nil {
+		logging.Error(r, err)
+		_ = s.ErrorMessage("Something went wrong. Please try again.", "email")
+		goto fail
+	}
+	_ = s.SuccessMessage("Email address updated.", "email")
+
+fail:
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
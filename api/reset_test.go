@@ -0,0 +1,177 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/polycloze/polycloze/auth"
+	"github.com/polycloze/polycloze/mail"
+	"github.com/polycloze/polycloze/mail/mailtest"
+	"github.com/polycloze/polycloze/sessions"
+)
+
+// Creates test server that sends mail through the SMTP server.
+// "/csrf" responds with the session's CSRF token.
+func resetServer(db *sql.DB, smtp *mailtest.Server) *httptest.Server {
+	ts := httptest.NewUnstartedServer(nil)
+
+	config := DefaultConfig()
+	config.Mail = mail.NewClient(mail.Config{
+		Host: smtp.Host,
+		Port: smtp.Port,
+		From: "polycloze <noreply@example.com>",
+	})
+	config.MailBaseURL = "http://" + ts.Listener.Addr().String()
+
+	r := chi.NewRouter()
+	r.Use(configMiddleware(config))
+	r.Use(auth.Middleware(db))
+	r.HandleFunc("/signin", handleSignIn)
+	r.HandleFunc("/reset-password", handleResetPassword)
+	r.HandleFunc("/reset-password/confirm", handleResetPasswordConfirm)
+	r.HandleFunc("/settings/email", handleChangeEmail)
+	r.HandleFunc("/csrf", func(w http.ResponseWriter, r *http.Request) {
+		s, err := sessions.ResumeSession(db, w, r)
+		if err == nil && s.IsSignedIn() {
+			fmt.Fprint(w, sessions.CSRFToken(s.ID))
+		}
+	})
+	ts.Config.Handler = r
+	ts.Start()
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		panic(err)
+	}
+	ts.Client().Jar = jar
+	return ts
+}
+
+// Requests password reset link for the email address.
+func requestPasswordReset(t *testing.T, ts *httptest.Server, email string) string {
+	v := url.Values{}
+	v.Set("email", email)
+	v.Set("csrf-token", pageCSRFToken(t, ts, "/reset-password"))
+	return submit(t, ts, "/reset-password", v)
+}
+
+// Waits until the SMTP server receives n messages, and returns them.
+// Reset links get sent in the background.
+func waitForMessages(t *testing.T, smtp *mailtest.Server, n int) []mailtest.Message {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		messages := smtp.Messages()
+		if len(messages) >= n || time.Now().After(deadline) {
+			return messages
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPasswordReset(t *testing.T) {
+	t.Parallel()
+
+	db := testDB()
+	defer db.Close()
+	smtp := mailtest.NewServer()
+	defer smtp.Close()
+	ts := resetServer(db, smtp)
+	defer ts.Close()
+
+	if err := auth.Register(db, "foo", "password"); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if err := auth.SetEmail(db, 1, "foo@example.com"); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	// Unknown addresses get the same response, but no email.
+	unknown := requestPasswordReset(t, ts, "bar@example.com")
+	if !strings.Contains(unknown, "If an account has this email address") {
+		t.Fatal("expected generic response:", unknown)
+	}
+	if messages := smtp.Messages(); len(messages) > 0 {
+		t.Fatal("expected no email to be sent:", messages)
+	}
+
+	requestPasswordReset(t, ts, "foo@example.com")
+	messages := waitForMessages(t, smtp, 1)
+	if len(messages) != 1 || messages[0].To[0] != "foo@example.com" {
+		t.Fatal("expected reset link to be sent:", messages)
+	}
+	link := regexp.MustCompile(`http://\S+`).FindString(messages[0].Body())
+	path := strings.TrimPrefix(link, ts.URL)
+	if !strings.HasPrefix(path, "/reset-password/confirm?token=") {
+		t.Fatal("expected link to reset page:", link)
+	}
+
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	v := url.Values{}
+	v.Set("token", u.Query().Get("token"))
+	v.Set("password", "new password")
+	v.Set("csrf-token", pageCSRFToken(t, ts, path))
+	if body := submit(t, ts, "/reset-password/confirm", v); !strings.Contains(body, "Password updated.") {
+		t.Fatal("expected password to be reset:", body)
+	}
+	if _, err := auth.Authenticate(db, "foo", "new password"); err != nil {
+		t.Fatal("expected new password to work:", err)
+	}
+
+	// Links are single-use.
+	if body := get(t, ts, path); !strings.Contains(body, "invalid or has expired") {
+		t.Fatal("expected link to be invalidated:", body)
+	}
+}
+
+func TestChangeEmailThrottled(t *testing.T) {
+	// Password guesses get throttled like sign-in attempts.
+	t.Parallel()
+
+	db := testDB()
+	defer db.Close()
+	smtp := mailtest.NewServer()
+	defer smtp.Close()
+	ts := resetServer(db, smtp)
+	defer ts.Close()
+
+	if err := auth.Register(db, "foo", "password"); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	v := url.Values{}
+	v.Set("username", "foo")
+	v.Set("password", "password")
+	v.Set("csrf-token", pageCSRFToken(t, ts, "/signin"))
+	submit(t, ts, "/signin", v)
+
+	for i := 0; i < auth.UsernameThrottle.MaxAttempts; i++ {
+		attempt := auth.SignInAttempt{Username: "foo", IP: "192.0.2.1", Time: time.Now()}
+		if err := auth.RecordSignInAttempt(db, attempt); err != nil {
+			t.Fatal("expected err to be nil:", err)
+		}
+	}
+
+	v = url.Values{}
+	v.Set("email", "foo@example.com")
+	v.Set("password", "password")
+	v.Set("csrf-token", get(t, ts, "/csrf"))
+	submit(t, ts, "/settings/email", v)
+	if email, err := auth.GetEmail(db, 1); err != nil || email != "" {
+		t.Fatal("expected throttled attempt to be rejected:", email, err)
+	}
+}
@@ -7,7 +7,9 @@ package api
 import (
 	"github.com/polycloze/polycloze/difficulty"
 	"github.com/polycloze/polycloze/flashcards"
+	"github.com/polycloze/polycloze/history"
 	"github.com/polycloze/polycloze/review_scheduler"
+	"github.com/polycloze/polycloze/sentences"
 )
 
 type ReviewResult = review_scheduler.Result
@@ -38,3 +40,52 @@ type SetCourseRequest struct {
 type SetCourseResponse struct {
 	Ok bool `json:"ok"`
 }
+
+type LanguagesResponse struct {
+	Languages []Language `json:"languages"`
+}
+
+type CoursesResponse struct {
+	Courses []Course `json:"courses"`
+}
+
+type VocabularyResponse struct {
+	Words []Word `json:"words"`
+}
+
+type ActivityResponse struct {
+	Activity []history.Summary `json:"activity"`
+}
+
+type VocabSizeResponse struct {
+	VocabSize []history.Metric[float64] `json:"vocabSize"`
+}
+
+type EstimatedLevelResponse struct {
+	EstimatedLevel []history.Metric[int] `json:"estimatedLevel"`
+}
+
+type SentencesResponse struct {
+	Sentences []sentences.Sentence `json:"sentences"`
+}
+
+type UploadResponse struct {
+	Message string `json:"message"`
+	Success bool   `json:"success"`
+}
+
+// Error response schema of /api/v1 endpoints.
+type ErrorResponse struct {
+	Error ErrorDetail `json:"error"`
+}
+
+type ErrorDetail struct {
+	// Machine-readable error code (e.g. "not_found").
+	Code string `json:"code"`
+
+	// Human-readable error message.
+	Message string `json:"message"`
+
+	// ID of the request, for finding related log entries.
+	RequestID string `json:"requestID,omitempty"`
+}
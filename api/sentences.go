@@ -4,13 +4,13 @@
 package api
 
 import (
-	"log"
 	"net/http"
 	"net/url"
 	"strconv"
 
 	"github.com/polycloze/polycloze/basedir"
 	"github.com/polycloze/polycloze/database"
+	"github.com/polycloze/polycloze/logging"
 	"github.com/polycloze/polycloze/sentences"
 )
 
@@ -31,6 +31,25 @@ func getSentencesLimit(q url.Values) int {
 	return limit
 }
 
+// Returns random sentences from the course.
+func courseSentences(r *http.Request, l1, l2 string, difficulty, limit int) ([]sentences.Sentence, error) {
+	db, err := database.Open(basedir.Course(l1, l2))
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	result, skipped, err := sentences.RandomSentences(db, difficulty, limit)
+	if err != nil {
+		return nil, err
+	}
+	recordBadSentences(r, l1+"-"+l2, skipped)
+	if result == nil {
+		result = make([]sentences.Sentence, 0)
+	}
+	return result, nil
+}
+
 // Returns random sentence from course.
 func handleSentences(w http.ResponseWriter, r *http.Request) {
 	q := r.URL.Query()
@@ -47,23 +66,14 @@ func handleSentences(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := database.Open(basedir.Course(l1, l2))
-	if err != nil {
-		log.Println(err)
-		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
-		return
-	}
-	defer db.Close()
-
-	limit := getSentencesLimit(q)
-	result, err := sentences.RandomSentences(db, difficulty, limit)
+	result, err := courseSentences(r, l1, l2, difficulty, getSentencesLimit(q))
 	if err != nil {
-		log.Println(err)
-		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		logging.Error(r, err)
+		internalError(w)
 		return
 	}
 
-	sendJSON(w, map[string][]sentences.Sentence{
-		"sentences": result,
+	sendJSON(w, SentencesResponse{
+		Sentences: result,
 	})
 }
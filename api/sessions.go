@@ -0,0 +1,77 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+// Session management handlers.
+package api
+
+import (
+	"net/http"
+
+	"github.com/polycloze/polycloze/auth"
+	"github.com/polycloze/polycloze/logging"
+	"github.com/polycloze/polycloze/sessions"
+)
+
+// Makes client IP address available to the sessions package.
+func recordClientIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, sessions.WithClientIP(r, clientIP(r)))
+	})
+}
+
+// Resumes signed-in session for session management handlers.
+// Returns false if the caller shouldn't continue handling the request.
+func resumeSessionSettings(w http.ResponseWriter, r *http.Request) (*sessions.Session, bool) {
+	if r.Method != "POST" {
+		http.Error(w, "expected POST request", http.StatusBadRequest)
+		return nil, false
+	}
+
+	s, err := sessions.ResumeSession(auth.GetDB(r), w, r)
+	if err != nil || !s.IsSignedIn() {
+		http.Redirect(w, r, "/signin", http.StatusSeeOther)
+		return nil, false
+	}
+
+	if !sessions.CheckCSRFToken(s.ID, r.FormValue("csrf-token")) {
+		_ = s.ErrorMessage("Something went wrong. Please try again.", "sessions")
+		http.Redirect(w, r, "/settings", http.StatusSeeOther)
+		return nil, false
+	}
+	return s, true
+}
+
+// Signs out one of the user's sessions.
+func handleRevokeSession(w http.ResponseWriter, r *http.Request) {
+	s, ok := resumeSessionSettings(w, r)
+	if !ok {
+		return
+	}
+
+	userID := s.Data["userID"].(int)
+	err := sessions.RevokeUserSession(auth.GetDB(r), userID, r.FormValue("session"))
+	if err != nil {
+		logging.Error(r, err)
+		_ = s.ErrorMessage("Something went wrong. Please try again.", "sessions")
+	} else {
+		_ = s.SuccessMessage("Session signed out.", "sessions")
+	}
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
+
+// Signs out all of the user's sessions except the current one.
+func handleRevokeOtherSessions(w http.ResponseWriter, r *http.Request) {
+	s, ok := resumeSessionSettings(w, r)
+	if !ok {
+		return
+	}
+
+	userID := s.Data["userID"].(int)
+	if _, err := sessions.DeleteOtherSessions(auth.GetDB(r), userID, s.ID); err != nil {
+		logging.Error(r, err)
+		_ = s.ErrorMessage("Something went wrong. Please try again.", "sessions")
+	} else {
+		_ = s.SuccessMessage("Other sessions signed out.", "sessions")
+	}
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
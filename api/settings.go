@@ -6,15 +6,16 @@ package api
 
 import (
 	"fmt"
-	"log"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 
 	"github.com/polycloze/polycloze/auth"
 	"github.com/polycloze/polycloze/basedir"
 	"github.com/polycloze/polycloze/database"
+	"github.com/polycloze/polycloze/logging"
 	"github.com/polycloze/polycloze/sessions"
 )
 
@@ -26,7 +27,19 @@ func handleSettings(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Guests have to register first.
+	// Demo visitors in visitor mode are guests, but they can't register.
+	if isGuest(r, s) && !demoEnabled(r) {
+		http.Redirect(w, r, "/register", http.StatusTemporaryRedirect)
+		return
+	}
+
 	if r.Method == "POST" {
+		if getConfig(r).ProxyAuthHeader != "" {
+			http.Error(w, "Passwords are managed by the reverse proxy.", http.StatusForbidden)
+			return
+		}
+
 		username := s.Data["username"].(string)
 		currentPassword := r.FormValue("current-password")
 		newPassword := r.FormValue("new-password")
@@ -40,14 +53,20 @@ func handleSettings(w http.ResponseWriter, r *http.Request) {
 			goto fail
 		}
 
+		minLength := getConfig(r).MinPasswordLength
+		if err := auth.CheckPassword(username, newPassword, minLength); err != nil {
+			_ = s.ErrorMessage(auth.PasswordMessage(err, minLength), "change-password")
+			goto fail
+		}
+
 		id, err := auth.Authenticate(db, username, currentPassword)
 		if err != nil {
-			log.Println(err)
+			logging.Error(r, err)
 			_ = s.ErrorMessage("Incorrect password.", "change-password")
 			goto fail
 		}
 
-		if err := auth.ChangePassword(db, id, newPassword); err != nil {
+		if err := auth.ChangePassword(db, id, newPassword, s.ID); err != nil {
 			_ = s.ErrorMessage(
 				"Something went wrong. Please try again.",
 				"change-password",
@@ -59,20 +78,83 @@ func handleSettings(w http.ResponseWriter, r *http.Request) {
 	}
 
 fail:
+	renderSettings(w, r, s)
+}
+
+// Renders settings page.
+func renderSettings(w http.ResponseWriter, r *http.Request, s *sessions.Session) {
 	// Get active course.
 	userID := s.Data["userID"].(int)
 	course, err := getUserActiveCourse(userID)
 	if err != nil {
-		log.Println(err)
-		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		logging.Error(r, err)
+		internalError(w)
+		return
+	}
+
+	tokens, err := auth.ListTokens(auth.GetDB(r), userID)
+	if err != nil {
+		logging.Error(r, err)
+		internalError(w)
+		return
+	}
+
+	userSessions, err := sessions.ListUserSessions(auth.GetDB(r), userID, s.ID)
+	if err != nil {
+		logging.Error(r, err)
+		internalError(w)
+		return
+	}
+
+	if err := addTwoFactorData(r, s); err != nil {
+		logging.Error(r, err)
+		internalError(w)
+		return
+	}
+
+	if err := addOIDCData(r, s); err != nil {
+		logging.Error(r, err)
+		internalError(w)
+		return
+	}
+
+	provisioned, err := auth.IsOIDCProvisioned(auth.GetDB(r), userID)
+	if err != nil {
+		logging.Error(r, err)
+		internalError(w)
 		return
 	}
+	s.Data["oidcProvisioned"] = provisioned
+	s.Data["skipPassword"] = provisioned && recentOIDCSignIn(s, time.Now())
+
+	if passwordResetEnabled(r) {
+		email, err := auth.GetEmail(auth.GetDB(r), userID)
+		if err != nil {
+			logging.Error(r, err)
+			internalError(w)
+			return
+		}
+		s.Data["canResetPassword"] = true
+		s.Data["email"] = email
+	}
 
 	s.Data["course"] = course
 	s.Data["csrfToken"] = sessions.CSRFToken(s.ID)
+	s.Data["tokens"] = tokens
+	s.Data["scopes"] = auth.Scopes
+	s.Data["sessions"] = userSessions
+	s.Data["minPasswordLength"] = getConfig(r).MinPasswordLength
+	s.Data["proxyAuth"] = getConfig(r).ProxyAuthHeader != ""
+	s.Data["demo"] = demoEnabled(r)
 	s.Data["changePasswordMessages"], _ = s.Messages("change-password")
+	s.Data["emailMessages"], _ = s.Messages("email")
 	s.Data["csvUploadMessages"], _ = s.Messages("csv-upload")
 	s.Data["resetProgressMessages"], _ = s.Messages("reset-progress")
+	s.Data["apiTokenMessages"], _ = s.Messages("api-tokens")
+	s.Data["twoFactorMessages"], _ = s.Messages("two-factor")
+	s.Data["oidcMessages"], _ = s.Messages("oidc")
+	s.Data["sessionMessages"], _ = s.Messages("sessions")
+	s.Data["deleteAccountMessages"], _ = s.Messages("delete-account")
 	renderTemplate(w, "settings.html", s.Data)
 }
 
@@ -118,7 +200,7 @@ func handleResetProgress(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := resetProgress(userID, l1, l2); err != nil {
-		log.Println(err)
+		logging.Error(r, err)
 		_ = s.ErrorMessage(
 			"Something went wrong. Please try again.",
 			"reset-progress",
@@ -126,6 +208,7 @@ func handleResetProgress(w http.ResponseWriter, r *http.Request) {
 		goto fail
 	}
 
+	publishReset(s, l1, l2)
 	_ = s.SuccessMessage("Progress has been reset.", "reset-progress")
 
 fail:
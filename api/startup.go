@@ -4,6 +4,7 @@
 package api
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -13,7 +14,8 @@ import (
 	"github.com/polycloze/polycloze/database"
 )
 
-// Version string of course files.
+// Version string of files in the data directory.
+// Course DBs have their own versions (see InstalledCourse).
 var dataVersion string
 
 type Language struct {
@@ -38,41 +40,33 @@ func (a ByCode) Less(i, j int) bool {
 }
 
 // Look for installed languages and courses.
-func Startup() {
-	// Look for courses and languages.
-	courses := findCourses()
-	languages := findL1Languages(courses)
-	if len(languages) <= 0 {
-		log.Fatal("Couldn't find installed courses. Please visit https://github.com/polycloze/polycloze/tree/main/python")
+func Startup() error {
+	// Look for courses and languages, and generate courses.json and
+	// languages.json.
+	changes, err := ReloadCourses()
+	if err != nil {
+		return err
+	}
+	for code, reason := range changes.Rejected {
+		log.Printf("Skipped invalid course %v: %v\n", code, reason)
+	}
+	if len(installedCourses()) == 0 {
+		return errors.New("couldn't find installed courses; please visit https://github.com/polycloze/polycloze/tree/main/python")
 	}
 
 	// Set version string.
 	versionFile := filepath.Join(basedir.DataDir, "version.txt")
 	version, err := os.ReadFile(versionFile)
 	if err != nil {
-		log.Fatal("Couldn't set version number.")
+		return fmt.Errorf("couldn't set version number: %w", err)
 	}
 	dataVersion = string(version)
 
-	// Generate courses.json.
-	coursesJSON := filepath.Join(basedir.StateDir, "courses.json")
-	err = writeJSON(coursesJSON, map[string][]Course{
-		"courses": courses,
-	})
-	if err != nil {
-		log.Fatal("failed to write courses.json:", err)
-	}
-
-	languagesJSON := filepath.Join(basedir.StateDir, "languages.json")
-	err = writeJSON(languagesJSON, map[string][]Language{
-		"languages": languages,
-	})
-	if err != nil {
-		log.Fatal("failed to write languages.json:", err)
-	}
-
 	// Compute hashes of static files.
-	_ = computeHashes()
+	if err := computeHashes(); err != nil {
+		return fmt.Errorf("failed to compute hashes of static files: %w", err)
+	}
+	return nil
 }
 
 // Input: path to course db file.
@@ -116,19 +110,6 @@ func getCourseInfo(path string) (Course, error) {
 	return course, nil
 }
 
-// Look for installed courses in data directory.
-func findCourses() []Course {
-	var courses []Course
-	matches, _ := filepath.Glob(filepath.Join(basedir.DataDir, "courses", "*.db"))
-	for _, match := range matches {
-		course, err := getCourseInfo(match)
-		if err == nil {
-			courses = append(courses, course)
-		}
-	}
-	return courses
-}
-
 func findL1Languages(courses []Course) []Language {
 	languages := make(map[Language]bool)
 	for _, course := range courses {
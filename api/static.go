@@ -10,10 +10,10 @@ import (
 	"io/fs"
 	"net/http"
 	"path/filepath"
+	"strings"
 
 	"github.com/polycloze/polycloze/auth"
 	"github.com/polycloze/polycloze/basedir"
-	"github.com/polycloze/polycloze/sessions"
 )
 
 //go:embed js/dist
@@ -70,10 +70,18 @@ func cacheUntilBusted(next http.Handler) http.HandlerFunc {
 	})
 }
 
-// Sets ETag header to data version found in `$DATA_DIR/polycloze/version.txt`.
+// Sets ETag header to the course version for course DBs, or else to the data
+// version found in `$DATA_DIR/polycloze/version.txt`.
+// Expects paths relative to the data directory, e.g. "courses/eng-spa.db".
 func versioned(next http.Handler) http.HandlerFunc {
-	etag := fmt.Sprintf(`"%s"`, dataVersion)
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		etag := fmt.Sprintf(`"%s"`, dataVersion)
+		if strings.HasPrefix(r.URL.Path, "courses/") {
+			code := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "courses/"), ".db")
+			if course, ok := lookupCourse(code); ok {
+				etag = course.ETag()
+			}
+		}
 		w.Header().Set("ETag", etag)
 		next.ServeHTTP(w, r)
 	})
@@ -87,6 +95,9 @@ func serveShare() http.Handler {
 func serveLanguagesJSON() http.HandlerFunc {
 	name := filepath.Join(basedir.StateDir, "languages.json")
 	handler := func(w http.ResponseWriter, r *http.Request) {
+		if etag := catalogETag(); etag != "" {
+			w.Header().Set("ETag", etag)
+		}
 		http.ServeFile(w, r, name)
 	}
 	return cacheUntilBusted(http.HandlerFunc(handler))
@@ -95,6 +106,9 @@ func serveLanguagesJSON() http.HandlerFunc {
 func serveCoursesJSON() http.HandlerFunc {
 	name := filepath.Join(basedir.StateDir, "courses.json")
 	handler := func(w http.ResponseWriter, r *http.Request) {
+		if etag := catalogETag(); etag != "" {
+			w.Header().Set("ETag", etag)
+		}
 		http.ServeFile(w, r, name)
 	}
 	return cacheUntilBusted(http.HandlerFunc(handler))
@@ -108,8 +122,7 @@ func serveUserData(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Check if user is signed in.
-	db := auth.GetDB(r)
-	s, err := sessions.ResumeSession(db, w, r)
+	s, err := resumeSession(w, r, auth.ScopeImportExport)
 	if err != nil || !s.IsSignedIn() {
 		http.NotFound(w, r)
 		return
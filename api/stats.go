@@ -5,7 +5,6 @@ package api
 
 import (
 	"fmt"
-	"log"
 	"net/http"
 	"strconv"
 	"time"
@@ -16,7 +15,7 @@ import (
 	"github.com/polycloze/polycloze/basedir"
 	"github.com/polycloze/polycloze/database"
 	"github.com/polycloze/polycloze/history"
-	"github.com/polycloze/polycloze/sessions"
+	"github.com/polycloze/polycloze/logging"
 )
 
 // If upgrade is non-empty, upgrades the database.
@@ -47,7 +46,7 @@ func CountTotal(l1, l2 string) (int, error) {
 
 func handleStatsActivity(w http.ResponseWriter, r *http.Request) {
 	db := auth.GetDB(r)
-	s, err := sessions.ResumeSession(db, w, r)
+	s, err := resumeSession(w, r, auth.ScopeReadStats)
 	if err != nil || !s.IsSignedIn() {
 		http.NotFound(w, r)
 		return
@@ -61,10 +60,10 @@ func handleStatsActivity(w http.ResponseWriter, r *http.Request) {
 	}
 
 	userID := s.Data["userID"].(int)
-	db, err = database.OpenReviewDB(basedir.Review(userID, l1, l2))
+	db, err = openReviewDB(r, userID, l1, l2)
 	if err != nil {
-		log.Println(err)
-		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		logging.Error(r, err)
+		internalError(w)
 		return
 	}
 	defer db.Close()
@@ -76,13 +75,13 @@ func handleStatsActivity(w http.ResponseWriter, r *http.Request) {
 		getStep(r),
 	)
 	if err != nil {
-		log.Println(err)
-		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		logging.Error(r, err)
+		internalError(w)
 		return
 	}
 
-	sendJSON(w, map[string]any{
-		"activity": result,
+	sendJSON(w, ActivityResponse{
+		Activity: result,
 		// TODO use unix timestamps?
 	})
 }
@@ -90,7 +89,7 @@ func handleStatsActivity(w http.ResponseWriter, r *http.Request) {
 // Responds with user's vocab size over time.
 func handleStatsVocab(w http.ResponseWriter, r *http.Request) {
 	db := auth.GetDB(r)
-	s, err := sessions.ResumeSession(db, w, r)
+	s, err := resumeSession(w, r, auth.ScopeReadStats)
 	if err != nil || !s.IsSignedIn() {
 		http.NotFound(w, r)
 		return
@@ -104,10 +103,10 @@ func handleStatsVocab(w http.ResponseWriter, r *http.Request) {
 	}
 
 	userID := s.Data["userID"].(int)
-	db, err = database.OpenReviewDB(basedir.Review(userID, l1, l2))
+	db, err = openReviewDB(r, userID, l1, l2)
 	if err != nil {
-		log.Println(err)
-		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		logging.Error(r, err)
+		internalError(w)
 		return
 	}
 	defer db.Close()
@@ -119,13 +118,13 @@ func handleStatsVocab(w http.ResponseWriter, r *http.Request) {
 		getStep(r),
 	)
 	if err != nil {
-		log.Println(err)
-		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		logging.Error(r, err)
+		internalError(w)
 		return
 	}
 
-	sendJSON(w, map[string]any{
-		"vocabSize": result,
+	sendJSON(w, VocabSizeResponse{
+		VocabSize: result,
 		// TODO use unix timestamps?
 	})
 }
@@ -133,7 +132,7 @@ func handleStatsVocab(w http.ResponseWriter, r *http.Request) {
 // Responds with user's estimated level over time.
 func handleStatsEstimatedLevel(w http.ResponseWriter, r *http.Request) {
 	db := auth.GetDB(r)
-	s, err := sessions.ResumeSession(db, w, r)
+	s, err := resumeSession(w, r, auth.ScopeReadStats)
 	if err != nil || !s.IsSignedIn() {
 		http.NotFound(w, r)
 		return
@@ -147,10 +146,10 @@ func handleStatsEstimatedLevel(w http.ResponseWriter, r *http.Request) {
 	}
 
 	userID := s.Data["userID"].(int)
-	db, err = database.OpenReviewDB(basedir.Review(userID, l1, l2))
+	db, err = openReviewDB(r, userID, l1, l2)
 	if err != nil {
-		log.Println(err)
-		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		logging.Error(r, err)
+		internalError(w)
 		return
 	}
 	defer db.Close()
@@ -162,13 +161,13 @@ func handleStatsEstimatedLevel(w http.ResponseWriter, r *http.Request) {
 		getStep(r),
 	)
 	if err != nil {
-		log.Println(err)
-		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		logging.Error(r, err)
+		internalError(w)
 		return
 	}
 
-	sendJSON(w, map[string]any{
-		"estimatedLevel": result,
+	sendJSON(w, EstimatedLevelResponse{
+		EstimatedLevel: result,
 	})
 }
 
@@ -28,6 +28,6 @@ var templates *template.Template = template.Must(
 func renderTemplate(w http.ResponseWriter, name string, data map[string]any) {
 	if err := templates.ExecuteTemplate(w, name, data); err != nil {
 		log.Println(fmt.Errorf("template execution error: %w", err))
-		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		internalError(w)
 	}
 }
@@ -0,0 +1,125 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+// API token management.
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/polycloze/polycloze/auth"
+	"github.com/polycloze/polycloze/logging"
+	"github.com/polycloze/polycloze/sessions"
+)
+
+// Responds to requests with an invalid API token.
+// Requests to /api/v1 get a JSON error response.
+func invalidToken(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/api/v1" || strings.HasPrefix(r.URL.Path, "/api/v1/") {
+		sendError(w, http.StatusUnauthorized, errUnauthorized, "Invalid API token.")
+		return
+	}
+	http.Error(w, "Unauthorized.", http.StatusUnauthorized)
+}
+
+// Resumes session from the session cookie, or from the API token used to
+// authenticate the request.
+// Token-authenticated requests must have the scope.
+func resumeSession(w http.ResponseWriter, r *http.Request, scope string) (*sessions.Session, error) {
+	db := auth.GetDB(r)
+	token := auth.GetToken(r)
+	if token == nil {
+		return sessions.ResumeSession(db, w, r)
+	}
+	if !token.HasScope(scope) {
+		return nil, fmt.Errorf("token does not have required scope: %v", scope)
+	}
+	logging.Set(r.Context(), "userID", token.UserID)
+	logging.Set(r.Context(), "tokenID", token.ID)
+	return sessions.StatelessSession(db, token.UserID, token.Username), nil
+}
+
+// Creates API token.
+// Shows the token on the settings page, because it can't be recovered later.
+func handleCreateToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "expected POST request", http.StatusBadRequest)
+		return
+	}
+
+	db := auth.GetDB(r)
+	s, err := sessions.ResumeSession(db, w, r)
+	if err != nil || !s.IsSignedIn() {
+		http.Redirect(w, r, "/signin", http.StatusSeeOther)
+		return
+	}
+
+	userID := s.Data["userID"].(int)
+	name := r.FormValue("token-name")
+	scopes := r.Form["token-scope"]
+	csrfToken := r.FormValue("csrf-token")
+
+	if !sessions.CheckCSRFToken(s.ID, csrfToken) {
+		_ = s.ErrorMessage("Something went wrong. Please try again.", "api-tokens")
+		http.Redirect(w, r, "/settings", http.StatusSeeOther)
+		return
+	}
+
+	if len(scopes) == 0 {
+		_ = s.ErrorMessage("Select at least one scope.", "api-tokens")
+		http.Redirect(w, r, "/settings", http.StatusSeeOther)
+		return
+	}
+
+	token, err := auth.CreateToken(db, userID, name, scopes)
+	if err != nil {
+		logging.Error(r, err)
+		_ = s.ErrorMessage("Could not create token. Please try again.", "api-tokens")
+		http.Redirect(w, r, "/settings", http.StatusSeeOther)
+		return
+	}
+
+	_ = s.SuccessMessage(
+		"Token created. Copy it now, because you won't be able to see it again.",
+		"api-tokens",
+	)
+	s.Data["newToken"] = token
+	renderSettings(w, r, s)
+}
+
+// Revokes API token.
+func handleRevokeToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "expected POST request", http.StatusBadRequest)
+		return
+	}
+
+	db := auth.GetDB(r)
+	s, err := sessions.ResumeSession(db, w, r)
+	if err != nil || !s.IsSignedIn() {
+		http.Redirect(w, r, "/signin", http.StatusSeeOther)
+		return
+	}
+
+	userID := s.Data["userID"].(int)
+	csrfToken := r.FormValue("csrf-token")
+	if !sessions.CheckCSRFToken(s.ID, csrfToken) {
+		_ = s.ErrorMessage("Something went wrong. Please try again.", "api-tokens")
+		goto done
+	}
+
+	if tokenID, err := strconv.Atoi(r.FormValue("token-id")); err != nil {
+		_ = s.ErrorMessage("Something went wrong. Please try again.", "api-tokens")
+	} else if err := auth.RevokeToken(db, userID, tokenID); err != nil {
+		logging.Error(r, err)
+		_ = s.ErrorMessage("Something went wrong. Please try again.", "api-tokens")
+	} else {
+		_ = s.SuccessMessage("Token revoked.", "api-tokens")
+	}
+
+done:
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
@@ -0,0 +1,244 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+// Two-factor authentication handlers.
+package api
+
+import (
+	"errors"
+	"html/template"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/polycloze/polycloze/auth"
+	"github.com/polycloze/polycloze/logging"
+	"github.com/polycloze/polycloze/sessions"
+)
+
+// Max time between entering the password and entering the 2FA code.
+const pendingSignInTimeout = 5 * time.Minute
+
+// Second step of signing in for users with 2FA.
+// Only works in the session where the user entered the correct password.
+func handleSignIn2FA(w http.ResponseWriter, r *http.Request) {
+	db := auth.GetDB(r)
+	s, err := sessions.ResumeSession(db, w, r)
+	if err != nil {
+		http.Redirect(w, r, "/signin", http.StatusSeeOther)
+		return
+	}
+	if !needsSignIn(r, s) {
+		http.Redirect(w, r, "/welcome", http.StatusSeeOther)
+		return
+	}
+
+	userID, ok := s.Data["pendingUserID"].(int)
+	if !ok {
+		http.Redirect(w, r, "/signin", http.StatusSeeOther)
+		return
+	}
+	since, _ := s.Data["pendingSince"].(int64)
+	if time.Since(time.Unix(since, 0)) > pendingSignInTimeout {
+		delete(s.Data, "pendingUserID")
+		delete(s.Data, "pendingSince")
+		if err := sessions.SaveData(db, s); err != nil {
+			logging.Error(r, err)
+		}
+		_ = s.ErrorMessage("Your sign-in attempt expired. Please try again.", "sign-in")
+		http.Redirect(w, r, "/signin", http.StatusSeeOther)
+		return
+	}
+
+	if r.Method == "POST" {
+		code := r.FormValue("code")
+		csrfToken := r.FormValue("csrf-token")
+
+		if !sessions.CheckCSRFToken(s.ID, csrfToken) {
+			_ = s.ErrorMessage("Authentication failed.", "sign-in-2fa")
+			goto fail
+		}
+
+		username, err := auth.GetUsername(db, userID)
+		if err != nil {
+			logging.Error(r, err)
+			_ = s.ErrorMessage("Authentication failed.", "sign-in-2fa")
+			goto fail
+		}
+		ip := clientIP(r)
+		logging.Set(r.Context(), "clientIP", ip)
+
+		// Guessing codes gets throttled like guessing passwords.
+		unlock := auth.LockSignIn(username, ip)
+		defer unlock()
+		now := time.Now()
+		wait, err := auth.SignInWait(db, username, ip, now)
+		if err != nil {
+			logging.Error(r, err)
+		}
+		if wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			_ = s.ErrorMessage(
+				"Too many failed sign-in attempts. Try again in "+formatWait(wait)+".",
+				"sign-in-2fa",
+			)
+			goto fail
+		}
+
+		err = auth.VerifyTOTP(db, userID, code, now)
+		attempt := auth.SignInAttempt{
+			Username: username,
+			IP:       ip,
+			Success:  err == nil,
+			Time:     now,
+		}
+		if err := auth.RecordSignInAttempt(db, attempt); err != nil {
+			logging.Error(r, err)
+		}
+		if err != nil {
+			if !errors.Is(err, auth.ErrInvalidCode) {
+				logging.Error(r, err)
+			}
+			_ = s.ErrorMessage("Invalid code.", "sign-in-2fa")
+			goto fail
+		}
+
+		if s, err = replaceGuestSession(w, r, s); err != nil {
+			internalError(w)
+			return
+		}
+		if completeSignIn(r, s, userID, username) != nil {
+			_ = s.ErrorMessage("Authentication failed.", "sign-in-2fa")
+			goto fail
+		}
+		rememberSignIn(w, r, s)
+		if err := initUserDirectory(userID); err != nil {
+			internalError(w)
+			return
+		}
+		http.Redirect(w, r, "/welcome", http.StatusSeeOther)
+		return
+	}
+
+fail:
+	messages, _ := s.Messages("sign-in-2fa")
+	renderTemplate(w, "signin-2fa.html", map[string]any{
+		"csrfToken": sessions.CSRFToken(s.ID),
+		"messages":  messages,
+		"remember":  r.FormValue("remember") != "",
+	})
+}
+
+// Adds 2FA status to session data for rendering the settings page.
+func addTwoFactorData(r *http.Request, s *sessions.Session) error {
+	db := auth.GetDB(r)
+	userID := s.Data["userID"].(int)
+
+	enabled, err := auth.HasTOTP(db, userID)
+	if err != nil {
+		return err
+	}
+	s.Data["totpEnabled"] = enabled
+	if enabled {
+		s.Data["recoveryCodesLeft"], err = auth.RemainingRecoveryCodes(db, userID)
+		return err
+	}
+
+	secret, err := auth.PendingTOTPSecret(db, userID)
+	if err != nil {
+		return err
+	}
+	if secret != "" {
+		s.Data["totpSecret"] = secret
+		s.Data["totpURI"] = template.URL(auth.TOTPURI(secret, s.Data["username"].(string)))
+	}
+	return nil
+}
+
+// Resumes signed-in session for 2FA settings handlers.
+// Returns false if the caller shouldn't continue handling the request.
+func resumeTwoFactorSettings(w http.ResponseWriter, r *http.Request) (*sessions.Session, bool) {
+	if r.Method != "POST" {
+		http.Error(w, "expected POST request", http.StatusBadRequest)
+		return nil, false
+	}
+	if getConfig(r).ProxyAuthHeader != "" {
+		http.Error(w, "Two-factor authentication is managed by the reverse proxy.", http.StatusForbidden)
+		return nil, false
+	}
+
+	s, err := sessions.ResumeSession(auth.GetDB(r), w, r)
+	if err != nil || !s.IsSignedIn() {
+		http.Redirect(w, r, "/signin", http.StatusSeeOther)
+		return nil, false
+	}
+
+	if !sessions.CheckCSRFToken(s.ID, r.FormValue("csrf-token")) {
+		_ = s.ErrorMessage("Something went wrong. Please try again.", "two-factor")
+		http.Redirect(w, r, "/settings", http.StatusSeeOther)
+		return nil, false
+	}
+	return s, true
+}
+
+// Generates a TOTP secret for the user to add to their authenticator app.
+func handleSetupTOTP(w http.ResponseWriter, r *http.Request) {
+	s, ok := resumeTwoFactorSettings(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := auth.SetupTOTP(auth.GetDB(r), s.Data["userID"].(int)); err != nil {
+		logging.Error(r, err)
+		_ = s.ErrorMessage("Something went wrong. Please try again.", "two-factor")
+	}
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
+
+// Enables 2FA after the user enters a code from their authenticator app.
+func handleEnableTOTP(w http.ResponseWriter, r *http.Request) {
+	s, ok := resumeTwoFactorSettings(w, r)
+	if !ok {
+		return
+	}
+
+	userID := s.Data["userID"].(int)
+	codes, err := auth.EnableTOTP(auth.GetDB(r), userID, r.FormValue("code"), time.Now())
+	if err != nil {
+		if !errors.Is(err, auth.ErrInvalidCode) {
+			logging.Error(r, err)
+		}
+		_ = s.ErrorMessage("Invalid code. Please try again.", "two-factor")
+		http.Redirect(w, r, "/settings", http.StatusSeeOther)
+		return
+	}
+
+	_ = s.SuccessMessage(
+		"Two-factor authentication enabled. Save these recovery codes now, because you won't be able to see them again.",
+		"two-factor",
+	)
+	s.Data["recoveryCodes"] = codes
+	renderSettings(w, r, s)
+}
+
+// Disables 2FA.
+// Requires the user's password.
+func handleDisableTOTP(w http.ResponseWriter, r *http.Request) {
+	s, ok := resumeTwoFactorSettings(w, r)
+	if !ok {
+		return
+	}
+
+	username := s.Data["username"].(string)
+	err := confirmPassword(w, r, username, r.FormValue("password"))
+	if err != nil {
+		_ = s.ErrorMessage(confirmPasswordMessage(err), "two-factor")
+	} else if err := auth.DisableTOTP(auth.GetDB(r), s.Data["userID"].(int)); err != nil {
+		logging.Error(r, err)
+		_ = s.ErrorMessage("Something went wrong. Please try again.", "two-factor")
+	} else {
+		_ = s.SuccessMessage("Two-factor authentication disabled.", "two-factor")
+	}
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
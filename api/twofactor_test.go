@@ -0,0 +1,221 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/go-chi/chi/v5"
+
+	"github.com/polycloze/polycloze/auth"
+	"github.com/polycloze/polycloze/basedir"
+	"github.com/polycloze/polycloze/sessions"
+)
+
+func TestMain(m *testing.M) {
+	// Signing in creates user directories.
+	dir, err := os.MkdirTemp("", "polycloze-api-test")
+	if err != nil {
+		panic(err)
+	}
+	if err := basedir.SetStateDir(dir); err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// Computes TOTP code for testing.
+func totpCode(secret string, t time.Time) string {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		panic(err)
+	}
+
+	var message [8]byte
+	binary.BigEndian.PutUint64(message[:], uint64(t.Unix()/30))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(message[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", value%1000000)
+}
+
+// Gets CSRF token from the page.
+func pageCSRFToken(t *testing.T, ts *httptest.Server, path string) string {
+	resp, err := ts.Client().Get(resolve(ts, path))
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	defer resp.Body.Close()
+
+	doc, err := goquery.NewDocumentFromReader(resp.Body)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	token, _ := doc.Find(`input[name="csrf-token"]`).Attr("value")
+	return token
+}
+
+// Submits form and returns the response body.
+func submit(t *testing.T, ts *httptest.Server, path string, v url.Values) string {
+	resp, err := ts.Client().PostForm(resolve(ts, path), v)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	return string(body)
+}
+
+func TestSignInWithTwoFactorAuthentication(t *testing.T) {
+	t.Parallel()
+
+	db := testDB()
+	defer db.Close()
+
+	if err := auth.Register(db, "foo", "password"); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	secret, err := auth.SetupTOTP(db, 1)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	enrolled := time.Now().Add(-time.Minute)
+	codes, err := auth.EnableTOTP(db, 1, totpCode(secret, enrolled), enrolled)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	r := chi.NewRouter()
+	r.Use(auth.Middleware(db))
+	r.HandleFunc("/signin", handleSignIn)
+	r.HandleFunc("/signin/2fa", handleSignIn2FA)
+	r.HandleFunc("/welcome", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "welcome")
+	})
+	ts := serverWithJar(r)
+	defer ts.Close()
+
+	v := url.Values{}
+	v.Set("username", "foo")
+	v.Set("password", "password")
+	v.Set("csrf-token", pageCSRFToken(t, ts, "/signin"))
+	if body := submit(t, ts, "/signin", v); !strings.Contains(body, "authenticator app") {
+		t.Fatal("expected to be asked for 2FA code:", body)
+	}
+
+	v = url.Values{}
+	v.Set("code", "nope")
+	v.Set("csrf-token", pageCSRFToken(t, ts, "/signin/2fa"))
+	if body := submit(t, ts, "/signin/2fa", v); !strings.Contains(body, "Invalid code.") {
+		t.Fatal("expected wrong code to be rejected:", body)
+	}
+
+	v.Set("code", codes[0])
+	if body := submit(t, ts, "/signin/2fa", v); body != "welcome" {
+		t.Fatal("expected recovery code to work:", body)
+	}
+}
+
+func TestTwoFactorStepRequiresPassword(t *testing.T) {
+	t.Parallel()
+
+	db := testDB()
+	defer db.Close()
+
+	r := chi.NewRouter()
+	r.Use(auth.Middleware(db))
+	r.HandleFunc("/signin", handleSignIn)
+	r.HandleFunc("/signin/2fa", handleSignIn2FA)
+	ts := serverWithJar(r)
+	defer ts.Close()
+
+	// Starts session.
+	pageCSRFToken(t, ts, "/signin")
+
+	resp, err := ts.Client().Get(resolve(ts, "/signin/2fa"))
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	resp.Body.Close()
+	if resp.Request.URL.Path != "/signin" {
+		t.Fatal("expected redirect to /signin:", resp.Request.URL.Path)
+	}
+}
+
+func TestDisableTOTPThrottled(t *testing.T) {
+	// Password guesses get throttled like sign-in attempts.
+	t.Parallel()
+
+	db := testDB()
+	defer db.Close()
+
+	if err := auth.Register(db, "foo", "password"); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	r := chi.NewRouter()
+	r.Use(configMiddleware(DefaultConfig()))
+	r.Use(auth.Middleware(db))
+	r.HandleFunc("/signin", handleSignIn)
+	r.HandleFunc("/settings/2fa/disable", handleDisableTOTP)
+	r.HandleFunc("/csrf", func(w http.ResponseWriter, r *http.Request) {
+		s, err := sessions.ResumeSession(db, w, r)
+		if err == nil && s.IsSignedIn() {
+			fmt.Fprint(w, sessions.CSRFToken(s.ID))
+		}
+	})
+	ts := serverWithJar(r)
+	defer ts.Close()
+
+	v := url.Values{}
+	v.Set("username", "foo")
+	v.Set("password", "password")
+	v.Set("csrf-token", pageCSRFToken(t, ts, "/signin"))
+	submit(t, ts, "/signin", v)
+
+	secret, err := auth.SetupTOTP(db, 1)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	enrolled := time.Now().Add(-time.Minute)
+	if _, err := auth.EnableTOTP(db, 1, totpCode(secret, enrolled), enrolled); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	for i := 0; i < auth.UsernameThrottle.MaxAttempts; i++ {
+		attempt := auth.SignInAttempt{Username: "foo", IP: "192.0.2.1", Time: time.Now()}
+		if err := auth.RecordSignInAttempt(db, attempt); err != nil {
+			t.Fatal("expected err to be nil:", err)
+		}
+	}
+
+	v = url.Values{}
+	v.Set("password", "password")
+	v.Set("csrf-token", get(t, ts, "/csrf"))
+	submit(t, ts, "/settings/2fa/disable", v)
+	if enabled, err := auth.HasTOTP(db, 1); err != nil || !enabled {
+		t.Fatal("expected throttled attempt to be rejected:", enabled, err)
+	}
+}
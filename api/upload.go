@@ -6,22 +6,18 @@ package api
 import (
 	"errors"
 	"fmt"
-	"log"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
 
 	"github.com/polycloze/polycloze/auth"
-	"github.com/polycloze/polycloze/basedir"
-	"github.com/polycloze/polycloze/database"
+	"github.com/polycloze/polycloze/logging"
 	"github.com/polycloze/polycloze/replay"
-	"github.com/polycloze/polycloze/sessions"
 )
 
 // Checks if uploaded file size is too big.
-func isTooBig(size int64) bool {
-	// Limit to 8MB.
-	return size > 8*1024*1024
+func isTooBig(size, limit int64) bool {
+	return size > limit
 }
 
 func handleUpload(w http.ResponseWriter, r *http.Request) {
@@ -40,7 +36,7 @@ func handleUpload(w http.ResponseWriter, r *http.Request) {
 
 	// Check if user is signed in.
 	db := auth.GetDB(r)
-	s, err := sessions.ResumeSession(db, w, r)
+	s, err := resumeSession(w, r, auth.ScopeImportExport)
 	if err != nil || !s.IsSignedIn() {
 		http.NotFound(w, r)
 		return
@@ -51,7 +47,7 @@ func handleUpload(w http.ResponseWriter, r *http.Request) {
 
 	// Check CSRF token.
 	csrfToken := r.FormValue("csrf-token")
-	if !sessions.CheckCSRFToken(s.ID, csrfToken) {
+	if !s.CheckCSRFToken(csrfToken) {
 		http.Error(w, "Forbidden.", http.StatusForbidden)
 		return
 	}
@@ -59,7 +55,7 @@ func handleUpload(w http.ResponseWriter, r *http.Request) {
 	// Handle upload.
 	file, header, err := r.FormFile("csv-upload")
 	if err != nil {
-		log.Println(err)
+		logging.Error(r, err)
 		message = "Something went wrong. Please try again."
 		_ = s.ErrorMessage(message, "csv-upload")
 		goto fail
@@ -71,17 +67,17 @@ func handleUpload(w http.ResponseWriter, r *http.Request) {
 		goto fail
 	}
 
-	if isTooBig(header.Size) {
-		message = "File is too big (>8MB)."
+	if limit := getConfig(r).MaxUploadSize; isTooBig(header.Size, limit) {
+		message = fmt.Sprintf("File is too big (>%v).", formatSize(limit))
 		_ = s.ErrorMessage(message, "csv-upload")
 		goto fail
 	}
 
 	// Open user's review DB.
 	// TODO import into a new db instead?
-	db, err = database.OpenReviewDB(basedir.Review(userID, l1, l2))
+	db, err = openReviewDB(r, userID, l1, l2)
 	if err != nil {
-		log.Println(fmt.Errorf("could not open review database (%v-%v): %w", l1, l2, err))
+		logging.Error(r, fmt.Errorf("could not open review database (%v-%v): %w", l1, l2, err))
 		message = "Something went wrong. Please try again."
 		_ = s.ErrorMessage(message, "csv-upload")
 		goto fail
@@ -97,7 +93,7 @@ func handleUpload(w http.ResponseWriter, r *http.Request) {
 			goto fail
 		}
 
-		log.Println(err)
+		logging.Error(r, err)
 		message = "Something went wrong. Please try again."
 		_ = s.ErrorMessage(message, "csv-upload")
 		goto fail
@@ -110,8 +106,8 @@ func handleUpload(w http.ResponseWriter, r *http.Request) {
 fail:
 	// Don't redirect to settings page.
 	// Client might use this API by using fetch.
-	sendJSON(w, map[string]any{
-		"message": message,
-		"success": success,
+	sendJSON(w, UploadResponse{
+		Message: message,
+		Success: success,
 	})
 }
@@ -0,0 +1,456 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+// Versioned JSON API.
+// Unlike the unversioned /api routes used by the bundled front-end, all
+// responses (including errors) are JSON documents with stable schemas.
+// See /api/v1/openapi.json.
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/polycloze/polycloze/auth"
+	"github.com/polycloze/polycloze/basedir"
+	"github.com/polycloze/polycloze/database"
+	"github.com/polycloze/polycloze/history"
+	"github.com/polycloze/polycloze/logging"
+	"github.com/polycloze/polycloze/sessions"
+)
+
+// Query parameter of an endpoint.
+type queryParam struct {
+	Name        string
+	Type        string // "integer" or "string"
+	Description string
+}
+
+// Describes an /api/v1 endpoint.
+// Used for routing and for generating the OpenAPI document, so the document
+// can't drift from the actual routes.
+type endpoint struct {
+	Method  string
+	Path    string // Relative to /api/v1
+	Summary string
+
+	// Required API token scope.
+	// Empty if the endpoint doesn't require authentication.
+	Scope string
+
+	// Write endpoints need a CSRF token when using session cookies.
+	CSRF bool
+
+	Query    []queryParam
+	Request  any // Request body schema, nil if none
+	Response any // Response body schema
+	Handler  http.HandlerFunc
+}
+
+// Query params of stats endpoints.
+var timeRangeParams = []queryParam{
+	{Name: "from", Type: "integer", Description: "UNIX timestamp (default: a week ago)"},
+	{Name: "to", Type: "integer", Description: "UNIX timestamp (default: now)"},
+	{Name: "step", Type: "integer", Description: "Number of seconds per data point (default: 1 day)"},
+}
+
+func v1Endpoints() []endpoint {
+	return []endpoint{
+		{
+			Method:   "GET",
+			Path:     "/languages",
+			Summary:  "Lists L1 languages of installed courses.",
+			Response: LanguagesResponse{},
+			Handler:  handleV1Languages,
+		},
+		{
+			Method:   "GET",
+			Path:     "/courses",
+			Summary:  "Lists installed courses.",
+			Response: CoursesResponse{},
+			Handler:  handleV1Courses,
+		},
+		{
+			Method:  "GET",
+			Path:    "/sentences/{l1}/{l2}",
+			Summary: "Picks random sentences from the course.",
+			Query: []queryParam{
+				{Name: "difficulty", Type: "integer", Description: "Frequency class of sentences"},
+				{Name: "limit", Type: "integer", Description: "Max number of sentences (1-1000, default: 10)"},
+			},
+			Response: SentencesResponse{},
+			Handler:  handleV1Sentences,
+		},
+		{
+			Method:   "POST",
+			Path:     "/flashcards/{l1}/{l2}",
+			Summary:  "Saves review results and generates new flashcards.",
+			Scope:    auth.ScopeSubmitReviews,
+			CSRF:     true,
+			Request:  FlashcardsRequest{},
+			Response: FlashcardsResponse{},
+			Handler:  handleV1Flashcards,
+		},
+		{
+			Method:  "GET",
+			Path:    "/vocabulary/{l1}/{l2}",
+			Summary: "Lists words the user has seen in the course.",
+			Scope:   auth.ScopeReadStats,
+			Query: []queryParam{
+				{Name: "limit", Type: "integer", Description: "Max number of words (10-100)"},
+				{Name: "after", Type: "string", Description: "Only include words after this one"},
+				{Name: "sortBy", Type: "string", Description: "One of: word, reviewed, due, strength"},
+			},
+			Response: VocabularyResponse{},
+			Handler:  handleV1Vocabulary,
+		},
+		{
+			Method:   "GET",
+			Path:     "/stats/activity/{l1}/{l2}",
+			Summary:  "Summarizes the user's review activity over time.",
+			Scope:    auth.ScopeReadStats,
+			Query:    timeRangeParams,
+			Response: ActivityResponse{},
+			Handler:  handleV1StatsActivity,
+		},
+		{
+			Method:   "GET",
+			Path:     "/stats/vocab/{l1}/{l2}",
+			Summary:  "Returns the user's vocabulary size over time.",
+			Scope:    auth.ScopeReadStats,
+			Query:    timeRangeParams,
+			Response: VocabSizeResponse{},
+			Handler:  handleV1StatsVocab,
+		},
+		{
+			Method:   "GET",
+			Path:     "/stats/estimate/{l1}/{l2}",
+			Summary:  "Returns the user's estimated level over time.",
+			Scope:    auth.ScopeReadStats,
+			Query:    timeRangeParams,
+			Response: EstimatedLevelResponse{},
+			Handler:  handleV1StatsEstimatedLevel,
+		},
+		{
+			Method:   "POST",
+			Path:     "/actions/set-course",
+			Summary:  "Sets the user's active course.",
+			Scope:    auth.ScopeSubmitReviews,
+			CSRF:     true,
+			Request:  SetCourseRequest{},
+			Response: SetCourseResponse{},
+			Handler:  handleV1SetCourse,
+		},
+	}
+}
+
+// Usage: r.Mount("/api/v1", v1Router())
+func v1Router() chi.Router {
+	r := chi.NewRouter()
+
+	endpoints := v1Endpoints()
+	for _, e := range endpoints {
+		r.Method(e.Method, e.Path, e.Handler)
+	}
+
+	r.Get("/openapi.json", serveOpenAPI(endpoints))
+
+	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		sendError(w, http.StatusNotFound, errNotFound, "Not found.")
+	})
+	r.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		sendError(w, http.StatusMethodNotAllowed, errBadRequest, "Method not allowed.")
+	})
+	return r
+}
+
+// Signs in user.
+// Sends an error response on failure (the caller shouldn't write to w).
+func v1Session(w http.ResponseWriter, r *http.Request, scope string) (*sessions.Session, bool) {
+	s, err := resumeSession(w, r, scope)
+	if err == nil && s.IsSignedIn() {
+		return s, true
+	}
+	if auth.GetToken(r) != nil {
+		sendError(w, http.StatusForbidden, errForbidden, "API token doesn't have the required scope.")
+	} else {
+		sendError(w, http.StatusUnauthorized, errUnauthorized, "Sign in or use an API token.")
+	}
+	return nil, false
+}
+
+// Gets course in URL params.
+// Sends an error response if the course doesn't exist.
+func v1Course(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	l1 := chi.URLParam(r, "l1")
+	l2 := chi.URLParam(r, "l2")
+	if !courseExists(l1, l2) {
+		sendError(w, http.StatusNotFound, errNotFound, "Course not found.")
+		return l1, l2, false
+	}
+	return l1, l2, true
+}
+
+// Signs in user and opens the user's review DB for the course in the URL
+// params.
+// Sends an error response on failure.
+// The caller has to Close the db on success.
+func v1ReviewDB(w http.ResponseWriter, r *http.Request, scope string) (*sessions.Session, *sql.DB, bool) {
+	l1, l2, ok := v1Course(w, r)
+	if !ok {
+		return nil, nil, false
+	}
+
+	s, ok := v1Session(w, r, scope)
+	if !ok {
+		return nil, nil, false
+	}
+
+	userID := s.Data["userID"].(int)
+	db, err := openReviewDB(r, userID, l1, l2)
+	if err != nil {
+		logging.Error(r, fmt.Errorf("could not open review database (%v-%v): %w", l1, l2, err))
+		sendInternalError(w)
+		return nil, nil, false
+	}
+	return s, db, true
+}
+
+// Max size of JSON request bodies in bytes.
+const maxJSONSize = 1 << 20
+
+// Decodes JSON request body.
+// Sends an error response on failure.
+func v1DecodeJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	// Allows parameters, e.g. "application/json; charset=utf-8".
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		sendError(w, http.StatusUnsupportedMediaType, errBadRequest, "Expected JSON request body.")
+		return false
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxJSONSize))
+	var maxBytesError *http.MaxBytesError
+	if errors.As(err, &maxBytesError) {
+		sendError(w, http.StatusRequestEntityTooLarge, errTooLarge, "Request body is too large.")
+		return false
+	}
+	if err != nil {
+		logging.Error(r, err)
+		sendError(w, http.StatusBadRequest, errBadRequest, "Could not read request.")
+		return false
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		sendError(w, http.StatusBadRequest, errBadRequest, "Could not parse JSON.")
+		return false
+	}
+	return true
+}
+
+// Reads JSON file generated in the state directory during startup.
+func readStateJSON(name string, v any) error {
+	bytes, err := os.ReadFile(filepath.Join(basedir.StateDir, name))
+	if err != nil {
+		return fmt.Errorf("failed to read %v: %w", name, err)
+	}
+	if err := json.Unmarshal(bytes, v); err != nil {
+		return fmt.Errorf("failed to parse %v: %w", name, err)
+	}
+	return nil
+}
+
+func handleV1Languages(w http.ResponseWriter, r *http.Request) {
+	var response LanguagesResponse
+	if err := readStateJSON("languages.json", &response); err != nil {
+		logging.Error(r, err)
+		sendInternalError(w)
+		return
+	}
+	sendJSON(w, response)
+}
+
+func handleV1Courses(w http.ResponseWriter, r *http.Request) {
+	var response CoursesResponse
+	if err := readStateJSON("courses.json", &response); err != nil {
+		logging.Error(r, err)
+		sendInternalError(w)
+		return
+	}
+	sendJSON(w, response)
+}
+
+func handleV1Sentences(w http.ResponseWriter, r *http.Request) {
+	l1, l2, ok := v1Course(w, r)
+	if !ok {
+		return
+	}
+
+	q := r.URL.Query()
+	difficulty, err := strconv.Atoi(q.Get("difficulty"))
+	if err != nil {
+		sendError(w, http.StatusBadRequest, errBadRequest, "Invalid difficulty.")
+		return
+	}
+
+	result, err := courseSentences(r, l1, l2, difficulty, getSentencesLimit(q))
+	if err != nil {
+		logging.Error(r, err)
+		sendInternalError(w)
+		return
+	}
+	sendJSON(w, SentencesResponse{Sentences: result})
+}
+
+func handleV1Flashcards(w http.ResponseWriter, r *http.Request) {
+	var data FlashcardsRequest
+	if !v1DecodeJSON(w, r, &data) {
+		return
+	}
+
+	s, db, ok := v1ReviewDB(w, r, auth.ScopeSubmitReviews)
+	if !ok {
+		return
+	}
+	defer db.Close()
+
+	l1 := chi.URLParam(r, "l1")
+	l2 := chi.URLParam(r, "l2")
+
+	// Create database connection with access to review and course DB.
+	hook := database.AttachCourse(basedir.Course(l1, l2))
+	con, err := database.NewConnection(db, r.Context(), hook)
+	if err != nil {
+		logging.Error(r, err)
+		sendInternalError(w)
+		return
+	}
+	defer con.Close()
+
+	if len(data.Reviews) > 0 {
+		token := r.Header.Get("X-CSRF-Token")
+		if token == "" {
+			token = data.CSRFToken
+		}
+		if !s.CheckCSRFToken(token) {
+			sendError(w, http.StatusForbidden, errForbidden, "Invalid CSRF token.")
+			return
+		}
+
+		if err := saveReviews(r, s, con, l1, l2, data); err != nil {
+			logging.Error(r, err)
+			sendInternalError(w)
+			return
+		}
+	}
+	sendJSON(w, nextFlashcards(r, con, l1, l2, data))
+}
+
+func handleV1Vocabulary(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if v := q.Get("sortBy"); v != "" && !isValidSortBy(v) {
+		sendError(w, http.StatusBadRequest, errBadRequest, "Invalid sortBy value.")
+		return
+	}
+
+	_, db, ok := v1ReviewDB(w, r, auth.ScopeReadStats)
+	if !ok {
+		return
+	}
+	defer db.Close()
+
+	results, err := searchVocabulary(db, getLimit(q), getAfter(q), getSortBy(q))
+	if err != nil {
+		logging.Error(r, fmt.Errorf("search error: %w", err))
+		sendInternalError(w)
+		return
+	}
+	sendJSON(w, VocabularyResponse{Words: results})
+}
+
+func handleV1StatsActivity(w http.ResponseWriter, r *http.Request) {
+	_, db, ok := v1ReviewDB(w, r, auth.ScopeReadStats)
+	if !ok {
+		return
+	}
+	defer db.Close()
+
+	result, err := history.Summarize(db, getFrom(r), getTo(r), getStep(r))
+	if err != nil {
+		logging.Error(r, err)
+		sendInternalError(w)
+		return
+	}
+	sendJSON(w, ActivityResponse{Activity: result})
+}
+
+func handleV1StatsVocab(w http.ResponseWriter, r *http.Request) {
+	_, db, ok := v1ReviewDB(w, r, auth.ScopeReadStats)
+	if !ok {
+		return
+	}
+	defer db.Close()
+
+	result, err := history.VocabSize(db, getFrom(r), getTo(r), getStep(r))
+	if err != nil {
+		logging.Error(r, err)
+		sendInternalError(w)
+		return
+	}
+	sendJSON(w, VocabSizeResponse{VocabSize: result})
+}
+
+func handleV1StatsEstimatedLevel(w http.ResponseWriter, r *http.Request) {
+	_, db, ok := v1ReviewDB(w, r, auth.ScopeReadStats)
+	if !ok {
+		return
+	}
+	defer db.Close()
+
+	result, err := history.EstimatedLevel(db, getFrom(r), getTo(r), getStep(r))
+	if err != nil {
+		logging.Error(r, err)
+		sendInternalError(w)
+		return
+	}
+	sendJSON(w, EstimatedLevelResponse{EstimatedLevel: result})
+}
+
+func handleV1SetCourse(w http.ResponseWriter, r *http.Request) {
+	var data SetCourseRequest
+	if !v1DecodeJSON(w, r, &data) {
+		return
+	}
+
+	s, ok := v1Session(w, r, auth.ScopeSubmitReviews)
+	if !ok {
+		return
+	}
+
+	if !s.CheckCSRFToken(r.Header.Get("X-CSRF-Token")) {
+		sendError(w, http.StatusForbidden, errForbidden, "Invalid CSRF token.")
+		return
+	}
+
+	if !courseExists(data.L1Code, data.L2Code) {
+		sendError(w, http.StatusBadRequest, errBadRequest, "Invalid course.")
+		return
+	}
+
+	userID := s.Data["userID"].(int)
+	if err := saveActiveCourse(userID, data.L1Code, data.L2Code); err != nil {
+		logging.Error(r, err)
+		sendInternalError(w)
+		return
+	}
+	sendJSON(w, SetCourseResponse{Ok: true})
+}
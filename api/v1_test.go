@@ -0,0 +1,222 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/polycloze/polycloze/auth"
+)
+
+// Creates server with /api/v1 routes for testing.
+func testV1Server(t *testing.T) *httptest.Server {
+	db := testDB()
+	t.Cleanup(func() { db.Close() })
+
+	r := chi.NewRouter()
+	r.Use(auth.Middleware(db))
+	r.Use(auth.TokenMiddlewareFunc(invalidToken))
+	r.Mount("/api/v1", v1Router())
+	ts := httptest.NewServer(r)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// Checks if response body is an error envelope with the expected code.
+func checkErrorResponse(t *testing.T, resp *http.Response, status int, code string) {
+	t.Helper()
+	defer resp.Body.Close()
+
+	if resp.StatusCode != status {
+		t.Fatal("unexpected status code:", resp.StatusCode, status)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Fatal("expected JSON error response:", ct)
+	}
+
+	var body ErrorResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if body.Error.Code != code || body.Error.Message == "" {
+		t.Fatal("unexpected error response:", body)
+	}
+}
+
+func TestV1NotFound(t *testing.T) {
+	t.Parallel()
+	ts := testV1Server(t)
+
+	resp, err := ts.Client().Get(resolve(ts, "/api/v1/does-not-exist"))
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	checkErrorResponse(t, resp, http.StatusNotFound, errNotFound)
+}
+
+func TestV1CourseNotFound(t *testing.T) {
+	t.Parallel()
+	ts := testV1Server(t)
+
+	resp, err := ts.Client().Get(resolve(ts, "/api/v1/vocabulary/xxx/yyy"))
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	checkErrorResponse(t, resp, http.StatusNotFound, errNotFound)
+}
+
+func TestV1InvalidToken(t *testing.T) {
+	t.Parallel()
+	ts := testV1Server(t)
+
+	req, err := http.NewRequest("GET", resolve(ts, "/api/v1/courses"), nil)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	req.Header.Set("Authorization", "Bearer invalid")
+
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	checkErrorResponse(t, resp, http.StatusUnauthorized, errUnauthorized)
+}
+
+func TestV1IgnoresBasicAuth(t *testing.T) {
+	// Reverse proxies and browsers can add Basic auth credentials.
+	t.Parallel()
+	ts := testV1Server(t)
+
+	req, err := http.NewRequest("GET", resolve(ts, "/api/v1/openapi.json"), nil)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	req.SetBasicAuth("foo", "bar")
+
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatal("expected request to go through:", resp.StatusCode)
+	}
+}
+
+func TestV1FlashcardsExpectsJSON(t *testing.T) {
+	t.Parallel()
+	ts := testV1Server(t)
+
+	url := resolve(ts, "/api/v1/flashcards/eng/spa")
+	resp, err := ts.Client().Post(url, "text/plain", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	checkErrorResponse(t, resp, http.StatusUnsupportedMediaType, errBadRequest)
+}
+
+func TestV1AcceptsJSONWithCharset(t *testing.T) {
+	t.Parallel()
+	ts := testV1Server(t)
+
+	// Gets past the content type check, so the course gets checked next.
+	url := resolve(ts, "/api/v1/flashcards/xxx/yyy")
+	resp, err := ts.Client().Post(url, "application/json; charset=utf-8", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	checkErrorResponse(t, resp, http.StatusNotFound, errNotFound)
+}
+
+func TestV1RequestTooLarge(t *testing.T) {
+	t.Parallel()
+	ts := testV1Server(t)
+
+	body := `{"reviews": "` + strings.Repeat("x", maxJSONSize) + `"}`
+	url := resolve(ts, "/api/v1/flashcards/eng/spa")
+	resp, err := ts.Client().Post(url, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	checkErrorResponse(t, resp, http.StatusRequestEntityTooLarge, errTooLarge)
+}
+
+func TestOpenAPIDocument(t *testing.T) {
+	// Every endpoint should be documented, and schemas of request and response
+	// types should be included.
+	t.Parallel()
+	ts := testV1Server(t)
+
+	resp, err := ts.Client().Get(resolve(ts, "/api/v1/openapi.json"))
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	defer resp.Body.Close()
+
+	var document struct {
+		OpenAPI    string                               `json:"openapi"`
+		Paths      map[string]map[string]map[string]any `json:"paths"`
+		Components struct {
+			Schemas map[string]map[string]any `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&document); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	if !strings.HasPrefix(document.OpenAPI, "3.") {
+		t.Fatal("expected OpenAPI 3 document:", document.OpenAPI)
+	}
+
+	for _, e := range v1Endpoints() {
+		path := "/api/v1" + e.Path
+		operation, ok := document.Paths[path][strings.ToLower(e.Method)]
+		if !ok {
+			t.Fatal("expected endpoint to be documented:", e.Method, path)
+		}
+
+		// Scopes can't be listed in the security requirements of HTTP auth
+		// schemes.
+		if e.Scope == "" {
+			continue
+		}
+		if operation["x-scope"] != e.Scope {
+			t.Fatal("expected required scope to be documented:", path, operation["x-scope"])
+		}
+		for _, requirement := range operation["security"].([]any) {
+			for scheme, scopes := range requirement.(map[string]any) {
+				if len(scopes.([]any)) > 0 {
+					t.Fatal("expected security requirement to not list scopes:", path, scheme, scopes)
+				}
+			}
+		}
+	}
+
+	for _, name := range []string{
+		"api.FlashcardsRequest",
+		"api.FlashcardsResponse",
+		"api.ErrorResponse",
+		"flashcards.Sentence",
+		"sentences.Sentence",
+		"history.Metric_int",
+	} {
+		if document.Components.Schemas[name] == nil {
+			t.Fatal("expected schema to be defined:", name)
+		}
+	}
+
+	// Fields with `omitempty` shouldn't be required.
+	required := document.Components.Schemas["flashcards.Sentence"]["required"]
+	for _, field := range required.([]any) {
+		if field == "tatoebaID" {
+			t.Fatal("expected optional field to not be required")
+		}
+	}
+}
@@ -6,7 +6,6 @@ package api
 import (
 	"database/sql"
 	"fmt"
-	"log"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -15,9 +14,7 @@ import (
 	"github.com/go-chi/chi/v5"
 
 	"github.com/polycloze/polycloze/auth"
-	"github.com/polycloze/polycloze/basedir"
-	"github.com/polycloze/polycloze/database"
-	"github.com/polycloze/polycloze/sessions"
+	"github.com/polycloze/polycloze/logging"
 )
 
 type Word struct {
@@ -30,7 +27,7 @@ type Word struct {
 
 func handleVocabulary(w http.ResponseWriter, r *http.Request) {
 	db := auth.GetDB(r)
-	s, err := sessions.ResumeSession(db, w, r)
+	s, err := resumeSession(w, r, auth.ScopeReadStats)
 	if err != nil || !s.IsSignedIn() {
 		http.NotFound(w, r)
 		return
@@ -44,10 +41,10 @@ func handleVocabulary(w http.ResponseWriter, r *http.Request) {
 	}
 
 	userID := s.Data["userID"].(int)
-	db, err = database.OpenReviewDB(basedir.Review(userID, l1, l2))
+	db, err = openReviewDB(r, userID, l1, l2)
 	if err != nil {
-		log.Println(fmt.Errorf("could not open review database (%v-%v): %w", l1, l2, err))
-		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		logging.Error(r, fmt.Errorf("could not open review database (%v-%v): %w", l1, l2, err))
+		internalError(w)
 		return
 	}
 	defer db.Close()
@@ -55,12 +52,12 @@ func handleVocabulary(w http.ResponseWriter, r *http.Request) {
 	q := r.URL.Query()
 	results, err := searchVocabulary(db, getLimit(q), getAfter(q), getSortBy(q))
 	if err != nil {
-		log.Println(fmt.Errorf("search error: %w", err))
-		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		logging.Error(r, fmt.Errorf("search error: %w", err))
+		internalError(w)
 		return
 	}
-	sendJSON(w, map[string][]Word{
-		"words": results,
+	sendJSON(w, VocabularyResponse{
+		Words: results,
 	})
 }
 
@@ -17,6 +17,7 @@ import (
 	"github.com/polycloze/polycloze/auth"
 	"github.com/polycloze/polycloze/basedir"
 	"github.com/polycloze/polycloze/database"
+	"github.com/polycloze/polycloze/logging"
 	"github.com/polycloze/polycloze/sessions"
 )
 
@@ -37,6 +38,16 @@ func getActiveCourse(db *sql.DB) (string, error) {
 	return course, nil
 }
 
+// Opens the user's data DB, and sets the active course.
+func saveActiveCourse(userID int, l1, l2 string) error {
+	db, err := database.OpenUserDB(basedir.UserData(userID))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return setActiveCourse(db, userID, l1, l2)
+}
+
 // Sets user's active course.
 // Also initializes user's review DB for the course.
 func setActiveCourse(db *sql.DB, userID int, l1, l2 string) error {
@@ -77,16 +88,16 @@ func handleWelcome(w http.ResponseWriter, r *http.Request) {
 	userID := s.Data["userID"].(int)
 	db, err = database.OpenUserDB(basedir.UserData(userID))
 	if err != nil {
-		log.Println(err)
-		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		logging.Error(r, err)
+		internalError(w)
 		return
 	}
 	defer db.Close()
 
 	// Redirect if the user has already been welcomed (i.e. course has been set).
 	if course, err := getActiveCourse(db); err != nil {
-		log.Println(err)
-		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		logging.Error(r, err)
+		internalError(w)
 		return
 	} else if course != "" {
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
@@ -124,15 +135,15 @@ show:
 	path := filepath.Join(basedir.StateDir, "courses.json")
 	bytes, err := os.ReadFile(path)
 	if err != nil {
-		log.Println(err)
-		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		logging.Error(r, err)
+		internalError(w)
 		return
 	}
 
 	var data map[string][]Course
 	if err := json.Unmarshal(bytes, &data); err != nil {
-		log.Println(err)
-		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		logging.Error(r, err)
+		internalError(w)
 		return
 	}
 
@@ -140,7 +151,7 @@ show:
 	courses, ok := data["courses"]
 	if !ok {
 		log.Println("malformed courses.json")
-		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		internalError(w)
 		return
 	}
 
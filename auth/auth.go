@@ -5,10 +5,15 @@
 package auth
 
 import (
+	"crypto/rand"
 	"database/sql"
+	"encoding/base64"
 	"errors"
+	"fmt"
 
 	"golang.org/x/crypto/bcrypt"
+
+	"github.com/polycloze/polycloze/sessions"
 )
 
 func saltHashPassword(password string) string {
@@ -19,8 +24,32 @@ func saltHashPassword(password string) string {
 	return string(result)
 }
 
+// Hashes random password, for users who sign in without one (e.g. with
+// single sign-on).
+func randomPasswordHash() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return saltHashPassword(base64.RawURLEncoding.EncodeToString(bytes)), nil
+}
+
+// Checks if password can be hashed.
+// Doesn't enforce the password policy (see CheckPassword).
+func checkHashable(password string) error {
+	if password == "" {
+		return ErrEmptyPassword
+	}
+	if len(password) > maxPasswordBytes {
+		return ErrPasswordTooLong
+	}
+	return nil
+}
+
 func Register(db *sql.DB, username, password string) error {
-	// NOTE allows empty string as password
+	if err := checkHashable(password); err != nil {
+		return fmt.Errorf("unable to register user: %w", err)
+	}
 	query := `INSERT INTO user (username, password) VALUES (?, ?)`
 	hash := saltHashPassword(password)
 	if _, err := db.Exec(query, username, hash); err != nil {
@@ -29,13 +58,18 @@ func Register(db *sql.DB, username, password string) error {
 	return nil
 }
 
+// Returned by Authenticate if the credentials are correct, but the account
+// has been disabled.
+var ErrDisabled = errors.New("account is disabled")
+
 // Validates credentials.
 // Returns user ID on success.
 func Authenticate(db *sql.DB, username, password string) (int, error) {
 	var id int
 	var hash string
-	query := `SELECT id, password FROM user WHERE username = ?`
-	err := db.QueryRow(query, username).Scan(&id, &hash)
+	var disabled bool
+	query := `SELECT id, password, disabled FROM user WHERE username = ?`
+	err := db.QueryRow(query, username).Scan(&id, &hash, &disabled)
 
 	if err != nil && hash != "" {
 		panic("something unexpected occurred")
@@ -43,14 +77,26 @@ func Authenticate(db *sql.DB, username, password string) (int, error) {
 	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err != nil {
 		return id, errors.New("unable to authenticate user")
 	}
+	if disabled {
+		return id, ErrDisabled
+	}
 	return id, nil
 }
 
-func ChangePassword(db *sql.DB, userID int, password string) error {
+// Changes password and signs out the user's other sessions.
+// keepSessionID: ID of session that stays signed in; if empty, all of the
+// user's sessions get signed out
+func ChangePassword(db *sql.DB, userID int, password, keepSessionID string) error {
+	if err := checkHashable(password); err != nil {
+		return fmt.Errorf("unable to update password: %w", err)
+	}
 	query := `UPDATE user SET password = ? WHERE id = ?`
 	hash := saltHashPassword(password)
 	if _, err := db.Exec(query, hash, userID); err != nil {
 		return errors.New("unable to update password")
 	}
+	if _, err := sessions.DeleteOtherSessions(db, userID, keepSessionID); err != nil {
+		return fmt.Errorf("updated password, but %w", err)
+	}
 	return nil
 }
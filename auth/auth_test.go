@@ -5,6 +5,7 @@ package auth
 
 import (
 	"database/sql"
+	"errors"
 	"strings"
 	"testing"
 
@@ -110,11 +111,32 @@ func TestRegisterEmptyPassword(t *testing.T) {
 	db := openDB()
 	defer db.Close()
 
-	if err := Register(db, "username", ""); err != nil {
-		t.Fatal("empty string should be allowed as password:", err)
+	if err := Register(db, "username", ""); !errors.Is(err, ErrEmptyPassword) {
+		t.Fatal("expected ErrEmptyPassword:", err)
 	}
-	if _, err := Authenticate(db, "username", ""); err != nil {
-		t.Fatal("empty string should be allowed as password:", err)
+	if _, err := Authenticate(db, "username", ""); err == nil {
+		t.Fatal("expected user to not be registered")
+	}
+}
+
+func TestCheckPassword(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		password string
+		expected error
+	}{
+		{"", ErrEmptyPassword},
+		{"short", ErrPasswordTooShort},
+		{strings.Repeat("x", 73), ErrPasswordTooLong},
+		{"Username", ErrPasswordUsername},
+		{"correct horse", nil},
+	}
+	for _, c := range cases {
+		err := CheckPassword("username", c.password, 8)
+		if !errors.Is(err, c.expected) && err != c.expected {
+			t.Fatalf("expected CheckPassword(%q) to be %v: %v", c.password, c.expected, err)
+		}
 	}
 }
 
@@ -133,7 +155,7 @@ func TestChangePassword(t *testing.T) {
 	}
 
 	// Change password
-	if err := ChangePassword(db, oldID, "baz"); err != nil {
+	if err := ChangePassword(db, oldID, "baz", ""); err != nil {
 		t.Fatal("expected err to be nil:", err)
 	}
 
@@ -154,12 +176,49 @@ func TestChangePassword(t *testing.T) {
 	}
 }
 
+func TestChangePasswordSignsOutOtherSessions(t *testing.T) {
+	t.Parallel()
+	db := openDB()
+	defer db.Close()
+
+	if err := Register(db, "foo", "bar"); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	for _, id := range []string{"current", "other"} {
+		query := `INSERT INTO user_session (session_id, user_id, username) VALUES (?, 1, 'foo')`
+		if _, err := db.Exec(query, id); err != nil {
+			t.Fatal("expected err to be nil:", err)
+		}
+	}
+
+	if err := ChangePassword(db, 1, "baz", "current"); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	var sessions []string
+	rows, err := db.Query(`SELECT session_id FROM user_session`)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			t.Fatal("expected err to be nil:", err)
+		}
+		sessions = append(sessions, id)
+	}
+	if len(sessions) != 1 || sessions[0] != "current" {
+		t.Fatal("expected only the current session to remain:", sessions)
+	}
+}
+
 func TestChangePasswordNonExistentUser(t *testing.T) {
 	t.Parallel()
 	db := openDB()
 	defer db.Close()
 
-	if err := ChangePassword(db, 1, "baz"); err != nil {
+	if err := ChangePassword(db, 1, "baz", ""); err != nil {
 		t.Fatal("ChangePassword should not return error if user doesn't exist:", err)
 	}
 }
@@ -181,7 +240,7 @@ func TestChangePasswordStorage(t *testing.T) {
 
 	// Change password
 	password := "password"
-	if err := ChangePassword(db, id, password); err != nil {
+	if err := ChangePassword(db, id, password, ""); err != nil {
 		t.Fatal("expected err to be nil:", err)
 	}
 
@@ -7,6 +7,7 @@ import (
 	"context"
 	"database/sql"
 	"net/http"
+	"strings"
 )
 
 type contextValueKey int
@@ -14,6 +15,7 @@ type contextValueKey int
 // Keys for getting values from request context.
 const (
 	keyUserDB contextValueKey = iota
+	keyToken
 )
 
 // Stuffs pointer to auth database into request context.
@@ -32,3 +34,53 @@ func Middleware(db *sql.DB) func(http.Handler) http.Handler {
 func GetDB(r *http.Request) *sql.DB {
 	return r.Context().Value(keyUserDB).(*sql.DB)
 }
+
+// Authenticates requests that have an `Authorization: Bearer <token>` header,
+// and stuffs the token into the request context.
+// Requests without a Bearer token (e.g. with Basic auth credentials added by
+// a reverse proxy) are passed through unchanged.
+// Responds with a plain text error if the token is invalid.
+// Assumes Middleware is used.
+func TokenMiddleware(next http.Handler) http.Handler {
+	return TokenMiddlewareFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Unauthorized.", http.StatusUnauthorized)
+	})(next)
+}
+
+// Like TokenMiddleware, but calls unauthorized to respond to requests with a
+// malformed or invalid Bearer token.
+func TokenMiddlewareFunc(unauthorized http.HandlerFunc) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			scheme, value, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+			if !strings.EqualFold(scheme, "Bearer") {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			value = strings.TrimSpace(value)
+			if value == "" {
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_request"`)
+				unauthorized(w, r)
+				return
+			}
+
+			token, err := AuthenticateToken(GetDB(r), value)
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				unauthorized(w, r)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), keyToken, &token)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// Gets API token used to authenticate the request.
+// Returns nil if the request wasn't authenticated with a token.
+func GetToken(r *http.Request) *Token {
+	token, _ := r.Context().Value(keyToken).(*Token)
+	return token
+}
@@ -0,0 +1,81 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+// Demo users for the public demo.
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Returned by GetDemoUser if the username belongs to a user who isn't a demo
+// user, or to an admin.
+var ErrNotDemoUser = errors.New("user is not a demo user")
+
+// Returns ID of the demo user that all visitors share.
+// Creates the user if they don't exist yet.
+// Refuses to use existing users who weren't created as demo users, so that
+// visitors can't get signed in to someone else's account.
+func GetDemoUser(db *sql.DB, username string) (int, error) {
+	id, err := findDemoUser(db, username)
+	if !errors.Is(err, sql.ErrNoRows) {
+		return id, err
+	}
+
+	hash, err := randomPasswordHash()
+	if err != nil {
+		return 0, fmt.Errorf("failed to create demo user: %w", err)
+	}
+
+	// Ignores conflicts in case of concurrent requests.
+	query := `
+		INSERT INTO user (username, password, demo) VALUES (?, ?, 1)
+		ON CONFLICT DO NOTHING
+	`
+	if _, err := db.Exec(query, username, hash); err != nil {
+		return 0, fmt.Errorf("failed to create demo user: %w", err)
+	}
+	return findDemoUser(db, username)
+}
+
+// Returns ID of demo user.
+// Returns sql.ErrNoRows if the user doesn't exist.
+func findDemoUser(db *sql.DB, username string) (int, error) {
+	var id int
+	var demo, isAdmin, disabled bool
+	query := `SELECT id, demo, is_admin, disabled FROM user WHERE username = ?`
+	err := db.QueryRow(query, username).Scan(&id, &demo, &isAdmin, &disabled)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get demo user: %w", err)
+	}
+	if !demo || isAdmin {
+		return 0, fmt.Errorf("%w: %v", ErrNotDemoUser, username)
+	}
+	if disabled {
+		return id, ErrDisabled
+	}
+	return id, nil
+}
+
+// Creates demo user for a single visitor.
+// Visitors are guests, so they get deleted after their sessions expire.
+// Returns the ID and the username of the user.
+func CreateDemoVisitor(db *sql.DB, now time.Time) (int, string, error) {
+	return createGuest(db, now, true)
+}
+
+// Checks if the user is a demo user.
+func IsDemoUser(db *sql.DB, userID int) (bool, error) {
+	var demo bool
+	query := `SELECT demo FROM user WHERE id = ?`
+	if err := db.QueryRow(query, userID).Scan(&demo); err != nil {
+		return false, fmt.Errorf("failed to check if user is a demo user: %w", err)
+	}
+	return demo, nil
+}
@@ -0,0 +1,62 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestGetDemoUser(t *testing.T) {
+	t.Parallel()
+	db := openDB()
+	defer db.Close()
+
+	id, err := GetDemoUser(db, "demo")
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if again, err := GetDemoUser(db, "demo"); err != nil || again != id {
+		t.Fatal("expected the same demo user to be reused:", again, id, err)
+	}
+
+	// Demo users that got promoted to admins aren't handed out.
+	if err := SetAdmin(db, id, true); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if _, err := GetDemoUser(db, "demo"); !errors.Is(err, ErrNotDemoUser) {
+		t.Fatal("expected admin to be refused:", err)
+	}
+}
+
+func TestGetDemoUserRefusesExistingUser(t *testing.T) {
+	t.Parallel()
+	db := openDB()
+	defer db.Close()
+
+	if err := Register(db, "foo", "password"); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if _, err := GetDemoUser(db, "foo"); !errors.Is(err, ErrNotDemoUser) {
+		t.Fatal("expected registered user to be refused:", err)
+	}
+}
+
+func TestCreateDemoVisitor(t *testing.T) {
+	t.Parallel()
+	db := openDB()
+	defer db.Close()
+
+	id, _, err := CreateDemoVisitor(db, time.Now())
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if demo, err := IsDemoUser(db, id); err != nil || !demo {
+		t.Fatal("expected visitor to be a demo user:", demo, err)
+	}
+	if guest, err := IsGuest(db, id); err != nil || !guest {
+		t.Fatal("expected visitor to be a guest:", guest, err)
+	}
+}
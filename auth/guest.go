@@ -0,0 +1,155 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+// Guest accounts for trying the app without registering.
+package auth
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/polycloze/polycloze/sessions"
+)
+
+// Returned by ConvertGuest if the user isn't a guest.
+var ErrNotGuest = errors.New("user is not a guest")
+
+// Prefix of guest usernames.
+const guestPrefix = "guest-"
+
+// Creates guest account with a random username and password.
+// Returns the ID and the username of the guest.
+func CreateGuest(db *sql.DB, now time.Time) (int, string, error) {
+	return createGuest(db, now, false)
+}
+
+// Creates guest account, which is also a demo user if demo is true.
+func createGuest(db *sql.DB, now time.Time, demo bool) (int, string, error) {
+	bytes := make([]byte, 8)
+	if _, err := rand.Read(bytes); err != nil {
+		return 0, "", fmt.Errorf("failed to create guest: %w", err)
+	}
+	username := guestPrefix + hex.EncodeToString(bytes)
+
+	hash, err := randomPasswordHash()
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to create guest: %w", err)
+	}
+
+	var id int
+	query := `
+		INSERT INTO user (username, password, guest_since, demo) VALUES (?, ?, ?, ?)
+		RETURNING id
+	`
+	if err := db.QueryRow(query, username, hash, now.Unix(), demo).Scan(&id); err != nil {
+		return 0, "", fmt.Errorf("failed to create guest: %w", err)
+	}
+	return id, username, nil
+}
+
+// Checks if the user is a guest.
+func IsGuest(db *sql.DB, userID int) (bool, error) {
+	var isGuest bool
+	query := `SELECT guest_since IS NOT NULL FROM user WHERE id = ?`
+	if err := db.QueryRow(query, userID).Scan(&isGuest); err != nil {
+		return false, fmt.Errorf("failed to check if user is a guest: %w", err)
+	}
+	return isGuest, nil
+}
+
+// Turns guest into a registered user, so that they keep their progress.
+func ConvertGuest(db *sql.DB, userID int, username, password string) error {
+	return convertGuest(db, userID, username, password, nil)
+}
+
+// Like ConvertGuest, but requires an invite code.
+func ConvertGuestWithInvite(db *sql.DB, userID int, username, password, code string) error {
+	return convertGuest(db, userID, username, password, &code)
+}
+
+// Converts guest into a registered user.
+// Uses the invite code if it isn't nil.
+func convertGuest(db *sql.DB, userID int, username, password string, code *string) error {
+	if err := checkHashable(password); err != nil {
+		return fmt.Errorf("unable to register user: %w", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("unable to register user: %w", err)
+	}
+	defer tx.Rollback()
+
+	if code != nil {
+		if err := useInvite(tx, *code); err != nil {
+			return err
+		}
+	}
+
+	query := `
+		UPDATE user SET username = ?, password = ?, guest_since = NULL
+		WHERE id = ? AND guest_since IS NOT NULL
+	`
+	result, err := tx.Exec(query, username, saltHashPassword(password), userID)
+	if err != nil {
+		return errors.New("unable to register user")
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return ErrNotGuest
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("unable to register user: %w", err)
+	}
+	return nil
+}
+
+// Returns IDs of guests who don't have any unexpired session left, nor any
+// other way to sign in again (API tokens or "remember me" tokens).
+// Their accounts can be deleted.
+func AbandonedGuests(db *sql.DB, now time.Time) ([]int, error) {
+	// Gives new guests time to get signed in.
+	// API tokens don't expire.
+	query := `
+		SELECT id FROM user
+		WHERE guest_since < ?
+			AND NOT EXISTS (
+				SELECT 1 FROM user_session
+				WHERE user_id = user.id AND created > ? AND updated > ?
+			)
+			AND NOT EXISTS (
+				SELECT 1 FROM api_token WHERE user_id = user.id
+			)
+			AND NOT EXISTS (
+				SELECT 1 FROM remember_token
+				WHERE user_id = user.id AND expires > ?
+			)
+	`
+	rows, err := db.Query(
+		query,
+		now.Add(-sessions.IdleTimeout).Unix(),
+		now.Add(-sessions.MaxAge).Unix(),
+		now.Add(-sessions.IdleTimeout).Unix(),
+		now.Unix(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list abandoned guests: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to list abandoned guests: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list abandoned guests: %w", err)
+	}
+	return ids, nil
+}
@@ -0,0 +1,144 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestConvertGuest(t *testing.T) {
+	t.Parallel()
+	db := openDB()
+	defer db.Close()
+
+	id, username, err := CreateGuest(db, time.Now())
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if isGuest, err := IsGuest(db, id); err != nil || !isGuest {
+		t.Fatal("expected user to be a guest:", isGuest, err)
+	}
+	if _, err := Authenticate(db, username, ""); err == nil {
+		t.Fatal("expected guest to not be able to sign in")
+	}
+
+	if err := ConvertGuest(db, id, "foo", "password"); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if isGuest, err := IsGuest(db, id); err != nil || isGuest {
+		t.Fatal("expected user to not be a guest anymore:", isGuest, err)
+	}
+	if userID, err := Authenticate(db, "foo", "password"); err != nil || userID != id {
+		t.Fatal("expected converted user to be able to sign in:", userID, err)
+	}
+	if err := ConvertGuest(db, id, "bar", "password"); !errors.Is(err, ErrNotGuest) {
+		t.Fatal("expected registered user to not be converted:", err)
+	}
+}
+
+func TestConvertGuestWithInvite(t *testing.T) {
+	t.Parallel()
+	db := openDB()
+	defer db.Close()
+
+	id, _, err := CreateGuest(db, time.Now())
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if err := ConvertGuestWithInvite(db, id, "foo", "password", "nope"); !errors.Is(err, ErrInvalidInvite) {
+		t.Fatal("expected invalid invite to be rejected:", err)
+	}
+
+	code, err := CreateInvite(db, id, 1)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if err := ConvertGuestWithInvite(db, id, "foo", "password", code); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+}
+
+func TestAbandonedGuests(t *testing.T) {
+	t.Parallel()
+	db := openDB()
+	defer db.Close()
+
+	now := time.Now()
+	old := now.Add(-24 * time.Hour)
+	active, _, err := CreateGuest(db, old)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	abandoned, _, err := CreateGuest(db, old)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if _, _, err := CreateGuest(db, now); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if err := Register(db, "foo", "password"); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	query := `
+		INSERT INTO user_session (session_id, user_id, created, updated)
+		VALUES ('active', ?, ?, ?), ('expired', ?, ?, ?)
+	`
+	_, err = db.Exec(query, active, now.Unix(), now.Unix(), abandoned, old.Unix(), old.Unix())
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	ids, err := AbandonedGuests(db, now)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if len(ids) != 1 || ids[0] != abandoned {
+		t.Fatal("expected only the abandoned guest to be returned:", ids)
+	}
+}
+
+func TestAbandonedGuestsWithTokens(t *testing.T) {
+	// Guests with API tokens or "remember me" tokens can still sign in.
+	t.Parallel()
+	db := openDB()
+	defer db.Close()
+
+	now := time.Now()
+	old := now.Add(-24 * time.Hour)
+	withAPIToken, _, err := CreateGuest(db, old)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if _, err := CreateToken(db, withAPIToken, "script", nil); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	remembered, _, err := CreateGuest(db, old)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	expired, _, err := CreateGuest(db, old)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	query := `
+		INSERT INTO remember_token (user_id, series, hash, expires)
+		VALUES (?, 'remembered', 'a', ?), (?, 'expired', 'b', ?)
+	`
+	_, err = db.Exec(query, remembered, now.Add(time.Hour).Unix(), expired, old.Unix())
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	ids, err := AbandonedGuests(db, now)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if len(ids) != 1 || ids[0] != expired {
+		t.Fatal("expected only the guest with the expired token to be returned:", ids)
+	}
+}
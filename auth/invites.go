@@ -0,0 +1,152 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+// Invite codes for invite-only registration.
+package auth
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Returned by RegisterWithInvite if the code doesn't exist or has been used
+// up.
+var ErrInvalidInvite = errors.New("invalid invite code")
+
+type Invite struct {
+	ID        int
+	Code      string
+	MaxUses   int
+	Uses      int
+	CreatedBy string // Username of admin; empty if unknown
+	Created   time.Time
+}
+
+// Generates a random invite code that's easy to type, e.g.
+// "K3Q7-ZP2M-A9XW-TR4D".
+func generateInviteCode() (string, error) {
+	bytes := make([]byte, 10)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	code := base32.StdEncoding.EncodeToString(bytes)
+	return code[0:4] + "-" + code[4:8] + "-" + code[8:12] + "-" + code[12:16], nil
+}
+
+// Normalizes invite code entered by user.
+func normalizeInviteCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// Creates invite code that can be used maxUses times.
+// createdBy: ID of admin, or 0 if the invite wasn't created by a user
+func CreateInvite(db *sql.DB, createdBy, maxUses int) (string, error) {
+	if maxUses < 1 {
+		return "", errors.New("failed to create invite: max uses must be positive")
+	}
+	code, err := generateInviteCode()
+	if err != nil {
+		return "", fmt.Errorf("failed to create invite: %w", err)
+	}
+
+	var creator sql.NullInt64
+	if createdBy > 0 {
+		creator = sql.NullInt64{Int64: int64(createdBy), Valid: true}
+	}
+	query := `INSERT INTO invite (code, max_uses, created_by) VALUES (?, ?, ?)`
+	if _, err := db.Exec(query, code, maxUses, creator); err != nil {
+		return "", fmt.Errorf("failed to create invite: %w", err)
+	}
+	return code, nil
+}
+
+// Lists invites that haven't been used up, most recent first.
+func ListInvites(db *sql.DB) ([]Invite, error) {
+	query := `
+		SELECT invite.id, code, max_uses, uses, coalesce(username, ''), created
+		FROM invite LEFT JOIN user ON (created_by = user.id)
+		WHERE uses < max_uses
+		ORDER BY created DESC, invite.id DESC
+	`
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list invites: %w", err)
+	}
+	defer rows.Close()
+
+	var invites []Invite
+	for rows.Next() {
+		var invite Invite
+		var created int64
+		err := rows.Scan(
+			&invite.ID,
+			&invite.Code,
+			&invite.MaxUses,
+			&invite.Uses,
+			&invite.CreatedBy,
+			&created,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list invites: %w", err)
+		}
+		invite.Created = time.Unix(created, 0)
+		invites = append(invites, invite)
+	}
+	return invites, nil
+}
+
+// Deletes invite.
+// Doesn't return an error if the invite doesn't exist.
+func DeleteInvite(db *sql.DB, inviteID int) error {
+	query := `DELETE FROM invite WHERE id = ?`
+	if _, err := db.Exec(query, inviteID); err != nil {
+		return fmt.Errorf("failed to delete invite: %w", err)
+	}
+	return nil
+}
+
+// Increments the invite's use count.
+// Returns ErrInvalidInvite if the invite doesn't exist or has been used up.
+func useInvite(tx *sql.Tx, code string) error {
+	query := `UPDATE invite SET uses = uses + 1 WHERE code = ? AND uses < max_uses`
+	result, err := tx.Exec(query, normalizeInviteCode(code))
+	if err != nil {
+		return fmt.Errorf("failed to use invite: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return ErrInvalidInvite
+	}
+	return nil
+}
+
+// Registers user using invite code.
+// The invite's use count only goes up if registration succeeds.
+func RegisterWithInvite(db *sql.DB, username, password, code string) error {
+	if err := checkHashable(password); err != nil {
+		return fmt.Errorf("unable to register user: %w", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("unable to register user: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := useInvite(tx, code); err != nil {
+		return err
+	}
+
+	query := `INSERT INTO user (username, password) VALUES (?, ?)`
+	if _, err := tx.Exec(query, username, saltHashPassword(password)); err != nil {
+		return errors.New("unable to register user")
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("unable to register user: %w", err)
+	}
+	return nil
+}
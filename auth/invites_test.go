@@ -0,0 +1,72 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRegisterWithInvite(t *testing.T) {
+	t.Parallel()
+	db := openDB()
+	defer db.Close()
+
+	code, err := CreateInvite(db, 0, 2)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	// Codes are case-insensitive.
+	if err := RegisterWithInvite(db, "foo", "password", strings.ToLower(code)); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if err := RegisterWithInvite(db, "bar", "password", code); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if err := RegisterWithInvite(db, "baz", "password", code); !errors.Is(err, ErrInvalidInvite) {
+		t.Fatal("expected used up invite to be rejected:", err)
+	}
+	if _, err := Authenticate(db, "baz", "password"); err == nil {
+		t.Fatal("expected user to not be registered")
+	}
+}
+
+func TestRegisterWithInviteFailureDoesNotUseInvite(t *testing.T) {
+	t.Parallel()
+	db := openDB()
+	defer db.Close()
+
+	if err := Register(db, "foo", "password"); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	code, err := CreateInvite(db, 0, 1)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	// Username is taken.
+	if err := RegisterWithInvite(db, "foo", "password", code); err == nil {
+		t.Fatal("expected registration to fail")
+	}
+
+	invites, err := ListInvites(db)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if len(invites) != 1 || invites[0].Uses != 0 {
+		t.Fatal("expected invite to be unused:", invites)
+	}
+}
+
+func TestRegisterWithInvalidInvite(t *testing.T) {
+	t.Parallel()
+	db := openDB()
+	defer db.Close()
+
+	if err := RegisterWithInvite(db, "foo", "password", "nope"); !errors.Is(err, ErrInvalidInvite) {
+		t.Fatal("expected ErrInvalidInvite:", err)
+	}
+}
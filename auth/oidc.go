@@ -0,0 +1,244 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+// Single sign-on with OpenID Connect.
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Max time between starting and finishing an OpenID Connect sign-in.
+const oidcLoginTimeout = 10 * time.Minute
+
+var (
+	// Returned by AuthenticateOIDC if no user is linked to the identity, and
+	// provisioning is disabled.
+	ErrNoLinkedUser = errors.New("no user is linked to this identity")
+
+	// Returned by LinkOIDCIdentity if the identity is linked to another
+	// user.
+	ErrIdentityInUse = errors.New("identity is linked to another user")
+
+	// Returned by ConsumeOIDCLogin if the sign-in doesn't exist or expired.
+	ErrInvalidLogin = errors.New("invalid or expired sign-in")
+)
+
+// Identity from an OpenID Connect provider.
+type OIDCIdentity struct {
+	Issuer  string
+	Subject string
+
+	// Suggested username for provisioned accounts (e.g. the
+	// preferred_username claim). Optional.
+	Username string
+}
+
+// Sign-in that's waiting for the provider to redirect back.
+type OIDCLogin struct {
+	State    string
+	Nonce    string
+	Verifier string
+
+	// Set if a signed-in user is linking their account instead of signing in.
+	LinkUserID int
+}
+
+// Saves sign-in for ConsumeOIDCLogin.
+func CreateOIDCLogin(db *sql.DB, login OIDCLogin) error {
+	var linkUserID sql.NullInt64
+	if login.LinkUserID > 0 {
+		linkUserID = sql.NullInt64{Int64: int64(login.LinkUserID), Valid: true}
+	}
+
+	// Also deletes expired sign-ins.
+	query := `DELETE FROM oidc_login WHERE created < unixepoch('now') - ?`
+	if _, err := db.Exec(query, int64(oidcLoginTimeout.Seconds())); err != nil {
+		return fmt.Errorf("failed to save sign-in: %w", err)
+	}
+
+	query = `
+		INSERT INTO oidc_login (state, nonce, verifier, link_user_id)
+		VALUES (?, ?, ?, ?)
+	`
+	_, err := db.Exec(query, login.State, login.Nonce, login.Verifier, linkUserID)
+	if err != nil {
+		return fmt.Errorf("failed to save sign-in: %w", err)
+	}
+	return nil
+}
+
+// Gets and deletes sign-in with the given state, so it can only be used once.
+func ConsumeOIDCLogin(db *sql.DB, state string) (OIDCLogin, error) {
+	login := OIDCLogin{State: state}
+	var linkUserID sql.NullInt64
+	var created int64
+	query := `
+		DELETE FROM oidc_login WHERE state = ?
+		RETURNING nonce, verifier, link_user_id, created
+	`
+	err := db.QueryRow(query, state).Scan(&login.Nonce, &login.Verifier, &linkUserID, &created)
+	if errors.Is(err, sql.ErrNoRows) {
+		return login, ErrInvalidLogin
+	}
+	if err != nil {
+		return login, fmt.Errorf("failed to get sign-in: %w", err)
+	}
+	if time.Since(time.Unix(created, 0)) > oidcLoginTimeout {
+		return login, ErrInvalidLogin
+	}
+	login.LinkUserID = int(linkUserID.Int64)
+	return login, nil
+}
+
+// Returns ID of user linked to the identity.
+// If there's none and provision is true, creates a new user.
+func AuthenticateOIDC(db *sql.DB, identity OIDCIdentity, provision bool) (int, error) {
+	var id int
+	var disabled bool
+	query := `
+		SELECT user.id, disabled FROM oidc_identity JOIN user ON (user_id = user.id)
+		WHERE issuer = ? AND subject = ?
+	`
+	err := db.QueryRow(query, identity.Issuer, identity.Subject).Scan(&id, &disabled)
+	if err == nil {
+		if disabled {
+			return id, ErrDisabled
+		}
+		return id, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("failed to authenticate user: %w", err)
+	}
+	if !provision {
+		return 0, ErrNoLinkedUser
+	}
+	return provisionOIDCUser(db, identity)
+}
+
+// Creates user for the identity.
+// Provisioned users get a random password, so they can only sign in with
+// single sign-on.
+func provisionOIDCUser(db *sql.DB, identity OIDCIdentity) (int, error) {
+	hash, err := randomPasswordHash()
+	if err != nil {
+		return 0, fmt.Errorf("failed to provision user: %w", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to provision user: %w", err)
+	}
+	defer tx.Rollback()
+
+	username, err := availableUsername(tx, identity.Username)
+	if err != nil {
+		return 0, fmt.Errorf("failed to provision user: %w", err)
+	}
+
+	query := `INSERT INTO user (username, password) VALUES (?, ?)`
+	result, err := tx.Exec(query, username, hash)
+	if err != nil {
+		return 0, fmt.Errorf("failed to provision user: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to provision user: %w", err)
+	}
+
+	query = `
+		INSERT INTO oidc_identity (issuer, subject, user_id, provisioned)
+		VALUES (?, ?, ?, 1)
+	`
+	if _, err := tx.Exec(query, identity.Issuer, identity.Subject, id); err != nil {
+		return 0, fmt.Errorf("failed to provision user: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to provision user: %w", err)
+	}
+	return int(id), nil
+}
+
+// Cleans up username suggested by the identity provider.
+func sanitizeUsername(username string) string {
+	// Email addresses aren't used as-is, to avoid revealing them.
+	username, _, _ = strings.Cut(username, "@")
+	username = strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, username)
+	if username == "" {
+		return "user"
+	}
+	return username
+}
+
+// Returns the suggested username if it's not taken yet, or the suggested
+// username with a number at the end (e.g. "alice2").
+func availableUsername(tx *sql.Tx, suggested string) (string, error) {
+	base := sanitizeUsername(suggested)
+	query := `SELECT count(*) FROM user WHERE username = ?`
+	for i := 1; i <= 1000; i++ {
+		username := base
+		if i > 1 {
+			username = fmt.Sprintf("%v%d", base, i)
+		}
+
+		var count int
+		if err := tx.QueryRow(query, username).Scan(&count); err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return username, nil
+		}
+	}
+	return "", errors.New("no available username")
+}
+
+// Links identity to an existing user, so that the user can sign in with it.
+func LinkOIDCIdentity(db *sql.DB, userID int, issuer, subject string) error {
+	query := `
+		INSERT INTO oidc_identity (issuer, subject, user_id) VALUES (?, ?, ?)
+		ON CONFLICT DO NOTHING
+	`
+	if _, err := db.Exec(query, issuer, subject, userID); err != nil {
+		return fmt.Errorf("failed to link identity: %w", err)
+	}
+
+	var linkedID int
+	query = `SELECT user_id FROM oidc_identity WHERE issuer = ? AND subject = ?`
+	if err := db.QueryRow(query, issuer, subject).Scan(&linkedID); err != nil {
+		return fmt.Errorf("failed to link identity: %w", err)
+	}
+	if linkedID != userID {
+		return ErrIdentityInUse
+	}
+	return nil
+}
+
+// Checks if the user has a linked identity from the issuer.
+func HasOIDCIdentity(db *sql.DB, userID int, issuer string) (bool, error) {
+	var count int
+	query := `SELECT count(*) FROM oidc_identity WHERE user_id = ? AND issuer = ?`
+	if err := db.QueryRow(query, userID, issuer).Scan(&count); err != nil {
+		return false, fmt.Errorf("failed to check linked identities: %w", err)
+	}
+	return count > 0, nil
+}
+
+// Checks if the user was created by single sign-on.
+func IsOIDCProvisioned(db *sql.DB, userID int) (bool, error) {
+	var count int
+	query := `SELECT count(*) FROM oidc_identity WHERE user_id = ? AND provisioned`
+	if err := db.QueryRow(query, userID).Scan(&count); err != nil {
+		return false, fmt.Errorf("failed to check provisioned identities: %w", err)
+	}
+	return count > 0, nil
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+package auth
+
+import (
+	"errors"
+	"testing"
+)
+
+const testIssuer = "https://idp.example.com"
+
+func TestAuthenticateOIDCWithoutProvisioning(t *testing.T) {
+	t.Parallel()
+	db := openDB()
+	defer db.Close()
+
+	identity := OIDCIdentity{Issuer: testIssuer, Subject: "abc", Username: "foo"}
+	if _, err := AuthenticateOIDC(db, identity, false); !errors.Is(err, ErrNoLinkedUser) {
+		t.Fatal("expected unlinked identity to be rejected:", err)
+	}
+
+	if err := Register(db, "foo", "bar"); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if err := LinkOIDCIdentity(db, 1, testIssuer, "abc"); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	id, err := AuthenticateOIDC(db, identity, false)
+	if err != nil || id != 1 {
+		t.Fatal("expected linked user to be signed in:", id, err)
+	}
+	if ok, err := IsOIDCProvisioned(db, 1); err != nil || ok {
+		t.Fatal("expected linked user to not be marked as provisioned:", ok, err)
+	}
+
+	if err := SetDisabled(db, 1, true); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if _, err := AuthenticateOIDC(db, identity, false); !errors.Is(err, ErrDisabled) {
+		t.Fatal("expected disabled user to be rejected:", err)
+	}
+}
+
+func TestAuthenticateOIDCProvisioning(t *testing.T) {
+	t.Parallel()
+	db := openDB()
+	defer db.Close()
+
+	// Existing users with the same username don't get taken over.
+	if err := Register(db, "alice", "password"); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	identity := OIDCIdentity{Issuer: testIssuer, Subject: "abc", Username: "alice@example.com"}
+	id, err := AuthenticateOIDC(db, identity, true)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if id == 1 {
+		t.Fatal("expected new user to be created")
+	}
+	if username, _ := GetUsername(db, id); username != "alice2" {
+		t.Fatal("expected username to be made unique:", username)
+	}
+	if ok, err := IsOIDCProvisioned(db, id); err != nil || !ok {
+		t.Fatal("expected user to be marked as provisioned:", ok, err)
+	}
+
+	again, err := AuthenticateOIDC(db, identity, true)
+	if err != nil || again != id {
+		t.Fatal("expected the same user to be signed in:", again, err)
+	}
+}
+
+func TestLinkOIDCIdentityInUse(t *testing.T) {
+	t.Parallel()
+	db := openDB()
+	defer db.Close()
+
+	for _, username := range []string{"foo", "bar"} {
+		if err := Register(db, username, "password"); err != nil {
+			t.Fatal("expected err to be nil:", err)
+		}
+	}
+	if err := LinkOIDCIdentity(db, 1, testIssuer, "abc"); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if err := LinkOIDCIdentity(db, 1, testIssuer, "abc"); err != nil {
+		t.Fatal("expected linking twice to succeed:", err)
+	}
+	if err := LinkOIDCIdentity(db, 2, testIssuer, "abc"); !errors.Is(err, ErrIdentityInUse) {
+		t.Fatal("expected identity to not be linked to another user:", err)
+	}
+}
+
+func TestConsumeOIDCLogin(t *testing.T) {
+	t.Parallel()
+	db := openDB()
+	defer db.Close()
+
+	login := OIDCLogin{State: "state", Nonce: "nonce", Verifier: "verifier"}
+	if err := CreateOIDCLogin(db, login); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	consumed, err := ConsumeOIDCLogin(db, "state")
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if consumed != login {
+		t.Fatal("expected sign-in to be saved:", consumed)
+	}
+	if _, err := ConsumeOIDCLogin(db, "state"); !errors.Is(err, ErrInvalidLogin) {
+		t.Fatal("expected sign-in to be single-use:", err)
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+// Password policy.
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// bcrypt ignores bytes after the 72nd.
+const maxPasswordBytes = 72
+
+var (
+	ErrEmptyPassword    = errors.New("password is empty")
+	ErrPasswordTooShort = errors.New("password is too short")
+	ErrPasswordTooLong  = errors.New("password is too long")
+	ErrPasswordUsername = errors.New("password is the same as the username")
+)
+
+// Checks if password is acceptable.
+// minLength: min number of characters
+func CheckPassword(username, password string, minLength int) error {
+	if password == "" {
+		return ErrEmptyPassword
+	}
+	if utf8.RuneCountInString(password) < minLength {
+		return fmt.Errorf("%w: needs at least %d characters", ErrPasswordTooShort, minLength)
+	}
+	if len(password) > maxPasswordBytes {
+		return ErrPasswordTooLong
+	}
+	if strings.EqualFold(password, username) {
+		return ErrPasswordUsername
+	}
+	return nil
+}
+
+// Returns message that explains why the password isn't acceptable.
+func PasswordMessage(err error, minLength int) string {
+	switch {
+	case errors.Is(err, ErrEmptyPassword), errors.Is(err, ErrPasswordTooShort):
+		return fmt.Sprintf("Password must have at least %d characters.", minLength)
+	case errors.Is(err, ErrPasswordTooLong):
+		return fmt.Sprintf("Password must not be longer than %d bytes.", maxPasswordBytes)
+	case errors.Is(err, ErrPasswordUsername):
+		return "Password must be different from your username."
+	default:
+		return "Invalid password."
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+// Authentication by a reverse proxy (e.g. oauth2-proxy or Authelia).
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/polycloze/polycloze/logging"
+	"github.com/polycloze/polycloze/sessions"
+)
+
+// Returned by AuthenticateProxyUser if the username belongs to a guest or a
+// demo user, or looks like a guest username.
+// These users don't have real accounts, so the proxy can't sign in as them.
+var ErrReservedUsername = errors.New("username is reserved")
+
+// Returns ID of user authenticated by the proxy.
+// Creates the user if they don't exist yet.
+func AuthenticateProxyUser(db *sql.DB, username string) (int, error) {
+	return getOrCreateUser(db, username)
+}
+
+// Signs in users authenticated by a reverse proxy, which puts the username in
+// the header.
+// The header is ignored unless fromTrustedProxy says the request came from
+// the proxy, because clients can set any header they want.
+// Trusted requests without the header end the signed-in session, if there's
+// one, because the user has signed out of the proxy.
+// Other requests without the header (e.g. for static files that the proxy
+// lets through) are left alone.
+// Does nothing if header is empty.
+// Assumes Middleware and TokenMiddleware are used.
+func ProxyMiddleware(
+	header string,
+	fromTrustedProxy func(*http.Request) bool,
+) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if header == "" {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if GetToken(r) != nil || !fromTrustedProxy(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			db := GetDB(r)
+			username := strings.TrimSpace(r.Header.Get(header))
+			if username == "" {
+				if sessions.HasSignedInSession(db, r) {
+					if err := sessions.EndSession(db, w, r); err != nil {
+						logging.Error(r, err)
+						http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+						return
+					}
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			userID, err := AuthenticateProxyUser(db, username)
+			if errors.Is(err, ErrDisabled) {
+				http.Error(w, "This account has been disabled.", http.StatusForbidden)
+				return
+			}
+			if errors.Is(err, ErrReservedUsername) {
+				http.Error(w, "This username is reserved.", http.StatusForbidden)
+				return
+			}
+			if err != nil {
+				logging.Error(r, err)
+				http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+				return
+			}
+
+			// Starts a new session if the proxy signed in another user.
+			s, err := sessions.ResumeSession(db, w, r)
+			if err == nil && s.Data["userID"] == userID {
+				next.ServeHTTP(w, r)
+				return
+			}
+			s, err = sessions.StartSession(db, w, r)
+			if err != nil {
+				logging.Error(r, err)
+				http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+				return
+			}
+			s.Data["userID"] = userID
+			s.Data["username"] = username
+			if err := sessions.SaveData(db, s); err != nil {
+				logging.Error(r, err)
+				http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+				return
+			}
+			if err := RecordSignIn(db, userID); err != nil {
+				logging.Error(r, err)
+			}
+			logging.Set(r.Context(), "userID", userID)
+			next.ServeHTTP(w, sessions.WithSession(r, s))
+		})
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/polycloze/polycloze/sessions"
+)
+
+func TestAuthenticateProxyUser(t *testing.T) {
+	t.Parallel()
+	db := openDB()
+	defer db.Close()
+
+	id, err := AuthenticateProxyUser(db, "foo")
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	again, err := AuthenticateProxyUser(db, "foo")
+	if err != nil || again != id {
+		t.Fatal("expected existing user to be reused:", again, err)
+	}
+
+	if err := SetDisabled(db, id, true); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if _, err := AuthenticateProxyUser(db, "foo"); !errors.Is(err, ErrDisabled) {
+		t.Fatal("expected disabled user to be rejected:", err)
+	}
+}
+
+func TestAuthenticateProxyUserReserved(t *testing.T) {
+	// The proxy can't sign in as guests or demo users.
+	t.Parallel()
+	db := openDB()
+	defer db.Close()
+
+	_, guest, err := CreateGuest(db, time.Now())
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if _, err := GetDemoUser(db, "demo"); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	for _, username := range []string{guest, "guest-new", "demo"} {
+		if _, err := AuthenticateProxyUser(db, username); !errors.Is(err, ErrReservedUsername) {
+			t.Fatal("expected reserved username to be rejected:", username, err)
+		}
+	}
+	if _, err := GetUsername(db, 3); err == nil {
+		t.Fatal("expected user with reserved username to not be created")
+	}
+}
+
+// Creates handler that trusts requests with the "X-Trusted" header, and
+// responds with the signed-in user's username.
+func proxyHandler(db *sql.DB) http.Handler {
+	trusted := func(r *http.Request) bool {
+		return r.Header.Get("X-Trusted") != ""
+	}
+	whoami := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, err := sessions.ResumeSession(db, w, r)
+		if err == nil && s.IsSignedIn() {
+			fmt.Fprint(w, s.Data["username"])
+		}
+	})
+	return Middleware(db)(ProxyMiddleware("Remote-User", trusted)(whoami))
+}
+
+// Sends request with the username header, and returns the response.
+func proxyRequest(handler http.Handler, username string, trusted bool, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Remote-User", username)
+	if trusted {
+		r.Header.Set("X-Trusted", "1")
+	}
+	for _, c := range cookies {
+		r.AddCookie(c)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestProxyMiddleware(t *testing.T) {
+	t.Parallel()
+	db := openDB()
+	defer db.Close()
+	handler := proxyHandler(db)
+
+	w := proxyRequest(handler, "foo", true)
+	if body := w.Body.String(); body != "foo" {
+		t.Fatal("expected user to be signed in:", body)
+	}
+
+	// The session gets reused by later requests.
+	var cookies []*http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.MaxAge >= 0 {
+			cookies = append(cookies, c)
+		}
+	}
+	if len(cookies) == 0 {
+		t.Fatal("expected session cookie to be set")
+	}
+	w = proxyRequest(handler, "foo", true, cookies...)
+	if len(w.Result().Cookies()) > 0 {
+		t.Fatal("expected session to be reused")
+	}
+
+	// Switching users at the proxy switches sessions.
+	w = proxyRequest(handler, "bar", true, cookies...)
+	if body := w.Body.String(); body != "bar" {
+		t.Fatal("expected other user to be signed in:", body)
+	}
+}
+
+func TestProxyMiddlewareUntrusted(t *testing.T) {
+	// Clients can't sign in by setting the header themselves.
+	t.Parallel()
+	db := openDB()
+	defer db.Close()
+
+	w := proxyRequest(proxyHandler(db), "foo", false)
+	if body := w.Body.String(); body != "" {
+		t.Fatal("expected user to not be signed in:", body)
+	}
+	if _, err := GetUsername(db, 1); err == nil {
+		t.Fatal("expected user to not be created")
+	}
+}
+
+func TestProxyMiddlewareSignOut(t *testing.T) {
+	// Signing out of the proxy ends the session.
+	t.Parallel()
+	db := openDB()
+	defer db.Close()
+	handler := proxyHandler(db)
+
+	// Requests without a session (e.g. health checks) are left alone.
+	w := proxyRequest(handler, "", true)
+	if cookies := w.Result().Cookies(); len(cookies) > 0 {
+		t.Fatal("expected no cookies to be set:", cookies)
+	}
+
+	w = proxyRequest(handler, "foo", true)
+	var cookies []*http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.MaxAge >= 0 {
+			cookies = append(cookies, c)
+		}
+	}
+
+	// Untrusted requests without the header don't end the session.
+	w = proxyRequest(handler, "", false, cookies...)
+	if body := w.Body.String(); body != "foo" {
+		t.Fatal("expected user to still be signed in:", body)
+	}
+
+	w = proxyRequest(handler, "", true, cookies...)
+	if body := w.Body.String(); body != "" {
+		t.Fatal("expected user to be signed out:", body)
+	}
+	w = proxyRequest(handler, "", false, cookies...)
+	if body := w.Body.String(); body != "" {
+		t.Fatal("expected session to be ended:", body)
+	}
+}
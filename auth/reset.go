@@ -0,0 +1,210 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+// Password resets through emailed links.
+package auth
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// How long password reset links stay valid.
+const ResetTokenLifetime = time.Hour
+
+// Min time between password reset emails to the same user.
+const resetInterval = time.Minute
+
+var (
+	// Returned by SetEmail if another user has the email address.
+	ErrEmailInUse = errors.New("email address is used by another user")
+
+	// Returned by SetEmail if the email address is malformed.
+	ErrInvalidEmail = errors.New("invalid email address")
+
+	// Returned by CreatePasswordReset if no active user has the email
+	// address.
+	ErrUnknownEmail = errors.New("no user has this email address")
+
+	// Returned by CreatePasswordReset if the user was sent a reset link
+	// recently.
+	ErrResetTooSoon = errors.New("password reset was requested recently")
+
+	// Returned if the password reset token doesn't exist or expired.
+	ErrInvalidResetToken = errors.New("invalid or expired password reset token")
+)
+
+// Checks if email is a bare email address (e.g. "alice@example.com").
+func validateEmail(email string) error {
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email {
+		return ErrInvalidEmail
+	}
+	return nil
+}
+
+// Sets user's email address.
+// Empty email removes the email address.
+func SetEmail(db *sql.DB, userID int, email string) error {
+	email = strings.TrimSpace(email)
+	var value sql.NullString
+	if email != "" {
+		if err := validateEmail(email); err != nil {
+			return err
+		}
+		value = sql.NullString{String: email, Valid: true}
+	}
+
+	query := `
+		SELECT count(*) FROM user
+		WHERE email = ? COLLATE NOCASE AND id != ?
+	`
+	var count int
+	if err := db.QueryRow(query, value, userID).Scan(&count); err != nil {
+		return fmt.Errorf("failed to set email: %w", err)
+	}
+	if count > 0 {
+		return ErrEmailInUse
+	}
+
+	query = `UPDATE user SET email = ? WHERE id = ?`
+	if _, err := db.Exec(query, value, userID); err != nil {
+		return fmt.Errorf("failed to set email: %w", err)
+	}
+	return nil
+}
+
+// Returns user's email address, or an empty string if they don't have one.
+func GetEmail(db *sql.DB, userID int) (string, error) {
+	var email sql.NullString
+	query := `SELECT email FROM user WHERE id = ?`
+	if err := db.QueryRow(query, userID).Scan(&email); err != nil {
+		return "", fmt.Errorf("failed to get email: %w", err)
+	}
+	return email.String, nil
+}
+
+// Generates random token for password reset links.
+func generateResetToken() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
+
+// Password reset that has to be sent to the user.
+type PasswordReset struct {
+	Username string
+	Email    string
+
+	// Only its hash gets stored.
+	Token string
+}
+
+// Creates password reset token for the user with the email address.
+func CreatePasswordReset(db *sql.DB, email string, now time.Time) (PasswordReset, error) {
+	reset := PasswordReset{}
+	var userID int
+	query := `
+		SELECT id, username, email FROM user
+		WHERE email = ? COLLATE NOCASE AND NOT disabled
+	`
+	err := db.QueryRow(query, strings.TrimSpace(email)).Scan(&userID, &reset.Username, &reset.Email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return reset, ErrUnknownEmail
+	}
+	if err != nil {
+		return reset, fmt.Errorf("failed to create password reset: %w", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return reset, fmt.Errorf("failed to create password reset: %w", err)
+	}
+	defer tx.Rollback()
+
+	query = `DELETE FROM password_reset WHERE created <= ?`
+	if _, err := tx.Exec(query, now.Add(-ResetTokenLifetime).Unix()); err != nil {
+		return reset, fmt.Errorf("failed to create password reset: %w", err)
+	}
+
+	// Limits how many emails an attacker can make the server send.
+	var count int
+	query = `SELECT count(*) FROM password_reset WHERE user_id = ? AND created > ?`
+	if err := tx.QueryRow(query, userID, now.Add(-resetInterval).Unix()).Scan(&count); err != nil {
+		return reset, fmt.Errorf("failed to create password reset: %w", err)
+	}
+	if count > 0 {
+		return reset, ErrResetTooSoon
+	}
+
+	reset.Token, err = generateResetToken()
+	if err != nil {
+		return reset, fmt.Errorf("failed to create password reset: %w", err)
+	}
+	query = `INSERT INTO password_reset (user_id, hash, created) VALUES (?, ?, ?)`
+	if _, err := tx.Exec(query, userID, hashToken(reset.Token), now.Unix()); err != nil {
+		return reset, fmt.Errorf("failed to create password reset: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return reset, fmt.Errorf("failed to create password reset: %w", err)
+	}
+	return reset, nil
+}
+
+// Returns username of the user who can reset their password with the token.
+func CheckResetToken(db *sql.DB, token string, now time.Time) (string, error) {
+	var username string
+	query := `
+		SELECT username FROM password_reset JOIN user ON user_id = user.id
+		WHERE hash = ? AND created > ? AND NOT disabled
+	`
+	err := db.QueryRow(query, hashToken(token), now.Add(-ResetTokenLifetime).Unix()).Scan(&username)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrInvalidResetToken
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to check password reset token: %w", err)
+	}
+	return username, nil
+}
+
+// Changes password of the user with the reset token, and signs out all of
+// their sessions.
+// Invalidates all of the user's reset tokens.
+func ResetPassword(db *sql.DB, token, password string, now time.Time) error {
+	if err := checkHashable(password); err != nil {
+		return fmt.Errorf("failed to reset password: %w", err)
+	}
+
+	var userID int
+	query := `
+		DELETE FROM password_reset
+		WHERE hash = ? AND created > ?
+			AND user_id IN (SELECT id FROM user WHERE NOT disabled)
+		RETURNING user_id
+	`
+	err := db.QueryRow(query, hashToken(token), now.Add(-ResetTokenLifetime).Unix()).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrInvalidResetToken
+	}
+	if err != nil {
+		return fmt.Errorf("failed to reset password: %w", err)
+	}
+
+	query = `DELETE FROM password_reset WHERE user_id = ?`
+	if _, err := db.Exec(query, userID); err != nil {
+		return fmt.Errorf("failed to reset password: %w", err)
+	}
+	if err := ChangePassword(db, userID, password, ""); err != nil {
+		return fmt.Errorf("failed to reset password: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSetEmail(t *testing.T) {
+	t.Parallel()
+	db := openDB()
+	defer db.Close()
+
+	for _, username := range []string{"foo", "bar"} {
+		if err := Register(db, username, "password"); err != nil {
+			t.Fatal("expected err to be nil:", err)
+		}
+	}
+
+	if err := SetEmail(db, 1, "Foo <foo@example.com>"); !errors.Is(err, ErrInvalidEmail) {
+		t.Fatal("expected email with display name to be rejected:", err)
+	}
+	if err := SetEmail(db, 1, "foo@example.com"); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if err := SetEmail(db, 2, "FOO@example.com"); !errors.Is(err, ErrEmailInUse) {
+		t.Fatal("expected email of another user to be rejected:", err)
+	}
+	if err := SetEmail(db, 1, ""); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if email, err := GetEmail(db, 1); err != nil || email != "" {
+		t.Fatal("expected email to be removed:", email, err)
+	}
+}
+
+func TestPasswordReset(t *testing.T) {
+	t.Parallel()
+	db := openDB()
+	defer db.Close()
+
+	if err := Register(db, "foo", "password"); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if err := SetEmail(db, 1, "foo@example.com"); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	now := time.Now()
+	if _, err := CreatePasswordReset(db, "bar@example.com", now); !errors.Is(err, ErrUnknownEmail) {
+		t.Fatal("expected unknown email to be rejected:", err)
+	}
+	reset, err := CreatePasswordReset(db, "Foo@Example.com", now)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if reset.Username != "foo" || reset.Email != "foo@example.com" {
+		t.Fatal("unexpected password reset:", reset)
+	}
+	if _, err := CreatePasswordReset(db, "foo@example.com", now); !errors.Is(err, ErrResetTooSoon) {
+		t.Fatal("expected repeated request to be throttled:", err)
+	}
+
+	if username, err := CheckResetToken(db, reset.Token, now); err != nil || username != "foo" {
+		t.Fatal("expected token to be valid:", username, err)
+	}
+	if err := ResetPassword(db, reset.Token, "new password", now); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if _, err := Authenticate(db, "foo", "new password"); err != nil {
+		t.Fatal("expected password to be changed:", err)
+	}
+	if err := ResetPassword(db, reset.Token, "another one", now); !errors.Is(err, ErrInvalidResetToken) {
+		t.Fatal("expected token to be single-use:", err)
+	}
+}
+
+func TestPasswordResetExpires(t *testing.T) {
+	t.Parallel()
+	db := openDB()
+	defer db.Close()
+
+	if err := Register(db, "foo", "password"); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if err := SetEmail(db, 1, "foo@example.com"); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	now := time.Now()
+	reset, err := CreatePasswordReset(db, "foo@example.com", now)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	later := now.Add(ResetTokenLifetime)
+	if _, err := CheckResetToken(db, reset.Token, later); !errors.Is(err, ErrInvalidResetToken) {
+		t.Fatal("expected token to expire:", err)
+	}
+	if err := ResetPassword(db, reset.Token, "new password", later); !errors.Is(err, ErrInvalidResetToken) {
+		t.Fatal("expected expired token to be rejected:", err)
+	}
+}
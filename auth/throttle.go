@@ -0,0 +1,236 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+// Sign-in throttling.
+package auth
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Limits failed sign-in attempts per key (username or IP address).
+type Throttle struct {
+	// Failed attempts allowed before backoff kicks in.
+	FreeAttempts int
+
+	// Delay after the first throttled attempt.
+	// Doubles with every failed attempt after that.
+	BaseDelay time.Duration
+
+	// Failed attempts before lockout.
+	MaxAttempts int
+
+	// Failed attempts older than this don't count.
+	// Also the lockout duration.
+	Window time.Duration
+}
+
+// Throttles for usernames and IP addresses.
+// IP addresses get more attempts, because users behind NAT share addresses.
+var (
+	UsernameThrottle = Throttle{
+		FreeAttempts: 3,
+		BaseDelay:    time.Second,
+		MaxAttempts:  10,
+		Window:       15 * time.Minute,
+	}
+	IPThrottle = Throttle{
+		FreeAttempts: 10,
+		BaseDelay:    time.Second,
+		MaxAttempts:  50,
+		Window:       15 * time.Minute,
+	}
+)
+
+// How long sign-in attempts are kept.
+const attemptRetention = 30 * 24 * time.Hour
+
+// Returns how long to wait after the last of n failed attempts.
+func (t Throttle) delay(n int) time.Duration {
+	if n >= t.MaxAttempts {
+		return t.Window
+	}
+	if n <= t.FreeAttempts {
+		return 0
+	}
+
+	delay := t.BaseDelay
+	for i := t.FreeAttempts + 1; i < n && delay < t.Window; i++ {
+		delay *= 2
+	}
+	if delay > t.Window {
+		return t.Window
+	}
+	return delay
+}
+
+// Mutexes that are created on demand, and deleted once they're no longer used.
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyedLock
+}
+
+type keyedLock struct {
+	sync.Mutex
+	refs int
+}
+
+// Locks key, and returns function for unlocking it.
+func (m *keyedMutex) lock(key string) func() {
+	m.mu.Lock()
+	if m.locks == nil {
+		m.locks = make(map[string]*keyedLock)
+	}
+	l, ok := m.locks[key]
+	if !ok {
+		l = &keyedLock{}
+		m.locks[key] = l
+	}
+	l.refs++
+	m.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		l.refs--
+		if l.refs == 0 {
+			delete(m.locks, key)
+		}
+	}
+}
+
+var signInLocks keyedMutex
+
+// Serializes sign-in attempts for the username and for the IP address.
+// Otherwise, concurrent attempts would all pass the SignInWait check before
+// any of them gets recorded.
+// Call the returned function after recording the attempt.
+func LockSignIn(username, ip string) func() {
+	// Usernames always get locked first, so that attempts can't deadlock.
+	unlockUsername := signInLocks.lock("username:" + username)
+	unlockIP := signInLocks.lock("ip:" + ip)
+	return func() {
+		unlockIP()
+		unlockUsername()
+	}
+}
+
+type SignInAttempt struct {
+	Username string
+	IP       string
+	Success  bool
+	Time     time.Time
+}
+
+// Records sign-in attempt, and deletes old ones.
+func RecordSignInAttempt(db *sql.DB, attempt SignInAttempt) error {
+	query := `
+		INSERT INTO sign_in_attempt (username, ip, success, created)
+		VALUES (?, ?, ?, ?)
+	`
+	_, err := db.Exec(query, attempt.Username, attempt.IP, attempt.Success, attempt.Time.Unix())
+	if err != nil {
+		return fmt.Errorf("failed to record sign-in attempt: %w", err)
+	}
+
+	query = `DELETE FROM sign_in_attempt WHERE created < ?`
+	if _, err := db.Exec(query, attempt.Time.Add(-attemptRetention).Unix()); err != nil {
+		return fmt.Errorf("failed to delete old sign-in attempts: %w", err)
+	}
+	return nil
+}
+
+// Returns how long the client has to wait before its next attempt gets
+// checked.
+// Use LockSignIn to check and record attempts atomically.
+// now: time of the new attempt
+func SignInWait(db *sql.DB, username, ip string, now time.Time) (time.Duration, error) {
+	// Successful sign-ins reset the username's count, but not the IP address's,
+	// so that an attacker with an account can't use it to keep guessing other
+	// users' passwords.
+	query := `
+		SELECT count(*), coalesce(max(created), 0) FROM sign_in_attempt
+		WHERE username = ? AND NOT success AND created > ? AND id > (
+			SELECT coalesce(max(id), 0) FROM sign_in_attempt
+			WHERE username = ? AND success
+		)
+	`
+	since := now.Add(-UsernameThrottle.Window).Unix()
+	wait, err := throttleWait(db, UsernameThrottle, now, query, username, since, username)
+	if err != nil {
+		return 0, err
+	}
+
+	query = `
+		SELECT count(*), coalesce(max(created), 0) FROM sign_in_attempt
+		WHERE ip = ? AND NOT success AND created > ?
+	`
+	since = now.Add(-IPThrottle.Window).Unix()
+	ipWait, err := throttleWait(db, IPThrottle, now, query, ip, since)
+	if err != nil {
+		return 0, err
+	}
+
+	if ipWait > wait {
+		return ipWait, nil
+	}
+	return wait, nil
+}
+
+// Computes wait time from the number of failed attempts and the time of the
+// last one.
+func throttleWait(
+	db *sql.DB,
+	t Throttle,
+	now time.Time,
+	query string,
+	args ...any,
+) (time.Duration, error) {
+	var count int
+	var last int64
+	if err := db.QueryRow(query, args...).Scan(&count, &last); err != nil {
+		return 0, fmt.Errorf("failed to count sign-in attempts: %w", err)
+	}
+
+	wait := time.Unix(last, 0).Add(t.delay(count)).Sub(now)
+	if wait < 0 {
+		return 0, nil
+	}
+	return wait, nil
+}
+
+// Returns most recent sign-in attempts, latest first.
+func RecentSignInAttempts(db *sql.DB, limit int) ([]SignInAttempt, error) {
+	query := `
+		SELECT username, ip, success, created FROM sign_in_attempt
+		ORDER BY created DESC, id DESC
+		LIMIT ?
+	`
+	rows, err := db.Query(query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get recent sign-in attempts: %w", err)
+	}
+	defer rows.Close()
+
+	var attempts []SignInAttempt
+	for rows.Next() {
+		var attempt SignInAttempt
+		var created int64
+		err := rows.Scan(&attempt.Username, &attempt.IP, &attempt.Success, &created)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get recent sign-in attempts: %w", err)
+		}
+		attempt.Time = time.Unix(created, 0)
+		attempts = append(attempts, attempt)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get recent sign-in attempts: %w", err)
+	}
+	return attempts, nil
+}
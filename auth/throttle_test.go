@@ -0,0 +1,191 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+package auth
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestThrottleDelay(t *testing.T) {
+	t.Parallel()
+
+	throttle := Throttle{
+		FreeAttempts: 3,
+		BaseDelay:    time.Second,
+		MaxAttempts:  10,
+		Window:       time.Minute,
+	}
+	cases := map[int]time.Duration{
+		0:  0,
+		3:  0,
+		4:  time.Second,
+		5:  2 * time.Second,
+		6:  4 * time.Second,
+		9:  32 * time.Second,
+		10: time.Minute,
+		20: time.Minute,
+	}
+	for n, expected := range cases {
+		if actual := throttle.delay(n); actual != expected {
+			t.Fatalf("expected delay(%d) to be %v: %v", n, expected, actual)
+		}
+	}
+}
+
+func recordFailures(t *testing.T, db *sql.DB, username, ip string, n int, at time.Time) {
+	for i := 0; i < n; i++ {
+		attempt := SignInAttempt{Username: username, IP: ip, Time: at}
+		if err := RecordSignInAttempt(db, attempt); err != nil {
+			t.Fatal("expected err to be nil:", err)
+		}
+	}
+}
+
+func TestSignInWaitUsername(t *testing.T) {
+	t.Parallel()
+	db := openDB()
+	defer db.Close()
+
+	now := time.Unix(time.Now().Unix(), 0) // Attempts are stored in seconds
+	recordFailures(t, db, "foo", "192.0.2.1", UsernameThrottle.FreeAttempts, now)
+	wait, err := SignInWait(db, "foo", "192.0.2.2", now)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if wait != 0 {
+		t.Fatal("expected free attempts to not be throttled:", wait)
+	}
+
+	recordFailures(t, db, "foo", "192.0.2.1", 1, now)
+	wait, err = SignInWait(db, "foo", "192.0.2.2", now)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if wait != UsernameThrottle.BaseDelay {
+		t.Fatal("expected username to be throttled:", wait)
+	}
+
+	// Other usernames aren't affected.
+	wait, err = SignInWait(db, "bar", "192.0.2.2", now)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if wait != 0 {
+		t.Fatal("expected other username to not be throttled:", wait)
+	}
+
+	// Successful sign-in resets the count.
+	attempt := SignInAttempt{Username: "foo", IP: "192.0.2.1", Success: true, Time: now}
+	if err := RecordSignInAttempt(db, attempt); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	wait, err = SignInWait(db, "foo", "192.0.2.2", now)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if wait != 0 {
+		t.Fatal("expected successful sign-in to reset throttle:", wait)
+	}
+}
+
+func TestSignInWaitLockout(t *testing.T) {
+	t.Parallel()
+	db := openDB()
+	defer db.Close()
+
+	now := time.Unix(time.Now().Unix(), 0) // Attempts are stored in seconds
+	recordFailures(t, db, "foo", "192.0.2.1", UsernameThrottle.MaxAttempts, now)
+	wait, err := SignInWait(db, "foo", "192.0.2.1", now)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if wait != UsernameThrottle.Window {
+		t.Fatal("expected username to be locked out:", wait)
+	}
+
+	// Lockout expires.
+	wait, err = SignInWait(db, "foo", "192.0.2.1", now.Add(UsernameThrottle.Window+time.Second))
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if wait != 0 {
+		t.Fatal("expected lockout to expire:", wait)
+	}
+}
+
+func TestSignInWaitIP(t *testing.T) {
+	t.Parallel()
+	db := openDB()
+	defer db.Close()
+
+	now := time.Unix(time.Now().Unix(), 0) // Attempts are stored in seconds
+
+	// Spread attempts across usernames so that only the IP gets throttled.
+	for i := 0; i <= IPThrottle.FreeAttempts; i++ {
+		recordFailures(t, db, string(rune('a'+i)), "192.0.2.1", 1, now)
+	}
+
+	wait, err := SignInWait(db, "foo", "192.0.2.1", now)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if wait == 0 {
+		t.Fatal("expected IP address to be throttled")
+	}
+
+	wait, err = SignInWait(db, "foo", "192.0.2.2", now)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if wait != 0 {
+		t.Fatal("expected other IP address to not be throttled:", wait)
+	}
+}
+
+func TestLockSignIn(t *testing.T) {
+	t.Parallel()
+
+	unlock := LockSignIn("lock-test", "192.0.2.1")
+
+	// Attempts for the same username wait, even from another address.
+	locked := make(chan func())
+	go func() {
+		locked <- LockSignIn("lock-test", "192.0.2.2")
+	}()
+	select {
+	case <-locked:
+		t.Fatal("expected attempt for the same username to wait")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Attempts for other usernames and addresses don't.
+	done := make(chan struct{})
+	go func() {
+		LockSignIn("lock-test-other", "192.0.2.3")()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected attempt for another username to not wait")
+	}
+
+	unlock()
+	select {
+	case unlockOther := <-locked:
+		unlockOther()
+	case <-time.After(time.Second):
+		t.Fatal("expected attempt to continue after unlock")
+	}
+
+	signInLocks.mu.Lock()
+	defer signInLocks.mu.Unlock()
+	for key := range signInLocks.locks {
+		if key == "username:lock-test" || key == "ip:192.0.2.1" {
+			t.Fatal("expected unused lock to be deleted:", key)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+// Personal access tokens.
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Token scopes.
+const (
+	ScopeReadStats     = "stats:read"
+	ScopeSubmitReviews = "reviews:write"
+	ScopeImportExport  = "data:import-export"
+)
+
+// List of all valid scopes.
+var Scopes = []string{
+	ScopeReadStats,
+	ScopeSubmitReviews,
+	ScopeImportExport,
+}
+
+// Checks if scope is valid.
+func IsValidScope(scope string) bool {
+	for _, valid := range Scopes {
+		if scope == valid {
+			return true
+		}
+	}
+	return false
+}
+
+// Prefix of generated tokens.
+// Makes tokens easier to recognize (e.g. by secret scanners).
+const tokenPrefix = "pct_"
+
+// Personal access token.
+// Doesn't contain the token itself, only its metadata.
+type Token struct {
+	ID       int
+	UserID   int
+	Username string
+	Name     string
+	Scopes   []string
+	Created  time.Time
+	LastUsed time.Time // Zero if the token hasn't been used
+}
+
+// Checks if token has the scope.
+func (t *Token) HasScope(scope string) bool {
+	if t == nil {
+		return false
+	}
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns SHA-256 hash of token in hex.
+func hashToken(token string) string {
+	result := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(result[:])
+}
+
+// Generates a cryptographically secure random 256-bit token.
+func generateToken() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return tokenPrefix + base64.RawURLEncoding.EncodeToString(bytes), nil
+}
+
+// Creates a new token for the user.
+// Returns the token in plaintext. Only its hash gets stored, so this is the
+// only chance to show it to the user.
+func CreateToken(db *sql.DB, userID int, name string, scopes []string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", errors.New("failed to create token: empty token name")
+	}
+	for _, scope := range scopes {
+		if !IsValidScope(scope) {
+			return "", fmt.Errorf("failed to create token: invalid scope: %v", scope)
+		}
+	}
+
+	token, err := generateToken()
+	if err != nil {
+		return "", fmt.Errorf("failed to create token: %w", err)
+	}
+
+	query := `INSERT INTO api_token (user_id, name, hash, scopes) VALUES (?, ?, ?, ?)`
+	_, err = db.Exec(query, userID, name, hashToken(token), strings.Join(scopes, " "))
+	if err != nil {
+		return "", fmt.Errorf("failed to create token: %w", err)
+	}
+	return token, nil
+}
+
+// Scans token metadata from a row.
+// Expects the following columns: id, user_id, username, name, scopes, created,
+// last_used.
+func scanToken(row interface{ Scan(...any) error }) (Token, error) {
+	var token Token
+	var scopes string
+	var created int64
+	var lastUsed sql.NullInt64
+	err := row.Scan(
+		&token.ID,
+		&token.UserID,
+		&token.Username,
+		&token.Name,
+		&scopes,
+		&created,
+		&lastUsed,
+	)
+	if err != nil {
+		return token, err
+	}
+
+	token.Scopes = strings.Fields(scopes)
+	token.Created = time.Unix(created, 0)
+	if lastUsed.Valid {
+		token.LastUsed = time.Unix(lastUsed.Int64, 0)
+	}
+	return token, nil
+}
+
+// Validates token.
+// Returns the token's metadata on success, and updates its last use timestamp.
+func AuthenticateToken(db *sql.DB, token string) (Token, error) {
+	query := `
+		SELECT api_token.id, user_id, username, name, scopes, created, last_used
+		FROM api_token JOIN user ON (user_id = user.id)
+		WHERE hash = ? AND NOT disabled
+	`
+	result, err := scanToken(db.QueryRow(query, hashToken(token)))
+	if err != nil {
+		return result, errors.New("unable to authenticate token")
+	}
+
+	query = `UPDATE api_token SET last_used = unixepoch('now') WHERE id = ?`
+	if _, err := db.Exec(query, result.ID); err != nil {
+		return result, fmt.Errorf("unable to authenticate token: %w", err)
+	}
+	return result, nil
+}
+
+// Lists user's tokens, most recent first.
+func ListTokens(db *sql.DB, userID int) ([]Token, error) {
+	query := `
+		SELECT api_token.id, user_id, username, name, scopes, created, last_used
+		FROM api_token JOIN user ON (user_id = user.id)
+		WHERE user_id = ?
+		ORDER BY created DESC, api_token.id DESC
+	`
+	rows, err := db.Query(query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tokens: %w", err)
+	}
+	defer rows.Close()
+
+	var tokens []Token
+	for rows.Next() {
+		token, err := scanToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list tokens: %w", err)
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, nil
+}
+
+// Deletes user's token.
+// Doesn't return an error if the token doesn't exist or belongs to a different
+// user, but doesn't delete anything either.
+func RevokeToken(db *sql.DB, userID, tokenID int) error {
+	query := `DELETE FROM api_token WHERE id = ? AND user_id = ?`
+	if _, err := db.Exec(query, tokenID, userID); err != nil {
+		return fmt.Errorf("failed to revoke token: %w", err)
+	}
+	return nil
+}
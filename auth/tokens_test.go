@@ -0,0 +1,184 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCreateAndAuthenticateToken(t *testing.T) {
+	t.Parallel()
+	db := openDB()
+	defer db.Close()
+
+	if err := Register(db, "foo", "bar"); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	id, err := Authenticate(db, "foo", "bar")
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	token, err := CreateToken(db, id, "script", []string{ScopeReadStats})
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	result, err := AuthenticateToken(db, token)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if result.UserID != id || result.Username != "foo" {
+		t.Fatal("expected token to belong to user:", result)
+	}
+	if !result.HasScope(ScopeReadStats) {
+		t.Fatal("expected token to have scope:", result.Scopes)
+	}
+	if result.HasScope(ScopeSubmitReviews) {
+		t.Fatal("expected token to not have scope:", result.Scopes)
+	}
+}
+
+func TestCreateTokenInvalidScope(t *testing.T) {
+	t.Parallel()
+	db := openDB()
+	defer db.Close()
+
+	if err := Register(db, "foo", "bar"); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if _, err := CreateToken(db, 1, "script", []string{"admin"}); err == nil {
+		t.Fatal("expected token creation with invalid scope to fail")
+	}
+}
+
+func TestTokenStorage(t *testing.T) {
+	// Tokens shouldn't be stored in plaintext.
+	t.Parallel()
+	db := openDB()
+	defer db.Close()
+
+	if err := Register(db, "foo", "bar"); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	token, err := CreateToken(db, 1, "script", []string{ScopeReadStats})
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	var hash string
+	query := `SELECT hash FROM api_token`
+	if err := db.QueryRow(query).Scan(&hash); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if strings.Contains(hash, token) || strings.Contains(token, hash) {
+		t.Fatal("token should not be stored in plaintext")
+	}
+}
+
+func TestRevokeToken(t *testing.T) {
+	t.Parallel()
+	db := openDB()
+	defer db.Close()
+
+	if err := Register(db, "foo", "bar"); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if err := Register(db, "baz", "qux"); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	token, err := CreateToken(db, 1, "script", []string{ScopeReadStats})
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	tokens, err := ListTokens(db, 1)
+	if err != nil || len(tokens) != 1 {
+		t.Fatal("expected user to have one token:", tokens, err)
+	}
+
+	// Other users can't revoke the token.
+	if err := RevokeToken(db, 2, tokens[0].ID); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if _, err := AuthenticateToken(db, token); err != nil {
+		t.Fatal("expected token to still be valid:", err)
+	}
+
+	if err := RevokeToken(db, 1, tokens[0].ID); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if _, err := AuthenticateToken(db, token); err == nil {
+		t.Fatal("expected revoked token to be invalid")
+	}
+}
+
+func TestTokenMiddleware(t *testing.T) {
+	t.Parallel()
+	db := openDB()
+	defer db.Close()
+
+	if err := Register(db, "foo", "bar"); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	token, err := CreateToken(db, 1, "script", []string{ScopeReadStats})
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	var found *Token
+	handler := Middleware(db)(TokenMiddleware(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			found = GetToken(r)
+		},
+	)))
+
+	// Valid token.
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || found == nil || found.UserID != 1 {
+		t.Fatal("expected request to be authenticated:", rec.Code, found)
+	}
+
+	// Invalid token.
+	found = nil
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer invalid")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized || found != nil {
+		t.Fatal("expected request to be rejected:", rec.Code, found)
+	}
+
+	// Malformed token.
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized || found != nil {
+		t.Fatal("expected request to be rejected:", rec.Code, found)
+	}
+
+	// No token.
+	req = httptest.NewRequest("GET", "/", nil)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || found != nil {
+		t.Fatal("expected request to pass through without token:", rec.Code, found)
+	}
+
+	// Other authentication schemes, e.g. added by a reverse proxy.
+	req = httptest.NewRequest("GET", "/", nil)
+	req.SetBasicAuth("foo", "bar")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || found != nil {
+		t.Fatal("expected request to pass through without token:", rec.Code, found)
+	}
+}
@@ -0,0 +1,297 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+// TOTP two-factor authentication (RFC 6238).
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP parameters.
+// These are the defaults of most authenticator apps.
+const (
+	totpPeriod = 30 * time.Second
+	totpDigits = 6
+
+	// Number of time steps before and after the current one that are
+	// accepted, to allow for clock drift.
+	totpSkew = 1
+)
+
+// Number of recovery codes generated when 2FA gets enabled.
+const recoveryCodeCount = 10
+
+var ErrInvalidCode = errors.New("invalid two-factor authentication code")
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Generates random 160-bit TOTP secret, encoded in base32.
+func generateTOTPSecret() (string, error) {
+	bytes := make([]byte, 20)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(bytes), nil
+}
+
+// Computes HOTP value (RFC 4226).
+func hotp(key []byte, counter uint64) string {
+	var message [8]byte
+	binary.BigEndian.PutUint64(message[:], counter)
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(message[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	modulus := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		modulus *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, value%modulus)
+}
+
+// Returns TOTP time step.
+func totpCounter(t time.Time) int64 {
+	return t.Unix() / int64(totpPeriod/time.Second)
+}
+
+// Checks TOTP code.
+// Returns the time step that matched the code.
+func validateTOTP(secret, code string, now time.Time) (int64, bool) {
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil {
+		return 0, false
+	}
+
+	code = strings.ReplaceAll(code, " ", "")
+	current := totpCounter(now)
+	for counter := current - totpSkew; counter <= current+totpSkew; counter++ {
+		expected := hotp(key, uint64(counter))
+		if hmac.Equal([]byte(code), []byte(expected)) {
+			return counter, true
+		}
+	}
+	return 0, false
+}
+
+// Returns otpauth URI for adding the secret to an authenticator app.
+func TOTPURI(secret, username string) string {
+	const issuer = "polycloze"
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(totpDigits))
+	v.Set("period", fmt.Sprint(int(totpPeriod/time.Second)))
+
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + username,
+		RawQuery: v.Encode(),
+	}
+	return u.String()
+}
+
+// Checks if the user has 2FA enabled.
+func HasTOTP(db *sql.DB, userID int) (bool, error) {
+	var enabled bool
+	query := `SELECT totp_secret IS NOT NULL FROM user WHERE id = ?`
+	if err := db.QueryRow(query, userID).Scan(&enabled); err != nil {
+		return false, fmt.Errorf("failed to check if 2FA is enabled: %w", err)
+	}
+	return enabled, nil
+}
+
+// Generates a new secret for the user to add to their authenticator app.
+// 2FA doesn't get enabled until the user confirms it with EnableTOTP.
+// Returns the secret.
+func SetupTOTP(db *sql.DB, userID int) (string, error) {
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return "", fmt.Errorf("failed to set up 2FA: %w", err)
+	}
+
+	query := `UPDATE user SET totp_pending_secret = ? WHERE id = ?`
+	if _, err := db.Exec(query, secret, userID); err != nil {
+		return "", fmt.Errorf("failed to set up 2FA: %w", err)
+	}
+	return secret, nil
+}
+
+// Returns secret that hasn't been confirmed yet, or an empty string if
+// there's none.
+func PendingTOTPSecret(db *sql.DB, userID int) (string, error) {
+	var secret sql.NullString
+	query := `SELECT totp_pending_secret FROM user WHERE id = ?`
+	if err := db.QueryRow(query, userID).Scan(&secret); err != nil {
+		return "", fmt.Errorf("failed to get pending 2FA secret: %w", err)
+	}
+	return secret.String, nil
+}
+
+// Enables 2FA if the code matches the pending secret.
+// Returns recovery codes in plaintext. Only their hashes get stored, so this
+// is the only chance to show them to the user.
+func EnableTOTP(db *sql.DB, userID int, code string, now time.Time) ([]string, error) {
+	secret, err := PendingTOTPSecret(db, userID)
+	if err != nil {
+		return nil, err
+	}
+	if secret == "" {
+		return nil, errors.New("failed to enable 2FA: 2FA hasn't been set up")
+	}
+
+	counter, ok := validateTOTP(secret, code, now)
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to enable 2FA: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE user
+		SET totp_secret = ?, totp_pending_secret = NULL, totp_last_counter = ?
+		WHERE id = ?
+	`
+	if _, err := tx.Exec(query, secret, counter, userID); err != nil {
+		return nil, fmt.Errorf("failed to enable 2FA: %w", err)
+	}
+
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to enable 2FA: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to enable 2FA: %w", err)
+	}
+	return codes, nil
+}
+
+// Disables 2FA and deletes recovery codes.
+func DisableTOTP(db *sql.DB, userID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to disable 2FA: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE user
+		SET totp_secret = NULL, totp_pending_secret = NULL, totp_last_counter = 0
+		WHERE id = ?
+	`
+	if _, err := tx.Exec(query, userID); err != nil {
+		return fmt.Errorf("failed to disable 2FA: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM recovery_code WHERE user_id = ?`, userID); err != nil {
+		return fmt.Errorf("failed to disable 2FA: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to disable 2FA: %w", err)
+	}
+	return nil
+}
+
+// Generates random recovery code, e.g. "3QF7-KD2M-XP9A".
+func generateRecoveryCode() (string, error) {
+	code, err := generateInviteCode()
+	if err != nil {
+		return "", err
+	}
+	return code[:14], nil
+}
+
+// Replaces user's recovery codes with new ones.
+// Returns the new codes in plaintext.
+func replaceRecoveryCodes(tx *sql.Tx, userID int) ([]string, error) {
+	if _, err := tx.Exec(`DELETE FROM recovery_code WHERE user_id = ?`, userID); err != nil {
+		return nil, err
+	}
+
+	var codes []string
+	query := `INSERT INTO recovery_code (user_id, hash) VALUES (?, ?)`
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec(query, userID, hashToken(code)); err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// Returns number of unused recovery codes.
+func RemainingRecoveryCodes(db *sql.DB, userID int) (int, error) {
+	var count int
+	query := `SELECT count(*) FROM recovery_code WHERE user_id = ? AND used IS NULL`
+	if err := db.QueryRow(query, userID).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count recovery codes: %w", err)
+	}
+	return count, nil
+}
+
+// Checks 2FA code, which can be a TOTP code or an unused recovery code.
+// TOTP codes can only be used once, and so can recovery codes.
+func VerifyTOTP(db *sql.DB, userID int, code string, now time.Time) error {
+	var secret sql.NullString
+	var lastCounter int64
+	query := `SELECT totp_secret, totp_last_counter FROM user WHERE id = ?`
+	if err := db.QueryRow(query, userID).Scan(&secret, &lastCounter); err != nil {
+		return fmt.Errorf("failed to verify 2FA code: %w", err)
+	}
+	if !secret.Valid {
+		return errors.New("failed to verify 2FA code: 2FA is disabled")
+	}
+
+	if counter, ok := validateTOTP(secret.String, code, now); ok {
+		// The condition prevents replays, even with concurrent requests.
+		query := `
+			UPDATE user SET totp_last_counter = ?
+			WHERE id = ? AND totp_last_counter < ?
+		`
+		result, err := db.Exec(query, counter, userID, counter)
+		if err != nil {
+			return fmt.Errorf("failed to verify 2FA code: %w", err)
+		}
+		if n, err := result.RowsAffected(); err != nil || n == 0 {
+			return ErrInvalidCode
+		}
+		return nil
+	}
+
+	code = strings.ToUpper(strings.TrimSpace(code))
+	query = `
+		UPDATE recovery_code SET used = unixepoch('now')
+		WHERE user_id = ? AND hash = ? AND used IS NULL
+	`
+	result, err := db.Exec(query, userID, hashToken(code))
+	if err != nil {
+		return fmt.Errorf("failed to verify 2FA code: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return ErrInvalidCode
+	}
+	return nil
+}
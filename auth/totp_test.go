@@ -0,0 +1,145 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHOTP(t *testing.T) {
+	// Test vectors from RFC 4226, appendix D.
+	t.Parallel()
+
+	key := []byte("12345678901234567890")
+	expected := []string{
+		"755224", "287082", "359152", "969429", "338314",
+		"254676", "287922", "162583", "399871", "520489",
+	}
+	for counter, code := range expected {
+		if actual := hotp(key, uint64(counter)); actual != code {
+			t.Fatalf("expected HOTP(%d) to be %v: %v", counter, code, actual)
+		}
+	}
+}
+
+func TestValidateTOTPSkew(t *testing.T) {
+	t.Parallel()
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	key, _ := totpEncoding.DecodeString(secret)
+
+	now := time.Now()
+	previous := hotp(key, uint64(totpCounter(now)-1))
+	if _, ok := validateTOTP(secret, previous, now); !ok {
+		t.Fatal("expected code from previous time step to be accepted")
+	}
+
+	old := hotp(key, uint64(totpCounter(now)-5))
+	if _, ok := validateTOTP(secret, old, now); ok {
+		t.Fatal("expected old code to be rejected")
+	}
+}
+
+// Returns current TOTP code for the secret.
+func currentCode(secret string, now time.Time) string {
+	key, _ := totpEncoding.DecodeString(secret)
+	return hotp(key, uint64(totpCounter(now)))
+}
+
+func TestEnableAndVerifyTOTP(t *testing.T) {
+	t.Parallel()
+	db := openDB()
+	defer db.Close()
+
+	if err := Register(db, "foo", "bar"); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	secret, err := SetupTOTP(db, 1)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if enabled, _ := HasTOTP(db, 1); enabled {
+		t.Fatal("expected 2FA to not be enabled before confirmation")
+	}
+
+	now := time.Now()
+	if _, err := EnableTOTP(db, 1, "000000", now.Add(-time.Hour)); !errors.Is(err, ErrInvalidCode) {
+		t.Fatal("expected wrong code to be rejected:", err)
+	}
+	codes, err := EnableTOTP(db, 1, currentCode(secret, now), now)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if len(codes) != recoveryCodeCount {
+		t.Fatal("expected recovery codes:", codes)
+	}
+	if enabled, _ := HasTOTP(db, 1); !enabled {
+		t.Fatal("expected 2FA to be enabled")
+	}
+
+	// The code used for enrollment can't be reused.
+	if err := VerifyTOTP(db, 1, currentCode(secret, now), now); !errors.Is(err, ErrInvalidCode) {
+		t.Fatal("expected code to not be reusable:", err)
+	}
+
+	later := now.Add(totpPeriod)
+	if err := VerifyTOTP(db, 1, currentCode(secret, later), later); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	t.Parallel()
+	db := openDB()
+	defer db.Close()
+
+	if err := Register(db, "foo", "bar"); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	secret, err := SetupTOTP(db, 1)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	now := time.Now()
+	codes, err := EnableTOTP(db, 1, currentCode(secret, now), now)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	if err := VerifyTOTP(db, 1, strings.ToLower(codes[0]), now); err != nil {
+		t.Fatal("expected recovery code to work:", err)
+	}
+	if err := VerifyTOTP(db, 1, codes[0], now); !errors.Is(err, ErrInvalidCode) {
+		t.Fatal("expected recovery code to be single-use:", err)
+	}
+	if n, _ := RemainingRecoveryCodes(db, 1); n != recoveryCodeCount-1 {
+		t.Fatal("expected one recovery code to be used:", n)
+	}
+
+	if err := DisableTOTP(db, 1); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if n, _ := RemainingRecoveryCodes(db, 1); n != 0 {
+		t.Fatal("expected recovery codes to be deleted:", n)
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	t.Parallel()
+
+	uri := TOTPURI("ABC", "foo")
+	if !strings.HasPrefix(uri, "otpauth://totp/polycloze:foo?") {
+		t.Fatal("unexpected URI:", uri)
+	}
+	if !strings.Contains(uri, "secret=ABC") {
+		t.Fatal("expected URI to contain secret:", uri)
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type User struct {
+	ID       int
+	Username string
+	IsAdmin  bool
+	Disabled bool
+
+	// Time of last sign-in.
+	// Zero if the user hasn't signed in yet.
+	LastSignIn time.Time
+}
+
+// Lists all registered users, ordered by ID.
+// Guests aren't included.
+func ListUsers(db *sql.DB) ([]User, error) {
+	query := `
+		SELECT id, username, is_admin, disabled,
+			(SELECT max(created) FROM sign_in WHERE user_id = user.id)
+		FROM user
+		WHERE guest_since IS NULL
+		ORDER BY id
+	`
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		var user User
+		var lastSignIn sql.NullInt64
+		err := rows.Scan(&user.ID, &user.Username, &user.IsAdmin, &user.Disabled, &lastSignIn)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list users: %w", err)
+		}
+		if lastSignIn.Valid {
+			user.LastSignIn = time.Unix(lastSignIn.Int64, 0)
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+	return users, nil
+}
+
+// Returns ID of user with the given username.
+func GetUserID(db *sql.DB, username string) (int, error) {
+	var id int
+	query := `SELECT id FROM user WHERE username = ?`
+	if err := db.QueryRow(query, username).Scan(&id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, fmt.Errorf("user not found: %v", username)
+		}
+		return 0, fmt.Errorf("failed to get user ID: %w", err)
+	}
+	return id, nil
+}
+
+// Deletes user account.
+// Sessions, messages and API tokens get deleted through foreign key cascades,
+// so db should have foreign key enforcement enabled (see
+// database.OpenAuthDB).
+// Also deletes the user's sign-in attempts, which contain IP addresses.
+// Doesn't delete the user's files.
+func DeleteUser(db *sql.DB, userID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+		DELETE FROM sign_in_attempt
+		WHERE username = (SELECT username FROM user WHERE id = ?)
+	`
+	if _, err := tx.Exec(query, userID); err != nil {
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+
+	query = `DELETE FROM user WHERE id = ?`
+	result, err := tx.Exec(query, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("user not found: %v", userID)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+	return nil
+}
+
+// Returns ID of user who signs in without a password.
+// Creates the user with a random password if they don't exist yet.
+// Returns ErrDisabled if the user is disabled, and ErrReservedUsername if the
+// username belongs to a guest or a demo user.
+func getOrCreateUser(db *sql.DB, username string) (int, error) {
+	if strings.HasPrefix(username, guestPrefix) {
+		return 0, ErrReservedUsername
+	}
+
+	var id int
+	var disabled, reserved bool
+	query := `
+		SELECT id, disabled, guest_since IS NOT NULL OR demo
+		FROM user WHERE username = ?
+	`
+	err := db.QueryRow(query, username).Scan(&id, &disabled, &reserved)
+	if err == nil {
+		if reserved {
+			return 0, ErrReservedUsername
+		}
+		if disabled {
+			return id, ErrDisabled
+		}
+		return id, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("failed to authenticate user: %w", err)
+	}
+
+	hash, err := randomPasswordHash()
+	if err != nil {
+		return 0, fmt.Errorf("failed to create user: %w", err)
+	}
+
+	// Ignores conflicts in case of concurrent requests.
+	query = `INSERT INTO user (username, password) VALUES (?, ?) ON CONFLICT DO NOTHING`
+	if _, err := db.Exec(query, username, hash); err != nil {
+		return 0, fmt.Errorf("failed to create user: %w", err)
+	}
+	query = `SELECT id FROM user WHERE username = ?`
+	if err := db.QueryRow(query, username).Scan(&id); err != nil {
+		return 0, fmt.Errorf("failed to create user: %w", err)
+	}
+	return id, nil
+}
+
+// Returns username of user.
+func GetUsername(db *sql.DB, userID int) (string, error) {
+	var username string
+	query := `SELECT username FROM user WHERE id = ?`
+	if err := db.QueryRow(query, userID).Scan(&username); err != nil {
+		return "", fmt.Errorf("failed to get username: %w", err)
+	}
+	return username, nil
+}
+
+// Checks if the user is an admin.
+func IsAdmin(db *sql.DB, userID int) (bool, error) {
+	var isAdmin bool
+	query := `SELECT is_admin FROM user WHERE id = ?`
+	if err := db.QueryRow(query, userID).Scan(&isAdmin); err != nil {
+		return false, fmt.Errorf("failed to check if user is an admin: %w", err)
+	}
+	return isAdmin, nil
+}
+
+// Grants or revokes admin privileges.
+func SetAdmin(db *sql.DB, userID int, isAdmin bool) error {
+	query := `UPDATE user SET is_admin = ? WHERE id = ?`
+	if _, err := db.Exec(query, isAdmin, userID); err != nil {
+		return fmt.Errorf("failed to update admin status: %w", err)
+	}
+	return nil
+}
+
+// Disables or enables user account.
+// Disabled users can't sign in or use API tokens, but their existing sessions
+// have to be deleted separately.
+func SetDisabled(db *sql.DB, userID int, disabled bool) error {
+	query := `UPDATE user SET disabled = ? WHERE id = ?`
+	if _, err := db.Exec(query, disabled, userID); err != nil {
+		return fmt.Errorf("failed to update account status: %w", err)
+	}
+	return nil
+}
+
+// Records successful sign-in.
+func RecordSignIn(db *sql.DB, userID int) error {
+	query := `INSERT INTO sign_in (user_id) VALUES (?)`
+	if _, err := db.Exec(query, userID); err != nil {
+		return fmt.Errorf("failed to record sign-in: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestListUsers(t *testing.T) {
+	t.Parallel()
+	db := openDB()
+	defer db.Close()
+
+	for _, username := range []string{"foo", "bar"} {
+		if err := Register(db, username, "password"); err != nil {
+			t.Fatal("expected err to be nil:", err)
+		}
+	}
+
+	users, err := ListUsers(db)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if len(users) != 2 || users[0].Username != "foo" || users[1].Username != "bar" {
+		t.Fatal("unexpected users:", users)
+	}
+}
+
+func TestDeleteUser(t *testing.T) {
+	// Deleting a user should also delete their API tokens.
+	t.Parallel()
+	db := openDB()
+	defer db.Close()
+
+	if err := Register(db, "foo", "bar"); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	id, err := GetUserID(db, "foo")
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	token, err := CreateToken(db, id, "script", []string{ScopeReadStats})
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	if err := DeleteUser(db, id); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if _, err := GetUserID(db, "foo"); err == nil {
+		t.Fatal("expected user to be deleted")
+	}
+	if _, err := AuthenticateToken(db, token); err == nil {
+		t.Fatal("expected user's tokens to be deleted")
+	}
+	if err := DeleteUser(db, id); err == nil {
+		t.Fatal("expected deleting missing user to fail")
+	}
+}
+
+func TestDeleteUserSignInAttempts(t *testing.T) {
+	t.Parallel()
+	db := openDB()
+	defer db.Close()
+
+	if err := Register(db, "foo", "bar"); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	attempt := SignInAttempt{Username: "foo", IP: "127.0.0.1", Time: time.Now()}
+	if err := RecordSignInAttempt(db, attempt); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	if err := DeleteUser(db, 1); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	attempts, err := RecentSignInAttempts(db, 10)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if len(attempts) > 0 {
+		t.Fatal("expected sign-in attempts to be deleted:", attempts)
+	}
+}
+
+func TestDisabledUserCannotAuthenticate(t *testing.T) {
+	t.Parallel()
+	db := openDB()
+	defer db.Close()
+
+	if err := Register(db, "foo", "bar"); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	id, err := GetUserID(db, "foo")
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	token, err := CreateToken(db, id, "script", []string{ScopeReadStats})
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	if err := SetDisabled(db, id, true); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if _, err := Authenticate(db, "foo", "bar"); !errors.Is(err, ErrDisabled) {
+		t.Fatal("expected disabled user to be rejected:", err)
+	}
+	if _, err := AuthenticateToken(db, token); err == nil {
+		t.Fatal("expected tokens of disabled user to be rejected")
+	}
+
+	if err := SetDisabled(db, id, false); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if _, err := Authenticate(db, "foo", "bar"); err != nil {
+		t.Fatal("expected enabled user to be accepted:", err)
+	}
+}
+
+func TestLastSignIn(t *testing.T) {
+	t.Parallel()
+	db := openDB()
+	defer db.Close()
+
+	if err := Register(db, "foo", "bar"); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if err := RecordSignIn(db, 1); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	users, err := ListUsers(db)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if users[0].LastSignIn.IsZero() {
+		t.Fatal("expected last sign-in to be set:", users[0])
+	}
+}
+
+func TestSetAdmin(t *testing.T) {
+	t.Parallel()
+	db := openDB()
+	defer db.Close()
+
+	if err := Register(db, "foo", "bar"); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if isAdmin, err := IsAdmin(db, 1); err != nil || isAdmin {
+		t.Fatal("expected new user to not be an admin:", err)
+	}
+	if err := SetAdmin(db, 1, true); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if isAdmin, err := IsAdmin(db, 1); err != nil || !isAdmin {
+		t.Fatal("expected user to be an admin:", err)
+	}
+}
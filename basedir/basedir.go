@@ -45,7 +45,18 @@ func xdgStateHome() string {
 }
 
 func initStateDir() error {
-	StateDir = path.Join(xdgStateHome(), "polycloze")
+	return SetStateDir(path.Join(xdgStateHome(), "polycloze"))
+}
+
+// Overrides data directory.
+func SetDataDir(dir string) {
+	DataDir = dir
+}
+
+// Overrides state directory.
+// Creates the directory if it doesn't exist yet.
+func SetStateDir(dir string) error {
+	StateDir = dir
 	users := path.Join(StateDir, "users")
 
 	if err := os.MkdirAll(users, 0o700); err != nil {
@@ -0,0 +1,120 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+// Writes self-signed certificate and key files into dir.
+func writeCert(t *testing.T, dir, commonName string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{commonName},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	certFile := path.Join(dir, "cert.pem")
+	keyFile := path.Join(dir, "key.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := os.WriteFile(certFile, certPEM, 0o600); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0o600); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	return certFile, keyFile
+}
+
+// Returns common name of the reloader's current certificate.
+func commonName(t *testing.T, r *Reloader) string {
+	cert, err := r.GetCertificate(nil)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	return parsed.Subject.CommonName
+}
+
+func TestNewReloaderMissingFiles(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	_, err := NewReloader(path.Join(dir, "cert.pem"), path.Join(dir, "key.pem"))
+	if err == nil {
+		t.Fatal("expected missing files to be rejected")
+	}
+}
+
+func TestReload(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	certFile, keyFile := writeCert(t, dir, "old.example.com")
+
+	r, err := NewReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if name := commonName(t, r); name != "old.example.com" {
+		t.Fatal("unexpected certificate:", name)
+	}
+
+	writeCert(t, dir, "new.example.com")
+	if err := r.Reload(); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if name := commonName(t, r); name != "new.example.com" {
+		t.Fatal("expected certificate to be reloaded:", name)
+	}
+}
+
+func TestReloadInvalidKeepsCertificate(t *testing.T) {
+	// A broken renewal shouldn't take the server down.
+	t.Parallel()
+	dir := t.TempDir()
+	certFile, keyFile := writeCert(t, dir, "old.example.com")
+
+	r, err := NewReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	if err := os.WriteFile(certFile, []byte("garbage"), 0o600); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if err := r.Reload(); err == nil {
+		t.Fatal("expected invalid certificate to be rejected")
+	}
+	if name := commonName(t, r); name != "old.example.com" {
+		t.Fatal("expected old certificate to be kept:", name)
+	}
+}
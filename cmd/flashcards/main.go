@@ -46,10 +46,13 @@ func main() {
 	}
 	defer con.Close()
 
-	items := flashcards.Get(con, n, pred)
+	items, skipped := flashcards.Get(con, n, pred)
 	for _, item := range items {
 		fmt.Println(item)
 	}
+	for _, err := range skipped {
+		log.Println(err)
+	}
 
 	throughput := float64(len(items)) / time.Since(start).Seconds()
 	fmt.Printf("throughput: %v\n", throughput)
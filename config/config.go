@@ -0,0 +1,566 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+// Server configuration.
+// Settings are read from a JSON file, and can be overridden by environment
+// variables.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Wrapper around time.Duration that's encoded as a string in JSON (e.g.
+// "30m").
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("invalid duration: %w", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration: %w", err)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Registration modes.
+const (
+	RegistrationOpen   = "open"
+	RegistrationClosed = "closed"
+	RegistrationInvite = "invite" // Requires invite code from an admin
+)
+
+// Demo modes.
+const (
+	DemoShared  = "shared"  // Visitors share one demo user
+	DemoVisitor = "visitor" // Every visitor gets their own demo user
+)
+
+type ServerConfig struct {
+	// Address to listen on (e.g. ":3000" or "127.0.0.1:3000").
+	// Unix socket paths are also allowed (e.g. "unix:/run/polycloze.sock" or
+	// "/run/polycloze.sock").
+	Address   string `json:"address"`
+	AllowCORS bool   `json:"allowCORS"`
+
+	// IP addresses or CIDR ranges of reverse proxies whose X-Forwarded-For
+	// headers are trusted (e.g. "127.0.0.1" or "10.0.0.0/8").
+	// "unix" trusts connections over unix sockets.
+	TrustedProxies []string `json:"trustedProxies"`
+
+	// See net/http.Server.
+	// Zero values mean no timeout.
+	// WriteTimeout is off by default, because it also limits how long
+	// downloads can take, including the zip archive from /settings/export.
+	ReadHeaderTimeout Duration `json:"readHeaderTimeout"`
+	ReadTimeout       Duration `json:"readTimeout"`
+	WriteTimeout      Duration `json:"writeTimeout"`
+	IdleTimeout       Duration `json:"idleTimeout"`
+	MaxHeaderBytes    int      `json:"maxHeaderBytes"`
+
+	// Max time to wait for in-flight requests to finish on shutdown.
+	ShutdownTimeout Duration `json:"shutdownTimeout"`
+}
+
+type PathsConfig struct {
+	// Empty values mean the XDG defaults get used.
+	DataDir  string `json:"dataDir"`
+	StateDir string `json:"stateDir"`
+}
+
+type CookiesConfig struct {
+	// Only send cookies over HTTPS.
+	// Always on when TLS is enabled.
+	Secure bool `json:"secure"`
+}
+
+type SessionsConfig struct {
+	// Sessions older than this get deleted.
+	MaxAge Duration `json:"maxAge"`
+
+	// Sessions that haven't been used for this long get deleted.
+	IdleTimeout Duration `json:"idleTimeout"`
+
+	// Lifetime of "remember me" tokens, which sign users back in after their
+	// sessions expire.
+	// Zero disables "remember me".
+	RememberFor Duration `json:"rememberFor"`
+}
+
+type UploadsConfig struct {
+	// Max size of uploaded files in bytes.
+	MaxSize int64 `json:"maxSize"`
+}
+
+type CoursesConfig struct {
+	// How often to check the courses directory for new or updated courses.
+	// Zero disables watching; courses still get reloaded on SIGHUP.
+	WatchInterval Duration `json:"watchInterval"`
+}
+
+type RegistrationConfig struct {
+	// "open", "closed" or "invite".
+	Mode string `json:"mode"`
+
+	// Min number of characters in new passwords.
+	MinPasswordLength int `json:"minPasswordLength"`
+
+	// Let visitors study without an account.
+	// Guests can register later to keep their progress.
+	Guests bool `json:"guests"`
+}
+
+type OIDCConfig struct {
+	// Single sign-on with OpenID Connect is enabled if the issuer is set.
+	Issuer       string `json:"issuer"`
+	ClientID     string `json:"clientID"`
+	ClientSecret string `json:"clientSecret"`
+
+	// URL of /signin/oidc/callback, as registered with the provider (e.g.
+	// "https://polycloze.example.com/signin/oidc/callback").
+	RedirectURL string `json:"redirectURL"`
+
+	// Scopes to request in addition to "openid".
+	Scopes []string `json:"scopes"`
+
+	// Create accounts for unknown users on their first sign-in.
+	// Otherwise, users have to link their accounts in the settings page
+	// first.
+	Provision bool `json:"provision"`
+
+	// Name of the provider shown on the sign-in page.
+	Name string `json:"name"`
+}
+
+// Checks if single sign-on is enabled.
+func (c OIDCConfig) Enabled() bool {
+	return c.Issuer != ""
+}
+
+type ProxyAuthConfig struct {
+	// Header that an authenticating reverse proxy (e.g. oauth2-proxy or
+	// Authelia) puts the username in (e.g. "Remote-User").
+	// Enables proxy authentication if set, which replaces the sign-in form.
+	// Only requests from server.trustedProxies are authenticated this way.
+	Header string `json:"header"`
+
+	// Where users get redirected after signing out (e.g. the proxy's
+	// sign-out page).
+	SignOutURL string `json:"signOutURL"`
+}
+
+// Checks if proxy authentication is enabled.
+func (c ProxyAuthConfig) Enabled() bool {
+	return c.Header != ""
+}
+
+type MailConfig struct {
+	// SMTP server for sending password reset links.
+	// Password resets are enabled if the host is set.
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+
+	// Sender address (e.g. "polycloze <noreply@example.com>").
+	From string `json:"from"`
+
+	// Public URL of the server, for links in emails (e.g.
+	// "https://polycloze.example.com").
+	BaseURL string `json:"baseURL"`
+}
+
+// Checks if sending mail is enabled.
+func (c MailConfig) Enabled() bool {
+	return c.Host != ""
+}
+
+type DemoConfig struct {
+	// "shared" or "visitor".
+	// Demo mode is disabled if empty.
+	// Visitors get signed in without a password, and can't register or
+	// change settings.
+	Mode string `json:"mode"`
+
+	// Username of the shared demo user.
+	// The server refuses to start if the username belongs to a registered
+	// user.
+	Username string `json:"username"`
+
+	// User directory (with user.db and reviews/) that demo users start with.
+	// Demo users start with no progress if empty.
+	SnapshotDir string `json:"snapshotDir"`
+
+	// How often the shared demo user's progress gets reset to the snapshot.
+	// Zero disables scheduled resets.
+	ResetInterval Duration `json:"resetInterval"`
+}
+
+// Checks if demo mode is enabled.
+func (c DemoConfig) Enabled() bool {
+	return c.Mode != ""
+}
+
+type TLSConfig struct {
+	// TLS is enabled if both files are set.
+	// The files get reloaded when the server receives SIGHUP.
+	CertFile string `json:"certFile"`
+	KeyFile  string `json:"keyFile"`
+
+	// Value of max-age in the Strict-Transport-Security header.
+	// Zero disables HSTS.
+	HSTSMaxAge Duration `json:"hstsMaxAge"`
+}
+
+// Checks if TLS is enabled.
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != "" && c.KeyFile != ""
+}
+
+type MetricsConfig struct {
+	// Serve Prometheus metrics on /metrics.
+	Enabled bool `json:"enabled"`
+
+	// Separate address for serving metrics (e.g. "127.0.0.1:9100").
+	// If empty, metrics are served on the main server.
+	Address string `json:"address"`
+}
+
+type Config struct {
+	Server       ServerConfig       `json:"server"`
+	TLS          TLSConfig          `json:"tls"`
+	Metrics      MetricsConfig      `json:"metrics"`
+	Paths        PathsConfig        `json:"paths"`
+	Cookies      CookiesConfig      `json:"cookies"`
+	Sessions     SessionsConfig     `json:"sessions"`
+	Uploads      UploadsConfig      `json:"uploads"`
+	Courses      CoursesConfig      `json:"courses"`
+	Registration RegistrationConfig `json:"registration"`
+	OIDC         OIDCConfig         `json:"oidc"`
+	ProxyAuth    ProxyAuthConfig    `json:"proxyAuth"`
+	Mail         MailConfig         `json:"mail"`
+	Demo         DemoConfig         `json:"demo"`
+}
+
+// Returns default configuration.
+func Default() Config {
+	return Config{
+		Server: ServerConfig{
+			Address:           ":3000",
+			ReadHeaderTimeout: Duration(10 * time.Second),
+			ReadTimeout:       Duration(time.Minute),
+			IdleTimeout:       Duration(2 * time.Minute),
+			MaxHeaderBytes:    64 * 1024,
+			ShutdownTimeout:   Duration(30 * time.Second),
+		},
+		TLS: TLSConfig{
+			HSTSMaxAge: Duration(365 * 24 * time.Hour),
+		},
+		Sessions: SessionsConfig{
+			MaxAge:      Duration(4 * time.Hour),
+			IdleTimeout: Duration(30 * time.Minute),
+			RememberFor: Duration(30 * 24 * time.Hour),
+		},
+		Uploads: UploadsConfig{
+			MaxSize: 8 * 1024 * 1024,
+		},
+		Registration: RegistrationConfig{
+			Mode:              RegistrationOpen,
+			MinPasswordLength: 8,
+		},
+		OIDC: OIDCConfig{
+			Scopes: []string{"profile", "email"},
+			Name:   "single sign-on",
+		},
+		Mail: MailConfig{
+			Port: 587,
+		},
+		Demo: DemoConfig{
+			Username:      "demo",
+			ResetInterval: Duration(24 * time.Hour),
+		},
+	}
+}
+
+// Returns path to default config file.
+// The file doesn't have to exist.
+func DefaultPath() string {
+	if val := os.Getenv("POLYCLOZE_CONFIG"); val != "" {
+		return val
+	}
+
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = path.Join(home, ".config")
+	}
+	return path.Join(dir, "polycloze", "config.json")
+}
+
+// Loads configuration file, then applies environment variable overrides.
+// Missing settings get default values.
+// If the file doesn't exist, only the defaults and overrides are used.
+func Load(name string) (Config, error) {
+	c := Default()
+
+	if name != "" {
+		bytes, err := os.ReadFile(name)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return c, fmt.Errorf("failed to load config: %w", err)
+		}
+		if err == nil {
+			if err := json.Unmarshal(bytes, &c); err != nil {
+				return c, fmt.Errorf("failed to parse config (%v): %w", name, err)
+			}
+		}
+	}
+
+	if err := applyEnv(&c, os.LookupEnv); err != nil {
+		return c, fmt.Errorf("failed to load config: %w", err)
+	}
+	if err := c.Validate(); err != nil {
+		return c, fmt.Errorf("failed to load config: %w", err)
+	}
+	return c, nil
+}
+
+// Checks if config values are valid.
+func (c Config) Validate() error {
+	if c.Server.Address == "" {
+		return errors.New("empty server address")
+	}
+	if err := c.Server.validateTimeouts(); err != nil {
+		return err
+	}
+	if c.Server.MaxHeaderBytes < 0 {
+		return errors.New("max header bytes must not be negative")
+	}
+	if _, err := ParseTrustedProxies(c.Server.TrustedProxies); err != nil {
+		return err
+	}
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		return errors.New("TLS needs both a certificate and a key file")
+	}
+	if c.TLS.HSTSMaxAge < 0 {
+		return errors.New("HSTS max age must not be negative")
+	}
+	if c.Sessions.MaxAge <= 0 || c.Sessions.IdleTimeout <= 0 {
+		return errors.New("session lifetimes must be positive")
+	}
+	if c.Sessions.RememberFor < 0 {
+		return errors.New("remember me duration must not be negative")
+	}
+	if c.Uploads.MaxSize <= 0 {
+		return errors.New("max upload size must be positive")
+	}
+	if c.Courses.WatchInterval < 0 {
+		return errors.New("course watch interval must not be negative")
+	}
+	switch c.Registration.Mode {
+	case RegistrationOpen, RegistrationClosed, RegistrationInvite:
+	default:
+		return fmt.Errorf("invalid registration mode: %v", c.Registration.Mode)
+	}
+	if c.Registration.MinPasswordLength < 1 {
+		return errors.New("min password length must be positive")
+	}
+	if c.OIDC.Enabled() && (c.OIDC.ClientID == "" || c.OIDC.RedirectURL == "") {
+		return errors.New("single sign-on needs a client ID and a redirect URL")
+	}
+	if c.ProxyAuth.Enabled() && len(c.Server.TrustedProxies) == 0 {
+		return errors.New("proxy authentication needs trusted proxies")
+	}
+	if c.Mail.Enabled() && (c.Mail.From == "" || c.Mail.BaseURL == "") {
+		return errors.New("mail needs a sender address and a base URL")
+	}
+	if c.Mail.Port <= 0 || c.Mail.Port > 65535 {
+		return fmt.Errorf("invalid mail port: %v", c.Mail.Port)
+	}
+	switch c.Demo.Mode {
+	case "", DemoShared, DemoVisitor:
+	default:
+		return fmt.Errorf("invalid demo mode: %v", c.Demo.Mode)
+	}
+	if c.Demo.Mode == DemoShared && c.Demo.Username == "" {
+		return errors.New("shared demo needs a username")
+	}
+	if c.Demo.ResetInterval < 0 {
+		return errors.New("demo reset interval must not be negative")
+	}
+	if c.Demo.Enabled() && c.ProxyAuth.Enabled() {
+		return errors.New("demo mode can't be used with proxy authentication")
+	}
+	return nil
+}
+
+func (c ServerConfig) validateTimeouts() error {
+	timeouts := []Duration{
+		c.ReadHeaderTimeout,
+		c.ReadTimeout,
+		c.WriteTimeout,
+		c.IdleTimeout,
+		c.ShutdownTimeout,
+	}
+	for _, timeout := range timeouts {
+		if timeout < 0 {
+			return errors.New("server timeouts must not be negative")
+		}
+	}
+	return nil
+}
+
+// Applies environment variable overrides.
+// lookup: usually os.LookupEnv.
+func applyEnv(c *Config, lookup func(string) (string, bool)) error {
+	// For backward compatibility.
+	if val, ok := lookup("PORT"); ok && val != "" {
+		if _, err := strconv.Atoi(val); err != nil {
+			return fmt.Errorf("invalid PORT: %w", err)
+		}
+		c.Server.Address = ":" + val
+	}
+
+	for _, o := range overrides(c) {
+		val, ok := lookup(o.name)
+		if !ok || val == "" {
+			continue
+		}
+		if err := o.apply(val); err != nil {
+			return fmt.Errorf("invalid %v: %w", o.name, err)
+		}
+	}
+	return nil
+}
+
+// Environment variable override.
+type override struct {
+	name  string
+	apply func(val string) error
+}
+
+func setString(p *string) func(string) error {
+	return func(val string) error {
+		*p = val
+		return nil
+	}
+}
+
+// Parses comma-separated list.
+func setStrings(p *[]string) func(string) error {
+	return func(val string) error {
+		*p = nil
+		for _, item := range strings.Split(val, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*p = append(*p, item)
+			}
+		}
+		return nil
+	}
+}
+
+func setBool(p *bool) func(string) error {
+	return func(val string) error {
+		parsed, err := strconv.ParseBool(val)
+		if err != nil {
+			return err
+		}
+		*p = parsed
+		return nil
+	}
+}
+
+func setInt(p *int) func(string) error {
+	return func(val string) error {
+		parsed, err := strconv.Atoi(val)
+		if err != nil {
+			return err
+		}
+		*p = parsed
+		return nil
+	}
+}
+
+func setInt64(p *int64) func(string) error {
+	return func(val string) error {
+		parsed, err := strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			return err
+		}
+		*p = parsed
+		return nil
+	}
+}
+
+func setDuration(p *Duration) func(string) error {
+	return func(val string) error {
+		parsed, err := time.ParseDuration(val)
+		if err != nil {
+			return err
+		}
+		*p = Duration(parsed)
+		return nil
+	}
+}
+
+// Returns list of supported environment variable overrides.
+func overrides(c *Config) []override {
+	return []override{
+		{"POLYCLOZE_ADDRESS", setString(&c.Server.Address)},
+		{"POLYCLOZE_ALLOW_CORS", setBool(&c.Server.AllowCORS)},
+		{"POLYCLOZE_TRUSTED_PROXIES", setStrings(&c.Server.TrustedProxies)},
+		{"POLYCLOZE_READ_HEADER_TIMEOUT", setDuration(&c.Server.ReadHeaderTimeout)},
+		{"POLYCLOZE_READ_TIMEOUT", setDuration(&c.Server.ReadTimeout)},
+		{"POLYCLOZE_WRITE_TIMEOUT", setDuration(&c.Server.WriteTimeout)},
+		{"POLYCLOZE_IDLE_TIMEOUT", setDuration(&c.Server.IdleTimeout)},
+		{"POLYCLOZE_SHUTDOWN_TIMEOUT", setDuration(&c.Server.ShutdownTimeout)},
+		{"POLYCLOZE_TLS_CERT", setString(&c.TLS.CertFile)},
+		{"POLYCLOZE_TLS_KEY", setString(&c.TLS.KeyFile)},
+		{"POLYCLOZE_METRICS", setBool(&c.Metrics.Enabled)},
+		{"POLYCLOZE_METRICS_ADDRESS", setString(&c.Metrics.Address)},
+		{"POLYCLOZE_DATA_DIR", setString(&c.Paths.DataDir)},
+		{"POLYCLOZE_STATE_DIR", setString(&c.Paths.StateDir)},
+		{"POLYCLOZE_SECURE_COOKIES", setBool(&c.Cookies.Secure)},
+		{"POLYCLOZE_SESSION_MAX_AGE", setDuration(&c.Sessions.MaxAge)},
+		{"POLYCLOZE_SESSION_IDLE_TIMEOUT", setDuration(&c.Sessions.IdleTimeout)},
+		{"POLYCLOZE_SESSION_REMEMBER_FOR", setDuration(&c.Sessions.RememberFor)},
+		{"POLYCLOZE_MAX_UPLOAD_SIZE", setInt64(&c.Uploads.MaxSize)},
+		{"POLYCLOZE_COURSES_WATCH_INTERVAL", setDuration(&c.Courses.WatchInterval)},
+		{"POLYCLOZE_REGISTRATION", setString(&c.Registration.Mode)},
+		{"POLYCLOZE_GUESTS", setBool(&c.Registration.Guests)},
+		{"POLYCLOZE_DEMO", setString(&c.Demo.Mode)},
+		{"POLYCLOZE_DEMO_USERNAME", setString(&c.Demo.Username)},
+		{"POLYCLOZE_DEMO_SNAPSHOT_DIR", setString(&c.Demo.SnapshotDir)},
+		{"POLYCLOZE_DEMO_RESET_INTERVAL", setDuration(&c.Demo.ResetInterval)},
+		{"POLYCLOZE_OIDC_ISSUER", setString(&c.OIDC.Issuer)},
+		{"POLYCLOZE_OIDC_CLIENT_ID", setString(&c.OIDC.ClientID)},
+		{"POLYCLOZE_OIDC_CLIENT_SECRET", setString(&c.OIDC.ClientSecret)},
+		{"POLYCLOZE_OIDC_REDIRECT_URL", setString(&c.OIDC.RedirectURL)},
+		{"POLYCLOZE_OIDC_PROVISION", setBool(&c.OIDC.Provision)},
+		{"POLYCLOZE_PROXY_AUTH_HEADER", setString(&c.ProxyAuth.Header)},
+		{"POLYCLOZE_PROXY_AUTH_SIGN_OUT_URL", setString(&c.ProxyAuth.SignOutURL)},
+		{"POLYCLOZE_MAIL_HOST", setString(&c.Mail.Host)},
+		{"POLYCLOZE_MAIL_PORT", setInt(&c.Mail.Port)},
+		{"POLYCLOZE_MAIL_USERNAME", setString(&c.Mail.Username)},
+		{"POLYCLOZE_MAIL_PASSWORD", setString(&c.Mail.Password)},
+		{"POLYCLOZE_MAIL_FROM", setString(&c.Mail.From)},
+		{"POLYCLOZE_MAIL_BASE_URL", setString(&c.Mail.BaseURL)},
+	}
+}
@@ -0,0 +1,299 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+package config
+
+import (
+	"net/netip"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+// Creates lookup function for testing environment variable overrides.
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		val, ok := vars[name]
+		return val, ok
+	}
+}
+
+// Writes config file in a temporary directory.
+func writeConfig(t *testing.T, contents string) string {
+	name := path.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(name, []byte(contents), 0o600); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	return name
+}
+
+func TestDefaultIsValid(t *testing.T) {
+	t.Parallel()
+	if err := Default().Validate(); err != nil {
+		t.Fatal("expected default config to be valid:", err)
+	}
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	// Missing config file isn't an error.
+	t.Parallel()
+	c, err := Load(path.Join(t.TempDir(), "missing.json"))
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if c.Uploads.MaxSize != Default().Uploads.MaxSize {
+		t.Fatal("expected default values:", c)
+	}
+}
+
+func TestLoadPartialFile(t *testing.T) {
+	// Settings missing from the file should keep their default values.
+	t.Parallel()
+	name := writeConfig(t, `{
+		"server": {"address": "127.0.0.1:8080"},
+		"sessions": {"idleTimeout": "1h"}
+	}`)
+
+	c, err := Load(name)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if c.Server.Address != "127.0.0.1:8080" {
+		t.Fatal("expected address to be loaded from file:", c.Server.Address)
+	}
+	if time.Duration(c.Sessions.IdleTimeout) != time.Hour {
+		t.Fatal("expected idle timeout to be loaded from file:", c.Sessions.IdleTimeout)
+	}
+	if c.Sessions.MaxAge != Default().Sessions.MaxAge {
+		t.Fatal("expected max age to have default value:", c.Sessions.MaxAge)
+	}
+}
+
+func TestLoadInvalidFile(t *testing.T) {
+	t.Parallel()
+	name := writeConfig(t, `{"sessions": {"maxAge": "forever"}}`)
+	if _, err := Load(name); err == nil {
+		t.Fatal("expected invalid duration to be rejected")
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	t.Parallel()
+	c := Default()
+	err := applyEnv(&c, env(map[string]string{
+		"POLYCLOZE_ADDRESS":         "/run/polycloze.sock",
+		"POLYCLOZE_SECURE_COOKIES":  "true",
+		"POLYCLOZE_SESSION_MAX_AGE": "24h",
+		"POLYCLOZE_MAX_UPLOAD_SIZE": "1024",
+		"POLYCLOZE_REGISTRATION":    "closed",
+	}))
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	if c.Server.Address != "/run/polycloze.sock" {
+		t.Fatal("expected address to be overridden:", c.Server.Address)
+	}
+	if !c.Cookies.Secure {
+		t.Fatal("expected secure cookies to be enabled")
+	}
+	if time.Duration(c.Sessions.MaxAge) != 24*time.Hour {
+		t.Fatal("expected max age to be overridden:", c.Sessions.MaxAge)
+	}
+	if c.Uploads.MaxSize != 1024 {
+		t.Fatal("expected max upload size to be overridden:", c.Uploads.MaxSize)
+	}
+	if c.Registration.Mode != RegistrationClosed {
+		t.Fatal("expected registration mode to be overridden:", c.Registration.Mode)
+	}
+}
+
+func TestApplyEnvTimeouts(t *testing.T) {
+	t.Parallel()
+	c := Default()
+	err := applyEnv(&c, env(map[string]string{
+		"POLYCLOZE_READ_HEADER_TIMEOUT": "5s",
+		"POLYCLOZE_IDLE_TIMEOUT":        "0",
+	}))
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if time.Duration(c.Server.ReadHeaderTimeout) != 5*time.Second {
+		t.Fatal("expected read header timeout to be overridden:", c.Server.ReadHeaderTimeout)
+	}
+	if c.Server.IdleTimeout != 0 {
+		t.Fatal("expected idle timeout to be overridden:", c.Server.IdleTimeout)
+	}
+}
+
+func TestApplyEnvPort(t *testing.T) {
+	// PORT is still supported.
+	t.Parallel()
+	c := Default()
+	if err := applyEnv(&c, env(map[string]string{"PORT": "8080"})); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if c.Server.Address != ":8080" {
+		t.Fatal("expected address to use PORT:", c.Server.Address)
+	}
+}
+
+func TestApplyEnvInvalid(t *testing.T) {
+	t.Parallel()
+	c := Default()
+	err := applyEnv(&c, env(map[string]string{"POLYCLOZE_SECURE_COOKIES": "maybe"}))
+	if err == nil {
+		t.Fatal("expected invalid boolean to be rejected")
+	}
+}
+
+func TestValidateRegistrationMode(t *testing.T) {
+	t.Parallel()
+	c := Default()
+	c.Registration.Mode = "sometimes"
+	if err := c.Validate(); err == nil {
+		t.Fatal("expected invalid registration mode to be rejected")
+	}
+}
+
+func TestValidateMinPasswordLength(t *testing.T) {
+	t.Parallel()
+	c := Default()
+	c.Registration.MinPasswordLength = 0
+	if err := c.Validate(); err == nil {
+		t.Fatal("expected non-positive min password length to be rejected")
+	}
+}
+
+func TestValidateNegativeTimeout(t *testing.T) {
+	t.Parallel()
+	c := Default()
+	c.Server.WriteTimeout = Duration(-time.Second)
+	if err := c.Validate(); err == nil {
+		t.Fatal("expected negative timeout to be rejected")
+	}
+}
+
+func TestValidateTLS(t *testing.T) {
+	// Certificate and key files have to be set together.
+	t.Parallel()
+	c := Default()
+	c.TLS.CertFile = "cert.pem"
+	if err := c.Validate(); err == nil {
+		t.Fatal("expected missing key file to be rejected")
+	}
+
+	c.TLS.KeyFile = "key.pem"
+	if err := c.Validate(); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if !c.TLS.Enabled() {
+		t.Fatal("expected TLS to be enabled")
+	}
+}
+
+func TestValidateOIDC(t *testing.T) {
+	t.Parallel()
+	c := Default()
+	c.OIDC.Issuer = "https://idp.example.com"
+	if err := c.Validate(); err == nil {
+		t.Fatal("expected missing client ID to be rejected")
+	}
+
+	c.OIDC.ClientID = "polycloze"
+	c.OIDC.RedirectURL = "https://polycloze.example.com/signin/oidc/callback"
+	if err := c.Validate(); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+}
+
+func TestValidateProxyAuth(t *testing.T) {
+	// Trusting the header from any client would let anyone sign in as anyone.
+	t.Parallel()
+	c := Default()
+	c.ProxyAuth.Header = "Remote-User"
+	if err := c.Validate(); err == nil {
+		t.Fatal("expected proxy authentication without trusted proxies to be rejected")
+	}
+
+	c.Server.TrustedProxies = []string{"127.0.0.1"}
+	if err := c.Validate(); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+}
+
+func TestValidateMail(t *testing.T) {
+	t.Parallel()
+	c := Default()
+	c.Mail.Host = "smtp.example.com"
+	if err := c.Validate(); err == nil {
+		t.Fatal("expected missing sender address to be rejected")
+	}
+
+	c.Mail.From = "noreply@example.com"
+	c.Mail.BaseURL = "https://polycloze.example.com"
+	if err := c.Validate(); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	c.Mail.Port = 0
+	if err := c.Validate(); err == nil {
+		t.Fatal("expected invalid port to be rejected")
+	}
+}
+
+func TestValidateDemo(t *testing.T) {
+	t.Parallel()
+	c := Default()
+	c.Demo.Mode = "nope"
+	if err := c.Validate(); err == nil {
+		t.Fatal("expected invalid demo mode to be rejected")
+	}
+
+	c.Demo.Mode = DemoShared
+	if err := c.Validate(); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	c.Demo.Username = ""
+	if err := c.Validate(); err == nil {
+		t.Fatal("expected shared demo without username to be rejected")
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	t.Parallel()
+
+	proxies, err := ParseTrustedProxies([]string{"127.0.0.1", "10.1.2.3/8", "::1", "unix"})
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if !proxies.Unix {
+		t.Fatal("expected unix sockets to be trusted")
+	}
+	for _, addr := range []string{"127.0.0.1", "10.9.9.9", "::1", "::ffff:127.0.0.1"} {
+		if !proxies.Contains(netip.MustParseAddr(addr)) {
+			t.Fatal("expected address to be trusted:", addr)
+		}
+	}
+	if proxies.Contains(netip.MustParseAddr("192.0.2.1")) {
+		t.Fatal("expected address to not be trusted")
+	}
+
+	if _, err := ParseTrustedProxies([]string{"localhost"}); err == nil {
+		t.Fatal("expected invalid proxy to be rejected")
+	}
+}
+
+func TestTrustedProxiesEnv(t *testing.T) {
+	t.Parallel()
+	c := Default()
+	err := applyEnv(&c, env(map[string]string{"POLYCLOZE_TRUSTED_PROXIES": "127.0.0.1, 10.0.0.0/8"}))
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if len(c.Server.TrustedProxies) != 2 || c.Server.TrustedProxies[1] != "10.0.0.0/8" {
+		t.Fatal("expected trusted proxies to be parsed:", c.Server.TrustedProxies)
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+package config
+
+import (
+	"fmt"
+	"net/netip"
+)
+
+// Parsed list of trusted reverse proxies.
+type TrustedProxies struct {
+	Prefixes []netip.Prefix
+
+	// Trust connections over unix sockets.
+	Unix bool
+}
+
+// Parses IP addresses and CIDR ranges of trusted proxies.
+func ParseTrustedProxies(proxies []string) (TrustedProxies, error) {
+	var result TrustedProxies
+	for _, proxy := range proxies {
+		if proxy == "unix" {
+			result.Unix = true
+			continue
+		}
+		if addr, err := netip.ParseAddr(proxy); err == nil {
+			result.Prefixes = append(result.Prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			return result, fmt.Errorf("invalid trusted proxy: %v", proxy)
+		}
+		result.Prefixes = append(result.Prefixes, prefix.Masked())
+	}
+	return result, nil
+}
+
+// Checks if the address belongs to a trusted proxy.
+func (t TrustedProxies) Contains(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range t.Prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,325 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+// Checks course databases for problems that would otherwise show up at
+// runtime.
+package coursecheck
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/polycloze/polycloze/database"
+	"github.com/polycloze/polycloze/text"
+)
+
+// Schema version (`PRAGMA user_version`) of courses built by the current
+// version of `python/scripts/migrate.py`.
+const SchemaVersion = 5
+
+// Max number of problems listed per check.
+// The rest only get counted.
+const maxProblems = 20
+
+// Tables that every course DB has.
+var Tables = []string{
+	"language",
+	"word",
+	"sentence",
+	"contains",
+	"translation",
+	"translates",
+}
+
+// Indexes that every course DB has, so that queries don't have to scan whole
+// tables.
+var Indexes = []string{
+	"index_contains_word",
+	"index_translates_source",
+	"index_word_frequency_class",
+}
+
+// Result of a single check.
+type Check struct {
+	Name string `json:"name"`
+	OK   bool   `json:"ok"`
+
+	// Total number of problems found.
+	// Problems only lists the first few.
+	ProblemCount int      `json:"problemCount"`
+	Problems     []string `json:"problems"`
+}
+
+func newCheck(name string) Check {
+	return Check{Name: name, OK: true, Problems: []string{}}
+}
+
+// Records problem found by the check.
+func (c *Check) fail(format string, args ...any) {
+	c.OK = false
+	c.ProblemCount++
+	if len(c.Problems) < maxProblems {
+		c.Problems = append(c.Problems, fmt.Sprintf(format, args...))
+	}
+}
+
+// Validation report of a course DB.
+type Report struct {
+	Path          string  `json:"path"`
+	Valid         bool    `json:"valid"`
+	SchemaVersion int     `json:"schemaVersion"`
+	Checks        []Check `json:"checks"`
+
+	// Set if the file couldn't be opened.
+	Error string `json:"error,omitempty"`
+}
+
+// Runs all checks on the course DB.
+func Validate(path string) Report {
+	report := Report{Path: path, Valid: true, Checks: []Check{}}
+
+	// Don't let sqlite create a new file.
+	if _, err := os.Stat(path); err != nil {
+		report.Valid = false
+		report.Error = fmt.Sprintf("failed to open course database: %v", err)
+		return report
+	}
+	db, err := database.Open(path)
+	if err != nil {
+		report.Valid = false
+		report.Error = fmt.Sprintf("failed to open course database: %v", err)
+		return report
+	}
+	defer db.Close()
+
+	schema := checkSchema(db, &report.SchemaVersion)
+	report.add(schema)
+	if !schema.OK {
+		// The other checks would only fail with query errors.
+		return report
+	}
+
+	report.add(checkLanguages(db))
+	report.add(checkTokens(db))
+	report.add(checkTranslations(db))
+	report.add(checkIndexes(db))
+	return report
+}
+
+// Checks schema version, tables and indexes, without reading course data.
+// Returns the first problem found.
+func CheckSchema(db *sql.DB) error {
+	var version int
+	for _, c := range []Check{checkSchema(db, &version), checkIndexes(db)} {
+		if !c.OK {
+			return errors.New(c.Problems[0])
+		}
+	}
+	return nil
+}
+
+func (r *Report) add(c Check) {
+	if !c.OK {
+		r.Valid = false
+	}
+	r.Checks = append(r.Checks, c)
+}
+
+// Checks schema version and tables.
+// Sets version to the DB's schema version.
+func checkSchema(db *sql.DB, version *int) Check {
+	c := newCheck("schema")
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(version); err != nil {
+		c.fail("failed to read schema version: %v", err)
+		return c
+	}
+	if *version < SchemaVersion {
+		c.fail("schema version is %v, expected %v; run python/scripts/migrate.py", *version, SchemaVersion)
+	}
+
+	query := `SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = ?`
+	for _, table := range Tables {
+		var count int
+		if err := db.QueryRow(query, table).Scan(&count); err != nil {
+			c.fail("failed to look up table %v: %v", table, err)
+		} else if count == 0 {
+			c.fail("missing table: %v", table)
+		}
+	}
+	return c
+}
+
+// Checks that there's one row for each language.
+func checkLanguages(db *sql.DB) Check {
+	c := newCheck("languages")
+
+	query := `SELECT id, code, name, bcp47 FROM language`
+	rows, err := db.Query(query)
+	if err != nil {
+		c.fail("failed to read languages: %v", err)
+		return c
+	}
+	defer rows.Close()
+
+	found := make(map[string]bool)
+	for rows.Next() {
+		var id, code, name, bcp47 string
+		if err := rows.Scan(&id, &code, &name, &bcp47); err != nil {
+			c.fail("failed to read languages: %v", err)
+			return c
+		}
+		if found[id] {
+			c.fail("duplicate language row: %v", id)
+		}
+		found[id] = true
+
+		if len(code) != 3 {
+			c.fail("language %v has invalid code: %q", id, code)
+		}
+		if name == "" {
+			c.fail("language %v has no name", id)
+		}
+		if bcp47 == "" {
+			c.fail("language %v has no BCP 47 tag", id)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		c.fail("failed to read languages: %v", err)
+		return c
+	}
+
+	for _, id := range []string{"l1", "l2"} {
+		if !found[id] {
+			c.fail("missing language row: %v", id)
+		}
+	}
+	return c
+}
+
+// Checks that every word in `contains` can be found among the sentence's
+// tokens after casefolding, so that flashcards can blank it out.
+func checkTokens(db *sql.DB) Check {
+	c := newCheck("tokens")
+
+	query := `
+		SELECT sentence.id, sentence.tokens, word.word
+		FROM contains
+		JOIN sentence ON sentence.id = contains.sentence
+		JOIN word ON word.id = contains.word
+		ORDER BY sentence.id
+	`
+	rows, err := db.Query(query)
+	if err != nil {
+		c.fail("failed to read sentences: %v", err)
+		return c
+	}
+	defer rows.Close()
+
+	// Rows are sorted by sentence, so tokens only get parsed once per
+	// sentence.
+	var (
+		previous int64 = -1
+		parsed   bool
+		tokens   map[string]bool
+	)
+	for rows.Next() {
+		var id int64
+		var data, word string
+		if err := rows.Scan(&id, &data, &word); err != nil {
+			c.fail("failed to read sentences: %v", err)
+			return c
+		}
+
+		if id != previous {
+			previous = id
+			var list []string
+			parsed = json.Unmarshal([]byte(data), &list) == nil
+			if !parsed {
+				c.fail("sentence %v has invalid tokens: %v", id, data)
+			}
+			tokens = make(map[string]bool)
+			for _, token := range list {
+				tokens[text.Casefold(token)] = true
+			}
+		}
+		if parsed && !tokens[text.Casefold(word)] {
+			c.fail("sentence %v doesn't contain word %q after casefolding", id, word)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		c.fail("failed to read sentences: %v", err)
+	}
+
+	// Words in `contains` that don't exist would be silently dropped by the
+	// join above.
+	query = `
+		SELECT count(*) FROM contains
+		WHERE NOT EXISTS (SELECT 1 FROM sentence WHERE id = contains.sentence)
+			OR NOT EXISTS (SELECT 1 FROM word WHERE id = contains.word)
+	`
+	var dangling int
+	if err := db.QueryRow(query).Scan(&dangling); err != nil {
+		c.fail("failed to read contains: %v", err)
+	} else if dangling > 0 {
+		c.fail("%v rows in contains refer to missing sentences or words", dangling)
+	}
+	return c
+}
+
+// Checks that every sentence has a translation.
+func checkTranslations(db *sql.DB) Check {
+	c := newCheck("translations")
+
+	query := `
+		SELECT id, tatoeba_id FROM sentence
+		WHERE tatoeba_id IS NULL OR NOT EXISTS (
+			SELECT 1 FROM translates
+			JOIN translation ON translation.tatoeba_id = translates.target
+			WHERE translates.source = sentence.tatoeba_id
+		)
+		ORDER BY id
+	`
+	rows, err := db.Query(query)
+	if err != nil {
+		c.fail("failed to read sentences: %v", err)
+		return c
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var tatoebaID sql.NullInt64
+		if err := rows.Scan(&id, &tatoebaID); err != nil {
+			c.fail("failed to read sentences: %v", err)
+			return c
+		}
+		if !tatoebaID.Valid {
+			c.fail("sentence %v has no Tatoeba ID, so it can't be translated", id)
+			continue
+		}
+		c.fail("sentence %v (Tatoeba ID %v) has no translation", id, tatoebaID.Int64)
+	}
+	if err := rows.Err(); err != nil {
+		c.fail("failed to read sentences: %v", err)
+	}
+	return c
+}
+
+// Checks that indexes exist.
+func checkIndexes(db *sql.DB) Check {
+	c := newCheck("indexes")
+
+	query := `SELECT count(*) FROM sqlite_master WHERE type = 'index' AND name = ?`
+	for _, index := range Indexes {
+		var count int
+		if err := db.QueryRow(query, index).Scan(&count); err != nil {
+			c.fail("failed to look up index %v: %v", index, err)
+		} else if count == 0 {
+			c.fail("missing index: %v", index)
+		}
+	}
+	return c
+}
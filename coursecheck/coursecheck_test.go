@@ -0,0 +1,171 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+package coursecheck
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/polycloze/polycloze/database"
+)
+
+// Creates valid course DB with one sentence.
+// Caller has to Close the DB.
+func createCourse(t *testing.T) (*sql.DB, string) {
+	path := filepath.Join(t.TempDir(), "eng-spa.db")
+	db, err := database.Open(path)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	query := `
+		PRAGMA user_version = 5;
+		CREATE TABLE language (id TEXT PRIMARY KEY, code TEXT, name TEXT, bcp47 TEXT);
+		CREATE TABLE word (id INTEGER PRIMARY KEY, word TEXT, frequency_class INTEGER);
+		CREATE TABLE sentence (
+			id INTEGER PRIMARY KEY,
+			tatoeba_id INTEGER,
+			text TEXT,
+			tokens TEXT,
+			frequency_class INTEGER
+		);
+		CREATE TABLE contains (sentence INTEGER, word INTEGER);
+		CREATE TABLE translation (id INTEGER PRIMARY KEY, tatoeba_id INTEGER, text TEXT);
+		CREATE TABLE translates (source INTEGER, target INTEGER);
+		CREATE INDEX index_contains_word ON contains (word);
+		CREATE INDEX index_translates_source ON translates (source);
+		CREATE INDEX index_word_frequency_class ON word (frequency_class);
+
+		INSERT INTO language VALUES
+			('l1', 'eng', 'English', 'en'),
+			('l2', 'spa', 'Spanish', 'es');
+		INSERT INTO word VALUES (1, 'hola', 1);
+		INSERT INTO sentence VALUES (1, 100, 'Hola.', '["Hola", "."]', 1);
+		INSERT INTO contains VALUES (1, 1);
+		INSERT INTO translation VALUES (1, 200, 'Hello.');
+		INSERT INTO translates VALUES (100, 200);
+	`
+	if _, err := db.Exec(query); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	return db, path
+}
+
+// Returns check with the given name.
+func findCheck(t *testing.T, report Report, name string) Check {
+	for _, check := range report.Checks {
+		if check.Name == name {
+			return check
+		}
+	}
+	t.Fatal("expected report to have check:", name, report)
+	return Check{}
+}
+
+func TestValidCourse(t *testing.T) {
+	t.Parallel()
+	db, path := createCourse(t)
+	defer db.Close()
+
+	report := Validate(path)
+	if !report.Valid || report.SchemaVersion != SchemaVersion || len(report.Checks) != 5 {
+		t.Fatal("expected course to be valid:", report)
+	}
+}
+
+func TestMissingFile(t *testing.T) {
+	t.Parallel()
+	report := Validate(filepath.Join(t.TempDir(), "eng-spa.db"))
+	if report.Valid || report.Error == "" {
+		t.Fatal("expected missing file to be reported:", report)
+	}
+}
+
+func TestOldSchemaVersion(t *testing.T) {
+	t.Parallel()
+	db, path := createCourse(t)
+	defer db.Close()
+
+	if _, err := db.Exec(`PRAGMA user_version = 4`); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	report := Validate(path)
+	if report.Valid || findCheck(t, report, "schema").OK {
+		t.Fatal("expected old schema version to be reported:", report)
+	}
+}
+
+func TestCasefoldMismatch(t *testing.T) {
+	t.Parallel()
+	db, path := createCourse(t)
+	defer db.Close()
+
+	if _, err := db.Exec(`UPDATE word SET word = 'adiós'`); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	report := Validate(path)
+	if check := findCheck(t, report, "tokens"); report.Valid || check.ProblemCount != 1 {
+		t.Fatal("expected missing token to be reported:", report)
+	}
+}
+
+func TestMissingTranslation(t *testing.T) {
+	t.Parallel()
+	db, path := createCourse(t)
+	defer db.Close()
+
+	if _, err := db.Exec(`DELETE FROM translation`); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	report := Validate(path)
+	if check := findCheck(t, report, "translations"); report.Valid || check.ProblemCount != 1 {
+		t.Fatal("expected missing translation to be reported:", report)
+	}
+}
+
+func TestMissingIndex(t *testing.T) {
+	t.Parallel()
+	db, path := createCourse(t)
+	defer db.Close()
+
+	if _, err := db.Exec(`DROP INDEX index_translates_source`); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	report := Validate(path)
+	if report.Valid || findCheck(t, report, "indexes").OK {
+		t.Fatal("expected missing index to be reported:", report)
+	}
+}
+
+func TestMissingLanguage(t *testing.T) {
+	t.Parallel()
+	db, path := createCourse(t)
+	defer db.Close()
+
+	if _, err := db.Exec(`DELETE FROM language WHERE id = 'l2'`); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	report := Validate(path)
+	if report.Valid || findCheck(t, report, "languages").OK {
+		t.Fatal("expected missing language to be reported:", report)
+	}
+}
+
+func TestProblemsAreCapped(t *testing.T) {
+	t.Parallel()
+	db, path := createCourse(t)
+	defer db.Close()
+
+	for i := 0; i < maxProblems+5; i++ {
+		query := `INSERT INTO sentence (tatoeba_id, text, tokens, frequency_class) VALUES (NULL, ?, '[]', 1)`
+		if _, err := db.Exec(query, i); err != nil {
+			t.Fatal("expected err to be nil:", err)
+		}
+	}
+	check := findCheck(t, Validate(path), "translations")
+	if check.ProblemCount != maxProblems+5 || len(check.Problems) != maxProblems {
+		t.Fatal("expected problems to be counted, but only some listed:", check)
+	}
+}
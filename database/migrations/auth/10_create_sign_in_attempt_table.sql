@@ -0,0 +1,33 @@
+-- Copyright (c) 2022 Levi Gruspe
+-- License: MIT, or AGPLv3 or later
+
+-- +goose Up
+-- Successful and failed sign-in attempts, for throttling brute-force attacks.
+CREATE TABLE IF NOT EXISTS sign_in_attempt (
+	id INTEGER PRIMARY KEY,
+
+	-- Not a foreign key, because attempts on usernames that don't exist also
+	-- get recorded.
+	username TEXT NOT NULL,
+
+	-- IP address of client.
+	ip TEXT NOT NULL,
+
+	success INTEGER NOT NULL,
+	created INTEGER NOT NULL DEFAULT (unixepoch('now'))
+);
+
+CREATE INDEX IF NOT EXISTS index_sign_in_attempt_username
+ON sign_in_attempt (username, created);
+
+CREATE INDEX IF NOT EXISTS index_sign_in_attempt_ip
+ON sign_in_attempt (ip, created);
+
+CREATE INDEX IF NOT EXISTS index_sign_in_attempt_created
+ON sign_in_attempt (created);
+
+-- +goose Down
+DROP INDEX IF EXISTS index_sign_in_attempt_created;
+DROP INDEX IF EXISTS index_sign_in_attempt_ip;
+DROP INDEX IF EXISTS index_sign_in_attempt_username;
+DROP TABLE IF EXISTS sign_in_attempt;
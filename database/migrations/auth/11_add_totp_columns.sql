@@ -0,0 +1,38 @@
+-- Copyright (c) 2022 Levi Gruspe
+-- License: MIT, or AGPLv3 or later
+
+-- +goose Up
+-- Base32-encoded TOTP secret; null if 2FA is disabled.
+ALTER TABLE user ADD COLUMN totp_secret TEXT;
+
+-- Secret that hasn't been confirmed by the user yet.
+ALTER TABLE user ADD COLUMN totp_pending_secret TEXT;
+
+-- Time step of the last accepted code, so that codes can't be reused.
+ALTER TABLE user ADD COLUMN totp_last_counter INTEGER NOT NULL DEFAULT 0;
+
+-- Single-use codes for signing in without the authenticator app.
+CREATE TABLE IF NOT EXISTS recovery_code (
+	id INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES user ON DELETE CASCADE,
+
+	-- SHA-256 hash of the code.
+	-- Codes are random, so they don't need to be salted.
+	hash TEXT NOT NULL CHECK(hash != ''),
+
+	used INTEGER,	-- null if the code hasn't been used
+	UNIQUE(user_id, hash)
+);
+
+-- User who entered the correct password, but hasn't entered a 2FA code yet.
+-- The session isn't signed in until then.
+ALTER TABLE user_session ADD COLUMN pending_user_id INTEGER;
+ALTER TABLE user_session ADD COLUMN pending_since INTEGER;
+
+-- +goose Down
+ALTER TABLE user_session DROP COLUMN pending_since;
+ALTER TABLE user_session DROP COLUMN pending_user_id;
+DROP TABLE IF EXISTS recovery_code;
+ALTER TABLE user DROP COLUMN totp_last_counter;
+ALTER TABLE user DROP COLUMN totp_pending_secret;
+ALTER TABLE user DROP COLUMN totp_secret;
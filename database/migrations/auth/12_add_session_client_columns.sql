@@ -0,0 +1,15 @@
+-- Copyright (c) 2022 Levi Gruspe
+-- License: MIT, or AGPLv3 or later
+
+-- +goose Up
+-- Client info, so that users can tell their sessions apart.
+-- `updated` doubles as the time of last activity.
+ALTER TABLE user_session ADD COLUMN user_agent TEXT NOT NULL DEFAULT '';
+ALTER TABLE user_session ADD COLUMN ip TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS index_user_session_user_id ON user_session (user_id);
+
+-- +goose Down
+DROP INDEX IF EXISTS index_user_session_user_id;
+ALTER TABLE user_session DROP COLUMN ip;
+ALTER TABLE user_session DROP COLUMN user_agent;
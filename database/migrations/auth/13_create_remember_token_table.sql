@@ -0,0 +1,31 @@
+-- Copyright (c) 2022 Levi Gruspe
+-- License: MIT, or AGPLv3 or later
+
+-- +goose Up
+-- "Remember me" tokens, which sign users back in after their sessions expire.
+-- Tokens get rotated on every use. The series stays the same, so reuse of an
+-- old token (e.g. a stolen one) can be detected.
+CREATE TABLE IF NOT EXISTS remember_token (
+	id INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES user ON DELETE CASCADE,
+	series TEXT UNIQUE NOT NULL CHECK(series != ''),
+
+	-- SHA-256 hashes of the current and the previous token.
+	-- The previous token stays valid for a short while after rotation, so
+	-- that concurrent requests don't look like theft.
+	hash TEXT NOT NULL CHECK(hash != ''),
+	previous_hash TEXT,
+	rotated INTEGER,
+
+	-- Session that the token last signed in.
+	session_id TEXT,
+
+	created INTEGER NOT NULL DEFAULT (unixepoch('now')),
+	expires INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS index_remember_token_user_id ON remember_token (user_id);
+
+-- +goose Down
+DROP INDEX IF EXISTS index_remember_token_user_id;
+DROP TABLE IF EXISTS remember_token;
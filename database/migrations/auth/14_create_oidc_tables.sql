@@ -0,0 +1,31 @@
+-- Copyright (c) 2022 Levi Gruspe
+-- License: MIT, or AGPLv3 or later
+
+-- +goose Up
+-- Links OpenID Connect identities to local users.
+CREATE TABLE IF NOT EXISTS oidc_identity (
+	issuer TEXT NOT NULL CHECK(issuer != ''),
+	subject TEXT NOT NULL CHECK(subject != ''),
+	user_id INTEGER NOT NULL REFERENCES user ON DELETE CASCADE,
+	created INTEGER NOT NULL DEFAULT (unixepoch('now')),
+	PRIMARY KEY (issuer, subject)
+);
+
+CREATE INDEX IF NOT EXISTS index_oidc_identity_user_id ON oidc_identity (user_id);
+
+-- Sign-ins that are waiting for the provider to redirect back.
+CREATE TABLE IF NOT EXISTS oidc_login (
+	state TEXT PRIMARY KEY,
+	nonce TEXT NOT NULL,
+	verifier TEXT NOT NULL,
+
+	-- Set if a signed-in user is linking their account instead of signing in.
+	link_user_id INTEGER REFERENCES user ON DELETE CASCADE,
+
+	created INTEGER NOT NULL DEFAULT (unixepoch('now'))
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS oidc_login;
+DROP INDEX IF EXISTS index_oidc_identity_user_id;
+DROP TABLE IF EXISTS oidc_identity;
@@ -0,0 +1,27 @@
+-- Copyright (c) 2022 Levi Gruspe
+-- License: MIT, or AGPLv3 or later
+
+-- +goose Up
+-- Optional email address for resetting forgotten passwords.
+ALTER TABLE user ADD COLUMN email TEXT;
+
+CREATE UNIQUE INDEX IF NOT EXISTS index_user_email ON user (email COLLATE NOCASE);
+
+-- Single-use tokens sent in password reset links.
+CREATE TABLE IF NOT EXISTS password_reset (
+	id INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES user ON DELETE CASCADE,
+
+	-- SHA-256 hash of the token.
+	hash TEXT UNIQUE NOT NULL CHECK(hash != ''),
+
+	created INTEGER NOT NULL DEFAULT (unixepoch('now'))
+);
+
+CREATE INDEX IF NOT EXISTS index_password_reset_user_id ON password_reset (user_id);
+
+-- +goose Down
+DROP INDEX IF EXISTS index_password_reset_user_id;
+DROP TABLE IF EXISTS password_reset;
+DROP INDEX IF EXISTS index_user_email;
+ALTER TABLE user DROP COLUMN email;
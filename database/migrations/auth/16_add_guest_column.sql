@@ -0,0 +1,10 @@
+-- Copyright (c) 2022 Levi Gruspe
+-- License: MIT, or AGPLv3 or later
+
+-- +goose Up
+-- Time when the guest account was created; null for registered users.
+-- Guests get deleted after their sessions expire.
+ALTER TABLE user ADD COLUMN guest_since INTEGER;
+
+-- +goose Down
+ALTER TABLE user DROP COLUMN guest_since;
@@ -0,0 +1,11 @@
+-- Copyright (c) 2022 Levi Gruspe
+-- License: MIT, or AGPLv3 or later
+
+-- +goose Up
+-- Demo users get handed out to anonymous visitors, and their progress gets
+-- reset to a snapshot.
+-- Existing users are never used as demo users.
+ALTER TABLE user ADD COLUMN demo INTEGER NOT NULL DEFAULT 0;
+
+-- +goose Down
+ALTER TABLE user DROP COLUMN demo;
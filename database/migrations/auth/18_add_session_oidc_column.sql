@@ -0,0 +1,11 @@
+-- Copyright (c) 2022 Levi Gruspe
+-- License: MIT, or AGPLv3 or later
+
+-- +goose Up
+-- Time when the user signed in with single sign-on; null for other sign-ins.
+-- Users provisioned by single sign-on don't know their password, so a recent
+-- sign-in lets them confirm actions like deleting their account.
+ALTER TABLE user_session ADD COLUMN oidc_signed_in INTEGER;
+
+-- +goose Down
+ALTER TABLE user_session DROP COLUMN oidc_signed_in;
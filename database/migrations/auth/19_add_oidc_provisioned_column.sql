@@ -0,0 +1,11 @@
+-- Copyright (c) 2022 Levi Gruspe
+-- License: MIT, or AGPLv3 or later
+
+-- +goose Up
+-- Set if the user was created by single sign-on, instead of linking an
+-- existing account.
+-- Provisioned users don't know their password.
+ALTER TABLE oidc_identity ADD COLUMN provisioned INTEGER NOT NULL DEFAULT 0;
+
+-- +goose Down
+ALTER TABLE oidc_identity DROP COLUMN provisioned;
@@ -0,0 +1,26 @@
+-- Copyright (c) 2022 Levi Gruspe
+-- License: MIT, or AGPLv3 or later
+
+-- +goose Up
+-- Personal access tokens for headless clients.
+CREATE TABLE IF NOT EXISTS api_token (
+	id INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES user ON DELETE CASCADE,
+	name TEXT NOT NULL CHECK(name != ''),
+
+	-- SHA-256 hash of the token.
+	-- Tokens are random, so they don't need to be salted.
+	hash TEXT UNIQUE NOT NULL CHECK(hash != ''),
+
+	-- Space-separated list of scopes.
+	scopes TEXT NOT NULL DEFAULT '',
+
+	created INTEGER NOT NULL DEFAULT (unixepoch('now')),
+	last_used INTEGER	-- null if the token hasn't been used
+);
+
+CREATE INDEX IF NOT EXISTS index_api_token_user_id ON api_token (user_id);
+
+-- +goose Down
+DROP INDEX IF EXISTS index_api_token_user_id;
+DROP TABLE IF EXISTS api_token;
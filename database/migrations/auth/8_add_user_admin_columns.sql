@@ -0,0 +1,23 @@
+-- Copyright (c) 2022 Levi Gruspe
+-- License: MIT, or AGPLv3 or later
+
+-- +goose Up
+ALTER TABLE user ADD COLUMN is_admin INTEGER NOT NULL DEFAULT 0;
+
+-- Disabled users can't sign in.
+ALTER TABLE user ADD COLUMN disabled INTEGER NOT NULL DEFAULT 0;
+
+-- Successful sign-ins, for the admin dashboard.
+CREATE TABLE IF NOT EXISTS sign_in (
+	id INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES user ON DELETE CASCADE,
+	created INTEGER NOT NULL DEFAULT (unixepoch('now'))
+);
+
+CREATE INDEX IF NOT EXISTS index_sign_in_created ON sign_in (created);
+
+-- +goose Down
+DROP INDEX IF EXISTS index_sign_in_created;
+DROP TABLE IF EXISTS sign_in;
+ALTER TABLE user DROP COLUMN disabled;
+ALTER TABLE user DROP COLUMN is_admin;
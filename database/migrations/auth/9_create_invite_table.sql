@@ -0,0 +1,20 @@
+-- Copyright (c) 2022 Levi Gruspe
+-- License: MIT, or AGPLv3 or later
+
+-- +goose Up
+-- Invite codes for invite-only registration.
+CREATE TABLE IF NOT EXISTS invite (
+	id INTEGER PRIMARY KEY,
+	code TEXT UNIQUE NOT NULL CHECK(code != ''),
+	max_uses INTEGER NOT NULL DEFAULT 1 CHECK(max_uses > 0),
+	uses INTEGER NOT NULL DEFAULT 0 CHECK(uses <= max_uses),
+
+	-- null if the invite was created from the command line, or if the admin
+	-- was deleted.
+	created_by INTEGER REFERENCES user ON DELETE SET NULL,
+
+	created INTEGER NOT NULL DEFAULT (unixepoch('now'))
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS invite;
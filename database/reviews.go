@@ -7,12 +7,16 @@ package database
 import (
 	"database/sql"
 	"fmt"
+	"time"
 
 	"github.com/pressly/goose/v3"
+
+	"github.com/polycloze/polycloze/metrics"
 )
 
 // Upgrades review DB to the latest version.
 func UpgradeReviewDB(db *sql.DB) error {
+	defer metrics.ReviewDBMigrationDuration.ObserveSince(time.Now())
 	if err := goose.Up(db, "migrations/reviews"); err != nil {
 		return fmt.Errorf("failed to upgrade review database: %w", err)
 	}
@@ -22,6 +26,8 @@ func UpgradeReviewDB(db *sql.DB) error {
 // Opens review database.
 // The caller has to Close the db.
 func OpenReviewDB(path string) (*sql.DB, error) {
+	defer metrics.ReviewDBOpenDuration.ObserveSince(time.Now())
+
 	db, err := Open(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open review database: %w", err)
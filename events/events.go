@@ -0,0 +1,135 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+// Publishes review events to other clients of the same user.
+package events
+
+import (
+	"sync"
+)
+
+// Max number of undelivered events per subscriber.
+// Events get dropped when a subscriber falls behind.
+const bufferSize = 64
+
+// Events are published per user and per course.
+type Topic struct {
+	UserID int
+	L1     string // ISO 639-3
+	L2     string // ISO 639-3
+}
+
+type Event struct {
+	Kind string // e.g. "review", "reset"
+	Data any    // Gets encoded into JSON
+
+	// Session that caused the event.
+	// The event doesn't get sent back to subscribers in the same session.
+	Source string
+}
+
+type Subscriber struct {
+	C       <-chan Event
+	c       chan Event
+	topic   Topic
+	session string
+}
+
+// Shouldn't be used as a constructor.
+// Use `NewBroker` instead.
+type Broker struct {
+	mu          sync.Mutex
+	subscribers map[Topic]map[*Subscriber]bool
+	closed      bool
+}
+
+func NewBroker() *Broker {
+	return &Broker{
+		subscribers: make(map[Topic]map[*Subscriber]bool),
+	}
+}
+
+// Subscribes to events on the topic.
+// The caller has to call `Unsubscribe` when done.
+func (b *Broker) Subscribe(topic Topic, session string) *Subscriber {
+	c := make(chan Event, bufferSize)
+	sub := &Subscriber{
+		C:       c,
+		c:       c,
+		topic:   topic,
+		session: session,
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		close(c)
+		return sub
+	}
+
+	subs, ok := b.subscribers[topic]
+	if !ok {
+		subs = make(map[*Subscriber]bool)
+		b.subscribers[topic] = subs
+	}
+	subs[sub] = true
+	return sub
+}
+
+// Removes subscriber and closes its channel.
+// Does nothing if the subscriber has already been removed.
+func (b *Broker) Unsubscribe(sub *Subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	subs, ok := b.subscribers[sub.topic]
+	if !ok || !subs[sub] {
+		return
+	}
+	delete(subs, sub)
+	if len(subs) == 0 {
+		delete(b.subscribers, sub.topic)
+	}
+	close(sub.c)
+}
+
+// Sends event to all subscribers of the topic, except those in the event's
+// source session.
+// Never blocks; subscribers that aren't keeping up miss the event.
+func (b *Broker) Publish(topic Topic, event Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for sub := range b.subscribers[topic] {
+		if event.Source != "" && sub.session == event.Source {
+			continue
+		}
+		select {
+		case sub.c <- event:
+		default:
+		}
+	}
+}
+
+// Removes all subscribers and closes their channels.
+// Subscribers added after the broker is closed get a closed channel.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, subs := range b.subscribers {
+		for sub := range subs {
+			close(sub.c)
+		}
+	}
+	b.subscribers = make(map[Topic]map[*Subscriber]bool)
+	b.closed = true
+}
+
+// Returns number of subscribers to the topic.
+func (b *Broker) Count(topic Topic) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subscribers[topic])
+}
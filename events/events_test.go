@@ -0,0 +1,125 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+package events
+
+import (
+	"testing"
+)
+
+func TestPublishToSubscriber(t *testing.T) {
+	t.Parallel()
+	b := NewBroker()
+	topic := Topic{UserID: 1, L1: "eng", L2: "spa"}
+
+	sub := b.Subscribe(topic, "laptop")
+	defer b.Unsubscribe(sub)
+
+	b.Publish(topic, Event{Kind: "review", Data: "foo", Source: "phone"})
+
+	select {
+	case event := <-sub.C:
+		if event.Kind != "review" || event.Data != "foo" {
+			t.Fatal("expected to receive published event:", event)
+		}
+	default:
+		t.Fatal("expected subscriber to receive event")
+	}
+}
+
+func TestPublishSkipsSourceSession(t *testing.T) {
+	// Clients shouldn't receive events they caused themselves.
+	t.Parallel()
+	b := NewBroker()
+	topic := Topic{UserID: 1, L1: "eng", L2: "spa"}
+
+	sub := b.Subscribe(topic, "phone")
+	defer b.Unsubscribe(sub)
+
+	b.Publish(topic, Event{Kind: "review", Source: "phone"})
+
+	select {
+	case event := <-sub.C:
+		t.Fatal("expected event to not be sent back to its source:", event)
+	default:
+	}
+}
+
+func TestPublishOtherTopic(t *testing.T) {
+	// Events in other courses or of other users shouldn't be received.
+	t.Parallel()
+	b := NewBroker()
+
+	sub := b.Subscribe(Topic{UserID: 1, L1: "eng", L2: "spa"}, "laptop")
+	defer b.Unsubscribe(sub)
+
+	b.Publish(Topic{UserID: 1, L1: "eng", L2: "deu"}, Event{Kind: "review"})
+	b.Publish(Topic{UserID: 2, L1: "eng", L2: "spa"}, Event{Kind: "review"})
+
+	select {
+	case event := <-sub.C:
+		t.Fatal("expected subscriber to not receive events from other topics:", event)
+	default:
+	}
+}
+
+func TestPublishDoesNotBlock(t *testing.T) {
+	// Slow subscribers shouldn't block publishers.
+	t.Parallel()
+	b := NewBroker()
+	topic := Topic{UserID: 1, L1: "eng", L2: "spa"}
+
+	sub := b.Subscribe(topic, "laptop")
+	defer b.Unsubscribe(sub)
+
+	for i := 0; i < 2*bufferSize; i++ {
+		b.Publish(topic, Event{Kind: "review"})
+	}
+	if len(sub.C) != bufferSize {
+		t.Fatal("expected extra events to be dropped:", len(sub.C))
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	t.Parallel()
+	b := NewBroker()
+	topic := Topic{UserID: 1, L1: "eng", L2: "spa"}
+
+	sub := b.Subscribe(topic, "laptop")
+	if b.Count(topic) != 1 {
+		t.Fatal("expected one subscriber:", b.Count(topic))
+	}
+
+	b.Unsubscribe(sub)
+	if b.Count(topic) != 0 {
+		t.Fatal("expected no subscribers:", b.Count(topic))
+	}
+	if _, ok := <-sub.C; ok {
+		t.Fatal("expected subscriber channel to be closed")
+	}
+
+	// Unsubscribing twice shouldn't panic.
+	b.Unsubscribe(sub)
+}
+
+func TestClose(t *testing.T) {
+	// Closing the broker should end all subscriptions, including new ones.
+	t.Parallel()
+	b := NewBroker()
+	topic := Topic{UserID: 1, L1: "eng", L2: "spa"}
+
+	sub := b.Subscribe(topic, "laptop")
+	b.Close()
+	if _, ok := <-sub.C; ok {
+		t.Fatal("expected subscriber channel to be closed")
+	}
+	b.Unsubscribe(sub)
+
+	late := b.Subscribe(topic, "phone")
+	if _, ok := <-late.C; ok {
+		t.Fatal("expected late subscriber channel to be closed")
+	}
+	if count := b.Count(topic); count != 0 {
+		t.Fatal("expected no subscribers after closing:", count)
+	}
+}
@@ -5,9 +5,12 @@ package flashcards
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/polycloze/polycloze/database"
+	"github.com/polycloze/polycloze/metrics"
 	"github.com/polycloze/polycloze/sentences"
 	"github.com/polycloze/polycloze/translator"
 	"github.com/polycloze/polycloze/word_scheduler"
@@ -38,52 +41,100 @@ func NewItemGenerator(db *sql.DB, courseDB string) ItemGenerator {
 	}
 }
 
-func generateItem[T database.Querier](q T, word word_scheduler.Word) (Item, error) {
+// Max number of example sentences to try before giving up on a word.
+const maxSentenceAttempts = 3
+
+// Creates cloze item from a random example sentence of the word.
+// exclude: IDs of sentences to skip
+func generateItemFrom[T database.Querier](q T, word word_scheduler.Word, exclude []int) (Item, error) {
 	var item Item
 
-	sentence, err := sentences.PickSentence(q, word.Word)
+	sentence, err := sentences.PickSentence(q, word.Word, exclude...)
 	if err != nil {
 		return item, err
 	}
 
 	translation, err := translator.Translate(q, sentence.TatoebaID)
+	if errors.Is(err, translator.ErrNoTranslation) {
+		return item, &sentences.BadSentenceError{SentenceID: sentence.ID, Err: err}
+	}
 	if err != nil {
-		// Panic because this shouldn't happen with generated course files.
-		panic(fmt.Errorf("could not translate sentence (%v): %w", sentence, err))
+		return item, err
+	}
+
+	parts, err := getParts(sentence.Tokens, word)
+	if err != nil {
+		return item, &sentences.BadSentenceError{
+			SentenceID: sentence.ID,
+			Word:       word.Word,
+			Err:        err,
+		}
 	}
 	return Item{
 		Translation: translation,
 		Sentence: Sentence{
 			ID:        sentence.ID,
-			Parts:     getParts(sentence.Tokens, word),
+			Parts:     parts,
 			TatoebaID: sentence.TatoebaID,
 		},
 	}, nil
 }
 
+// Creates cloze item for the word.
+// Skips bad sentences, and returns them along with the item.
+func generateItem[T database.Querier](
+	q T,
+	word word_scheduler.Word,
+) (Item, []*sentences.BadSentenceError, error) {
+	var skipped []*sentences.BadSentenceError
+	var exclude []int
+	for i := 0; i < maxSentenceAttempts; i++ {
+		item, err := generateItemFrom(q, word, exclude)
+
+		var bad *sentences.BadSentenceError
+		if errors.As(err, &bad) {
+			skipped = append(skipped, bad)
+			exclude = append(exclude, bad.SentenceID)
+			continue
+		}
+		return item, skipped, err
+	}
+	return Item{}, skipped, fmt.Errorf("no usable example sentence for word: %v", word.Word)
+}
+
 // Creates a cloze item for each word.
-func generateItems(con *database.Connection, words []word_scheduler.Word) []Item {
+// Words without usable example sentences get skipped.
+func generateItems(
+	con *database.Connection,
+	words []word_scheduler.Word,
+) ([]Item, []*sentences.BadSentenceError) {
 	// To make sure JSON encoding is not nil:
 	items := make([]Item, 0)
+	var skipped []*sentences.BadSentenceError
 	for _, word := range words {
-		if item, err := generateItem(con, word); err == nil {
+		item, bad, err := generateItem(con, word)
+		skipped = append(skipped, bad...)
+		if err == nil {
 			items = append(items, item)
 		}
 	}
-	return items
+	return items, skipped
 }
 
-// Returns list of flashcards to show.
+// Returns list of flashcards to show, and sentences that were skipped because
+// of bad course data.
 // n: max number of flashcards to return.
 // Database connection should have access to course and review data.
 func Get(
 	con *database.Connection,
 	n int,
 	pred func(word string) bool,
-) []Item {
+) ([]Item, []*sentences.BadSentenceError) {
+	defer metrics.FlashcardsDuration.ObserveSince(time.Now())
+
 	words, err := word_scheduler.GetWordsWith(con, n, pred)
 	if err != nil {
-		return nil
+		return nil, nil
 	}
 	return generateItems(con, words)
 }
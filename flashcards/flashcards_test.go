@@ -5,10 +5,15 @@ package flashcards
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"testing"
 
 	"github.com/polycloze/polycloze/basedir"
 	"github.com/polycloze/polycloze/database"
+	"github.com/polycloze/polycloze/translator"
+	"github.com/polycloze/polycloze/utils"
+	"github.com/polycloze/polycloze/word_scheduler"
 )
 
 func pred(_ string) bool {
@@ -32,3 +37,80 @@ func BenchmarkGetFlashcards(b *testing.B) {
 		Get(con, 10, pred)
 	}
 }
+
+// Adds example sentence of the word "hola", with a translation if
+// translationID is positive.
+func insertSentence(db *sql.DB, id int, tokens string, translationID int) {
+	query := `
+		INSERT INTO sentence (id, tatoeba_id, text, tokens, frequency_class)
+		VALUES (?, ?, ?, ?, 1);
+		INSERT OR IGNORE INTO word (id, word, frequency_class) VALUES (1, 'hola', 1);
+		INSERT INTO contains (sentence, word) VALUES (?, 1);
+	`
+	if _, err := db.Exec(query, id, id, tokens, tokens, id); err != nil {
+		panic(err)
+	}
+	if translationID <= 0 {
+		return
+	}
+
+	query = `
+		INSERT INTO translation (tatoeba_id, text) VALUES (?, ?);
+		INSERT INTO translates (source, target) VALUES (?, ?);
+	`
+	if _, err := db.Exec(query, translationID, tokens, id, translationID); err != nil {
+		panic(err)
+	}
+}
+
+func TestGenerateItemSkipsBadSentences(t *testing.T) {
+	t.Parallel()
+	db := utils.TestingDatabase()
+	defer db.Close()
+
+	// Doesn't contain "hola" after casefolding.
+	insertSentence(db, 1, `["Adiós", "."]`, 101)
+	// No translation.
+	insertSentence(db, 2, `["Hola", "!"]`, 0)
+	insertSentence(db, 3, `["Hola", "."]`, 103)
+
+	word := word_scheduler.Word{Word: "hola"}
+	for i := 0; i < 10; i++ {
+		item, skipped, err := generateItem(db, word)
+		if err != nil {
+			t.Fatal("expected err to be nil:", err)
+		}
+		if item.Sentence.ID != 3 {
+			t.Fatal("expected only good sentence to be used:", item)
+		}
+		for _, bad := range skipped {
+			if bad.SentenceID == 3 {
+				t.Fatal("expected good sentence not to be skipped:", bad)
+			}
+		}
+	}
+}
+
+func TestGenerateItemWithoutGoodSentences(t *testing.T) {
+	t.Parallel()
+	db := utils.TestingDatabase()
+	defer db.Close()
+
+	insertSentence(db, 1, `["Hola", "."]`, 0)
+
+	_, skipped, err := generateItem(db, word_scheduler.Word{Word: "hola"})
+	if err == nil {
+		t.Fatal("expected word without usable sentences to be skipped")
+	}
+	if len(skipped) != 1 || !errors.Is(skipped[0], translator.ErrNoTranslation) {
+		t.Fatal("expected sentence without translation to be reported:", skipped)
+	}
+}
+
+func TestGetPartsCasefoldMismatch(t *testing.T) {
+	t.Parallel()
+	_, err := getParts([]string{"Adiós", "."}, word_scheduler.Word{Word: "hola"})
+	if !errors.Is(err, ErrWordNotInSentence) {
+		t.Fatal("expected ErrWordNotInSentence:", err)
+	}
+}
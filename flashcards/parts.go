@@ -4,6 +4,7 @@
 package flashcards
 
 import (
+	"errors"
 	"fmt"
 	"math/rand"
 	"strings"
@@ -33,8 +34,12 @@ type Part struct {
 	Answers []Answer `json:"answers,omitempty"`
 }
 
+// Returned by getParts if none of the tokens match the word.
+// Happens when Python and Go casefold the word differently.
+var ErrWordNotInSentence = errors.New("word not found in sentence tokens")
+
 // Returns parts of cloze item.
-func getParts(tokens []string, word word_scheduler.Word) []Part {
+func getParts(tokens []string, word word_scheduler.Word) ([]Part, error) {
 	// TODO word: string -> Word
 	normalized := text.Casefold(word.Word)
 
@@ -47,12 +52,7 @@ func getParts(tokens []string, word word_scheduler.Word) []Part {
 	}
 
 	if len(indices) == 0 {
-		message := fmt.Sprintf(
-			"Python casefold different from golang casefold: %s, %v",
-			normalized,
-			tokens,
-		)
-		panic(message)
+		return nil, fmt.Errorf("%w: %s, %v", ErrWordNotInSentence, normalized, tokens)
 	}
 
 	// Pick a random one if there are multiple matches.
@@ -77,5 +77,5 @@ func getParts(tokens []string, word word_scheduler.Word) []Part {
 			},
 		},
 	}
-	return []Part{before, missing, after}
+	return []Part{before, missing, after}, nil
 }
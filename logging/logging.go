@@ -0,0 +1,204 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+// Structured (JSON) logging with per-request fields.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// Name of header that contains the request ID.
+const RequestIDHeader = "X-Request-ID"
+
+// Incoming request IDs (e.g. from a reverse proxy) are only reused if they
+// match this pattern.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+var (
+	mu     sync.Mutex
+	output io.Writer = os.Stderr
+)
+
+// Changes where log entries get written.
+func SetOutput(w io.Writer) {
+	mu.Lock()
+	defer mu.Unlock()
+	output = w
+}
+
+// Writes log entry as a line of JSON.
+func write(level, msg string, fields map[string]any) {
+	entry := make(map[string]any, len(fields)+3)
+	for key, value := range fields {
+		entry[key] = value
+	}
+	entry["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	entry["level"] = level
+	entry["msg"] = msg
+
+	bytes, err := json.Marshal(entry)
+	if err != nil {
+		bytes, _ = json.Marshal(map[string]any{
+			"time":  entry["time"],
+			"level": "error",
+			"msg":   fmt.Sprintf("failed to encode log entry: %v", err),
+		})
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	_, _ = output.Write(append(bytes, '\n'))
+}
+
+// Writer for the standard library logger.
+// Turns each line into a JSON log entry.
+type stdWriter struct{}
+
+func (stdWriter) Write(p []byte) (int, error) {
+	write("info", strings.TrimSuffix(string(p), "\n"), nil)
+	return len(p), nil
+}
+
+// Returns writer that can be used with log.SetOutput.
+// The logger's flags should be set to 0, because entries already have a
+// timestamp.
+func StdWriter() io.Writer {
+	return stdWriter{}
+}
+
+// Log fields of a request.
+// Handlers add fields as they find out more about the request (e.g. user ID).
+type requestFields struct {
+	id     string
+	mu     sync.Mutex
+	values map[string]any
+}
+
+func (f *requestFields) set(key string, value any) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.values[key] = value
+}
+
+// Returns a copy of the fields.
+func (f *requestFields) copy() map[string]any {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	values := make(map[string]any, len(f.values))
+	for key, value := range f.values {
+		values[key] = value
+	}
+	return values
+}
+
+type contextKey int
+
+const keyFields contextKey = iota
+
+func getFields(ctx context.Context) *requestFields {
+	fields, _ := ctx.Value(keyFields).(*requestFields)
+	return fields
+}
+
+// Adds field to the request's log entries.
+// Does nothing if the request didn't go through the middleware.
+func Set(ctx context.Context, key string, value any) {
+	if fields := getFields(ctx); fields != nil {
+		fields.set(key, value)
+	}
+}
+
+// Returns ID of the request, or an empty string if there's none.
+func RequestID(ctx context.Context) string {
+	if fields := getFields(ctx); fields != nil {
+		return fields.id
+	}
+	return ""
+}
+
+// Adds course to the fields if the route has l1 and l2 params.
+// URL params are only available after routing.
+func addCourse(ctx context.Context, fields map[string]any) {
+	if rctx := chi.RouteContext(ctx); rctx != nil {
+		l1 := rctx.URLParam("l1")
+		l2 := rctx.URLParam("l2")
+		if l1 != "" && l2 != "" {
+			fields["course"] = l1 + "-" + l2
+		}
+	}
+}
+
+// Logs error along with the request's fields.
+func Error(r *http.Request, err error) {
+	fields := make(map[string]any)
+	if f := getFields(r.Context()); f != nil {
+		fields = f.copy()
+	}
+	addCourse(r.Context(), fields)
+	write("error", err.Error(), fields)
+}
+
+// Logs message without request fields.
+func Info(msg string, fields map[string]any) {
+	write("info", msg, fields)
+}
+
+func generateRequestID() string {
+	bytes := make([]byte, 8)
+	if _, err := rand.Read(bytes); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(bytes)
+}
+
+// Assigns request ID and logs requests when they're done.
+// The request ID is sent back in the X-Request-ID header.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = generateRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		fields := &requestFields{
+			id:     id,
+			values: map[string]any{"requestID": id},
+		}
+		ctx := context.WithValue(r.Context(), keyFields, fields)
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(ctx))
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		entry := fields.copy()
+		addCourse(ctx, entry)
+		entry["method"] = r.Method
+		entry["path"] = r.URL.Path
+		entry["status"] = status
+		entry["bytes"] = ww.BytesWritten()
+		entry["duration"] = time.Since(start).Seconds()
+		entry["remoteAddr"] = r.RemoteAddr
+		write("info", "request", entry)
+	})
+}
@@ -0,0 +1,118 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Decodes log entries written by f.
+// Not parallel-safe, because it changes the package's output.
+func captureEntries(t *testing.T, f func()) []map[string]any {
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	defer SetOutput(io.Discard)
+	f()
+
+	var entries []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var entry map[string]any
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatal("expected log entry to be JSON:", line)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestRequestFields(t *testing.T) {
+	// Error and request log entries should include the request's fields.
+	var requestID string
+	r := chi.NewRouter()
+	r.Use(Middleware)
+	r.HandleFunc("/{l1}/{l2}", func(w http.ResponseWriter, r *http.Request) {
+		requestID = RequestID(r.Context())
+		Set(r.Context(), "userID", 1)
+		Error(r, errors.New("oops"))
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	var w *httptest.ResponseRecorder
+	entries := captureEntries(t, func() {
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/eng/spa", nil))
+	})
+
+	if requestID == "" || w.Header().Get(RequestIDHeader) != requestID {
+		t.Fatal("expected request ID to be sent back:", w.Header())
+	}
+	if len(entries) != 2 {
+		t.Fatal("expected error and request log entries:", entries)
+	}
+
+	errorEntry, requestEntry := entries[0], entries[1]
+	if errorEntry["level"] != "error" || errorEntry["msg"] != "oops" {
+		t.Fatal("unexpected error entry:", errorEntry)
+	}
+	for _, entry := range entries {
+		if entry["requestID"] != requestID || entry["userID"] != 1.0 || entry["course"] != "eng-spa" {
+			t.Fatal("expected entry to include request fields:", entry)
+		}
+	}
+	if requestEntry["status"] != float64(http.StatusTeapot) || requestEntry["path"] != "/eng/spa" {
+		t.Fatal("unexpected request entry:", requestEntry)
+	}
+}
+
+func TestStdWriter(t *testing.T) {
+	entries := captureEntries(t, func() {
+		if _, err := StdWriter().Write([]byte("hello\n")); err != nil {
+			t.Fatal("expected err to be nil:", err)
+		}
+	})
+	if len(entries) != 1 || entries[0]["msg"] != "hello" || entries[0]["level"] != "info" {
+		t.Fatal("unexpected log entries:", entries)
+	}
+}
+
+func TestIncomingRequestID(t *testing.T) {
+	// Valid request IDs from reverse proxies should be reused.
+	t.Parallel()
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(RequestIDHeader, "abc-123")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if id := w.Header().Get(RequestIDHeader); id != "abc-123" {
+		t.Fatal("expected incoming request ID to be reused:", id)
+	}
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(RequestIDHeader, "bad\"id")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if id := w.Header().Get(RequestIDHeader); id == "" || id == "bad\"id" {
+		t.Fatal("expected invalid request ID to be replaced:", id)
+	}
+}
+
+func TestSetWithoutMiddleware(t *testing.T) {
+	// Should be a no-op.
+	t.Parallel()
+	req := httptest.NewRequest("GET", "/", nil)
+	Set(req.Context(), "userID", 1)
+	if id := RequestID(req.Context()); id != "" {
+		t.Fatal("expected no request ID:", id)
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+package mail
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/polycloze/polycloze/mail/mailtest"
+)
+
+func TestSend(t *testing.T) {
+	t.Parallel()
+
+	server := mailtest.NewServer()
+	defer server.Close()
+
+	client := NewClient(Config{
+		Host:     server.Host,
+		Port:     server.Port,
+		Username: "user",
+		Password: "secret",
+		From:     "polycloze <noreply@example.com>",
+	})
+	body := "Hello,\n\nThis line is longer than seventy-six characters, so it gets wrapped by the encoder.\n"
+	if err := client.Send("alice@example.com", "Hello, Ålice", body); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	messages := server.Messages()
+	if len(messages) != 1 {
+		t.Fatal("expected one message:", messages)
+	}
+	message := messages[0]
+	if message.From != "noreply@example.com" {
+		t.Fatal("unexpected sender:", message.From)
+	}
+	if len(message.To) != 1 || message.To[0] != "alice@example.com" {
+		t.Fatal("unexpected recipients:", message.To)
+	}
+	if !strings.Contains(message.Subject(), "=?utf-8?q?") {
+		t.Fatal("expected subject to be encoded:", message.Subject())
+	}
+	if message.Body() != body {
+		t.Fatal("expected body to be sent unchanged:", message.Body())
+	}
+	if username, password := server.Credentials(); username != "user" || password != "secret" {
+		t.Fatal("expected client to authenticate:", username, password)
+	}
+}
+
+func TestSendRejectsHeaderInjection(t *testing.T) {
+	t.Parallel()
+
+	client := NewClient(Config{Host: "127.0.0.1", Port: 1, From: "noreply@example.com"})
+	if err := client.Send("alice@example.com", "Hi\r\nBcc: eve@example.com", ""); err == nil {
+		t.Fatal("expected subject with newline to be rejected")
+	}
+	if err := client.Send("alice@example.com\r\nBcc: eve@example.com", "Hi", ""); err == nil {
+		t.Fatal("expected invalid recipient to be rejected")
+	}
+}
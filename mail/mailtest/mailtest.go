@@ -0,0 +1,195 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+// In-process SMTP server for testing.
+// Accepts every message without delivering it.
+package mailtest
+
+import (
+	"encoding/base64"
+	"io"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"sync"
+)
+
+type Message struct {
+	From string
+	To   []string
+
+	// Raw message, including headers.
+	Data string
+}
+
+// Returns the message's subject.
+func (m Message) Subject() string {
+	msg, err := mail.ReadMessage(strings.NewReader(m.Data))
+	if err != nil {
+		return ""
+	}
+	return msg.Header.Get("Subject")
+}
+
+// Returns the message's decoded body.
+func (m Message) Body() string {
+	msg, err := mail.ReadMessage(strings.NewReader(m.Data))
+	if err != nil {
+		return ""
+	}
+	var r io.Reader = msg.Body
+	if msg.Header.Get("Content-Transfer-Encoding") == "quoted-printable" {
+		r = quotedprintable.NewReader(r)
+	}
+	body, _ := io.ReadAll(r)
+	return strings.ReplaceAll(string(body), "\r\n", "\n")
+}
+
+type Server struct {
+	Host string
+	Port int
+
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mu       sync.Mutex
+	messages []Message
+
+	// Credentials of the last client that authenticated.
+	username string
+	password string
+}
+
+// Starts server on a random local port.
+func NewServer() *Server {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	addr := listener.Addr().(*net.TCPAddr)
+	s := &Server{
+		Host:     addr.IP.String(),
+		Port:     addr.Port,
+		listener: listener,
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s
+}
+
+// Stops server.
+func (s *Server) Close() {
+	s.listener.Close()
+	s.wg.Wait()
+}
+
+// Returns received messages.
+func (s *Server) Messages() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Message(nil), s.messages...)
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			s.handle(textproto.NewConn(conn))
+		}()
+	}
+}
+
+// Returns address in "MAIL FROM:<address>" or "RCPT TO:<address>".
+func parsePath(arg string) string {
+	start := strings.Index(arg, "<")
+	end := strings.LastIndex(arg, ">")
+	if start < 0 || end < start {
+		return ""
+	}
+	return arg[start+1 : end]
+}
+
+func (s *Server) handle(c *textproto.Conn) {
+	_ = c.PrintfLine("220 mailtest ESMTP")
+
+	var current Message
+	for {
+		line, err := c.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "HELO":
+			_ = c.PrintfLine("250 mailtest")
+		case "EHLO":
+			_ = c.PrintfLine("250-mailtest")
+			_ = c.PrintfLine("250-AUTH PLAIN")
+			_ = c.PrintfLine("250 8BITMIME")
+		case "AUTH":
+			s.authenticate(arg)
+			_ = c.PrintfLine("235 2.7.0 Authentication successful")
+		case "MAIL":
+			current = Message{From: parsePath(arg)}
+			_ = c.PrintfLine("250 2.1.0 OK")
+		case "RCPT":
+			current.To = append(current.To, parsePath(arg))
+			_ = c.PrintfLine("250 2.1.5 OK")
+		case "DATA":
+			_ = c.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			data, err := io.ReadAll(c.DotReader())
+			if err != nil {
+				return
+			}
+			current.Data = string(data)
+			s.mu.Lock()
+			s.messages = append(s.messages, current)
+			s.mu.Unlock()
+			current = Message{}
+			_ = c.PrintfLine("250 2.0.0 OK")
+		case "RSET":
+			current = Message{}
+			_ = c.PrintfLine("250 2.0.0 OK")
+		case "NOOP":
+			_ = c.PrintfLine("250 2.0.0 OK")
+		case "QUIT":
+			_ = c.PrintfLine("221 2.0.0 Bye")
+			return
+		default:
+			_ = c.PrintfLine("502 5.5.2 Command not recognized")
+		}
+	}
+}
+
+// Records credentials in "AUTH PLAIN <initial response>".
+func (s *Server) authenticate(arg string) {
+	_, response, _ := strings.Cut(arg, " ")
+	decoded, err := base64.StdEncoding.DecodeString(response)
+	if err != nil {
+		return
+	}
+	parts := strings.Split(string(decoded), "\x00")
+	if len(parts) != 3 {
+		return
+	}
+	s.mu.Lock()
+	s.username = parts[1]
+	s.password = parts[2]
+	s.mu.Unlock()
+}
+
+// Returns username and password of the last client that authenticated.
+func (s *Server) Credentials() (string, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.username, s.password
+}
@@ -4,60 +4,238 @@
 package main
 
 import (
+	"database/sql"
 	"flag"
 	"fmt"
 	"log"
-	"net/http"
 	"os"
-	"strconv"
+	"strings"
+	"time"
 
 	"github.com/polycloze/polycloze/api"
 	"github.com/polycloze/polycloze/basedir"
+	"github.com/polycloze/polycloze/certs"
+	"github.com/polycloze/polycloze/config"
 	"github.com/polycloze/polycloze/database"
+	"github.com/polycloze/polycloze/logging"
+	"github.com/polycloze/polycloze/mail"
+	"github.com/polycloze/polycloze/oidc"
+	"github.com/polycloze/polycloze/sessions"
 )
 
 type Args struct {
-	cors bool
-	port int
+	cors   bool
+	port   int
+	config string
 }
 
-func defaultPortNumber() int {
-	port := os.Getenv("PORT")
-	if port != "" {
-		v, err := strconv.Atoi(port)
-		if err == nil {
-			return v
+func parseArgs() (Args, map[string]bool) {
+	var args Args
+
+	flag.BoolVar(&args.cors, "c", false, "allow CORS")
+	flag.IntVar(&args.port, "p", 3000, "port number (overrides config)")
+	flag.StringVar(&args.config, "config", config.DefaultPath(), "path to config file")
+	flag.Parse()
+
+	// Command-line flags override the config file, but only if they're set.
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	return args, set
+}
+
+// Loads config and applies command-line overrides.
+func loadConfig(args Args, set map[string]bool) config.Config {
+	c, err := config.Load(args.config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if set["c"] {
+		c.Server.AllowCORS = args.cors
+	}
+	if set["p"] {
+		c.Server.Address = fmt.Sprintf(":%v", args.port)
+	}
+	return c
+}
+
+// Applies config to packages that are configured globally.
+func applyConfig(c config.Config) {
+	if c.Paths.DataDir != "" {
+		basedir.SetDataDir(c.Paths.DataDir)
+	}
+	if c.Paths.StateDir != "" {
+		if err := basedir.SetStateDir(c.Paths.StateDir); err != nil {
+			log.Fatal(err)
 		}
 	}
-	return 3000
+
+	sessions.SecureCookies = c.Cookies.Secure || c.TLS.Enabled()
+	sessions.MaxAge = time.Duration(c.Sessions.MaxAge)
+	sessions.IdleTimeout = time.Duration(c.Sessions.IdleTimeout)
+	sessions.RememberFor = time.Duration(c.Sessions.RememberFor)
 }
 
-func parseArgs() Args {
-	var args Args
+// Deletes abandoned guest accounts every hour.
+func collectGuests(db *sql.DB) {
+	for range time.Tick(time.Hour) {
+		n, err := api.CollectGuests(db)
+		if err != nil {
+			log.Println(err)
+		}
+		if n > 0 {
+			log.Printf("Deleted %v abandoned guests\n", n)
+		}
+	}
+}
 
-	flag.BoolVar(&args.cors, "c", false, "allow CORS")
-	flag.IntVar(&args.port, "p", defaultPortNumber(), "port number")
-	flag.Parse()
-	return args
+// Resets the shared demo user's progress every interval.
+func resetDemo(db *sql.DB, c api.Config, interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := api.ResetDemo(db, c); err != nil {
+			log.Println(err)
+		}
+	}
 }
 
 func main() {
-	api.Startup()
+	if len(os.Args) > 1 && os.Args[1] == "admin" {
+		runAdmin(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "validate-course" {
+		runValidateCourse(os.Args[2:])
+		return
+	}
+
+	log.SetFlags(0)
+	log.SetOutput(logging.StdWriter())
+
+	c := loadConfig(parseArgs())
+	applyConfig(c)
+	if err := run(c); err != nil {
+		log.Fatal(err)
+	}
+	log.Println("Server stopped")
+}
 
-	args := parseArgs()
-	config := api.Config{AllowCORS: args.cors, Port: args.port}
+// Runs the server until it gets shut down.
+// Returns errors instead of exiting, so that deferred cleanup still runs.
+func run(c config.Config) error {
+	if err := api.Startup(); err != nil {
+		return err
+	}
 
 	db, err := database.OpenAuthDB(basedir.Auth())
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	defer db.Close()
+	// Review DBs are closed by the handlers that open them, so the auth DB is
+	// the only one left once in-flight requests are done.
+	defer func() {
+		if err := db.Close(); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	// Already validated when the config was loaded.
+	proxies, _ := config.ParseTrustedProxies(c.Server.TrustedProxies)
 
-	r, err := api.Router(config, db)
+	apiConfig := api.Config{
+		AllowCORS:         c.Server.AllowCORS,
+		MaxUploadSize:     c.Uploads.MaxSize,
+		Registration:      c.Registration.Mode,
+		MinPasswordLength: c.Registration.MinPasswordLength,
+		Guests:            c.Registration.Guests,
+		WriteTimeout:      time.Duration(c.Server.WriteTimeout),
+		TrustedProxies:    proxies,
+		ServeMetrics:      c.Metrics.Enabled && c.Metrics.Address == "",
+	}
+	if c.OIDC.Enabled() {
+		apiConfig.OIDC = oidc.NewClient(oidc.Config{
+			Issuer:       c.OIDC.Issuer,
+			ClientID:     c.OIDC.ClientID,
+			ClientSecret: c.OIDC.ClientSecret,
+			RedirectURL:  c.OIDC.RedirectURL,
+			Scopes:       c.OIDC.Scopes,
+		})
+		apiConfig.OIDCProvision = c.OIDC.Provision
+		apiConfig.OIDCName = c.OIDC.Name
+	}
+	if c.ProxyAuth.Enabled() {
+		apiConfig.ProxyAuthHeader = c.ProxyAuth.Header
+		apiConfig.ProxyAuthSignOutURL = c.ProxyAuth.SignOutURL
+	}
+	if c.Mail.Enabled() {
+		apiConfig.Mail = mail.NewClient(mail.Config{
+			Host:     c.Mail.Host,
+			Port:     c.Mail.Port,
+			Username: c.Mail.Username,
+			Password: c.Mail.Password,
+			From:     c.Mail.From,
+		})
+		apiConfig.MailBaseURL = c.Mail.BaseURL
+	}
+	if c.Demo.Enabled() {
+		apiConfig.Demo = c.Demo.Mode
+		apiConfig.DemoUsername = c.Demo.Username
+		apiConfig.DemoSnapshotDir = c.Demo.SnapshotDir
+	}
+	if c.TLS.Enabled() {
+		apiConfig.HSTSMaxAge = time.Duration(c.TLS.HSTSMaxAge)
+	}
+	r, err := api.Router(apiConfig, db)
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	// Demo visitors in visitor mode are guests.
+	if apiConfig.Guests || apiConfig.Demo == config.DemoVisitor {
+		go collectGuests(db)
+	}
+	if c.Demo.Mode == config.DemoShared {
+		// The demo user starts from the snapshot after every restart.
+		if err := api.ResetDemo(db, apiConfig); err != nil {
+			return err
+		}
+		if interval := time.Duration(c.Demo.ResetInterval); interval > 0 {
+			go resetDemo(db, apiConfig, interval)
+		}
+	}
+
+	if interval := time.Duration(c.Courses.WatchInterval); interval > 0 {
+		go api.WatchCourses(interval)
 	}
-	log.Printf("Listening on port %v\n", args.port)
-	log.Printf("Start learning: http://127.0.0.1:%v\n", args.port)
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%v", args.port), r))
+
+	if c.Metrics.Enabled && c.Metrics.Address != "" {
+		metricsServer, err := serveMetrics(c.Metrics.Address, api.MetricsHandler(db))
+		if err != nil {
+			return err
+		}
+		defer metricsServer.Close()
+	}
+
+	var reloader *certs.Reloader
+	scheme := "http"
+	if c.TLS.Enabled() {
+		reloader, err = certs.NewReloader(c.TLS.CertFile, c.TLS.KeyFile)
+		if err != nil {
+			return err
+		}
+		scheme = "https"
+	}
+
+	l, err := listen(c.Server.Address)
+	if err != nil {
+		return err
+	}
+	log.Printf("Listening on %v\n", l.Addr())
+	if host := c.Server.Address; strings.HasPrefix(host, ":") {
+		log.Printf("Start learning: %v://127.0.0.1%v\n", scheme, host)
+	} else if socketPath(host) == "" {
+		log.Printf("Start learning: %v://%v\n", scheme, host)
+	}
+
+	srv := newServer(c.Server, r)
+	return serve(srv, l, reloader, time.Duration(c.Server.ShutdownTimeout))
 }
@@ -0,0 +1,313 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+// Minimal metrics collection in the Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default histogram buckets in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type collector interface {
+	// Writes metric in text exposition format.
+	write(w io.Writer) error
+}
+
+// Shouldn't be used as a constructor.
+// Use `NewRegistry` instead.
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, c)
+}
+
+// Writes all metrics in the registry.
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	collectors := append([]collector(nil), r.collectors...)
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, c := range collectors {
+		if err := c.write(bw); err != nil {
+			return fmt.Errorf("failed to write metrics: %w", err)
+		}
+	}
+	return bw.Flush()
+}
+
+// Serves metrics in the registries.
+func Handler(registries ...*Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		for _, r := range registries {
+			if err := r.Write(w); err != nil {
+				log.Println(err)
+				return
+			}
+		}
+	})
+}
+
+// Metric name, description and label names.
+type desc struct {
+	name   string
+	help   string
+	labels []string
+}
+
+func (d desc) writeHeader(w io.Writer, kind string) error {
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, d.help, d.name, kind)
+	return err
+}
+
+// Joins label values into a map key.
+func (d desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("%v: expected %d label values, got %d", d.name, len(d.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+// Formats labels for a series, e.g. `{method="GET",route="/"}`.
+// extra gets appended to the labels (e.g. `le="0.5"`).
+func (d desc) formatLabels(key string, extra string) string {
+	var pairs []string
+	if len(d.labels) > 0 {
+		for i, value := range strings.Split(key, "\xff") {
+			pairs = append(pairs, fmt.Sprintf(`%s="%s"`, d.labels[i], escaper.Replace(value)))
+		}
+	}
+	if extra != "" {
+		pairs = append(pairs, extra)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Escapes label values.
+var escaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Returns sorted keys of the map.
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Shouldn't be used as a constructor.
+// Use `NewCounter` instead.
+type Counter struct {
+	desc
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// Creates counter and adds it to the registry.
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{
+		desc:   desc{name: name, help: help, labels: labels},
+		values: make(map[string]float64),
+	}
+	r.register(c)
+	return c
+}
+
+// Adds 1 to the counter.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Adds a non-negative value to the counter.
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		panic("counters can't decrease")
+	}
+	key := c.key(labelValues)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[key] += v
+}
+
+func (c *Counter) write(w io.Writer) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.writeHeader(w, "counter"); err != nil {
+		return err
+	}
+	if len(c.labels) == 0 && len(c.values) == 0 {
+		_, err := fmt.Fprintf(w, "%s 0\n", c.name)
+		return err
+	}
+	for _, key := range sortedKeys(c.values) {
+		labels := c.formatLabels(key, "")
+		value := formatFloat(c.values[key])
+		if _, err := fmt.Fprintf(w, "%s%s %s\n", c.name, labels, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Shouldn't be used as a constructor.
+// Use `NewGaugeFunc` instead.
+type GaugeFunc struct {
+	desc
+	f func() (float64, error)
+}
+
+// Creates gauge whose value is computed by f whenever metrics are collected,
+// and adds it to the registry.
+// The gauge is left out if f returns an error.
+func (r *Registry) NewGaugeFunc(name, help string, f func() (float64, error)) *GaugeFunc {
+	g := &GaugeFunc{
+		desc: desc{name: name, help: help},
+		f:    f,
+	}
+	r.register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w io.Writer) error {
+	value, err := g.f()
+	if err != nil {
+		log.Printf("failed to collect %v: %v\n", g.name, err)
+		return nil
+	}
+	if err := g.writeHeader(w, "gauge"); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s %s\n", g.name, formatFloat(value))
+	return err
+}
+
+type histogramSeries struct {
+	counts []uint64 // Per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+// Shouldn't be used as a constructor.
+// Use `NewHistogram` instead.
+type Histogram struct {
+	desc
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+// Creates histogram and adds it to the registry.
+// buckets: upper bounds in increasing order.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{
+		desc:    desc{name: name, help: help, labels: labels},
+		buckets: buckets,
+		series:  make(map[string]*histogramSeries),
+	}
+	r.register(h)
+	return h
+}
+
+// Records an observation.
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	key := h.key(labelValues)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	for i, bound := range h.buckets {
+		if v <= bound {
+			s.counts[i]++
+			break
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+// Records time elapsed since start in seconds.
+func (h *Histogram) ObserveSince(start time.Time, labelValues ...string) {
+	h.Observe(time.Since(start).Seconds(), labelValues...)
+}
+
+func (h *Histogram) write(w io.Writer) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := h.writeHeader(w, "histogram"); err != nil {
+		return err
+	}
+
+	series := h.series
+	if len(h.labels) == 0 && len(series) == 0 {
+		series = map[string]*histogramSeries{
+			"": {counts: make([]uint64, len(h.buckets))},
+		}
+	}
+	for _, key := range sortedKeys(series) {
+		s := series[key]
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i]
+			le := fmt.Sprintf("le=%q", formatFloat(bound))
+			labels := h.formatLabels(key, le)
+			if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labels, cumulative); err != nil {
+				return err
+			}
+		}
+
+		labels := h.formatLabels(key, `le="+Inf"`)
+		if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labels, s.count); err != nil {
+			return err
+		}
+		labels = h.formatLabels(key, "")
+		if _, err := fmt.Fprintf(w, "%s_sum%s %s\n", h.name, labels, formatFloat(s.sum)); err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "%s_count%s %d\n", h.name, labels, s.count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
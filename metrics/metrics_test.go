@@ -0,0 +1,88 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+package metrics
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// Returns metrics in registry as text.
+func collect(t *testing.T, r *Registry) string {
+	var sb strings.Builder
+	if err := r.Write(&sb); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	return sb.String()
+}
+
+// Checks if every line is in the output.
+func expectLines(t *testing.T, output string, lines ...string) {
+	t.Helper()
+	for _, line := range lines {
+		if !strings.Contains(output, line+"\n") {
+			t.Fatalf("expected line %q in output:\n%v", line, output)
+		}
+	}
+}
+
+func TestCounter(t *testing.T) {
+	t.Parallel()
+	r := NewRegistry()
+	c := r.NewCounter("test_total", "Test counter.", "kind")
+	c.Inc("a")
+	c.Add(2, "a")
+	c.Inc(`b"`)
+
+	expectLines(t, collect(t, r),
+		"# HELP test_total Test counter.",
+		"# TYPE test_total counter",
+		`test_total{kind="a"} 3`,
+		`test_total{kind="b\""} 1`,
+	)
+}
+
+func TestCounterWithoutLabels(t *testing.T) {
+	// Counters without labels should be reported even if they're zero.
+	t.Parallel()
+	r := NewRegistry()
+	r.NewCounter("test_total", "Test counter.")
+	expectLines(t, collect(t, r), "test_total 0")
+}
+
+func TestHistogram(t *testing.T) {
+	t.Parallel()
+	r := NewRegistry()
+	h := r.NewHistogram("test_seconds", "Test histogram.", []float64{0.1, 1}, "route")
+	h.Observe(0.05, "/")
+	h.Observe(0.5, "/")
+	h.Observe(5, "/")
+
+	expectLines(t, collect(t, r),
+		"# TYPE test_seconds histogram",
+		`test_seconds_bucket{route="/",le="0.1"} 1`,
+		`test_seconds_bucket{route="/",le="1"} 2`,
+		`test_seconds_bucket{route="/",le="+Inf"} 3`,
+		`test_seconds_sum{route="/"} 5.55`,
+		`test_seconds_count{route="/"} 3`,
+	)
+}
+
+func TestGaugeFunc(t *testing.T) {
+	t.Parallel()
+	r := NewRegistry()
+	r.NewGaugeFunc("test_gauge", "Test gauge.", func() (float64, error) {
+		return 42, nil
+	})
+	r.NewGaugeFunc("broken_gauge", "Broken gauge.", func() (float64, error) {
+		return 0, errors.New("broken")
+	})
+
+	output := collect(t, r)
+	expectLines(t, output, "# TYPE test_gauge gauge", "test_gauge 42")
+	if strings.Contains(output, "broken_gauge") {
+		t.Fatal("expected gauge with error to be left out:", output)
+	}
+}
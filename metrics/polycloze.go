@@ -0,0 +1,50 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+package metrics
+
+// Registry for polycloze metrics.
+var Default = NewRegistry()
+
+var (
+	RequestDuration = Default.NewHistogram(
+		"polycloze_http_request_duration_seconds",
+		"Time spent handling HTTP requests.",
+		DefaultBuckets,
+		"method", "route", "status",
+	)
+
+	FlashcardsDuration = Default.NewHistogram(
+		"polycloze_flashcards_generation_duration_seconds",
+		"Time spent generating flashcards.",
+		DefaultBuckets,
+	)
+
+	ReviewDBOpenDuration = Default.NewHistogram(
+		"polycloze_review_db_open_duration_seconds",
+		"Time spent opening review databases, including migrations.",
+		DefaultBuckets,
+	)
+
+	ReviewDBMigrationDuration = Default.NewHistogram(
+		"polycloze_review_db_migration_duration_seconds",
+		"Time spent migrating review databases.",
+		DefaultBuckets,
+	)
+
+	ReviewsProcessed = Default.NewCounter(
+		"polycloze_reviews_processed_total",
+		"Number of reviews saved.",
+	)
+
+	BadSentences = Default.NewCounter(
+		"polycloze_bad_sentences_total",
+		"Number of sentences skipped because of bad course data.",
+		"course",
+	)
+
+	PanicsRecovered = Default.NewCounter(
+		"polycloze_panics_recovered_total",
+		"Number of panics recovered while handling requests.",
+	)
+)
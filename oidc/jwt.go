@@ -0,0 +1,309 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+// ID token verification.
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Allowed clock difference between the server and the provider.
+const clockSkew = time.Minute
+
+// Min time between fetches of the provider's signing keys.
+// Unknown key IDs trigger a fetch, because providers rotate keys.
+const keyRefreshInterval = time.Minute
+
+// Verified claims of an ID token.
+type IDToken struct {
+	Issuer  string
+	Subject string
+	Expiry  time.Time
+
+	// Optional claims.
+	PreferredUsername string
+	Name              string
+	Email             string
+	EmailVerified     bool
+}
+
+type claims struct {
+	Issuer            string   `json:"iss"`
+	Subject           string   `json:"sub"`
+	Audience          audience `json:"aud"`
+	AuthorizedParty   string   `json:"azp"`
+	Expiry            int64    `json:"exp"`
+	IssuedAt          int64    `json:"iat"`
+	Nonce             string   `json:"nonce"`
+	PreferredUsername string   `json:"preferred_username"`
+	Name              string   `json:"name"`
+	Email             string   `json:"email"`
+	EmailVerified     bool     `json:"email_verified"`
+}
+
+// The "aud" claim, which can be a string or an array of strings.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*a = audience{s}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+func (a audience) contains(clientID string) bool {
+	for _, aud := range a {
+		if aud == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+// JSON web key.
+// Only RSA and P-256 keys are supported.
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+
+	// RSA keys.
+	N string `json:"n"`
+	E string `json:"e"`
+
+	// EC keys.
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	bytes, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(bytes), nil
+}
+
+// Returns *rsa.PublicKey or *ecdsa.PublicKey.
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve: %v", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		curve := elliptic.P256()
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("invalid EC point")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported key type: %v", k.Kty)
+	}
+}
+
+// Cache of the provider's signing keys.
+type keySet struct {
+	client *Client
+	uri    string
+
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+// Gets key with the given ID.
+// Fetches the provider's keys again if the key is unknown.
+func (s *keySet) get(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if key, ok := s.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(s.fetched) < keyRefreshInterval {
+		return nil, fmt.Errorf("unknown signing key: %v", kid)
+	}
+
+	var body struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := s.client.getJSON(ctx, s.uri, &body); err != nil {
+		return nil, fmt.Errorf("failed to fetch signing keys: %w", err)
+	}
+	s.fetched = time.Now()
+	s.keys = make(map[string]crypto.PublicKey)
+	for _, k := range body.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if key, err := k.publicKey(); err == nil {
+			s.keys[k.Kid] = key
+		}
+	}
+
+	if key, ok := s.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key: %v", kid)
+}
+
+// Checks JWT signature.
+func verifySignature(alg string, key crypto.PublicKey, signed, signature []byte) error {
+	hash := sha256.Sum256(signed)
+	switch alg {
+	case "RS256":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("key doesn't match algorithm")
+		}
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, hash[:], signature)
+
+	case "ES256":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return errors.New("key doesn't match algorithm")
+		}
+		if len(signature) != 64 {
+			return errors.New("invalid signature length")
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(pub, hash[:], r, s) {
+			return errors.New("invalid signature")
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unsupported algorithm: %v", alg)
+	}
+}
+
+// Verifies ID token signature and claims.
+func (c *Client) verify(ctx context.Context, rawToken, nonce string, now time.Time) (*IDToken, error) {
+	parts := strings.Split(rawToken, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("invalid ID token: malformed JWT")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	bytes, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token: %w", err)
+	}
+	if err := json.Unmarshal(bytes, &header); err != nil {
+		return nil, fmt.Errorf("invalid ID token: %w", err)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token: %w", err)
+	}
+	if _, err := c.discover(ctx); err != nil {
+		return nil, err
+	}
+	key, err := c.keys.get(ctx, header.Kid)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token: %w", err)
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	if err := verifySignature(header.Alg, key, signed, signature); err != nil {
+		return nil, fmt.Errorf("invalid ID token: %w", err)
+	}
+
+	var cl claims
+	bytes, err = base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token: %w", err)
+	}
+	if err := json.Unmarshal(bytes, &cl); err != nil {
+		return nil, fmt.Errorf("invalid ID token: %w", err)
+	}
+	if err := c.checkClaims(cl, nonce, now); err != nil {
+		return nil, fmt.Errorf("invalid ID token: %w", err)
+	}
+
+	token := IDToken{
+		Issuer:            cl.Issuer,
+		Subject:           cl.Subject,
+		Expiry:            time.Unix(cl.Expiry, 0),
+		PreferredUsername: cl.PreferredUsername,
+		Name:              cl.Name,
+		Email:             cl.Email,
+		EmailVerified:     cl.EmailVerified,
+	}
+	return &token, nil
+}
+
+func (c *Client) checkClaims(cl claims, nonce string, now time.Time) error {
+	if strings.TrimSuffix(cl.Issuer, "/") != strings.TrimSuffix(c.config.Issuer, "/") {
+		return fmt.Errorf("unexpected issuer: %v", cl.Issuer)
+	}
+	if cl.Subject == "" {
+		return errors.New("missing subject")
+	}
+	if !cl.Audience.contains(c.config.ClientID) {
+		return errors.New("token wasn't issued for this client")
+	}
+	if len(cl.Audience) > 1 && cl.AuthorizedParty != c.config.ClientID {
+		return errors.New("token wasn't issued for this client")
+	}
+	if now.After(time.Unix(cl.Expiry, 0).Add(clockSkew)) {
+		return errors.New("token expired")
+	}
+	if time.Unix(cl.IssuedAt, 0).After(now.Add(clockSkew)) {
+		return errors.New("token was issued in the future")
+	}
+	if subtle.ConstantTimeCompare([]byte(cl.Nonce), []byte(nonce)) != 1 {
+		return errors.New("nonce mismatch")
+	}
+	return nil
+}
@@ -0,0 +1,212 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+// OpenID Connect client for single sign-on.
+// Only supports the authorization code flow with PKCE.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Config struct {
+	// Issuer URL of the identity provider.
+	// The provider's metadata gets discovered from
+	// <issuer>/.well-known/openid-configuration.
+	Issuer string
+
+	ClientID string
+
+	// Empty for public clients.
+	ClientSecret string
+
+	// URL of the callback handler, as registered with the provider.
+	RedirectURL string
+
+	// Scopes to request in addition to "openid".
+	Scopes []string
+}
+
+// Provider metadata.
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Shouldn't be used as a constructor.
+// Use `NewClient` instead.
+type Client struct {
+	config     Config
+	httpClient *http.Client
+
+	mu       sync.Mutex
+	metadata *metadata
+	keys     *keySet
+}
+
+// Creates client for the provider.
+// Doesn't contact the provider until the client gets used, so that the server
+// can start even if the provider is down.
+func NewClient(c Config) *Client {
+	return &Client{
+		config:     c,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Returns the provider's issuer URL.
+func (c *Client) Issuer() string {
+	return c.config.Issuer
+}
+
+// Gets the provider's metadata.
+// Caches the result on success.
+func (c *Client) discover(ctx context.Context) (*metadata, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.metadata != nil {
+		return c.metadata, nil
+	}
+
+	issuer := strings.TrimSuffix(c.config.Issuer, "/")
+	var m metadata
+	if err := c.getJSON(ctx, issuer+"/.well-known/openid-configuration", &m); err != nil {
+		return nil, fmt.Errorf("failed to discover provider metadata: %w", err)
+	}
+	if strings.TrimSuffix(m.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("failed to discover provider metadata: issuer mismatch: %v", m.Issuer)
+	}
+	if m.AuthorizationEndpoint == "" || m.TokenEndpoint == "" || m.JWKSURI == "" {
+		return nil, errors.New("failed to discover provider metadata: missing endpoints")
+	}
+
+	c.metadata = &m
+	c.keys = &keySet{client: c, uri: m.JWKSURI}
+	return c.metadata, nil
+}
+
+func (c *Client) getJSON(ctx context.Context, uri string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", uri, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status: %v", resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// Generates random string for state, nonce and PKCE code verifiers.
+func RandomString() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
+
+// Computes S256 PKCE code challenge.
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// Returns URL of the provider's authorization endpoint.
+// The caller should store state, nonce and verifier, and check them in the
+// callback.
+func (c *Client) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	m, err := c.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	u, err := url.Parse(m.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("invalid authorization endpoint: %w", err)
+	}
+
+	scopes := append([]string{"openid"}, c.config.Scopes...)
+	v := u.Query()
+	v.Set("response_type", "code")
+	v.Set("client_id", c.config.ClientID)
+	v.Set("redirect_uri", c.config.RedirectURL)
+	v.Set("scope", strings.Join(scopes, " "))
+	v.Set("state", state)
+	v.Set("nonce", nonce)
+	v.Set("code_challenge", codeChallenge(verifier))
+	v.Set("code_challenge_method", "S256")
+	u.RawQuery = v.Encode()
+	return u.String(), nil
+}
+
+// Response from the token endpoint.
+type tokenResponse struct {
+	IDToken string `json:"id_token"`
+
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// Exchanges authorization code for an ID token, and verifies the token.
+func (c *Client) Exchange(ctx context.Context, code, verifier, nonce string) (*IDToken, error) {
+	m, err := c.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", c.config.RedirectURL)
+	form.Set("client_id", c.config.ClientID)
+	form.Set("code_verifier", verifier)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", m.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange code: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if c.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(c.config.ClientID), url.QueryEscape(c.config.ClientSecret))
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange code: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var body tokenResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+		return nil, fmt.Errorf("failed to exchange code: %v: %w", resp.Status, err)
+	}
+	if body.Error != "" {
+		return nil, fmt.Errorf("failed to exchange code: %v: %v", body.Error, body.ErrorDescription)
+	}
+	if resp.StatusCode != http.StatusOK || body.IDToken == "" {
+		return nil, fmt.Errorf("failed to exchange code: %v: missing ID token", resp.Status)
+	}
+	return c.verify(ctx, body.IDToken, nonce, time.Now())
+}
@@ -0,0 +1,147 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+package oidc
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/polycloze/polycloze/oidc/oidctest"
+)
+
+const redirectURL = "http://polycloze.test/signin/oidc/callback"
+
+func testClient(p *oidctest.Provider) *Client {
+	return NewClient(Config{
+		Issuer:       p.URL,
+		ClientID:     p.ClientID,
+		ClientSecret: p.ClientSecret,
+		RedirectURL:  redirectURL,
+		Scopes:       []string{"profile"},
+	})
+}
+
+// Follows the authorization URL, and returns the code and state that the
+// provider redirects back with.
+func authorize(t *testing.T, authURL string) (string, string) {
+	client := http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	resp.Body.Close()
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	return location.Query().Get("code"), location.Query().Get("state")
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	t.Parallel()
+	p := oidctest.NewProvider("polycloze", "secret")
+	defer p.Close()
+	p.SetUser(oidctest.User{Subject: "abc", PreferredUsername: "alice"})
+	c := testClient(p)
+
+	ctx := context.Background()
+	authURL, err := c.AuthCodeURL(ctx, "state", "nonce", "verifier")
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	code, state := authorize(t, authURL)
+	if state != "state" {
+		t.Fatal("expected state to be returned:", state)
+	}
+
+	token, err := c.Exchange(ctx, code, "verifier", "nonce")
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if token.Subject != "abc" || token.PreferredUsername != "alice" {
+		t.Fatal("unexpected claims:", token)
+	}
+
+	// Codes are single-use.
+	if _, err := c.Exchange(ctx, code, "verifier", "nonce"); err == nil {
+		t.Fatal("expected reused code to be rejected")
+	}
+}
+
+func TestExchangeWrongVerifier(t *testing.T) {
+	t.Parallel()
+	p := oidctest.NewProvider("polycloze", "")
+	defer p.Close()
+	c := testClient(p)
+
+	ctx := context.Background()
+	authURL, err := c.AuthCodeURL(ctx, "state", "nonce", "verifier")
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	code, _ := authorize(t, authURL)
+	if _, err := c.Exchange(ctx, code, "wrong", "nonce"); err == nil {
+		t.Fatal("expected wrong PKCE verifier to be rejected")
+	}
+}
+
+func TestVerifyRejectsBadTokens(t *testing.T) {
+	t.Parallel()
+	p := oidctest.NewProvider("polycloze", "")
+	defer p.Close()
+	c := testClient(p)
+
+	now := time.Now()
+	valid := func() map[string]any {
+		return map[string]any{
+			"iss":   p.URL,
+			"sub":   "abc",
+			"aud":   "polycloze",
+			"exp":   now.Add(time.Hour).Unix(),
+			"iat":   now.Unix(),
+			"nonce": "nonce",
+		}
+	}
+
+	ctx := context.Background()
+	if _, err := c.verify(ctx, p.Sign(valid()), "nonce", now); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	tests := map[string]func(map[string]any){
+		"issuer":   func(c map[string]any) { c["iss"] = "https://evil.test" },
+		"audience": func(c map[string]any) { c["aud"] = "other" },
+		"expired":  func(c map[string]any) { c["exp"] = now.Add(-time.Hour).Unix() },
+		"nonce":    func(c map[string]any) { c["nonce"] = "other" },
+		"subject":  func(c map[string]any) { delete(c, "sub") },
+	}
+	for name, modify := range tests {
+		claims := valid()
+		modify(claims)
+		if _, err := c.verify(ctx, p.Sign(claims), "nonce", now); err == nil {
+			t.Fatal("expected token to be rejected:", name)
+		}
+	}
+
+	// Tampered signature.
+	token := p.Sign(valid())
+	if _, err := c.verify(ctx, token+"x", "nonce", now); err == nil {
+		t.Fatal("expected tampered token to be rejected")
+	}
+	none := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+	unsigned := none + "." + strings.Split(token, ".")[1] + "."
+	if _, err := c.verify(ctx, unsigned, "nonce", now); err == nil {
+		t.Fatal("expected unsigned token to be rejected")
+	}
+}
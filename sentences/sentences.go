@@ -6,7 +6,9 @@ package sentences
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/polycloze/polycloze/database"
 	"github.com/polycloze/polycloze/translator"
@@ -22,6 +24,25 @@ type Sentence struct {
 	Translation translator.Translation `json:"translation"`
 }
 
+// Problem with course data that makes a sentence unusable.
+// Functions that run into one skip the sentence if they can.
+type BadSentenceError struct {
+	SentenceID int
+	Word       string // Empty if the problem isn't with a particular word
+	Err        error
+}
+
+func (e *BadSentenceError) Error() string {
+	if e.Word != "" {
+		return fmt.Sprintf("bad sentence %v for word %q: %v", e.SentenceID, e.Word, e.Err)
+	}
+	return fmt.Sprintf("bad sentence %v: %v", e.SentenceID, e.Err)
+}
+
+func (e *BadSentenceError) Unwrap() error {
+	return e.Err
+}
+
 func findWordID[T database.Querier](q T, word string) (int, error) {
 	query := `select id from word where word = ?`
 	row := q.QueryRow(query, word)
@@ -31,7 +52,9 @@ func findWordID[T database.Querier](q T, word string) (int, error) {
 	return id, err
 }
 
-func PickSentence[T database.Querier](q T, word string) (Sentence, error) {
+// Picks random example sentence of the word.
+// exclude: IDs of sentences to skip, e.g. because they turned out to be bad
+func PickSentence[T database.Querier](q T, word string, exclude ...int) (Sentence, error) {
 	id, err := findWordID(q, word)
 	if err != nil {
 		return Sentence{}, err
@@ -45,9 +68,16 @@ func PickSentence[T database.Querier](q T, word string) (Sentence, error) {
 		SELECT id, tatoeba_id, text, tokens FROM contains
 		JOIN sentence ON (sentence = id)
 		WHERE word = ?
-		ORDER BY random() LIMIT 1
 	`
-	row := q.QueryRow(query, id)
+	args := []any{id}
+	if len(exclude) > 0 {
+		query += `AND id NOT IN (?` + strings.Repeat(`, ?`, len(exclude)-1) + `)`
+		for _, sentenceID := range exclude {
+			args = append(args, sentenceID)
+		}
+	}
+	query += ` ORDER BY random() LIMIT 1`
+	row := q.QueryRow(query, args...)
 
 	var sentence Sentence
 	var tatoebaID sql.NullInt64
@@ -59,7 +89,10 @@ func PickSentence[T database.Querier](q T, word string) (Sentence, error) {
 	}
 
 	if err := json.Unmarshal([]byte(tokens), &sentence.Tokens); err != nil {
-		return sentence, err
+		return sentence, &BadSentenceError{
+			SentenceID: sentence.ID,
+			Err:        fmt.Errorf("invalid tokens: %w", err),
+		}
 	}
 
 	if tatoebaID.Valid {
@@ -72,9 +105,14 @@ func PickSentence[T database.Querier](q T, word string) (Sentence, error) {
 
 // Returns random sentence from the database.
 // The results don't include tokens.
+// Sentences without translations get skipped, and are returned separately.
 // NOTE Only picks random sentence from first 10,000 sentences in the DB for
 // speed.
-func RandomSentences[T database.Querier](q T, difficulty int, limit int) ([]Sentence, error) {
+func RandomSentences[T database.Querier](
+	q T,
+	difficulty int,
+	limit int,
+) ([]Sentence, []*BadSentenceError, error) {
 	query := `
 		SELECT id, tatoeba_id, text
 		FROM (SELECT * FROM sentence WHERE frequency_class = ? LIMIT 10000)
@@ -83,17 +121,19 @@ func RandomSentences[T database.Querier](q T, difficulty int, limit int) ([]Sent
 	`
 	rows, err := q.Query(query, difficulty, limit)
 	if err != nil {
-		return nil, fmt.Errorf("failed to pick random sentences: %w", err)
+		return nil, nil, fmt.Errorf("failed to pick random sentences: %w", err)
 	}
 	defer rows.Close()
 
-	var sentences []Sentence
+	// Translations get looked up after the rows are closed, so that queriers
+	// with only one connection (e.g. in-memory DBs) work.
+	var candidates []Sentence
 	for rows.Next() {
 		var sentence Sentence
 		var tatoebaID sql.NullInt64
 
 		if err := rows.Scan(&sentence.ID, &tatoebaID, &sentence.Text); err != nil {
-			return nil, fmt.Errorf("failed to pick random sentences: %w", err)
+			return nil, nil, fmt.Errorf("failed to pick random sentences: %w", err)
 		}
 
 		if tatoebaID.Valid {
@@ -101,17 +141,28 @@ func RandomSentences[T database.Querier](q T, difficulty int, limit int) ([]Sent
 		} else {
 			sentence.TatoebaID = -1
 		}
+		candidates = append(candidates, sentence)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to pick random sentences: %w", err)
+	}
+	rows.Close()
 
+	var sentences []Sentence
+	var skipped []*BadSentenceError
+	for _, sentence := range candidates {
 		translation, err := translator.Translate(q, sentence.TatoebaID)
+		if errors.Is(err, translator.ErrNoTranslation) {
+			skipped = append(skipped, &BadSentenceError{SentenceID: sentence.ID, Err: err})
+			continue
+		}
 		if err != nil {
-			// Panic because this shouldn't happen with generated course files.
-			panic(fmt.Errorf("could not translate sentence (%v): %w", sentence, err))
+			return nil, nil, fmt.Errorf("failed to pick random sentences: %w", err)
 		}
 		sentence.Translation = translation
-
 		sentences = append(sentences, sentence)
 	}
-	return sentences, nil
+	return sentences, skipped, nil
 }
 
 func Search[T database.Querier](q T, text string) (Sentence, error) {
@@ -5,10 +5,13 @@ package sentences
 
 import (
 	"context"
+	"errors"
 	"testing"
 
 	"github.com/polycloze/polycloze/basedir"
 	"github.com/polycloze/polycloze/database"
+	"github.com/polycloze/polycloze/translator"
+	"github.com/polycloze/polycloze/utils"
 )
 
 func BenchmarkPickSentence(b *testing.B) {
@@ -36,3 +39,65 @@ func BenchmarkPickSentence(b *testing.B) {
 		b.Log("result:", sentence)
 	}
 }
+
+func TestRandomSentencesSkipsUntranslatedSentences(t *testing.T) {
+	t.Parallel()
+	db := utils.TestingDatabase()
+	defer db.Close()
+
+	query := `
+		INSERT INTO sentence (id, tatoeba_id, text, tokens, frequency_class) VALUES
+			(1, 10, 'foo', '[]', 1),
+			(2, 20, 'bar', '[]', 1),
+			(3, NULL, 'baz', '[]', 1);
+		INSERT INTO translation (tatoeba_id, text) VALUES (11, 'oof');
+		INSERT INTO translates (source, target) VALUES (10, 11), (20, 21);
+	`
+	if _, err := db.Exec(query); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	result, skipped, err := RandomSentences(db, 1, 10)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if len(result) != 1 || result[0].ID != 1 || result[0].Translation.Text != "oof" {
+		t.Fatal("expected only translated sentence to be returned:", result)
+	}
+	if len(skipped) != 2 {
+		t.Fatal("expected untranslated sentences to be skipped:", skipped)
+	}
+	for _, bad := range skipped {
+		if !errors.Is(bad, translator.ErrNoTranslation) {
+			t.Fatal("expected ErrNoTranslation:", bad)
+		}
+	}
+}
+
+func TestPickSentenceExclude(t *testing.T) {
+	t.Parallel()
+	db := utils.TestingDatabase()
+	defer db.Close()
+
+	query := `
+		INSERT INTO word (id, word, frequency_class) VALUES (1, 'foo', 1);
+		INSERT INTO sentence (id, tatoeba_id, text, tokens, frequency_class) VALUES
+			(1, 10, 'foo', '["foo"]', 1),
+			(2, 20, 'foo!', 'not json', 1);
+		INSERT INTO contains (sentence, word) VALUES (1, 1), (2, 1);
+	`
+	if _, err := db.Exec(query); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	_, err := PickSentence(db, "foo", 1)
+	var bad *BadSentenceError
+	if !errors.As(err, &bad) || bad.SentenceID != 2 {
+		t.Fatal("expected sentence with invalid tokens to be reported:", err)
+	}
+
+	sentence, err := PickSentence(db, "foo", 2)
+	if err != nil || sentence.ID != 1 {
+		t.Fatal("expected excluded sentence to be skipped:", sentence, err)
+	}
+}
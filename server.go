@@ -0,0 +1,170 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/polycloze/polycloze/api"
+	"github.com/polycloze/polycloze/certs"
+	"github.com/polycloze/polycloze/config"
+)
+
+// Returns unix socket path in address, or an empty string if the address
+// isn't a unix socket.
+func socketPath(address string) string {
+	if strings.HasPrefix(address, "unix:") {
+		return strings.TrimPrefix(address, "unix:")
+	}
+	if strings.HasPrefix(address, "/") {
+		return address
+	}
+	return ""
+}
+
+// Listens on TCP address or unix socket.
+func listen(address string) (net.Listener, error) {
+	path := socketPath(address)
+	if path == "" {
+		l, err := net.Listen("tcp", address)
+		if err != nil {
+			return nil, fmt.Errorf("failed to listen on %v: %w", address, err)
+		}
+		return l, nil
+	}
+
+	// Remove stale socket left behind by a previous process.
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+		}
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %v: %w", path, err)
+	}
+
+	// Let the reverse proxy connect to the socket if it's in the same group.
+	if err := os.Chmod(path, 0o660); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("failed to set socket permissions: %w", err)
+	}
+	return l, nil
+}
+
+func newServer(c config.ServerConfig, handler http.Handler) *http.Server {
+	srv := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: time.Duration(c.ReadHeaderTimeout),
+		ReadTimeout:       time.Duration(c.ReadTimeout),
+		WriteTimeout:      time.Duration(c.WriteTimeout),
+		IdleTimeout:       time.Duration(c.IdleTimeout),
+		MaxHeaderBytes:    c.MaxHeaderBytes,
+	}
+	srv.RegisterOnShutdown(api.Shutdown)
+	return srv
+}
+
+// Reloads courses and logs changes.
+func reloadCourses() {
+	changes, err := api.ReloadCourses()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	log.Println("Reloaded courses:", changes)
+}
+
+// Serves requests until the process receives SIGINT or SIGTERM, then waits
+// for in-flight requests to finish.
+// Courses get reloaded on SIGHUP.
+// If reloader isn't nil, requests are served over TLS, and the certificate
+// also gets reloaded on SIGHUP.
+func serve(srv *http.Server, l net.Listener, reloader *certs.Reloader, shutdownTimeout time.Duration) error {
+	if reloader != nil {
+		srv.TLSConfig = reloader.TLSConfig()
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		if reloader != nil {
+			errs <- srv.ServeTLS(l, "", "")
+		} else {
+			errs <- srv.Serve(l)
+		}
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+loop:
+	for {
+		select {
+		case err := <-errs:
+			return err
+		case sig := <-signals:
+			if sig != syscall.SIGHUP {
+				log.Printf("Received %v, shutting down\n", sig)
+				break loop
+			}
+			reloadCourses()
+			if reloader == nil {
+				continue
+			}
+			if err := reloader.Reload(); err != nil {
+				log.Println(err)
+			} else {
+				log.Println("Reloaded TLS certificate")
+			}
+		}
+	}
+
+	ctx := context.Background()
+	if shutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, shutdownTimeout)
+		defer cancel()
+	}
+	if err := srv.Shutdown(ctx); err != nil {
+		return fmt.Errorf("failed to shut down gracefully: %w", err)
+	}
+	if err := <-errs; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Serves metrics on a separate listener in the background.
+// The caller has to Close the returned server.
+func serveMetrics(address string, handler http.Handler) (*http.Server, error) {
+	l, err := listen(address)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", handler)
+	srv := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := srv.Serve(l); !errors.Is(err, http.ErrServerClosed) {
+			log.Println(err)
+		}
+	}()
+	log.Printf("Serving metrics on %v\n", l.Addr())
+	return srv, nil
+}
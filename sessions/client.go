@@ -0,0 +1,266 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+// Client info and activity of sessions.
+package sessions
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Max number of bytes of user agent strings that get stored.
+const maxUserAgentLength = 256
+
+// Last activity doesn't get updated more often than this, to avoid writing
+// to the DB on every request.
+const touchInterval = time.Minute
+
+type contextKey int
+
+const (
+	keyClientIP contextKey = iota
+	keySessionID
+)
+
+// Stores client IP address in request context, so that sessions record it
+// instead of the remote address (e.g. behind reverse proxies).
+func WithClientIP(r *http.Request, ip string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), keyClientIP, ip))
+}
+
+// Returns client IP address set by WithClientIP, or the request's remote
+// address.
+func clientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(keyClientIP).(string); ok {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Records client info and last activity of session.
+// Does nothing if the session was updated less than `interval` ago.
+func touch(db *sql.DB, id string, r *http.Request, interval time.Duration) error {
+	userAgent := r.UserAgent()
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+
+	query := `
+		UPDATE user_session
+		SET user_agent = ?, ip = ?, updated = unixepoch('now')
+		WHERE session_id = ? AND updated <= unixepoch('now') - ?
+	`
+	_, err := db.Exec(query, userAgent, clientIP(r), id, int64(interval.Seconds()))
+	if err != nil {
+		return fmt.Errorf("failed to update session activity: %w", err)
+	}
+	return nil
+}
+
+// Session info shown to users.
+type Info struct {
+	// Identifies the session without revealing its ID, which is secret.
+	Handle string
+
+	// Whether it's the session that made the request.
+	Current bool
+
+	// Whether it's a "remember me" token whose session already expired.
+	// The token signs the client back in on its next visit.
+	Remembered bool
+
+	Created    time.Time
+	LastActive time.Time
+	UserAgent  string
+	IP         string
+}
+
+// Returns public identifier of session.
+func handle(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Lists user's unexpired sessions, most recently active first.
+// currentID: ID of session that made the request
+func ListUserSessions(db *sql.DB, userID int, currentID string) ([]Info, error) {
+	query := `
+		SELECT session_id, created, updated, user_agent, ip FROM user_session
+		WHERE user_id = ?
+			AND created >= (unixepoch('now') - ?)
+			AND updated >= (unixepoch('now') - ?)
+		ORDER BY updated DESC, created DESC
+	`
+	rows, err := db.Query(query, userID, int64(MaxAge.Seconds()), int64(IdleTimeout.Seconds()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	defer rows.Close()
+
+	var sessions []Info
+	for rows.Next() {
+		var id string
+		var created, updated int64
+		var info Info
+		if err := rows.Scan(&id, &created, &updated, &info.UserAgent, &info.IP); err != nil {
+			return nil, fmt.Errorf("failed to list sessions: %w", err)
+		}
+		info.Handle = handle(id)
+		info.Current = id == currentID
+		info.Created = time.Unix(created, 0)
+		info.LastActive = time.Unix(updated, 0)
+		sessions = append(sessions, info)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+
+	remembered, err := listRememberedSessions(db, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	return append(sessions, remembered...), nil
+}
+
+// Lists user's unexpired "remember me" tokens that aren't tied to an
+// unexpired session.
+func listRememberedSessions(db *sql.DB, userID int) ([]Info, error) {
+	query := `
+		SELECT series, created, coalesce(rotated, created) FROM remember_token
+		WHERE user_id = ? AND expires >= unixepoch('now') AND NOT EXISTS (
+			SELECT 1 FROM user_session
+			WHERE user_session.session_id = remember_token.session_id
+				AND created >= (unixepoch('now') - ?)
+				AND updated >= (unixepoch('now') - ?)
+		)
+		ORDER BY 3 DESC
+	`
+	rows, err := db.Query(query, userID, int64(MaxAge.Seconds()), int64(IdleTimeout.Seconds()))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []Info
+	for rows.Next() {
+		var series string
+		var created, used int64
+		if err := rows.Scan(&series, &created, &used); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, Info{
+			Handle:     handle(series),
+			Remembered: true,
+			Created:    time.Unix(created, 0),
+			LastActive: time.Unix(used, 0),
+		})
+	}
+	return sessions, rows.Err()
+}
+
+// Deletes user's session with the given handle, and the "remember me" token
+// that signed it in.
+// Doesn't return an error if there's no such session, but doesn't delete
+// anything either.
+func RevokeUserSession(db *sql.DB, userID int, sessionHandle string) error {
+	query := `SELECT session_id FROM user_session WHERE user_id = ?`
+	rows, err := db.Query(query, userID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+
+	var match string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to revoke session: %w", err)
+		}
+		if handle(id) == sessionHandle {
+			match = id
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+	if match == "" {
+		return revokeRememberToken(db, userID, sessionHandle)
+	}
+
+	query = `DELETE FROM remember_token WHERE session_id = ? AND user_id = ?`
+	if _, err := db.Exec(query, match, userID); err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+	query = `DELETE FROM user_session WHERE session_id = ? AND user_id = ?`
+	if _, err := db.Exec(query, match, userID); err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+	return nil
+}
+
+// Deletes user's "remember me" token with the given handle, if any.
+func revokeRememberToken(db *sql.DB, userID int, tokenHandle string) error {
+	query := `SELECT series FROM remember_token WHERE user_id = ?`
+	rows, err := db.Query(query, userID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+
+	var match string
+	for rows.Next() {
+		var series string
+		if err := rows.Scan(&series); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to revoke session: %w", err)
+		}
+		if handle(series) == tokenHandle {
+			match = series
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+	if match == "" {
+		return nil
+	}
+
+	query = `DELETE FROM remember_token WHERE series = ? AND user_id = ?`
+	if _, err := db.Exec(query, match, userID); err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+	return nil
+}
+
+// Deletes all of the user's sessions and "remember me" tokens except the
+// current session's.
+// Returns the number of deleted sessions.
+// keepID: ID of session to keep; if empty, all sessions get deleted
+func DeleteOtherSessions(db *sql.DB, userID int, keepID string) (int64, error) {
+	query := `
+		DELETE FROM remember_token
+		WHERE user_id = ? AND (session_id IS NULL OR session_id != ?)
+	`
+	if _, err := db.Exec(query, userID, keepID); err != nil {
+		return 0, fmt.Errorf("failed to delete other sessions: %w", err)
+	}
+
+	query = `DELETE FROM user_session WHERE user_id = ? AND session_id != ?`
+	result, err := db.Exec(query, userID, keepID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete other sessions: %w", err)
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,130 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+package sessions
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Starts signed-in session for testing.
+func signedInSession(t *testing.T, db *sql.DB, userID int, userAgent string) *Session {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("User-Agent", userAgent)
+	r = WithClientIP(r, "192.0.2.1")
+
+	s, err := StartSession(db, httptest.NewRecorder(), r)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	s.Data["userID"] = userID
+	s.Data["username"] = "foo"
+	if err := SaveData(db, s); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	return s
+}
+
+func TestListUserSessions(t *testing.T) {
+	t.Parallel()
+	db := testDB()
+	defer db.Close()
+	disableForeignKeys(db)
+
+	current := signedInSession(t, db, 1, "Firefox")
+	signedInSession(t, db, 1, "Chrome")
+	signedInSession(t, db, 2, "Safari")
+
+	sessions, err := ListUserSessions(db, 1, current.ID)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if len(sessions) != 2 {
+		t.Fatal("expected two sessions:", sessions)
+	}
+
+	var found bool
+	for _, info := range sessions {
+		if info.Current {
+			found = true
+			if info.UserAgent != "Firefox" || info.IP != "192.0.2.1" {
+				t.Fatal("expected client info to be recorded:", info)
+			}
+		}
+		if info.Handle == "" || info.Handle == current.ID {
+			t.Fatal("expected handle to not be the session ID:", info.Handle)
+		}
+	}
+	if !found {
+		t.Fatal("expected current session to be marked:", sessions)
+	}
+}
+
+func TestRevokeUserSession(t *testing.T) {
+	t.Parallel()
+	db := testDB()
+	defer db.Close()
+	disableForeignKeys(db)
+
+	s := signedInSession(t, db, 1, "Firefox")
+	other := signedInSession(t, db, 2, "Chrome")
+
+	// Users can't revoke other users' sessions.
+	if err := RevokeUserSession(db, 1, handle(other.ID)); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if len(getData(db, other.ID)) == 0 {
+		t.Fatal("expected other user's session to not be revoked")
+	}
+
+	if err := RevokeUserSession(db, 1, handle(s.ID)); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if len(getData(db, s.ID)) != 0 {
+		t.Fatal("expected session to be revoked")
+	}
+}
+
+func TestDeleteOtherSessions(t *testing.T) {
+	t.Parallel()
+	db := testDB()
+	defer db.Close()
+	disableForeignKeys(db)
+
+	current := signedInSession(t, db, 1, "Firefox")
+	signedInSession(t, db, 1, "Chrome")
+	signedInSession(t, db, 1, "Safari")
+
+	count, err := DeleteOtherSessions(db, 1, current.ID)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if count != 2 {
+		t.Fatal("expected two sessions to be deleted:", count)
+	}
+	if len(getData(db, current.ID)) == 0 {
+		t.Fatal("expected current session to be kept")
+	}
+}
+
+func TestExpiredSessionCannotBeResumed(t *testing.T) {
+	t.Parallel()
+	db := testDB()
+	defer db.Close()
+	disableForeignKeys(db)
+
+	s := signedInSession(t, db, 1, "Firefox")
+	query := `UPDATE user_session SET updated = updated - ? WHERE session_id = ?`
+	if _, err := db.Exec(query, int64(IdleTimeout.Seconds())+1, s.ID); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: cookieName, Value: s.ID})
+	if _, err := ResumeSession(db, httptest.NewRecorder(), r); err == nil {
+		t.Fatal("expected idle session to be expired")
+	}
+}
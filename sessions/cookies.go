@@ -4,33 +4,53 @@
 package sessions
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"net/http"
+	"time"
 )
 
 // Name of cookie that stores session ID.
 const cookieName = "id"
 
-// Gets session cookie from client.
+// Only send session cookies over HTTPS.
+// Can be changed during startup.
+var SecureCookies = false
+
+// Gets session cookie from client, or the session set by WithSession.
 // Returns an error if no ID is found.
 // Does not validate the cookie.
 func getCookie(r *http.Request) (*http.Cookie, error) {
+	if id, ok := r.Context().Value(keySessionID).(string); ok {
+		return &http.Cookie{Name: cookieName, Value: id}, nil
+	}
 	return r.Cookie(cookieName)
 }
 
+// Makes the rest of the request use the session, as if the client had sent
+// its cookie.
+// Useful for middleware that start sessions.
+func WithSession(r *http.Request, s *Session) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), keySessionID, s.ID))
+}
+
 // Checks if the session ID in the cookie is still valid (in DB and not expired).
 func validateCookie(db *sql.DB, c *http.Cookie) error {
 	if c.Name != cookieName {
 		return errors.New("incorrect cookie name")
 	}
-	var id string
-	// TODO scan expiry date for checking
-	query := `SELECT session_id FROM user_session WHERE session_id = ?`
-	if err := db.QueryRow(query, c.Value).Scan(&id); err != nil {
+	var created, updated int64
+	query := `SELECT created, updated FROM user_session WHERE session_id = ?`
+	if err := db.QueryRow(query, c.Value).Scan(&created, &updated); err != nil {
 		return fmt.Errorf("invalid session ID: %w", err)
 	}
+
+	now := time.Now()
+	if now.Sub(time.Unix(created, 0)) > MaxAge || now.Sub(time.Unix(updated, 0)) > IdleTimeout {
+		return errors.New("session expired")
+	}
 	return nil
 }
 
@@ -38,9 +58,10 @@ func setCookie(w http.ResponseWriter, id string) {
 	c := http.Cookie{
 		Name:     cookieName,
 		Value:    id,
+		Path:     "/",
 		SameSite: http.SameSiteStrictMode,
 		HttpOnly: true,
-		Secure:   false,
+		Secure:   SecureCookies,
 	}
 	http.SetCookie(w, &c)
 }
@@ -49,9 +70,10 @@ func deleteCookie(w http.ResponseWriter) {
 	c := http.Cookie{
 		Name:     cookieName,
 		Value:    "",
+		Path:     "/",
 		SameSite: http.SameSiteStrictMode,
 		HttpOnly: true,
-		Secure:   false,
+		Secure:   SecureCookies,
 		MaxAge:   -1,
 	}
 	http.SetCookie(w, &c)
@@ -24,3 +24,13 @@ func CSRFToken(sessionID string) string {
 func CheckCSRFToken(sessionID, token string) bool {
 	return CSRFToken(sessionID) == token
 }
+
+// Validates CSRF token for the session.
+// Stateless sessions are exempt, because browsers don't attach their
+// credentials to cross-site requests automatically.
+func (s *Session) CheckCSRFToken(token string) bool {
+	if s.IsStateless() {
+		return true
+	}
+	return CheckCSRFToken(s.ID, token)
+}
@@ -12,14 +12,34 @@ import (
 func getData(db *sql.DB, id string) map[string]any {
 	var userID sql.NullInt32
 	var username sql.NullString
+	var pendingUserID sql.NullInt32
+	var pendingSince sql.NullInt64
+	var oidcSignedIn sql.NullInt64
 
 	data := make(map[string]any)
-	query := `SELECT user_id, username FROM user_session WHERE session_id = ?`
-	if err := db.QueryRow(query, id).Scan(&userID, &username); err == nil {
+	query := `
+		SELECT user_id, username, pending_user_id, pending_since, oidc_signed_in
+		FROM user_session WHERE session_id = ?
+	`
+	err := db.QueryRow(query, id).Scan(
+		&userID,
+		&username,
+		&pendingUserID,
+		&pendingSince,
+		&oidcSignedIn,
+	)
+	if err == nil {
 		if userID.Valid && username.Valid {
 			data["userID"] = int(userID.Int32)
 			data["username"] = username.String
 		}
+		if pendingUserID.Valid && pendingSince.Valid {
+			data["pendingUserID"] = int(pendingUserID.Int32)
+			data["pendingSince"] = pendingSince.Int64
+		}
+		if oidcSignedIn.Valid {
+			data["oidcSignedIn"] = oidcSignedIn.Int64
+		}
 	}
 	return data
 }
@@ -28,7 +48,20 @@ func getData(db *sql.DB, id string) map[string]any {
 // The session must exist already.
 // `SaveData` would still return `nil`, but wouldn't insert a new entry for the missing session.
 func SaveData(db *sql.DB, s *Session) error {
-	query := `UPDATE user_session SET user_id = ?, username = ?, updated = unixepoch('now') WHERE session_id = ?`
-	_, err := db.Exec(query, s.Data["userID"], s.Data["username"], s.ID)
+	query := `
+		UPDATE user_session
+		SET user_id = ?, username = ?, pending_user_id = ?, pending_since = ?,
+			oidc_signed_in = ?, updated = unixepoch('now')
+		WHERE session_id = ?
+	`
+	_, err := db.Exec(
+		query,
+		s.Data["userID"],
+		s.Data["username"],
+		s.Data["pendingUserID"],
+		s.Data["pendingSince"],
+		s.Data["oidcSignedIn"],
+		s.ID,
+	)
 	return err
 }
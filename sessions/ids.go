@@ -50,13 +50,30 @@ func reserveID(db *sql.DB, id string) error {
 }
 
 // Deletes session ID from the database.
-// Also deletes old (created > 4 hours ago) and idle (updated > 30 minutes ago) sessions.
+// Also deletes old (created > MaxAge ago) and idle (updated > IdleTimeout ago)
+// sessions.
 func deleteID(db *sql.DB, id string) error {
 	query := `
 		DELETE FROM user_session WHERE session_id = ?
-			OR created < (unixepoch('now') - 14400)
-			OR updated < (unixepoch('now') - 1800)
+			OR created < (unixepoch('now') - ?)
+			OR updated < (unixepoch('now') - ?)
 	`
-	_, err := db.Exec(query, id)
+	_, err := db.Exec(query, id, int64(MaxAge.Seconds()), int64(IdleTimeout.Seconds()))
 	return err
 }
+
+// Counts signed-in sessions that haven't expired.
+func CountActive(db *sql.DB) (int, error) {
+	query := `
+		SELECT count(*) FROM user_session
+		WHERE user_id IS NOT NULL
+			AND created >= (unixepoch('now') - ?)
+			AND updated >= (unixepoch('now') - ?)
+	`
+	var count int
+	err := db.QueryRow(query, int64(MaxAge.Seconds()), int64(IdleTimeout.Seconds())).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count active sessions: %w", err)
+	}
+	return count, nil
+}
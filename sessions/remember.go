@@ -0,0 +1,249 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+// "Remember me" tokens.
+package sessions
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/polycloze/polycloze/logging"
+)
+
+// Lifetime of "remember me" tokens.
+// Zero disables "remember me".
+// Can be changed during startup.
+var RememberFor = 30 * 24 * time.Hour
+
+// Name of cookie that stores "remember me" token.
+const rememberCookieName = "remember"
+
+// How long the previous token stays valid after rotation.
+const rotationGracePeriod = time.Minute
+
+// Returns SHA-256 hash of token in hex.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func setRememberCookie(w http.ResponseWriter, series, token string) {
+	c := http.Cookie{
+		Name:     rememberCookieName,
+		Value:    series + "." + token,
+		Path:     "/",
+		MaxAge:   int(RememberFor.Seconds()),
+		SameSite: http.SameSiteStrictMode,
+		HttpOnly: true,
+		Secure:   SecureCookies,
+	}
+	http.SetCookie(w, &c)
+}
+
+func deleteRememberCookie(w http.ResponseWriter) {
+	c := http.Cookie{
+		Name:     rememberCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		SameSite: http.SameSiteStrictMode,
+		HttpOnly: true,
+		Secure:   SecureCookies,
+	}
+	http.SetCookie(w, &c)
+}
+
+// Gets series and token from the client's "remember me" cookie.
+func getRememberCookie(r *http.Request) (string, string, error) {
+	c, err := r.Cookie(rememberCookieName)
+	if err != nil {
+		return "", "", err
+	}
+	series, token, ok := strings.Cut(c.Value, ".")
+	if !ok || series == "" || token == "" {
+		return "", "", errors.New("invalid remember me cookie")
+	}
+	return series, token, nil
+}
+
+// Issues "remember me" token for the signed-in session, so that the user
+// stays signed in after the session expires.
+// Does nothing if "remember me" is disabled.
+func Remember(db *sql.DB, w http.ResponseWriter, s *Session) error {
+	if RememberFor <= 0 {
+		return nil
+	}
+	if !s.IsSignedIn() || s.IsStateless() {
+		return errors.New("failed to remember session: not signed in")
+	}
+
+	series, err := generateID()
+	if err != nil {
+		return fmt.Errorf("failed to remember session: %w", err)
+	}
+	token, err := generateID()
+	if err != nil {
+		return fmt.Errorf("failed to remember session: %w", err)
+	}
+
+	query := `
+		INSERT INTO remember_token (user_id, series, hash, session_id, expires)
+		VALUES (?, ?, ?, ?, ?)
+	`
+	expires := time.Now().Add(RememberFor).Unix()
+	_, err = db.Exec(query, s.Data["userID"], series, hashToken(token), s.ID, expires)
+	if err != nil {
+		return fmt.Errorf("failed to remember session: %w", err)
+	}
+	setRememberCookie(w, series, token)
+	return nil
+}
+
+// Deletes the client's "remember me" token, if any.
+func forget(db *sql.DB, w http.ResponseWriter, r *http.Request) error {
+	series, _, err := getRememberCookie(r)
+	if err != nil {
+		return nil
+	}
+	deleteRememberCookie(w)
+
+	query := `DELETE FROM remember_token WHERE series = ?`
+	if _, err := db.Exec(query, series); err != nil {
+		return fmt.Errorf("failed to delete remember me token: %w", err)
+	}
+	return nil
+}
+
+// Signs user back in with their "remember me" token, and rotates the token.
+// Reuse of an old token signs the user out everywhere, because it means that
+// the token has been stolen (or that the thief has used it first).
+func resumeRemembered(db *sql.DB, w http.ResponseWriter, r *http.Request) (*Session, error) {
+	series, token, err := getRememberCookie(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var userID int
+	var username, hash string
+	var previousHash, sessionID sql.NullString
+	var rotated sql.NullInt64
+	var expires int64
+	var disabled bool
+	query := `
+		SELECT user_id, username, hash, previous_hash, rotated, session_id, expires,
+			disabled
+		FROM remember_token JOIN user ON (user_id = user.id)
+		WHERE series = ?
+	`
+	err = db.QueryRow(query, series).Scan(
+		&userID,
+		&username,
+		&hash,
+		&previousHash,
+		&rotated,
+		&sessionID,
+		&expires,
+		&disabled,
+	)
+	if err != nil {
+		deleteRememberCookie(w)
+		return nil, fmt.Errorf("invalid remember me token: %w", err)
+	}
+
+	now := time.Now()
+	if disabled || now.Unix() > expires {
+		_ = forget(db, w, r)
+		return nil, errors.New("remember me token expired")
+	}
+
+	tokenHash := hashToken(token)
+	switch {
+	case hmac.Equal([]byte(tokenHash), []byte(hash)):
+		return rotateRememberToken(db, w, r, series, hash, userID, username)
+
+	case previousHash.Valid &&
+		hmac.Equal([]byte(tokenHash), []byte(previousHash.String)) &&
+		now.Sub(time.Unix(rotated.Int64, 0)) <= rotationGracePeriod:
+		// Concurrent request that was sent before the client received the new
+		// token. Reuses the session created during rotation.
+		if !sessionID.Valid {
+			return nil, errors.New("remember me token was rotated")
+		}
+		if err := validateCookie(db, &http.Cookie{Name: cookieName, Value: sessionID.String}); err != nil {
+			return nil, err
+		}
+		setCookie(w, sessionID.String)
+		s := Session{
+			ID:   sessionID.String,
+			Data: getData(db, sessionID.String),
+			db:   db,
+		}
+		return &s, nil
+
+	default:
+		logging.Error(r, fmt.Errorf("remember me token was reused; signing out user %d", userID))
+		deleteRememberCookie(w)
+		if _, err := DeleteUserSessions(db, userID); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("remember me token was reused")
+	}
+}
+
+// Starts new session for the remembered user, and replaces the token.
+// The token gets replaced first, so that requests that lose the race (or
+// replay a stolen token) don't get signed in.
+func rotateRememberToken(
+	db *sql.DB,
+	w http.ResponseWriter,
+	r *http.Request,
+	series, hash string,
+	userID int,
+	username string,
+) (*Session, error) {
+	id, err := generateUniqueID(db)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resume remembered session: %w", err)
+	}
+	token, err := generateID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to rotate remember me token: %w", err)
+	}
+
+	// The hash condition makes sure only one request rotates the token.
+	query := `
+		UPDATE remember_token
+		SET hash = ?, previous_hash = hash, rotated = unixepoch('now'), session_id = ?
+		WHERE series = ? AND hash = ?
+	`
+	result, err := db.Exec(query, hashToken(token), id, series, hash)
+	if err != nil {
+		_ = deleteID(db, id)
+		return nil, fmt.Errorf("failed to rotate remember me token: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		_ = deleteID(db, id)
+		return nil, errors.New("failed to rotate remember me token: token was already rotated")
+	}
+	setRememberCookie(w, series, token)
+
+	s, err := newSessionWithID(db, w, r, id)
+	if err != nil {
+		return nil, err
+	}
+	s.Data["userID"] = userID
+	s.Data["username"] = username
+	if err := SaveData(db, s); err != nil {
+		return nil, fmt.Errorf("failed to resume remembered session: %w", err)
+	}
+	logging.Set(r.Context(), "userID", userID)
+	return s, nil
+}
@@ -0,0 +1,234 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+package sessions
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Creates user with ID 1 and a remembered session.
+// Returns the session and the "remember me" cookie.
+func rememberedSession(t *testing.T, db *sql.DB) (*Session, *http.Cookie) {
+	query := `INSERT INTO user (id, username, password) VALUES (1, 'foo', 'bar')`
+	if _, err := db.Exec(query); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	s := signedInSession(t, db, 1, "Firefox")
+	w := httptest.NewRecorder()
+	if err := Remember(db, w, s); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	return s, responseCookie(t, w, rememberCookieName)
+}
+
+// Returns cookie set by the response.
+func responseCookie(t *testing.T, w *httptest.ResponseRecorder, name string) *http.Cookie {
+	var found *http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.Name == name {
+			found = c
+		}
+	}
+	if found == nil {
+		t.Fatal("expected cookie to be set:", name)
+	}
+	return found
+}
+
+// Resumes session of client that only has a "remember me" cookie.
+func resumeWithToken(db *sql.DB, c *http.Cookie) (*Session, *httptest.ResponseRecorder, error) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(c)
+	w := httptest.NewRecorder()
+	s, err := ResumeSession(db, w, r)
+	return s, w, err
+}
+
+func TestRememberResumesSession(t *testing.T) {
+	t.Parallel()
+	db := testDB()
+	defer db.Close()
+
+	s, c := rememberedSession(t, db)
+
+	resumed, w, err := resumeWithToken(db, c)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if !resumed.IsSignedIn() || resumed.Data["userID"] != 1 {
+		t.Fatal("expected remembered user to be signed in:", resumed.Data)
+	}
+	if resumed.ID == s.ID {
+		t.Fatal("expected a new session to be started")
+	}
+
+	rotated := responseCookie(t, w, rememberCookieName)
+	if rotated.Value == c.Value {
+		t.Fatal("expected token to be rotated")
+	}
+	if _, _, err := resumeWithToken(db, rotated); err != nil {
+		t.Fatal("expected rotated token to work:", err)
+	}
+}
+
+func TestRememberTokenReuseWithinGracePeriod(t *testing.T) {
+	// Concurrent requests with the old token get the same session.
+	t.Parallel()
+	db := testDB()
+	defer db.Close()
+
+	_, c := rememberedSession(t, db)
+
+	first, _, err := resumeWithToken(db, c)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	second, _, err := resumeWithToken(db, c)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if first.ID != second.ID {
+		t.Fatal("expected concurrent requests to share session:", first.ID, second.ID)
+	}
+}
+
+func TestRotateRememberTokenLosesRace(t *testing.T) {
+	// Requests that don't get to rotate the token don't get a session.
+	t.Parallel()
+	db := testDB()
+	defer db.Close()
+
+	_, c := rememberedSession(t, db)
+
+	var series, hash string
+	query := `SELECT series, hash FROM remember_token`
+	if err := db.QueryRow(query).Scan(&series, &hash); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if _, _, err := resumeWithToken(db, c); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	var before, after int
+	query = `SELECT count(*) FROM user_session`
+	if err := db.QueryRow(query).Scan(&before); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	if _, err := rotateRememberToken(db, w, r, series, hash, 1, "foo"); err == nil {
+		t.Fatal("expected already rotated token to be rejected")
+	}
+	if cookies := w.Result().Cookies(); len(cookies) > 0 {
+		t.Fatal("expected no cookies to be set:", cookies)
+	}
+	if err := db.QueryRow(query).Scan(&after); err != nil || after != before {
+		t.Fatal("expected no session to be created:", before, after, err)
+	}
+}
+
+func TestRememberTokenTheft(t *testing.T) {
+	// Reusing an old token signs the user out everywhere.
+	t.Parallel()
+	db := testDB()
+	defer db.Close()
+
+	_, c := rememberedSession(t, db)
+
+	resumed, w, err := resumeWithToken(db, c)
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	rotated := responseCookie(t, w, rememberCookieName)
+
+	query := `UPDATE remember_token SET rotated = rotated - 3600`
+	if _, err := db.Exec(query); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if _, _, err := resumeWithToken(db, c); err == nil {
+		t.Fatal("expected old token to be rejected")
+	}
+
+	if len(getData(db, resumed.ID)) > 0 {
+		t.Fatal("expected user's sessions to be deleted")
+	}
+	if _, _, err := resumeWithToken(db, rotated); err == nil {
+		t.Fatal("expected current token to be revoked")
+	}
+}
+
+func TestEndSessionRevokesRememberToken(t *testing.T) {
+	t.Parallel()
+	db := testDB()
+	defer db.Close()
+
+	s, c := rememberedSession(t, db)
+
+	r := httptest.NewRequest("POST", "/signout", nil)
+	r.AddCookie(&http.Cookie{Name: cookieName, Value: s.ID})
+	r.AddCookie(c)
+	if err := EndSession(db, httptest.NewRecorder(), r); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	if _, _, err := resumeWithToken(db, c); err == nil {
+		t.Fatal("expected token to be revoked")
+	}
+}
+
+func TestRevokeRememberedSession(t *testing.T) {
+	t.Parallel()
+	db := testDB()
+	defer db.Close()
+
+	s, c := rememberedSession(t, db)
+	if _, err := db.Exec(`DELETE FROM user_session WHERE session_id = ?`, s.ID); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+
+	sessions, err := ListUserSessions(db, 1, "")
+	if err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if len(sessions) != 1 || !sessions[0].Remembered {
+		t.Fatal("expected remembered session to be listed:", sessions)
+	}
+
+	if err := RevokeUserSession(db, 1, sessions[0].Handle); err != nil {
+		t.Fatal("expected err to be nil:", err)
+	}
+	if _, _, err := resumeWithToken(db, c); err == nil {
+		t.Fatal("expected token to be revoked")
+	}
+}
+
+func TestHasSignedInSession(t *testing.T) {
+	// Doesn't sign in remembered users.
+	t.Parallel()
+	db := testDB()
+	defer db.Close()
+
+	s, c := rememberedSession(t, db)
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: cookieName, Value: s.ID})
+	if !HasSignedInSession(db, r) {
+		t.Fatal("expected session to be signed in")
+	}
+
+	r = httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(c)
+	if HasSignedInSession(db, r) {
+		t.Fatal("expected remembered user to not count as signed in")
+	}
+	var count int
+	if err := db.QueryRow(`SELECT count(*) FROM user_session`).Scan(&count); err != nil || count != 1 {
+		t.Fatal("expected no session to be started:", count, err)
+	}
+}
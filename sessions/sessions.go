@@ -7,6 +7,19 @@ import (
 	"database/sql"
 	"fmt"
 	"net/http"
+	"time"
+
+	"github.com/polycloze/polycloze/logging"
+)
+
+// Session lifetimes.
+// Can be changed during startup.
+var (
+	// Sessions older than this get deleted.
+	MaxAge = 4 * time.Hour
+
+	// Sessions that haven't been used for this long get deleted.
+	IdleTimeout = 30 * time.Minute
 )
 
 // Represents a user session.
@@ -15,6 +28,10 @@ type Session struct {
 	ID   string
 	Data map[string]any
 	db   *sql.DB // Reference to auth/session DB.
+
+	// Stateless sessions aren't backed by a session cookie (e.g. requests
+	// authenticated with an API token).
+	stateless bool
 }
 
 // Checks if session data contains a user ID.
@@ -59,12 +76,23 @@ func StartSession(db *sql.DB, w http.ResponseWriter, r *http.Request) (*Session,
 	if err := EndSession(db, w, r); err != nil {
 		return nil, fmt.Errorf("failed to start session: %w", err)
 	}
+	return newSession(db, w, r)
+}
 
+// Starts a new session without ending the existing one.
+func newSession(db *sql.DB, w http.ResponseWriter, r *http.Request) (*Session, error) {
 	id, err := generateUniqueID(db)
 	if err != nil {
 		return nil, fmt.Errorf("failed to start session: %w", err)
 	}
+	return newSessionWithID(db, w, r, id)
+}
 
+// Like newSession, but with an ID generated by the caller.
+func newSessionWithID(db *sql.DB, w http.ResponseWriter, r *http.Request, id string) (*Session, error) {
+	if err := touch(db, id, r, 0); err != nil {
+		return nil, fmt.Errorf("failed to start session: %w", err)
+	}
 	setCookie(w, id)
 
 	s := Session{
@@ -76,16 +104,28 @@ func StartSession(db *sql.DB, w http.ResponseWriter, r *http.Request) (*Session,
 }
 
 // Resumes an existing (valid) session.
-// If there's none, returns an error.
+// If there's none, signs the user back in with their "remember me" token.
+// If that fails too, returns an error.
 func ResumeSession(db *sql.DB, w http.ResponseWriter, r *http.Request) (*Session, error) {
 	c, err := getCookie(r)
+	if err == nil {
+		err = validateCookie(db, c)
+		if err != nil {
+			// Only deletes the session cookie, because the "remember me"
+			// token might still be valid.
+			_ = deleteID(db, c.Value)
+			deleteCookie(w)
+		}
+	}
 	if err != nil {
+		if s, err := resumeRemembered(db, w, r); err == nil {
+			return s, nil
+		}
 		return nil, fmt.Errorf("failed to resume session: %w", err)
 	}
 
-	if err := validateCookie(db, c); err != nil {
-		_ = EndSession(db, w, r)
-		return nil, fmt.Errorf("failed to resume session: %w", err)
+	if err := touch(db, c.Value, r, touchInterval); err != nil {
+		logging.Error(r, err)
 	}
 
 	s := Session{
@@ -93,9 +133,45 @@ func ResumeSession(db *sql.DB, w http.ResponseWriter, r *http.Request) (*Session
 		Data: getData(db, c.Value),
 		db:   db,
 	}
+	if userID, ok := s.Data["userID"]; ok {
+		logging.Set(r.Context(), "userID", userID)
+	}
 	return &s, nil
 }
 
+// Checks if the request's session cookie belongs to a valid, signed-in
+// session.
+// Unlike ResumeSession, doesn't refresh the session, or sign in remembered
+// users.
+func HasSignedInSession(db *sql.DB, r *http.Request) bool {
+	c, err := getCookie(r)
+	if err != nil || validateCookie(db, c) != nil {
+		return false
+	}
+	data := getData(db, c.Value)
+	return hasUserID(data) && hasUsername(data)
+}
+
+// Creates a session for a request that was authenticated without a session
+// cookie (e.g. with an API token).
+// The session doesn't get saved into the database.
+func StatelessSession(db *sql.DB, userID int, username string) *Session {
+	s := Session{
+		Data: map[string]any{
+			"userID":   userID,
+			"username": username,
+		},
+		db:        db,
+		stateless: true,
+	}
+	return &s
+}
+
+// Checks if the session isn't backed by a session cookie.
+func (s *Session) IsStateless() bool {
+	return s != nil && s.stateless
+}
+
 // Resumes an existing (valid) session, or starts a new one if there's none yet.
 func StartOrResumeSession(db *sql.DB, w http.ResponseWriter, r *http.Request) (*Session, error) {
 	s, err := ResumeSession(db, w, r)
@@ -105,9 +181,13 @@ func StartOrResumeSession(db *sql.DB, w http.ResponseWriter, r *http.Request) (*
 	return StartSession(db, w, r)
 }
 
-// Ends a session.
+// Ends a session, and revokes the client's "remember me" token.
 // Does nothing if there's no client session cookie.
 func EndSession(db *sql.DB, w http.ResponseWriter, r *http.Request) error {
+	if err := forget(db, w, r); err != nil {
+		return fmt.Errorf("failed to end session: %w", err)
+	}
+
 	var id string
 	c, err := getCookie(r)
 	if err == nil {
@@ -123,3 +203,32 @@ func EndSession(db *sql.DB, w http.ResponseWriter, r *http.Request) error {
 	deleteCookie(w)
 	return nil
 }
+
+// Deletes all sessions and "remember me" tokens of the user.
+// Returns the number of deleted sessions.
+func DeleteUserSessions(db *sql.DB, userID int) (int64, error) {
+	query := `DELETE FROM remember_token WHERE user_id = ?`
+	if _, err := db.Exec(query, userID); err != nil {
+		return 0, fmt.Errorf("failed to delete user sessions: %w", err)
+	}
+
+	query = `DELETE FROM user_session WHERE user_id = ?`
+	result, err := db.Exec(query, userID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete user sessions: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// Deletes all sessions and "remember me" tokens.
+// Returns the number of deleted sessions.
+func DeleteAllSessions(db *sql.DB) (int64, error) {
+	if _, err := db.Exec(`DELETE FROM remember_token`); err != nil {
+		return 0, fmt.Errorf("failed to delete sessions: %w", err)
+	}
+	result, err := db.Exec(`DELETE FROM user_session`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete sessions: %w", err)
+	}
+	return result.RowsAffected()
+}
@@ -4,6 +4,7 @@
 package translator
 
 import (
+	"database/sql"
 	"errors"
 	"fmt"
 
@@ -15,26 +16,34 @@ type Translation struct {
 	Text      string `json:"text"`
 }
 
+// Returned by Translate if the sentence doesn't have a translation.
+// Only happens with bad course data.
+var ErrNoTranslation = errors.New("sentence has no translation")
+
+// Picks random translation of the sentence.
+// Links to translations that don't exist get ignored.
 func Translate[T database.Querier](q T, tatoebaID int64) (Translation, error) {
 	var translation Translation
 
 	if tatoebaID <= 0 {
-		return translation, errors.New("sentence has no TatoebaID")
+		return translation, fmt.Errorf("sentence has no TatoebaID: %w", ErrNoTranslation)
 	}
 
 	query := `
 		SELECT tatoeba_id, text FROM translation
-		WHERE tatoeba_id = (
-			SELECT target FROM translates
-			WHERE source = ?
-			ORDER BY random() LIMIT 1
+		WHERE tatoeba_id IN (
+			SELECT target FROM translates WHERE source = ?
 		)
+		ORDER BY random() LIMIT 1
 	`
 
 	row := q.QueryRow(query, tatoebaID)
 	err := row.Scan(&translation.TatoebaID, &translation.Text)
+	if errors.Is(err, sql.ErrNoRows) {
+		return translation, ErrNoTranslation
+	}
 	if err != nil {
 		return translation, fmt.Errorf("failed to translate sentence: %w", err)
 	}
-	return translation, err
+	return translation, nil
 }
@@ -0,0 +1,70 @@
+// Copyright (c) 2022 Levi Gruspe
+// License: GNU AGPLv3 or later
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/polycloze/polycloze/basedir"
+	"github.com/polycloze/polycloze/config"
+	"github.com/polycloze/polycloze/coursecheck"
+)
+
+// Output of `polycloze validate-course`.
+type validationOutput struct {
+	Valid   bool                 `json:"valid"`
+	Courses []coursecheck.Report `json:"courses"`
+}
+
+// Runs `polycloze validate-course`.
+// args: arguments after "validate-course"
+func runValidateCourse(args []string) {
+	flags := flag.NewFlagSet("validate-course", flag.ExitOnError)
+	configFile := flags.String("config", config.DefaultPath(), "path to config file")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: polycloze validate-course [-config file] [course.db ...]")
+		fmt.Fprintln(flags.Output(), "Checks course databases, or all installed courses if none are given.")
+		fmt.Fprintln(flags.Output(), "Prints a JSON report, and exits with status 1 if a course is invalid.")
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+
+	paths := flags.Args()
+	if len(paths) == 0 {
+		c, err := config.Load(*configFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if c.Paths.DataDir != "" {
+			basedir.SetDataDir(c.Paths.DataDir)
+		}
+		paths, _ = filepath.Glob(filepath.Join(basedir.DataDir, "courses", "*.db"))
+		if len(paths) == 0 {
+			log.Fatal("couldn't find installed courses")
+		}
+	}
+
+	output := validationOutput{Valid: true}
+	for _, path := range paths {
+		report := coursecheck.Validate(path)
+		if !report.Valid {
+			output.Valid = false
+		}
+		output.Courses = append(output.Courses, report)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(output); err != nil {
+		log.Fatal(err)
+	}
+	if !output.Valid {
+		os.Exit(1)
+	}
+}